@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/pkg/errors"
+)
+
+// admissionReview is the body of the requests of a ValidatingWebhookConfiguration and of the responses to them, in
+// version admission.k8s.io/v1beta1. Only the fields the controller needs are declared.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Kind       string             `json:"kind,omitempty"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Kind      admissionKind   `json:"kind"`
+	Namespace string          `json:"namespace,omitempty"`
+	Operation string          `json:"operation,omitempty"`
+	Object    json.RawMessage `json:"object,omitempty"`
+}
+
+type admissionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+type admissionResponse struct {
+	UID     string           `json:"uid"`
+	Allowed bool             `json:"allowed"`
+	Result  *admissionStatus `json:"status,omitempty"`
+}
+
+type admissionStatus struct {
+	Message string `json:"message,omitempty"`
+}
+
+// ServeAdmission answers the admission reviews of a ValidatingWebhookConfiguration, denying ingresses of this
+// controller that it would refuse to reconcile or whose kong annotations are malformed, so that they are rejected when
+// they are applied rather than only reported once they are in the cluster. Other objects, and ingresses of other
+// controllers, are allowed.
+func (controller *KongIngressController) ServeAdmission(writer http.ResponseWriter, request *http.Request) {
+	review := admissionReview{}
+	if err := json.NewDecoder(request.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(writer, "Request is not an admission review", http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionResponse{UID: review.Request.UID, Allowed: true}
+	if err := controller.admitObject(review.Request); err != nil {
+		response.Allowed = false
+		response.Result = &admissionStatus{Message: err.Error()}
+		logging.Infof(logging.Fields{"uid": review.Request.UID, "error": err}, "Denying admission of %s: %v", review.Request.Kind.Kind, err)
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(admissionReview{APIVersion: review.APIVersion, Kind: review.Kind, Response: response}); err != nil {
+		logging.Errorf(logging.Fields{"uid": review.Request.UID, "error": err}, "Failed to write admission response: %v", err)
+	}
+}
+
+// admitObject returns the reason to deny the object of an admission request, or nil to allow it
+func (controller *KongIngressController) admitObject(request *admissionRequest) error {
+	if request.Kind.Kind != "Ingress" || request.Operation == "DELETE" {
+		return nil
+	}
+	ingress := &v1beta1.Ingress{}
+	if err := json.Unmarshal(request.Object, ingress); err != nil {
+		return errors.Wrap(err, "Failed to decode ingress")
+	}
+	if ingress.ObjectMeta.Namespace == "" {
+		ingress.ObjectMeta.Namespace = request.Namespace
+	}
+	return validateIngressAdmission(controller, ingress)
+}
+
+// validateIngressAdmission returns why the controller would not reconcile the ingress as it is, or nil when it would
+func validateIngressAdmission(controller *KongIngressController, ingress *v1beta1.Ingress) error {
+	if fairGame, _ := ingressIsFairGame(controller, ingress); !fairGame {
+		return nil
+	}
+	if paths := countIngressPaths(ingress); controller.MaxPathsPerIngress > 0 && paths > controller.MaxPathsPerIngress {
+		return errors.Errorf("Ingress '%s' has %d paths, more than the limit of %d", getIngressKey(ingress), paths, controller.MaxPathsPerIngress)
+	}
+	if err := validateIngressSupported(ingress); err != nil {
+		return errors.Wrapf(err, "Ingress '%s' is not supported", getIngressKey(ingress))
+	}
+	if problems := annotationProblems(ingress, parseAnnotations(ingress)); len(problems) > 0 {
+		return errors.Errorf("Ingress '%s' has malformed annotations %s", getIngressKey(ingress), strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+func reviewIngress(t *testing.T, kiController *KongIngressController, ingress *v1beta1.Ingress) *admissionResponse {
+	object, err := json.Marshal(ingress)
+	if err != nil {
+		t.Fatalf("Could not encode ingress: %v", err)
+	}
+	body, _ := json.Marshal(admissionReview{
+		APIVersion: "admission.k8s.io/v1beta1",
+		Kind:       "AdmissionReview",
+		Request: &admissionRequest{
+			UID:       "review-1",
+			Kind:      admissionKind{Group: "extensions", Version: "v1beta1", Kind: "Ingress"},
+			Namespace: ingress.ObjectMeta.Namespace,
+			Operation: "CREATE",
+			Object:    object,
+		},
+	})
+	recorder := httptest.NewRecorder()
+	kiController.ServeAdmission(recorder, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Admission review answered with status %d", recorder.Code)
+	}
+	review := admissionReview{}
+	if err := json.NewDecoder(recorder.Body).Decode(&review); err != nil || review.Response == nil {
+		t.Fatalf("Admission review answered without a response: %v", err)
+	}
+	if review.Response.UID != "review-1" {
+		t.Errorf("Admission response is for '%s', want the uid of the request", review.Response.UID)
+	}
+	return review.Response
+}
+
+func TestAdmissionDeniesIngressesTheControllerWouldRefuse(t *testing.T) {
+	kiController := New(nil, nil, nil)
+
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{stripURIAnnotation: "true"}
+	if response := reviewIngress(t, kiController, &ingress); !response.Allowed {
+		t.Errorf("Valid ingress denied: %+v", response.Result)
+	}
+
+	ingress.ObjectMeta.Annotations = map[string]string{rateLimitMinuteAnnotation: "sixty", stripURIAnnotation: "yes"}
+	response := reviewIngress(t, kiController, &ingress)
+	if response.Allowed || response.Result == nil {
+		t.Fatal("Ingress with malformed annotations allowed")
+	}
+	if !strings.Contains(response.Result.Message, rateLimitMinuteAnnotation) || !strings.Contains(response.Result.Message, stripURIAnnotation) {
+		t.Errorf("Denial '%s' does not name every malformed annotation", response.Result.Message)
+	}
+
+	unsupported := sampleIngress("shopservice", "prod")
+	unsupported.Spec.Backend = &unsupported.Spec.Rules[0].HTTP.Paths[0].Backend
+	if response := reviewIngress(t, kiController, &unsupported); response.Allowed {
+		t.Error("Ingress with both rules and a default backend allowed")
+	}
+
+	// Ingresses of other controllers are none of this controller's business
+	unsupported.ObjectMeta.Annotations = map[string]string{ingressClassAnnotation: "nginx"}
+	if response := reviewIngress(t, kiController, &unsupported); !response.Allowed {
+		t.Errorf("Ingress of another class denied: %+v", response.Result)
+	}
+}
+
+func TestAdmissionRefusesRequestsThatAreNotReviews(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	New(nil, nil, nil).ServeAdmission(recorder, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(`{"kind": "Ingress"}`)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Request without an admission request answered with status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
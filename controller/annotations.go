@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	// annotationPrefix namespaces the annotations that configure how an ingress is represented in kong
+	annotationPrefix = "kong.sprinthive.io/"
+	// annotationPrefixLoose matches anything that looks like it was meant to be a kong annotation, including typos of the domain
+	annotationPrefixLoose = "kong."
+)
+
+// annotationValidator checks the value of a known annotation, returning an error describing a malformed value
+type annotationValidator func(value string) error
+
+// knownAnnotations is the set of annotations the controller understands, each with the validation of its value
+var knownAnnotations = map[string]annotationValidator{
+	managedAnnotation:         validateBool,
+	stripURIAnnotation:        validateBool,
+	preserveHostAnnotation:    validateBool,
+	upstreamSchemeAnnotation:  validateUpstreamScheme,
+	upstreamServiceAnnotation: validateResourceName,
+	upstreamPathAnnotation:    validateUpstreamPath,
+	connectTimeoutAnnotation:  validatePositiveInt,
+	readTimeoutAnnotation:     validatePositiveInt,
+	writeTimeoutAnnotation:    validatePositiveInt,
+	retriesAnnotation:         validateNonNegativeInt,
+	methodsAnnotation:         validateMethods,
+}
+
+// exclusiveAnnotations maps annotations that cannot be combined with another annotation to that annotation, which takes
+// precedence when both are set
+var exclusiveAnnotations = map[string]string{}
+
+// requiredAnnotations maps annotations that only take effect alongside another annotation to that annotation, so that
+// an annotation set on its own is reported rather than silently doing nothing
+var requiredAnnotations = map[string]string{}
+
+// ingressAnnotations is the outcome of parsing the kong annotations on an ingress
+type ingressAnnotations struct {
+	// values holds the known annotations whose values are valid
+	values map[string]string
+	// invalid holds the known annotations whose values are malformed
+	invalid map[string]error
+	// unknown holds annotations that look like kong annotations but are not recognized
+	unknown []string
+}
+
+func parseAnnotations(ingress *v1beta1.Ingress) ingressAnnotations {
+	annotations := ingressAnnotations{
+		values:  map[string]string{},
+		invalid: map[string]error{},
+	}
+
+	for key, value := range ingress.ObjectMeta.Annotations {
+		if !strings.HasPrefix(key, annotationPrefixLoose) {
+			continue
+		}
+
+		validate, known := knownAnnotations[key]
+		if !known {
+			annotations.unknown = append(annotations.unknown, key)
+			continue
+		}
+		if err := validate(value); err != nil {
+			annotations.invalid[key] = err
+			continue
+		}
+		annotations.values[key] = value
+	}
+	for key, preferred := range exclusiveAnnotations {
+		_, found := annotations.values[key]
+		if _, preferredFound := annotations.values[preferred]; found && preferredFound {
+			annotations.invalid[key] = errors.Errorf("cannot be combined with '%s'", preferred)
+			delete(annotations.values, key)
+		}
+	}
+	for key, required := range requiredAnnotations {
+		_, found := annotations.values[key]
+		if _, requiredFound := annotations.values[required]; found && !requiredFound {
+			annotations.invalid[key] = errors.Errorf("only takes effect along with '%s'", required)
+			delete(annotations.values, key)
+		}
+	}
+	sort.Strings(annotations.unknown)
+
+	return annotations
+}
+
+// anyMalformed reports whether any of the annotations has a malformed value. An annotation that is only refused because
+// it cannot be combined with the annotation that takes precedence over it is not malformed.
+func (annotations ingressAnnotations) anyMalformed(keys []string) bool {
+	for _, key := range keys {
+		if _, invalid := annotations.invalid[key]; !invalid {
+			continue
+		}
+		if _, superseded := annotations.values[exclusiveAnnotations[key]]; !superseded {
+			return true
+		}
+	}
+	return false
+}
+
+func validateBool(value string) error {
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+// reportAnnotationProblems logs each malformed annotation and raises a single warning event listing them all, since the
+// settings they carry are skipped while the rest of the ingress is reconciled, and logs unknown annotations to help
+// catch typos
+func reportAnnotationProblems(controller *KongIngressController, ingress *v1beta1.Ingress, annotations ingressAnnotations) {
+	for _, key := range invalidAnnotationKeys(annotations) {
+		value, err := redactURL(ingress.ObjectMeta.Annotations[key]), annotations.invalid[key]
+		logging.Warningf(ingressFields(ingress).With(logging.Fields{"annotation": key, "error": err}), "Ignoring annotation '%s' on ingress '%s' with malformed value '%s': %v", key, getIngressKey(ingress), value, err)
+	}
+	if problems := annotationProblems(ingress, annotations); len(problems) > 0 && controller.Recorder != nil {
+		controller.Recorder.Eventf(ingress, v1.EventTypeWarning, "InvalidAnnotation", "Ignoring malformed annotations %s", strings.Join(problems, "; "))
+	}
+	for _, key := range annotations.unknown {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"annotation": key}), "Ignored unknown annotation '%s' on ingress '%s'", key, getIngressKey(ingress))
+	}
+}
+
+// annotationProblems describes each malformed annotation with its value, in the order of their keys
+func annotationProblems(ingress *v1beta1.Ingress, annotations ingressAnnotations) []string {
+	problems := []string{}
+	for _, key := range invalidAnnotationKeys(annotations) {
+		problems = append(problems, fmt.Sprintf("'%s' with value '%s': %v", key, redactURL(ingress.ObjectMeta.Annotations[key]), annotations.invalid[key]))
+	}
+	return problems
+}
+
+func invalidAnnotationKeys(annotations ingressAnnotations) []string {
+	keys := []string{}
+	for key := range annotations.invalid {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
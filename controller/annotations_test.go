@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestMalformedKnownAnnotationRaisesWarning(t *testing.T) {
+	key := annotationPrefix + "test-count"
+	knownAnnotations[key] = func(value string) error {
+		_, err := strconv.Atoi(value)
+		return err
+	}
+	defer delete(knownAnnotations, key)
+
+	ingress := sampleIngress("annotatedservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{key: "three"}
+	recorder := record.NewFakeRecorder(10)
+	kiController := KongIngressController{Recorder: recorder}
+
+	annotations := parseAnnotations(&ingress)
+	reportAnnotationProblems(&kiController, &ingress, annotations)
+
+	if _, found := annotations.values[key]; found {
+		t.Errorf("Malformed annotation '%s' should be skipped", key)
+	}
+	if _, found := annotations.invalid[key]; !found {
+		t.Errorf("Malformed annotation '%s' was not reported as invalid", key)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning InvalidAnnotation") || !strings.Contains(event, key) {
+			t.Errorf("Unexpected event for malformed annotation: %s", event)
+		}
+	default:
+		t.Error("No warning event raised for malformed annotation")
+	}
+}
+
+func TestUnknownKongAnnotationIsIgnored(t *testing.T) {
+	ingress := sampleIngress("annotatedservice", "prod")
+	typo := "kong.sprinthve.io/strip-uri"
+	ingress.ObjectMeta.Annotations = map[string]string{
+		typo:                          "true",
+		"kubernetes.io/ingress.class": "kong",
+	}
+	recorder := record.NewFakeRecorder(10)
+	kiController := KongIngressController{Recorder: recorder}
+
+	annotations := parseAnnotations(&ingress)
+	reportAnnotationProblems(&kiController, &ingress, annotations)
+
+	if len(annotations.unknown) != 1 || annotations.unknown[0] != typo {
+		t.Errorf("Unknown annotations are %v, want [%s]", annotations.unknown, typo)
+	}
+	if len(annotations.values) != 0 || len(annotations.invalid) != 0 {
+		t.Errorf("Unknown annotation should not be parsed, got values %v and invalid %v", annotations.values, annotations.invalid)
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Unknown annotation should only be logged, but raised event: %s", event)
+	default:
+	}
+}
+
+func TestMalformedAnnotationsReportedTogether(t *testing.T) {
+	ingress := sampleIngress("annotatedservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{
+		rateLimitMinuteAnnotation: "sixty",
+		ipWhitelistAnnotation:     "10.0.0.0/33",
+		stripURIAnnotation:        "true",
+	}
+	recorder := record.NewFakeRecorder(10)
+	kiController := KongIngressController{Recorder: recorder}
+
+	annotations := parseAnnotations(&ingress)
+	reportAnnotationProblems(&kiController, &ingress, annotations)
+
+	if _, found := annotations.values[stripURIAnnotation]; !found {
+		t.Error("Valid annotation should be kept alongside malformed ones")
+	}
+	select {
+	case event := <-recorder.Events:
+		whitelist, rateLimit := strings.Index(event, ipWhitelistAnnotation), strings.Index(event, rateLimitMinuteAnnotation)
+		if !strings.HasPrefix(event, "Warning InvalidAnnotation") || whitelist < 0 || rateLimit < 0 || rateLimit < whitelist {
+			t.Errorf("Event for malformed annotations is '%s', want both listed in order", event)
+		}
+	default:
+		t.Fatal("No warning event raised for malformed annotations")
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Malformed annotations should be reported in a single event, but also raised: %s", event)
+	default:
+	}
+}
+
+func TestAnnotationWithoutRequiredAnnotationIsInvalid(t *testing.T) {
+	ingress := sampleIngress("annotatedservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{statsdPortAnnotation: "8126", healthcheckPathAnnotation: "/healthz"}
+	annotations := parseAnnotations(&ingress)
+	for _, key := range []string{statsdPortAnnotation, healthcheckPathAnnotation} {
+		if _, found := annotations.invalid[key]; !found {
+			t.Errorf("Annotation '%s' without the annotation it needs was not reported as invalid", key)
+		}
+	}
+
+	ingress.ObjectMeta.Annotations[statsdHostAnnotation] = "statsd.monitoring"
+	ingress.ObjectMeta.Annotations[useUpstreamAnnotation] = "true"
+	annotations = parseAnnotations(&ingress)
+	if len(annotations.invalid) != 0 {
+		t.Errorf("Annotations along with those they need are invalid: %v", annotations.invalid)
+	}
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+// apiSnapshot holds the kong apis the reaper listed, so that the resync after it can reconcile ingresses against them
+// rather than fetching each api on its own. Each api is handed out once, since the reconcile it is handed to may change
+// it, and apis the snapshot does not hold are fetched as before.
+type apiSnapshot struct {
+	mutex sync.Mutex
+	taken time.Time
+	apis  map[string]*kong.Api
+}
+
+// set replaces the apis of the snapshot with those just listed
+func (snapshot *apiSnapshot) set(apis []*kong.Api) {
+	snapshot.mutex.Lock()
+	defer snapshot.mutex.Unlock()
+	snapshot.taken = time.Now()
+	snapshot.apis = make(map[string]*kong.Api, len(apis))
+	for _, api := range apis {
+		snapshot.apis[api.Name] = api
+	}
+}
+
+// take removes the api from the snapshot and returns it, unless the snapshot is older than maxAge
+func (snapshot *apiSnapshot) take(name string, maxAge time.Duration) (*kong.Api, bool) {
+	snapshot.mutex.Lock()
+	defer snapshot.mutex.Unlock()
+	api, found := snapshot.apis[name]
+	if !found {
+		return nil, false
+	}
+	delete(snapshot.apis, name)
+	if time.Since(snapshot.taken) > maxAge {
+		return nil, false
+	}
+	return api, true
+}
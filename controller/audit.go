@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	auditCreate = "create"
+	auditPatch  = "patch"
+	auditDelete = "delete"
+
+	auditEntityAPI = "api"
+)
+
+// AuditEntry describes a single change made to Kong by the controller
+type AuditEntry struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Operation string      `json:"operation"`
+	Entity    string      `json:"entity"`
+	Name      string      `json:"name"`
+	Ingress   string      `json:"ingress,omitempty"`
+	Change    interface{} `json:"change,omitempty"`
+}
+
+// AuditLog appends one JSON line per change made to Kong to a file
+type AuditLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewAuditLog opens (or creates) the audit file at path for appending
+func NewAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open audit file '%s'", path)
+	}
+
+	return &AuditLog{file: file}, nil
+}
+
+// Record appends the entry to the audit file and flushes it to disk
+func (auditLog *AuditLog) Record(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "Failed to serialize audit entry")
+	}
+
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	if _, err := auditLog.file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "Failed to write audit entry")
+	}
+
+	return auditLog.file.Sync()
+}
+
+// Close closes the underlying audit file
+func (auditLog *AuditLog) Close() error {
+	return auditLog.file.Close()
+}
+
+// record is a no-op when auditing is disabled. Audit failures are logged rather than failing the change,
+// since the change has already been applied to Kong.
+func (auditLog *AuditLog) record(operation string, entity string, name string, ingressKey string, change interface{}) {
+	if auditLog == nil {
+		return
+	}
+
+	err := auditLog.Record(AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Operation: operation,
+		Entity:    entity,
+		Name:      name,
+		Ingress:   ingressKey,
+		Change:    change,
+	})
+	if err != nil {
+		logging.Errorf(keyFields(ingressKey).With(logging.Fields{entity: name, "error": err}), "Failed to audit %s of %s '%s': %v", operation, entity, name, err)
+	}
+}
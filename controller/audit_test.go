@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestAuditLogRecordsCreateAndPatch(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	auditFile, err := ioutil.TempFile("", "kong-audit")
+	if err != nil {
+		t.Fatalf("Could not create audit file: %v", err)
+	}
+	auditFile.Close()
+	defer os.Remove(auditFile.Name())
+
+	auditLog, err := NewAuditLog(auditFile.Name())
+	if err != nil {
+		t.Fatalf("Could not open audit log: %v", err)
+	}
+	defer auditLog.Close()
+	kiController.AuditLog = auditLog
+
+	ingress := sampleIngress("auditedservice", "prod")
+	apiName := getQualifiedName(&ingress)
+	existingAPI := apiFromIngress(&ingress)
+	existingAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	existingAPI.PreserveHost = true
+
+	apiCreated := false
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, nil)
+		apiCreated = true
+	})
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet {
+			if !apiCreated {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeObjectResponse(t, &writer, existingAPI)
+		}
+	})
+
+	syncIngress(kiController, &ingress)
+
+	updatedIngress := sampleIngress("auditedservice", "prod")
+	updatedIngress.Spec.Rules[0].Host = "some-other-host"
+	syncIngress(kiController, &updatedIngress)
+
+	entries := readAuditEntries(t, auditFile.Name())
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit entries but got %d: %v", len(entries), entries)
+	}
+
+	expected := []struct {
+		operation string
+		change    kong.ApiRequest
+	}{
+		{auditCreate, apiRequestFromIngress(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))},
+		{auditPatch, kong.ApiRequest{ID: existingAPI.ID, Hosts: "some-other-host"}},
+	}
+	for i, entry := range entries {
+		if entry.Operation != expected[i].operation || entry.Entity != auditEntityAPI || entry.Name != apiName {
+			t.Errorf("Audit entry %d is %s of %s '%s', want %s of %s '%s'", i, entry.Operation, entry.Entity, entry.Name, expected[i].operation, auditEntityAPI, apiName)
+		}
+		if entry.Ingress != "prod/auditedservice" {
+			t.Errorf("Audit entry %d has ingress '%s', want 'prod/auditedservice'", i, entry.Ingress)
+		}
+		if entry.Timestamp.IsZero() {
+			t.Errorf("Audit entry %d has no timestamp", i)
+		}
+
+		change := kong.ApiRequest{}
+		if err := json.Unmarshal(entry.Change, &change); err != nil {
+			t.Errorf("Could not parse change in audit entry %d: %v", i, err)
+		}
+		expectedJSON, _ := objectToJSON(expected[i].change)
+		changeJSON, _ := objectToJSON(change)
+		if changeJSON != expectedJSON {
+			t.Errorf("Audit entry %d has change '%s', want '%s'", i, changeJSON, expectedJSON)
+		}
+	}
+}
+
+type parsedAuditEntry struct {
+	AuditEntry
+	Change json.RawMessage `json:"change"`
+}
+
+func readAuditEntries(t *testing.T, path string) []parsedAuditEntry {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Could not open audit file: %v", err)
+	}
+	defer file.Close()
+
+	entries := []parsedAuditEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := parsedAuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Audit line '%s' is not valid JSON: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
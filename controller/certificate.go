@@ -0,0 +1,601 @@
+package controller
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/nccurry/go-kong/kong"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SNIConflictFirstWins keeps an SNI on the certificate that claimed it first and backs off the secret that conflicts with it
+	SNIConflictFirstWins = "first-wins"
+	// SNIConflictLastWins reassigns an SNI to the certificate from the most recently reconciled secret
+	SNIConflictLastWins = "last-wins"
+
+	// defaultCertificateSNI is the catch-all SNI whose certificate kong serves when no other SNI matches
+	defaultCertificateSNI = "*"
+
+	auditEntityCertificate = "certificate"
+)
+
+// maxSNIConflictBackoff caps how long a conflicting secret waits before its SNI is retried
+var maxSNIConflictBackoff = time.Hour
+
+type sniClaim struct {
+	sni    string
+	secret string
+}
+
+type sniConflict struct {
+	retryAfter time.Time
+	backoff    time.Duration
+}
+
+// sniTracker remembers which secret configured each SNI so that two secrets fighting over one SNI can be detected, and
+// which SNIs each ingress lists so that those dropped from its tls section can be released
+type sniTracker struct {
+	mutex     sync.Mutex
+	owners    map[string]string
+	conflicts map[sniClaim]*sniConflict
+	listed    map[string][]sniClaim
+}
+
+func (tracker *sniTracker) owner(sni string) string {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.owners[sni]
+}
+
+func (tracker *sniTracker) claim(sni string, secretKey string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.owners == nil {
+		tracker.owners = map[string]string{}
+	}
+	tracker.owners[sni] = secretKey
+	delete(tracker.conflicts, sniClaim{sni, secretKey})
+}
+
+func (tracker *sniTracker) release(sni string, secretKey string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.owners[sni] == secretKey {
+		delete(tracker.owners, sni)
+	}
+	delete(tracker.conflicts, sniClaim{sni, secretKey})
+}
+
+// list records the SNIs the ingress lists for each of its secrets and returns those it listed before but no longer
+// does, leaving out any that another ingress still lists for the same secret
+func (tracker *sniTracker) list(ingressKey string, claims []sniClaim) []sniClaim {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	previous := tracker.listed[ingressKey]
+	if len(claims) == 0 {
+		delete(tracker.listed, ingressKey)
+	} else {
+		if tracker.listed == nil {
+			tracker.listed = map[string][]sniClaim{}
+		}
+		tracker.listed[ingressKey] = claims
+	}
+
+	stillListed := map[sniClaim]bool{}
+	for _, listed := range tracker.listed {
+		for _, claim := range listed {
+			stillListed[claim] = true
+		}
+	}
+	dropped := []sniClaim{}
+	for _, claim := range previous {
+		if !stillListed[claim] {
+			dropped = append(dropped, claim)
+		}
+	}
+	return dropped
+}
+
+func (tracker *sniTracker) inBackoff(sni string, secretKey string) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	conflict, found := tracker.conflicts[sniClaim{sni, secretKey}]
+	return found && time.Now().Before(conflict.retryAfter)
+}
+
+// conflict records that the secret failed to claim the SNI and returns how long to wait before trying again
+func (tracker *sniTracker) conflict(sni string, secretKey string) time.Duration {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.conflicts == nil {
+		tracker.conflicts = map[sniClaim]*sniConflict{}
+	}
+
+	claim := sniClaim{sni, secretKey}
+	conflict, found := tracker.conflicts[claim]
+	if !found {
+		conflict = &sniConflict{backoff: FullResyncInterval}
+		tracker.conflicts[claim] = conflict
+	} else if conflict.backoff < maxSNIConflictBackoff {
+		conflict.backoff *= 2
+		if conflict.backoff > maxSNIConflictBackoff {
+			conflict.backoff = maxSNIConflictBackoff
+		}
+	}
+	conflict.retryAfter = time.Now().Add(conflict.backoff)
+
+	return conflict.backoff
+}
+
+// reconcileCertificate makes sure kong serves the certificate in the TLS secret for each of the hosts it lists, from a
+// single kong certificate holding all of them as SNIs. A TLS entry without hosts configures kong's default certificate.
+func reconcileCertificate(controller *KongIngressController, ingress *v1beta1.Ingress, ingressTLS *v1beta1.IngressTLS) error {
+	secretKey := getSecretKey(ingress, ingressTLS)
+	secret, err := controller.CoreClient.Secrets(ingress.ObjectMeta.Namespace).Get(ingressTLS.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to fetch TLS secret '%s'", secretKey)
+	}
+
+	cert := string(secret.Data[v1.TLSCertKey])
+	key := string(secret.Data[v1.TLSPrivateKeyKey])
+	if cert == "" || key == "" {
+		return errors.Errorf("Secret '%s' does not contain both '%s' and '%s'", secretKey, v1.TLSCertKey, v1.TLSPrivateKeyKey)
+	}
+
+	snis := []string{}
+	for _, host := range getTLSHosts(ingressTLS) {
+		if preferred := preferredTLSSecret(controller, ingress, host); preferred != ingressTLS.SecretName {
+			logging.V(2).Infof(logging.Fields{"sni": host, "secret": secretKey}, "Skipping SNI '%s' for secret '%s' in favour of the newer certificate in secret '%s'", host, secretKey, preferred)
+			continue
+		}
+		if controller.sniTracker.inBackoff(host, secretKey) {
+			logging.V(2).Infof(logging.Fields{"sni": host, "secret": secretKey}, "Skipping SNI '%s' for secret '%s' while its conflict backs off", host, secretKey)
+			continue
+		}
+		snis = append(snis, host)
+	}
+	if len(snis) == 0 {
+		return nil
+	}
+
+	return reconcileSNIs(controller, getIngressKey(ingress), secretKey, snis, cert, key)
+}
+
+// reconcileSNIs brings the certificates kong serves the SNIs of a secret from up to date with the secret. Each kong
+// certificate is fetched once however many of the SNIs it holds, and the SNIs kong has no certificate for yet are added
+// to the certificate that already holds the cert and key of the secret, or else to a new one, rather than each getting
+// a certificate of its own.
+func reconcileSNIs(controller *KongIngressController, ingressKey string, secretKey string, snis []string, cert string, key string) error {
+	wanted := map[string]bool{}
+	for _, sni := range snis {
+		wanted[sni] = true
+	}
+
+	var current *kong.Certificate
+	accounted := map[string]bool{}
+	missing := []string{}
+	for _, sni := range snis {
+		if accounted[sni] {
+			continue
+		}
+		certificate, found, err := fetchCertificate(controller, sni)
+		if err != nil {
+			return err
+		}
+		if !found {
+			missing = append(missing, sni)
+			continue
+		}
+		held := []string{}
+		for _, heldSNI := range append([]string{sni}, certificate.Snis...) {
+			if wanted[heldSNI] && !accounted[heldSNI] {
+				accounted[heldSNI] = true
+				held = append(held, heldSNI)
+			}
+		}
+
+		if certificate.Cert == cert && certificate.Key == key {
+			for _, heldSNI := range held {
+				controller.sniTracker.claim(heldSNI, secretKey)
+			}
+		} else {
+			certificate, err = updateCertificate(controller, ingressKey, secretKey, certificate, held, cert, key)
+			if err != nil {
+				return err
+			}
+			if certificate == nil {
+				continue
+			}
+		}
+		if current == nil {
+			current = certificate
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if current != nil {
+		return addCertificateSNIs(controller, ingressKey, secretKey, current, missing)
+	}
+	return createCertificate(controller, ingressKey, secretKey, missing, cert, key)
+}
+
+// fetchCertificate returns the kong certificate holding the SNI, or false when kong has none
+func fetchCertificate(controller *KongIngressController, sni string) (*kong.Certificate, bool, error) {
+	certificate, resp, err := controller.KongClient.Certificates.Get(sni)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Failed to fetch certificate for SNI '%s'", sni)
+	}
+	if certificate == nil {
+		return nil, false, errors.Errorf("Failed to fetch certificate for SNI '%s', kong returned no certificate", sni)
+	}
+	return certificate, true, nil
+}
+
+// updateCertificate makes kong serve the SNIs of the secret held by a kong certificate from the cert and key of the
+// secret. SNIs another secret claimed are resolved one at a time by the SNI conflict policy. It returns the certificate
+// that now serves the other SNIs, or nil when all of them were contested.
+func updateCertificate(controller *KongIngressController, ingressKey string, secretKey string, certificate *kong.Certificate, held []string, cert string, key string) (*kong.Certificate, error) {
+	uncontested := []string{}
+	for _, sni := range held {
+		if owner := controller.sniTracker.owner(sni); owner != "" && owner != secretKey {
+			if err := resolveSNIConflict(controller, ingressKey, secretKey, sni, cert, key, certificate); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		uncontested = append(uncontested, sni)
+	}
+	if len(uncontested) == 0 {
+		return nil, nil
+	}
+	return assignCertificate(controller, ingressKey, secretKey, certificate, uncontested, cert, key)
+}
+
+// assignCertificate makes kong serve SNIs held by a kong certificate from the cert and key of the secret. The
+// certificate is patched when it holds no other SNIs. Otherwise the SNIs are detached from it onto a certificate of
+// their own, so that the other SNIs keep the cert and key they are served. It returns the certificate now serving them.
+func assignCertificate(controller *KongIngressController, ingressKey string, secretKey string, certificate *kong.Certificate, snis []string, cert string, key string) (*kong.Certificate, error) {
+	assigned := map[string]bool{}
+	for _, sni := range snis {
+		assigned[sni] = true
+	}
+	others := []string{}
+	for _, sni := range certificate.Snis {
+		if !assigned[sni] {
+			others = append(others, sni)
+		}
+	}
+
+	if len(others) == 0 {
+		if err := patchCertificate(controller, ingressKey, secretKey, certificate.ID, snis, cert, key); err != nil {
+			return nil, err
+		}
+		return &kong.Certificate{ID: certificate.ID, Cert: cert, Key: key, Snis: snis}, nil
+	}
+
+	if err := detachCertificateSNIs(controller, ingressKey, certificate, others); err != nil {
+		return nil, err
+	}
+	if err := postCertificate(controller, ingressKey, secretKey, snis, cert, key); err != nil {
+		return nil, err
+	}
+	created, found, err := fetchCertificate(controller, snis[0])
+	if err != nil || !found {
+		return nil, err
+	}
+	return created, nil
+}
+
+// detachCertificateSNIs leaves a kong certificate holding only the remaining SNIs
+func detachCertificateSNIs(controller *KongIngressController, ingressKey string, certificate *kong.Certificate, remaining []string) error {
+	joined := strings.Join(remaining, ",")
+	logging.Infof(logging.Fields{"snis": remaining, "certificate": certificate.ID}, "Detaching SNIs from certificate '%s', leaving SNIs '%s'", certificate.ID, joined)
+	_, err := controller.KongClient.Certificates.Patch(&kong.CertificateRequest{
+		ID:   certificate.ID,
+		Snis: joined,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to detach SNIs from certificate '%s'", certificate.ID)
+	}
+	controller.AuditLog.record(auditPatch, auditEntityCertificate, joined, ingressKey, map[string]string{"snis": joined})
+	return nil
+}
+
+// addCertificateSNIs adds SNIs to a kong certificate that already holds the cert and key of the secret
+func addCertificateSNIs(controller *KongIngressController, ingressKey string, secretKey string, certificate *kong.Certificate, snis []string) error {
+	allSNIs := strings.Join(append(append([]string{}, certificate.Snis...), snis...), ",")
+	logging.Infof(logging.Fields{"snis": snis, "secret": secretKey, "certificate": certificate.ID}, "Adding SNIs '%s' from secret '%s' to certificate '%s'", strings.Join(snis, ","), secretKey, certificate.ID)
+	resp, err := controller.KongClient.Certificates.Patch(&kong.CertificateRequest{
+		ID:   certificate.ID,
+		Snis: allSNIs,
+	})
+	if isSNIConflict(resp, err) {
+		return reconcileEachSNI(controller, ingressKey, secretKey, snis, certificate.Cert, certificate.Key)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to add SNIs '%s' to certificate '%s'", strings.Join(snis, ","), certificate.ID)
+	}
+	controller.AuditLog.record(auditPatch, auditEntityCertificate, strings.Join(snis, ","), ingressKey, certificateChange(secretKey, allSNIs))
+	for _, sni := range snis {
+		controller.sniTracker.claim(sni, secretKey)
+	}
+	return nil
+}
+
+// createCertificate creates a kong certificate with the cert and key of the secret for the SNIs
+func createCertificate(controller *KongIngressController, ingressKey string, secretKey string, snis []string, cert string, key string) error {
+	err := postCertificate(controller, ingressKey, secretKey, snis, cert, key)
+	if errors.Cause(err) == errSNIConflict {
+		if len(snis) == 1 {
+			return resolveSNIConflict(controller, ingressKey, secretKey, snis[0], cert, key, nil)
+		}
+		return reconcileEachSNI(controller, ingressKey, secretKey, snis, cert, key)
+	}
+	return err
+}
+
+// errSNIConflict is returned by postCertificate when kong refused the certificate because one of its SNIs belongs to
+// another certificate
+var errSNIConflict = errors.New("SNI already associated with another certificate")
+
+// postCertificate posts a new kong certificate for the SNIs without resolving conflicts
+func postCertificate(controller *KongIngressController, ingressKey string, secretKey string, snis []string, cert string, key string) error {
+	joined := strings.Join(snis, ",")
+	logging.Infof(logging.Fields{"snis": snis, "secret": secretKey}, "Creating new certificate for SNIs '%s' from secret '%s'", joined, secretKey)
+	resp, err := controller.KongClient.Certificates.Post(&kong.CertificateRequest{
+		Cert: cert,
+		Key:  key,
+		Snis: joined,
+	})
+	if isSNIConflict(resp, err) {
+		return errors.Wrapf(errSNIConflict, "Failed to create certificate for SNIs '%s'", joined)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create certificate for SNIs '%s'", joined)
+	}
+	controller.AuditLog.record(auditCreate, auditEntityCertificate, joined, ingressKey, certificateChange(secretKey, joined))
+	for _, sni := range snis {
+		controller.sniTracker.claim(sni, secretKey)
+	}
+	return nil
+}
+
+// reconcileEachSNI falls back to reconciling the SNIs one at a time when kong refused them together because one of
+// them was taken by another certificate in the meantime, so that the conflict is resolved for that SNI alone
+func reconcileEachSNI(controller *KongIngressController, ingressKey string, secretKey string, snis []string, cert string, key string) error {
+	for _, sni := range snis {
+		if err := reconcileSNI(controller, ingressKey, secretKey, sni, cert, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preferredTLSSecret picks the secret that should serve the host when more than one TLS entry of the ingress lists it,
+// as happens while a certificate is being rotated. The certificate that became valid most recently wins, so that the
+// host does not flap between the old and new certificates.
+func preferredTLSSecret(controller *KongIngressController, ingress *v1beta1.Ingress, host string) string {
+	candidates := []string{}
+	for i := range ingress.Spec.TLS {
+		for _, tlsHost := range getTLSHosts(&ingress.Spec.TLS[i]) {
+			if tlsHost == host {
+				candidates = append(candidates, ingress.Spec.TLS[i].SecretName)
+				break
+			}
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	preferred := ""
+	var newest time.Time
+	for _, secretName := range candidates {
+		secret, err := controller.CoreClient.Secrets(ingress.ObjectMeta.Namespace).Get(secretName, metav1.GetOptions{})
+		if err != nil {
+			continue
+		}
+		notBefore := certificateNotBefore(secret.Data[v1.TLSCertKey])
+		if preferred == "" || notBefore.After(newest) {
+			preferred = secretName
+			newest = notBefore
+		}
+	}
+	return preferred
+}
+
+// certificateNotBefore returns when the first certificate of a PEM chain becomes valid, or the zero time when it cannot
+// be parsed
+func certificateNotBefore(certPEM []byte) time.Time {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}
+	}
+	return certificate.NotBefore
+}
+
+// reconcileSNI brings the certificate kong serves a single SNI of the secret from up to date with the secret
+func reconcileSNI(controller *KongIngressController, ingressKey string, secretKey string, sni string, cert string, key string) error {
+	if controller.sniTracker.inBackoff(sni, secretKey) {
+		logging.V(2).Infof(logging.Fields{"sni": sni, "secret": secretKey}, "Skipping SNI '%s' for secret '%s' while its conflict backs off", sni, secretKey)
+		return nil
+	}
+
+	certificate, found, err := fetchCertificate(controller, sni)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return createCertificate(controller, ingressKey, secretKey, []string{sni}, cert, key)
+	}
+
+	if certificate.Cert == cert && certificate.Key == key {
+		controller.sniTracker.claim(sni, secretKey)
+		return nil
+	}
+
+	if owner := controller.sniTracker.owner(sni); owner != "" && owner != secretKey {
+		return resolveSNIConflict(controller, ingressKey, secretKey, sni, cert, key, certificate)
+	}
+
+	_, err = assignCertificate(controller, ingressKey, secretKey, certificate, []string{sni}, cert, key)
+	return err
+}
+
+// resolveSNIConflict is called when the secret tries to claim an SNI that kong already serves from another secret
+func resolveSNIConflict(controller *KongIngressController, ingressKey string, secretKey string, sni string, cert string, key string, existing *kong.Certificate) error {
+	owner := "an unknown secret"
+	if ownerKey := controller.sniTracker.owner(sni); ownerKey != "" {
+		owner = fmt.Sprintf("secret '%s'", ownerKey)
+	}
+
+	if controller.SNIConflictPolicy == SNIConflictLastWins {
+		logging.Warningf(logging.Fields{"sni": sni, "secret": secretKey}, "SNI conflict: '%s' is claimed by both secret '%s' and %s. Reassigning it to secret '%s'", sni, secretKey, owner, secretKey)
+		if existing == nil {
+			certificate, found, err := fetchCertificate(controller, sni)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return postCertificate(controller, ingressKey, secretKey, []string{sni}, cert, key)
+			}
+			existing = certificate
+		}
+		_, err := assignCertificate(controller, ingressKey, secretKey, existing, []string{sni}, cert, key)
+		return err
+	}
+
+	backoff := controller.sniTracker.conflict(sni, secretKey)
+	logging.Warningf(logging.Fields{"sni": sni, "secret": secretKey}, "SNI conflict: '%s' is claimed by both secret '%s' and %s. Keeping the existing certificate and retrying in %v", sni, secretKey, owner, backoff)
+	return nil
+}
+
+// patchCertificate replaces the cert and key of the kong certificate serving the SNIs with those of the secret
+func patchCertificate(controller *KongIngressController, ingressKey string, secretKey string, certificateID string, snis []string, cert string, key string) error {
+	sni := strings.Join(snis, ",")
+	logging.Infof(logging.Fields{"snis": sni, "secret": secretKey}, "Updating certificate for SNIs '%s' from secret '%s'", sni, secretKey)
+	_, err := controller.KongClient.Certificates.Patch(&kong.CertificateRequest{
+		ID:   certificateID,
+		Cert: cert,
+		Key:  key,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to patch certificate for SNIs '%s'", sni)
+	}
+	controller.AuditLog.record(auditPatch, auditEntityCertificate, sni, ingressKey, certificateChange(secretKey, sni))
+	for _, patched := range snis {
+		controller.sniTracker.claim(patched, secretKey)
+	}
+
+	return nil
+}
+
+// releaseCertificates forgets the SNIs claimed by a deleted ingress so that other secrets may claim them
+func releaseCertificates(controller *KongIngressController, ingress *v1beta1.Ingress) {
+	controller.sniTracker.list(getIngressKey(ingress), nil)
+	for _, claim := range listedSNIs(ingress) {
+		controller.sniTracker.release(claim.sni, claim.secret)
+	}
+}
+
+// releaseDroppedSNIs removes the SNIs dropped from the tls section of the ingress from the kong certificates of their
+// secrets and forgets them, so that other secrets may claim them
+func releaseDroppedSNIs(controller *KongIngressController, ingress *v1beta1.Ingress) error {
+	ingressKey := getIngressKey(ingress)
+	for _, claim := range controller.sniTracker.list(ingressKey, listedSNIs(ingress)) {
+		if controller.sniTracker.owner(claim.sni) == claim.secret {
+			if err := removeCertificateSNI(controller, ingressKey, claim.sni); err != nil {
+				return err
+			}
+		}
+		controller.sniTracker.release(claim.sni, claim.secret)
+	}
+	return nil
+}
+
+// removeCertificateSNI stops kong serving the SNI, deleting its certificate when it holds no other SNIs
+func removeCertificateSNI(controller *KongIngressController, ingressKey string, sni string) error {
+	certificate, found, err := fetchCertificate(controller, sni)
+	if err != nil || !found {
+		return err
+	}
+	others := []string{}
+	for _, held := range certificate.Snis {
+		if held != sni {
+			others = append(others, held)
+		}
+	}
+	if len(others) > 0 {
+		return detachCertificateSNIs(controller, ingressKey, certificate, others)
+	}
+
+	logging.Infof(logging.Fields{"sni": sni, "certificate": certificate.ID}, "Deleting certificate '%s' of SNI '%s' dropped from all ingresses", certificate.ID, sni)
+	resp, err := controller.KongClient.Certificates.Delete(certificate.ID)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return errors.Wrapf(err, "Failed to delete certificate '%s' of SNI '%s'", certificate.ID, sni)
+	}
+	controller.AuditLog.record(auditDelete, auditEntityCertificate, sni, ingressKey, nil)
+	return nil
+}
+
+// listedSNIs returns the SNIs the tls section of the ingress lists for each of its secrets
+func listedSNIs(ingress *v1beta1.Ingress) []sniClaim {
+	claims := []sniClaim{}
+	for i := range ingress.Spec.TLS {
+		ingressTLS := &ingress.Spec.TLS[i]
+		for _, host := range getTLSHosts(ingressTLS) {
+			claims = append(claims, sniClaim{sni: host, secret: getSecretKey(ingress, ingressTLS)})
+		}
+	}
+	return claims
+}
+
+// isSNIConflict recognizes kong rejecting a certificate because one of its SNIs belongs to another certificate
+func isSNIConflict(resp *http.Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	if resp != nil && resp.StatusCode == http.StatusConflict {
+		return true
+	}
+	return strings.Contains(err.Error(), "already associated")
+}
+
+// certificateChange describes a certificate change for the audit log without exposing the private key
+func certificateChange(secretKey string, sni string) map[string]string {
+	return map[string]string{
+		"secret": secretKey,
+		"snis":   sni,
+	}
+}
+
+func getTLSHosts(ingressTLS *v1beta1.IngressTLS) []string {
+	if len(ingressTLS.Hosts) == 0 {
+		return []string{defaultCertificateSNI}
+	}
+	return ingressTLS.Hosts
+}
+
+func getSecretKey(ingress *v1beta1.Ingress, ingressTLS *v1beta1.IngressTLS) string {
+	return fmt.Sprintf("%s/%s", ingress.ObjectMeta.Namespace, ingressTLS.SecretName)
+}
@@ -0,0 +1,483 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestCertificateCreatedForTLSHost(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("secureservice", "prod", "secure-tls")
+	kiController.CoreClient = fake.NewSimpleClientset(sampleTLSSecret("prod", "secure-tls", "cert-1")).CoreV1()
+	sni := ingress.Spec.TLS[0].Hosts[0]
+
+	certificateCreated := false
+	mux.HandleFunc("/certificates/"+sni, func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/certificates", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, kong.CertificateRequest{
+			Cert: "cert-1",
+			Key:  "key-cert-1",
+			Snis: sni,
+		})
+		certificateCreated = true
+	})
+
+	err := reconcileCertificate(kiController, &ingress, &ingress.Spec.TLS[0])
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling certificate: %v", err)
+	}
+	if !certificateCreated {
+		t.Error("Certificate for the TLS host was not created")
+	}
+	if owner := kiController.sniTracker.owner(sni); owner != "prod/secure-tls" {
+		t.Errorf("SNI '%s' is owned by '%s', want 'prod/secure-tls'", sni, owner)
+	}
+}
+
+func TestDefaultCertificateForTLSWithoutHosts(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("secureservice", "prod", "default-tls")
+	ingress.Spec.TLS[0].Hosts = nil
+	kiController.CoreClient = fake.NewSimpleClientset(sampleTLSSecret("prod", "default-tls", "cert-2")).CoreV1()
+
+	certificatePatched := false
+	mux.HandleFunc("/certificates/"+defaultCertificateSNI, func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writeObjectResponse(t, &writer, kong.Certificate{
+			ID:   "default-cert",
+			Cert: "cert-1",
+			Key:  "key-cert-1",
+			Snis: []string{defaultCertificateSNI},
+		})
+	})
+	mux.HandleFunc("/certificates/default-cert", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPatch, kong.CertificateRequest{
+			ID:   "default-cert",
+			Cert: "cert-2",
+			Key:  "key-cert-2",
+		})
+		certificatePatched = true
+	})
+
+	err := reconcileCertificate(kiController, &ingress, &ingress.Spec.TLS[0])
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling default certificate: %v", err)
+	}
+	if !certificatePatched {
+		t.Error("Default certificate was not updated from the TLS entry without hosts")
+	}
+}
+
+func TestSNIConflictFromKongBacksOff(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("secureservice", "prod", "secure-tls")
+	kiController.CoreClient = fake.NewSimpleClientset(sampleTLSSecret("prod", "secure-tls", "cert-1")).CoreV1()
+	sni := ingress.Spec.TLS[0].Hosts[0]
+
+	kongRequests := 0
+	mux.HandleFunc("/certificates/"+sni, func(writer http.ResponseWriter, request *http.Request) {
+		kongRequests++
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/certificates", func(writer http.ResponseWriter, request *http.Request) {
+		kongRequests++
+		writer.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(writer, `{"message":"snis: %s already associated with existing certificate"}`, sni)
+	})
+
+	err := reconcileCertificate(kiController, &ingress, &ingress.Spec.TLS[0])
+	if err != nil {
+		t.Fatalf("SNI conflict should back off rather than fail, got: %v", err)
+	}
+	if !kiController.sniTracker.inBackoff(sni, "prod/secure-tls") {
+		t.Fatal("Secret with a conflicting SNI is not backing off")
+	}
+
+	requestsBeforeRetry := kongRequests
+	reconcileCertificate(kiController, &ingress, &ingress.Spec.TLS[0])
+	if kongRequests != requestsBeforeRetry {
+		t.Errorf("Kong was called %d more times while the conflict was backing off", kongRequests-requestsBeforeRetry)
+	}
+}
+
+func TestSNIConflictBetweenSecrets(t *testing.T) {
+	for _, policy := range []string{SNIConflictFirstWins, SNIConflictLastWins} {
+		setup()
+
+		firstIngress := sampleTLSIngress("secureservice", "prod", "first-tls")
+		secondIngress := sampleTLSIngress("secureservice", "prod", "second-tls")
+		kiController.SNIConflictPolicy = policy
+		kiController.CoreClient = fake.NewSimpleClientset(
+			sampleTLSSecret("prod", "first-tls", "cert-1"),
+			sampleTLSSecret("prod", "second-tls", "cert-2"),
+		).CoreV1()
+		sni := firstIngress.Spec.TLS[0].Hosts[0]
+		kiController.sniTracker.claim(sni, "prod/first-tls")
+
+		certificatePatched := false
+		mux.HandleFunc("/certificates/"+sni, func(writer http.ResponseWriter, request *http.Request) {
+			testRequestMatches(t, request, http.MethodGet, nil)
+			writeObjectResponse(t, &writer, kong.Certificate{
+				ID:   "first-cert",
+				Cert: "cert-1",
+				Key:  "key-cert-1",
+				Snis: []string{sni},
+			})
+		})
+		mux.HandleFunc("/certificates/first-cert", func(writer http.ResponseWriter, request *http.Request) {
+			testRequestMatches(t, request, http.MethodPatch, kong.CertificateRequest{
+				ID:   "first-cert",
+				Cert: "cert-2",
+				Key:  "key-cert-2",
+			})
+			certificatePatched = true
+		})
+
+		err := reconcileCertificate(kiController, &secondIngress, &secondIngress.Spec.TLS[0])
+		if err != nil {
+			t.Errorf("Unexpected error reconciling conflicting certificate with policy %s: %v", policy, err)
+		}
+
+		expectedOwner := "prod/first-tls"
+		if policy == SNIConflictLastWins {
+			expectedOwner = "prod/second-tls"
+		}
+		if certificatePatched != (policy == SNIConflictLastWins) {
+			t.Errorf("Certificate patched: %v, want %v with policy %s", certificatePatched, !certificatePatched, policy)
+		}
+		if owner := kiController.sniTracker.owner(sni); owner != expectedOwner {
+			t.Errorf("SNI '%s' is owned by '%s', want '%s' with policy %s", sni, owner, expectedOwner, policy)
+		}
+
+		shutdown()
+	}
+}
+
+func TestLastWinsDetachesSNIFromSharedCertificate(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("secureservice", "prod", "second-tls")
+	kiController.SNIConflictPolicy = SNIConflictLastWins
+	kiController.CoreClient = fake.NewSimpleClientset(sampleTLSSecret("prod", "second-tls", "cert-2")).CoreV1()
+	sni := ingress.Spec.TLS[0].Hosts[0]
+	kiController.sniTracker.claim(sni, "prod/first-tls")
+	kiController.sniTracker.claim("other.somedomain", "prod/first-tls")
+
+	detached := false
+	created := false
+	mux.HandleFunc("/certificates/"+sni, func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		if created {
+			writeObjectResponse(t, &writer, kong.Certificate{ID: "second-cert", Cert: "cert-2", Key: "key-cert-2", Snis: []string{sni}})
+			return
+		}
+		writeObjectResponse(t, &writer, kong.Certificate{ID: "first-cert", Cert: "cert-1", Key: "key-cert-1", Snis: []string{sni, "other.somedomain"}})
+	})
+	mux.HandleFunc("/certificates/first-cert", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPatch, kong.CertificateRequest{
+			ID:   "first-cert",
+			Snis: "other.somedomain",
+		})
+		detached = true
+	})
+	mux.HandleFunc("/certificates", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, kong.CertificateRequest{
+			Cert: "cert-2",
+			Key:  "key-cert-2",
+			Snis: sni,
+		})
+		created = true
+	})
+
+	if err := reconcileCertificate(kiController, &ingress, &ingress.Spec.TLS[0]); err != nil {
+		t.Fatalf("Unexpected error reconciling conflicting certificate: %v", err)
+	}
+	if !detached {
+		t.Error("Contested SNI was not detached from the shared certificate")
+	}
+	if !created {
+		t.Error("No certificate was created for the contested SNI")
+	}
+	if owner := kiController.sniTracker.owner(sni); owner != "prod/second-tls" {
+		t.Errorf("SNI '%s' is owned by '%s', want 'prod/second-tls'", sni, owner)
+	}
+	if owner := kiController.sniTracker.owner("other.somedomain"); owner != "prod/first-tls" {
+		t.Errorf("SNI 'other.somedomain' is owned by '%s', want 'prod/first-tls'", owner)
+	}
+}
+
+func TestLastWinsCreatesCertificateWhenKongHasNone(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	kiController.SNIConflictPolicy = SNIConflictLastWins
+	sni := "secureservice.somedomain"
+	kiController.sniTracker.claim(sni, "prod/first-tls")
+
+	created := false
+	mux.HandleFunc("/certificates/"+sni, func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/certificates", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, kong.CertificateRequest{
+			Cert: "cert-2",
+			Key:  "key-cert-2",
+			Snis: sni,
+		})
+		created = true
+	})
+
+	if err := resolveSNIConflict(kiController, "prod/secureservice", "prod/second-tls", sni, "cert-2", "key-cert-2", nil); err != nil {
+		t.Fatalf("Unexpected error resolving SNI conflict: %v", err)
+	}
+	if !created {
+		t.Error("No certificate was created for an SNI kong has no certificate for")
+	}
+	if owner := kiController.sniTracker.owner(sni); owner != "prod/second-tls" {
+		t.Errorf("SNI '%s' is owned by '%s', want 'prod/second-tls'", sni, owner)
+	}
+}
+
+func TestSNIsDroppedFromTLSAreReleased(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("secureservice", "prod", "shared-tls")
+	ingress.Spec.TLS[0].Hosts = []string{"a.somedomain", "b.somedomain", "c.somedomain"}
+	other := sampleTLSIngress("otherservice", "prod", "shared-tls")
+	other.Spec.TLS[0].Hosts = []string{"c.somedomain"}
+	kiController.sniTracker.list(getIngressKey(&ingress), listedSNIs(&ingress))
+	kiController.sniTracker.list(getIngressKey(&other), listedSNIs(&other))
+	for _, sni := range ingress.Spec.TLS[0].Hosts {
+		kiController.sniTracker.claim(sni, "prod/shared-tls")
+	}
+
+	mux.HandleFunc("/certificates/b.somedomain", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writeObjectResponse(t, &writer, kong.Certificate{ID: "shared-cert", Snis: []string{"a.somedomain", "b.somedomain", "c.somedomain"}})
+	})
+	detached := false
+	mux.HandleFunc("/certificates/shared-cert", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPatch, kong.CertificateRequest{
+			ID:   "shared-cert",
+			Snis: "a.somedomain,c.somedomain",
+		})
+		detached = true
+	})
+	mux.HandleFunc("/certificates/c.somedomain", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("SNI still listed by another ingress was removed from kong, got %s", request.Method)
+	})
+
+	ingress.Spec.TLS[0].Hosts = []string{"a.somedomain"}
+	if err := releaseDroppedSNIs(kiController, &ingress); err != nil {
+		t.Fatalf("Unexpected error releasing dropped SNIs: %v", err)
+	}
+	if !detached {
+		t.Error("SNI dropped from the tls section was not removed from its certificate")
+	}
+	expectedOwners := map[string]string{"a.somedomain": "prod/shared-tls", "b.somedomain": "", "c.somedomain": "prod/shared-tls"}
+	for sni, expectedOwner := range expectedOwners {
+		if owner := kiController.sniTracker.owner(sni); owner != expectedOwner {
+			t.Errorf("SNI '%s' is owned by '%s', want '%s'", sni, owner, expectedOwner)
+		}
+	}
+}
+
+func TestNewestCertificateWinsForSharedHost(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("rotatedservice", "prod", "rotated-tls-new")
+	ingress.Spec.TLS = append([]v1beta1.IngressTLS{{
+		Hosts:      ingress.Spec.TLS[0].Hosts,
+		SecretName: "rotated-tls-old",
+	}}, ingress.Spec.TLS...)
+	oldCert := selfSignedCertificate(t, time.Now().Add(-60*24*time.Hour))
+	newCert := selfSignedCertificate(t, time.Now().Add(-time.Hour))
+	kiController.CoreClient = fake.NewSimpleClientset(
+		sampleTLSSecret("prod", "rotated-tls-old", oldCert),
+		sampleTLSSecret("prod", "rotated-tls-new", newCert),
+	).CoreV1()
+	sni := ingress.Spec.TLS[0].Hosts[0]
+
+	mux.HandleFunc("/certificates/"+sni, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	appliedCerts := []string{}
+	mux.HandleFunc("/certificates", func(writer http.ResponseWriter, request *http.Request) {
+		certificateRequest := kong.CertificateRequest{}
+		json.NewDecoder(request.Body).Decode(&certificateRequest)
+		appliedCerts = append(appliedCerts, certificateRequest.Cert)
+	})
+
+	for i := range ingress.Spec.TLS {
+		if err := reconcileCertificate(kiController, &ingress, &ingress.Spec.TLS[i]); err != nil {
+			t.Fatalf("Unexpected error reconciling certificate: %v", err)
+		}
+	}
+	if len(appliedCerts) != 1 || appliedCerts[0] != newCert {
+		t.Errorf("Expected only the newer certificate to be applied for SNI '%s', got %d certificates", sni, len(appliedCerts))
+	}
+	if owner := kiController.sniTracker.owner(sni); owner != "prod/rotated-tls-new" {
+		t.Errorf("SNI '%s' is owned by '%s', want 'prod/rotated-tls-new'", sni, owner)
+	}
+}
+
+func TestSecretWithSeveralHostsGetsOneCertificate(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("secureservice", "prod", "shared-tls")
+	ingress.Spec.TLS[0].Hosts = []string{"a.somedomain", "b.somedomain", "c.somedomain"}
+	kiController.CoreClient = fake.NewSimpleClientset(sampleTLSSecret("prod", "shared-tls", "cert-1")).CoreV1()
+	for _, sni := range ingress.Spec.TLS[0].Hosts {
+		mux.HandleFunc("/certificates/"+sni, func(writer http.ResponseWriter, request *http.Request) {
+			testRequestMatches(t, request, http.MethodGet, nil)
+			writer.WriteHeader(http.StatusNotFound)
+		})
+	}
+	created := 0
+	mux.HandleFunc("/certificates", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, kong.CertificateRequest{
+			Cert: "cert-1",
+			Key:  "key-cert-1",
+			Snis: "a.somedomain,b.somedomain,c.somedomain",
+		})
+		created++
+	})
+
+	if err := reconcileCertificate(kiController, &ingress, &ingress.Spec.TLS[0]); err != nil {
+		t.Fatalf("Unexpected error reconciling certificate: %v", err)
+	}
+	if created != 1 {
+		t.Errorf("%d certificates were created for the hosts of the secret, want one", created)
+	}
+	for _, sni := range ingress.Spec.TLS[0].Hosts {
+		if owner := kiController.sniTracker.owner(sni); owner != "prod/shared-tls" {
+			t.Errorf("SNI '%s' is owned by '%s', want 'prod/shared-tls'", sni, owner)
+		}
+	}
+}
+
+func TestNewHostsOfSecretAddedToItsCertificate(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("secureservice", "prod", "shared-tls")
+	ingress.Spec.TLS[0].Hosts = []string{"a.somedomain", "b.somedomain", "c.somedomain", "d.somedomain"}
+	kiController.CoreClient = fake.NewSimpleClientset(sampleTLSSecret("prod", "shared-tls", "cert-1")).CoreV1()
+	fetches := map[string]int{}
+	sharedCertificate := kong.Certificate{
+		ID:   "shared-cert",
+		Cert: "cert-1",
+		Key:  "key-cert-1",
+		Snis: []string{"a.somedomain", "b.somedomain"},
+	}
+	for _, sni := range ingress.Spec.TLS[0].Hosts {
+		sni := sni
+		mux.HandleFunc("/certificates/"+sni, func(writer http.ResponseWriter, request *http.Request) {
+			testRequestMatches(t, request, http.MethodGet, nil)
+			fetches[sni]++
+			if sni == "a.somedomain" || sni == "b.somedomain" {
+				writeObjectResponse(t, &writer, sharedCertificate)
+				return
+			}
+			writer.WriteHeader(http.StatusNotFound)
+		})
+	}
+	patches := 0
+	mux.HandleFunc("/certificates/shared-cert", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPatch, kong.CertificateRequest{
+			ID:   "shared-cert",
+			Snis: "a.somedomain,b.somedomain,c.somedomain,d.somedomain",
+		})
+		patches++
+	})
+	mux.HandleFunc("/certificates", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Certificate created for the new hosts of a secret that already has one, got %s", request.Method)
+	})
+
+	if err := reconcileCertificate(kiController, &ingress, &ingress.Spec.TLS[0]); err != nil {
+		t.Fatalf("Unexpected error reconciling certificate: %v", err)
+	}
+	if patches != 1 {
+		t.Errorf("Certificate of the secret was patched %d times, want once for all new hosts", patches)
+	}
+	if fetches["b.somedomain"] != 0 {
+		t.Errorf("SNI held by an already fetched certificate was fetched %d times, want none", fetches["b.somedomain"])
+	}
+	if owner := kiController.sniTracker.owner("d.somedomain"); owner != "prod/shared-tls" {
+		t.Errorf("SNI 'd.somedomain' is owned by '%s', want 'prod/shared-tls'", owner)
+	}
+}
+
+func sampleTLSIngress(name string, namespace string, secretName string) v1beta1.Ingress {
+	ingress := sampleIngress(name, namespace)
+	ingress.Spec.TLS = []v1beta1.IngressTLS{
+		{
+			Hosts:      []string{ingress.Spec.Rules[0].Host},
+			SecretName: secretName,
+		},
+	}
+	return ingress
+}
+
+func sampleTLSSecret(namespace string, name string, cert string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:       []byte(cert),
+			v1.TLSPrivateKeyKey: []byte("key-" + cert),
+		},
+	}
+}
+
+func selfSignedCertificate(t *testing.T, notBefore time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(notBefore.Unix()),
+		Subject:      pkix.Name{CommonName: "rotatedservice.somedomain"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
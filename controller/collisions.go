@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"strings"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+// routeClaims maps what the paths of managed ingresses register in kong, their routes and api names, to the key of the
+// ingress that claimed each first
+type routeClaims map[string]string
+
+// earlierRouteClaims returns the routes and api names claimed by the managed ingresses that take precedence over the
+// ingress. Ingresses in different namespaces get apis of different names, so without this two of them with the same
+// host and path would both be created, leaving kong to pick either for their requests.
+func earlierRouteClaims(controller *KongIngressController, ingress *v1beta1.Ingress) routeClaims {
+	claims := routeClaims{}
+	for _, other := range controller.cachedIngresses() {
+		if !claimsBefore(other, ingress) {
+			continue
+		}
+		if fairGame, _ := ingressIsFairGame(controller, other); !fairGame {
+			continue
+		}
+		annotations := parseAnnotations(other)
+		for _, path := range getIngressPaths(other) {
+			for _, claim := range pathClaims(controller, other, path, annotations) {
+				if _, found := claims[claim]; !found {
+					claims[claim] = getIngressKey(other)
+				}
+			}
+		}
+	}
+	return claims
+}
+
+// conflictingIngress returns the key of the ingress that claimed the route or api name of the path first, or an empty
+// string when the path is free to be reconciled
+func (claims routeClaims) conflictingIngress(controller *KongIngressController, ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) string {
+	for _, claim := range pathClaims(controller, ingress, path, annotations) {
+		if owner, found := claims[claim]; found {
+			return owner
+		}
+	}
+	return ""
+}
+
+// claimsBefore reports whether other takes precedence over ingress for a route both claim. The older ingress wins, so
+// that an ingress already serving a route keeps it when another is created for it, and ties go to the lower key.
+func claimsBefore(other *v1beta1.Ingress, ingress *v1beta1.Ingress) bool {
+	otherKey, key := getIngressKey(other), getIngressKey(ingress)
+	if otherKey == key {
+		return false
+	}
+	otherCreated, created := other.ObjectMeta.CreationTimestamp.Time, ingress.ObjectMeta.CreationTimestamp.Time
+	if !otherCreated.Equal(created) {
+		return otherCreated.Before(created)
+	}
+	return otherKey < key
+}
+
+// pathClaims returns the api name of a path and its routes, one for each host it matches with its uri. A path without
+// hosts matches every host.
+func pathClaims(controller *KongIngressController, ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) []string {
+	uri := strings.TrimSuffix(path.path, "/")
+	if uri == "" {
+		uri = "/"
+	}
+	claims := []string{"api " + getAPIName(controller, ingress, path)}
+	hosts := sortedUniqueHosts(getAPIHosts(ingress, path, annotations))
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	for _, host := range hosts {
+		claims = append(claims, "route "+host+uri)
+	}
+	return claims
+}
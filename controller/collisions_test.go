@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestIngressWithClaimedRouteIsRefused(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	recorder := record.NewFakeRecorder(10)
+	kiController.Recorder = recorder
+
+	owner := sampleIngress("shopservice", "prod")
+	owner.ObjectMeta.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	// The same host and path in another namespace gets an api of a different name but the same route
+	conflicting := sampleIngress("shopservice", "staging")
+	conflicting.ObjectMeta.CreationTimestamp = metav1.NewTime(time.Now())
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&owner)
+	ingressStore.Add(&conflicting)
+	kiController.ingressStore = ingressStore
+
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Unexpected request %s %s for an ingress whose route is claimed", request.Method, request.URL.Path)
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+
+	result, err := kiController.ReconcileIngress(context.Background(), &conflicting)
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling ingress: %v", err)
+	}
+	if len(result.Paths) != 1 || result.Paths[0].Action != APIConflict {
+		t.Errorf("Reconcile result is %+v, want the path refused for its conflict", result)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning RouteConflict") || !strings.Contains(event, getIngressKey(&owner)) {
+			t.Errorf("Unexpected event for ingress with a claimed route: %s", event)
+		}
+	default:
+		t.Error("No warning event raised for ingress with a claimed route")
+	}
+}
+
+func TestOlderIngressKeepsItsRoute(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	owner := sampleIngress("shopservice", "staging")
+	owner.ObjectMeta.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	newer := sampleIngress("shopservice", "prod")
+	newer.ObjectMeta.CreationTimestamp = metav1.NewTime(time.Now())
+	other := sampleIngress("cartservice", "prod")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&owner)
+	ingressStore.Add(&newer)
+	ingressStore.Add(&other)
+	kiController.ingressStore = ingressStore
+
+	path := getIngressPaths(&owner)[0]
+	if conflicting := earlierRouteClaims(kiController, &owner).conflictingIngress(kiController, &owner, path, parseAnnotations(&owner)); conflicting != "" {
+		t.Errorf("Route of the oldest ingress is claimed by '%s', want it kept", conflicting)
+	}
+	path = getIngressPaths(&newer)[0]
+	if conflicting := earlierRouteClaims(kiController, &newer).conflictingIngress(kiController, &newer, path, parseAnnotations(&newer)); conflicting != getIngressKey(&owner) {
+		t.Errorf("Route of the newer ingress is claimed by '%s', want '%s'", conflicting, getIngressKey(&owner))
+	}
+
+	// Paths only differing in a trailing slash are the same route
+	newer.Spec.Rules[0].HTTP.Paths[0].Path = "/shop"
+	owner.Spec.Rules[0].HTTP.Paths[0].Path = "/shop/"
+	path = getIngressPaths(&newer)[0]
+	if conflicting := earlierRouteClaims(kiController, &newer).conflictingIngress(kiController, &newer, path, parseAnnotations(&newer)); conflicting != getIngressKey(&owner) {
+		t.Errorf("Route '/shop' is claimed by '%s', want '%s' with '/shop/'", conflicting, getIngressKey(&owner))
+	}
+}
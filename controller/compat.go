@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/nccurry/go-kong/kong"
+	"github.com/pkg/errors"
+)
+
+// KongNodeInfo is the subset of the information served at the root of the kong admin API used to check compatibility
+type KongNodeInfo struct {
+	Version string `json:"version"`
+	Plugins struct {
+		AvailableOnServer map[string]bool `json:"available_on_server"`
+	} `json:"plugins"`
+}
+
+// FeatureCompatibility describes whether a controller feature works with the connected kong
+type FeatureCompatibility struct {
+	Feature   string
+	Supported bool
+	Detail    string
+}
+
+// CompatibilityReport lists which controller features the connected kong supports
+type CompatibilityReport struct {
+	Version          string
+	Features         []FeatureCompatibility
+	AvailablePlugins []string
+}
+
+type kongVersion struct {
+	major int
+	minor int
+	patch int
+}
+
+var kongVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// CheckKongCompatibility queries the connected kong for its version and reports which controller features it supports
+func CheckKongCompatibility(kongClient *kong.Client) (*CompatibilityReport, error) {
+	req, err := kongClient.NewRequest(http.MethodGet, "", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to build kong node information request")
+	}
+
+	info := KongNodeInfo{}
+	_, err = kongClient.Do(req, &info)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to fetch kong node information")
+	}
+
+	return NewCompatibilityReport(&info)
+}
+
+// NewCompatibilityReport derives the compatibility of controller features from kong's node information
+func NewCompatibilityReport(info *KongNodeInfo) (*CompatibilityReport, error) {
+	version, err := parseKongVersion(info.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	report := CompatibilityReport{
+		Version: info.Version,
+		Features: []FeatureCompatibility{
+			{
+				Feature:   "Legacy apis entity (-kong-api-model=apis)",
+				Supported: version.atLeast(0, 10) && !version.atLeast(1, 0),
+				Detail:    apisDetail(version),
+			},
+			{
+				Feature:   "API matching on hosts and uris",
+				Supported: version.atLeast(0, 10) && !version.atLeast(1, 0),
+				Detail:    "requires Kong 0.10 up to but excluding 1.0",
+			},
+			{
+				Feature:   "Services and routes entities (-kong-api-model=services)",
+				Supported: version.atLeast(0, 13),
+				Detail:    "requires Kong 0.13 or later",
+			},
+			{
+				Feature:   "Route matching on hosts and paths",
+				Supported: version.atLeast(0, 13),
+				Detail:    "requires Kong 0.13 or later",
+			},
+			{
+				Feature:   "Certificates and SNIs",
+				Supported: version.atLeast(0, 10),
+				Detail:    "requires Kong 0.10 or later",
+			},
+			{
+				Feature:   "Upstreams with active health checks (use-upstream annotation)",
+				Supported: version.atLeast(0, 12),
+				Detail:    "requires Kong 0.12 or later",
+			},
+		},
+	}
+
+	for plugin, available := range info.Plugins.AvailableOnServer {
+		if available {
+			report.AvailablePlugins = append(report.AvailablePlugins, plugin)
+		}
+	}
+	sort.Strings(report.AvailablePlugins)
+
+	return &report, nil
+}
+
+func (report *CompatibilityReport) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Kong version: %s\n", report.Version)
+	for _, feature := range report.Features {
+		supported := "no"
+		if feature.Supported {
+			supported = "yes"
+		}
+		fmt.Fprintf(&buf, "  %-56s %-3s (%s)\n", feature.Feature, supported, feature.Detail)
+	}
+	fmt.Fprintf(&buf, "Plugins available: %v\n", report.AvailablePlugins)
+
+	return buf.String()
+}
+
+func apisDetail(version kongVersion) string {
+	switch {
+	case version.atLeast(1, 0):
+		return "removed in Kong 1.0"
+	case version.atLeast(0, 13):
+		return "deprecated since Kong 0.13"
+	default:
+		return "requires Kong 0.10 up to but excluding 1.0"
+	}
+}
+
+// parseKongVersion understands versions like 0.11.2, 0.13.0rc1 and 0.33-enterprise-edition
+func parseKongVersion(version string) (kongVersion, error) {
+	matches := kongVersionPattern.FindStringSubmatch(version)
+	if matches == nil {
+		return kongVersion{}, errors.Errorf("Unrecognized kong version '%s'", version)
+	}
+
+	parsed := kongVersion{}
+	parsed.major, _ = strconv.Atoi(matches[1])
+	parsed.minor, _ = strconv.Atoi(matches[2])
+	if matches[3] != "" {
+		parsed.patch, _ = strconv.Atoi(matches[3])
+	}
+
+	return parsed, nil
+}
+
+func (version kongVersion) atLeast(major int, minor int) bool {
+	return version.major > major || (version.major == major && version.minor >= minor)
+}
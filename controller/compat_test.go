@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const sampleKongNodeInfo = `{
+	"version": "0.11.2",
+	"tagline": "Welcome to kong",
+	"plugins": {
+		"available_on_server": {
+			"rate-limiting": true,
+			"cors": true,
+			"statsd": false
+		},
+		"enabled_in_cluster": []
+	}
+}`
+
+func TestKongCompatibilityReport(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		fmt.Fprint(writer, sampleKongNodeInfo)
+	})
+
+	report, err := CheckKongCompatibility(kongClient)
+	if err != nil {
+		t.Fatalf("Unexpected error checking kong compatibility: %v", err)
+	}
+
+	if report.Version != "0.11.2" {
+		t.Errorf("Kong version is '%s', want '0.11.2'", report.Version)
+	}
+	expectedSupport := map[string]bool{
+		"Legacy apis entity (-kong-api-model=apis)":               true,
+		"API matching on hosts and uris":                          true,
+		"Services and routes entities (-kong-api-model=services)": false,
+		"Route matching on hosts and paths":                       false,
+		"Certificates and SNIs":                                   true,
+	}
+	for _, feature := range report.Features {
+		if supported, found := expectedSupport[feature.Feature]; !found || supported != feature.Supported {
+			t.Errorf("Feature '%s' supported: %v, want %v", feature.Feature, feature.Supported, supported)
+		}
+	}
+	if got := strings.Join(report.AvailablePlugins, ","); got != "cors,rate-limiting" {
+		t.Errorf("Available plugins are '%s', want 'cors,rate-limiting'", got)
+	}
+}
+
+func TestParseKongVersion(t *testing.T) {
+	versions := map[string]kongVersion{
+		"0.11.2":                  {0, 11, 2},
+		"0.13.0rc1":               {0, 13, 0},
+		"1.0":                     {1, 0, 0},
+		"0.33-enterprise-edition": {0, 33, 0},
+	}
+	for version, expected := range versions {
+		parsed, err := parseKongVersion(version)
+		if err != nil || parsed != expected {
+			t.Errorf("Parsed version '%s' as %v (%v), want %v", version, parsed, err, expected)
+		}
+	}
+
+	if _, err := parseKongVersion("next"); err == nil {
+		t.Error("Expected an error parsing a version without a number")
+	}
+}
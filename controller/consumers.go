@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/pkg/errors"
+)
+
+// The annotations that protect the apis of an ingress with kong's key-auth plugin. The consumers secret holds an entry
+// per consumer whose value is its keys, one per line.
+const (
+	authAnnotation                = annotationPrefix + "auth"
+	authConsumersSecretAnnotation = annotationPrefix + "auth-consumers-secret"
+
+	authKeyAuth   = "key-auth"
+	keyAuthPlugin = "key-auth"
+
+	auditEntityConsumer   = "consumer"
+	auditEntityCredential = "key-auth credential"
+)
+
+func init() {
+	knownAnnotations[authAnnotation] = validateAuth
+	knownAnnotations[authConsumersSecretAnnotation] = validateNotEmpty
+}
+
+func validateAuth(value string) error {
+	if value != authKeyAuth {
+		return errors.Errorf("must be '%s'", authKeyAuth)
+	}
+	return nil
+}
+
+func validateNotEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("must not be empty")
+	}
+	return nil
+}
+
+// kongConsumer is a consumer of kong apis. The controller finds the consumers it made for a secret by their custom id.
+type kongConsumer struct {
+	ID       string `json:"id,omitempty"`
+	Username string `json:"username"`
+	CustomID string `json:"custom_id"`
+}
+
+// kongConsumerList is a page of consumers, with the offset of the next page unless it is the last
+type kongConsumerList struct {
+	Data   []kongConsumer `json:"data"`
+	Offset string         `json:"offset,omitempty"`
+}
+
+// consumersPageSize is how many consumers are asked for at a time, the most kong answers with
+const consumersPageSize = 1000
+
+// keyAuthCredential is a key a consumer authenticates to the key-auth plugin with
+type keyAuthCredential struct {
+	ID  string `json:"id,omitempty"`
+	Key string `json:"key"`
+}
+
+type keyAuthCredentialList struct {
+	Data []keyAuthCredential `json:"data"`
+}
+
+// desiredKeyAuthConfig asks for the key-auth plugin with kong's default config when the ingress annotates key-auth
+func desiredKeyAuthConfig(annotations ingressAnnotations) map[string]interface{} {
+	if annotations.values[authAnnotation] != authKeyAuth {
+		return nil
+	}
+	return map[string]interface{}{}
+}
+
+// consumersSecretPrefix is the start of the custom ids of the consumers made for the entries of a secret
+func (controller *KongIngressController) consumersSecretPrefix(namespace string, secretName string) string {
+	return controller.ManagedPrefix + namespace + "/" + secretName + "/"
+}
+
+// consumerUsername is the username of the consumer made for an entry of a secret, unique across secrets and namespaces.
+// The parts are separated by a '~', which neither the entries of secrets nor kubernetes names can contain.
+func (controller *KongIngressController) consumerUsername(namespace string, secretName string, entry string) string {
+	return controller.ManagedPrefix + entry + "~" + secretName + "~" + namespace
+}
+
+// reconcileConsumers makes the consumers of the consumers secret of the ingress and their keys match the secret: a
+// consumer for each of its entries holding exactly the keys listed in it. Consumers of entries removed from the secret
+// are deleted, revoking their keys. Consumers are left alone once no ingress references the secret, since another
+// ingress may still.
+func reconcileConsumers(controller *KongIngressController, ingress *v1beta1.Ingress, annotations ingressAnnotations) error {
+	secretName, found := annotations.values[authConsumersSecretAnnotation]
+	if !found {
+		return nil
+	}
+	namespace := ingress.ObjectMeta.Namespace
+	ingressKey := getIngressKey(ingress)
+	secretKey := namespace + "/" + secretName
+	secret, err := controller.CoreClient.Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to fetch consumers secret '%s'", secretKey)
+	}
+
+	consumers, err := listConsumers(controller)
+	if err != nil {
+		return err
+	}
+	prefix := controller.consumersSecretPrefix(namespace, secretName)
+	existing := map[string]kongConsumer{}
+	for _, consumer := range consumers {
+		if strings.HasPrefix(consumer.CustomID, prefix) {
+			existing[strings.TrimPrefix(consumer.CustomID, prefix)] = consumer
+		}
+	}
+
+	entries := []string{}
+	for entry := range secret.Data {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	errs := []error{}
+	for _, entry := range entries {
+		consumer, found := existing[entry]
+		delete(existing, entry)
+		if !found {
+			consumer = kongConsumer{
+				Username: controller.consumerUsername(namespace, secretName, entry),
+				CustomID: prefix + entry,
+			}
+			logging.Infof(ingressFields(ingress).With(logging.Fields{"secret": secretName, "consumer": consumer.Username}), "Creating consumer '%s' for entry '%s' of secret '%s'", consumer.Username, entry, secretKey)
+			if err := doKongRequest(controller, http.MethodPost, "consumers", consumer, &consumer); err != nil {
+				errs = append(errs, errors.Wrapf(err, "Failed to create consumer '%s'", consumer.Username))
+				continue
+			}
+			controller.AuditLog.record(auditCreate, auditEntityConsumer, consumer.Username, ingressKey, consumer)
+			// A dry run answers without the id the keys of the consumer would be added under
+			if consumer.ID == "" {
+				continue
+			}
+		}
+		if err := reconcileConsumerKeys(controller, ingressKey, consumer, secretKeys(secret.Data[entry])); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, consumer := range existing {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"secret": secretName, "consumer": consumer.Username}), "Deleting consumer '%s', whose entry was removed from secret '%s'", consumer.Username, secretKey)
+		if err := doKongRequest(controller, http.MethodDelete, "consumers/"+consumer.ID, nil, nil); err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to delete consumer '%s'", consumer.Username))
+			continue
+		}
+		controller.AuditLog.record(auditDelete, auditEntityConsumer, consumer.Username, ingressKey, consumer)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// listConsumers returns every consumer in kong, following the pages kong splits them into
+func listConsumers(controller *KongIngressController) ([]kongConsumer, error) {
+	consumers := []kongConsumer{}
+	offset := ""
+	for {
+		path := "consumers?size=" + strconv.Itoa(consumersPageSize)
+		if offset != "" {
+			path += "&offset=" + url.QueryEscape(offset)
+		}
+		page := kongConsumerList{}
+		if err := doKongRequest(controller, http.MethodGet, path, nil, &page); err != nil {
+			return nil, errors.Wrap(err, "Failed to get kong consumer list")
+		}
+		consumers = append(consumers, page.Data...)
+		if page.Offset == "" || len(page.Data) == 0 {
+			return consumers, nil
+		}
+		offset = page.Offset
+	}
+}
+
+// secretKeys returns the keys listed in an entry of a consumers secret, one per line
+func secretKeys(value []byte) map[string]bool {
+	keys := map[string]bool{}
+	for _, line := range strings.Split(string(value), "\n") {
+		if key := strings.TrimSpace(line); key != "" {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+// reconcileConsumerKeys adds the keys the consumer is missing and revokes those it should no longer have. Keys are
+// never logged or audited, only the ids of their credentials.
+func reconcileConsumerKeys(controller *KongIngressController, ingressKey string, consumer kongConsumer, keys map[string]bool) error {
+	credentials := keyAuthCredentialList{}
+	path := "consumers/" + consumer.ID + "/key-auth"
+	if err := doKongRequest(controller, http.MethodGet, path, nil, &credentials); err != nil {
+		return errors.Wrapf(err, "Failed to fetch the keys of consumer '%s'", consumer.Username)
+	}
+
+	errs := []error{}
+	for _, credential := range credentials.Data {
+		if keys[credential.Key] {
+			delete(keys, credential.Key)
+			continue
+		}
+		logging.Infof(keyFields(ingressKey).With(logging.Fields{"consumer": consumer.Username}), "Revoking key '%s' of consumer '%s'", credential.ID, consumer.Username)
+		if err := doKongRequest(controller, http.MethodDelete, path+"/"+credential.ID, nil, nil); err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to revoke key '%s' of consumer '%s'", credential.ID, consumer.Username))
+			continue
+		}
+		controller.AuditLog.record(auditDelete, auditEntityCredential, consumer.Username, ingressKey, map[string]string{"id": credential.ID})
+	}
+	for key := range keys {
+		created, err := addConsumerKey(controller, path, key)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to add a key to consumer '%s'", consumer.Username))
+			continue
+		}
+		logging.Infof(keyFields(ingressKey).With(logging.Fields{"consumer": consumer.Username}), "Added key '%s' to consumer '%s'", created.ID, consumer.Username)
+		controller.AuditLog.record(auditCreate, auditEntityCredential, consumer.Username, ingressKey, map[string]string{"id": created.ID})
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// addConsumerKey adds the key to the credentials at the path. Kong echoes the key in the errors it answers with, so only
+// the status of a failure is returned.
+func addConsumerKey(controller *KongIngressController, path string, key string) (keyAuthCredential, error) {
+	created := keyAuthCredential{}
+	req, err := controller.KongClient.NewRequest(http.MethodPost, path, keyAuthCredential{Key: key})
+	if err != nil {
+		return created, errors.New("Failed to build the request")
+	}
+	resp, err := controller.KongClient.Do(req, &created)
+	if err != nil {
+		if resp == nil {
+			return created, errors.New("Kong could not be reached")
+		}
+		return created, errors.Errorf("Kong answered %s", resp.Status)
+	}
+	return created, nil
+}
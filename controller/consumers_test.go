@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestConsumersMatchSecret(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.CoreClient = fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-consumers", Namespace: "prod"},
+		Data: map[string][]byte{
+			"alice": []byte("key-1\nkey-2\n"),
+			"bob":   []byte("key-3"),
+		},
+	}).CoreV1()
+
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{authAnnotation: authKeyAuth, authConsumersSecretAnnotation: "shop-consumers"}
+
+	mutex := sync.Mutex{}
+	changes := []string{}
+	change := func(request *http.Request, body string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changes = append(changes, request.Method+" "+request.URL.Path+" "+body)
+	}
+	mux.HandleFunc("/consumers", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongConsumerList{Data: []kongConsumer{
+				{ID: "consumer-alice", Username: "alice~shop-consumers~prod", CustomID: "prod/shop-consumers/alice"},
+				{ID: "consumer-carol", Username: "carol~shop-consumers~prod", CustomID: "prod/shop-consumers/carol"},
+				{ID: "consumer-dave", Username: "dave", CustomID: "prod/other-consumers/dave"},
+			}})
+		case http.MethodPost:
+			consumer := kongConsumer{}
+			if err := json.NewDecoder(request.Body).Decode(&consumer); err != nil {
+				t.Fatalf("Error decoding consumer: %v", err)
+			}
+			change(request, consumer.Username+" "+consumer.CustomID)
+			consumer.ID = "consumer-bob"
+			writer.WriteHeader(http.StatusCreated)
+			json.NewEncoder(writer).Encode(consumer)
+		}
+	})
+	credentials := map[string][]keyAuthCredential{
+		"consumer-alice": {{ID: "credential-1", Key: "key-1"}, {ID: "credential-old", Key: "key-old"}},
+		"consumer-bob":   {},
+	}
+	for consumerID := range credentials {
+		consumerID := consumerID
+		mux.HandleFunc("/consumers/"+consumerID+"/key-auth", func(writer http.ResponseWriter, request *http.Request) {
+			switch request.Method {
+			case http.MethodGet:
+				writeObjectResponse(t, &writer, keyAuthCredentialList{Data: credentials[consumerID]})
+			case http.MethodPost:
+				credential := keyAuthCredential{}
+				if err := json.NewDecoder(request.Body).Decode(&credential); err != nil {
+					t.Fatalf("Error decoding credential: %v", err)
+				}
+				change(request, credential.Key)
+				writer.WriteHeader(http.StatusCreated)
+				json.NewEncoder(writer).Encode(keyAuthCredential{ID: "credential-" + credential.Key, Key: credential.Key})
+			}
+		})
+	}
+	mux.HandleFunc("/consumers/consumer-alice/key-auth/credential-old", func(writer http.ResponseWriter, request *http.Request) {
+		change(request, "")
+		writer.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/consumers/consumer-carol", func(writer http.ResponseWriter, request *http.Request) {
+		change(request, "")
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := reconcileConsumers(kiController, &ingress, parseAnnotations(&ingress)); err != nil {
+		t.Fatalf("Unexpected error reconciling consumers: %v", err)
+	}
+
+	sort.Strings(changes)
+	expected := []string{
+		"DELETE /consumers/consumer-alice/key-auth/credential-old ",
+		"DELETE /consumers/consumer-carol ",
+		"POST /consumers bob~shop-consumers~prod prod/shop-consumers/bob",
+		"POST /consumers/consumer-alice/key-auth key-2",
+		"POST /consumers/consumer-bob/key-auth key-3",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Consumers changed with\n%s\nwant\n%s", strings.Join(changes, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestKeyAuthPluginOnlyForKeyAuth(t *testing.T) {
+	ingress := sampleIngress("shopservice", "prod")
+	if config := desiredKeyAuthConfig(parseAnnotations(&ingress)); config != nil {
+		t.Errorf("Ingress without the auth annotation asks for key-auth config %v, want none", config)
+	}
+	ingress.ObjectMeta.Annotations = map[string]string{authAnnotation: authKeyAuth}
+	if config := desiredKeyAuthConfig(parseAnnotations(&ingress)); config == nil {
+		t.Error("Ingress annotated with key-auth does not ask for the key-auth plugin")
+	}
+	if err := validateAuth("basic-auth"); err == nil {
+		t.Error("Expected an error validating auth 'basic-auth'")
+	}
+}
+
+func TestConsumersListedAcrossPages(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	pages := map[string]kongConsumerList{
+		"":       {Data: []kongConsumer{{ID: "consumer-1", CustomID: "prod/shop-consumers/alice"}}, Offset: "page-2"},
+		"page-2": {Data: []kongConsumer{{ID: "consumer-2", CustomID: "prod/shop-consumers/bob"}}},
+	}
+	mux.HandleFunc("/consumers", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		if size := request.URL.Query().Get("size"); size != "1000" {
+			t.Errorf("Consumers listed %s at a time, want 1000", size)
+		}
+		writeObjectResponse(t, &writer, pages[request.URL.Query().Get("offset")])
+	})
+
+	consumers, err := listConsumers(kiController)
+	if err != nil {
+		t.Fatalf("Unexpected error listing consumers: %v", err)
+	}
+	if len(consumers) != 2 || consumers[0].ID != "consumer-1" || consumers[1].ID != "consumer-2" {
+		t.Errorf("Listed consumers %v, want those of both pages", consumers)
+	}
+}
+
+func TestConsumerUsernamesUniqueForDottedNames(t *testing.T) {
+	kiController := New(nil, nil, nil)
+	if first, second := kiController.consumerUsername("prod", "c", "a.b"), kiController.consumerUsername("prod", "b.c", "a"); first == second {
+		t.Errorf("Entry 'a.b' of secret 'c' and entry 'a' of secret 'b.c' both get username '%s'", first)
+	}
+}
@@ -3,15 +3,29 @@ package controller
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
-	"github.com/golang/glog"
+	"github.com/SprintHive/kong-ingress-controller/logging"
 	"github.com/nccurry/go-kong/kong"
 	"github.com/pkg/errors"
 )
@@ -19,251 +33,1437 @@ import (
 // KongIngressController watches ingress updates and makes corresponding changes to the service proxy
 type KongIngressController struct {
 	IngressClient cache.Getter
+	CoreClient    corev1.CoreV1Interface
 	KongClient    *kong.Client
+	// Resource is the kind of resource watched for the routes to configure in kong, ResourceIngress or ResourceHTTPRoute
+	Resource string
+	// HTTPRouteClient fetches HTTPRoutes when they are the watched resource
+	HTTPRouteClient cache.Getter
+	// KongAPIModel is the model of kong entities each api is represented by, KongAPIModelAPIs or KongAPIModelServices
+	KongAPIModel string
+	// DefaultRequestBuffering and DefaultResponseBuffering set the buffering of the routes of the services model whose
+	// ingress does not annotate it, leaving kong's default when nil
+	DefaultRequestBuffering  *bool
+	DefaultResponseBuffering *bool
+	// AuditLog optionally records every change made to Kong
+	AuditLog *AuditLog
+	// PatchStrategy decides how drifted apis are patched: PatchStrategyField sends a patch per drifted field, while
+	// PatchStrategyMerge sends a single patch with just the drifted fields
+	PatchStrategy string
+	// ManagedFields lists the api fields whose drift is corrected, leaving manual changes to the others in place.
+	// When empty every field in ManageableAPIFields is managed.
+	ManagedFields []string
+	// EnforceDefaults corrects drift of settings the controller only defaults, like preserve_host, when neither an
+	// annotation nor an override of the ingress sets them. Without it such settings are left as they were changed in kong.
+	EnforceDefaults bool
+	// RecreateOnImmutable recreates apis when kong refuses to patch their drifted fields, instead of failing the reconcile
+	RecreateOnImmutable bool
+	// MaxPathsPerIngress refuses to reconcile ingresses with more paths than this, to protect kong from pathological objects.
+	// Zero disables the limit.
+	MaxPathsPerIngress int
+	// OverrideClient optionally fetches the KongIngress custom resources named by override annotations. Without it
+	// overrides are read from config maps.
+	OverrideClient cache.Getter
+	// PluginClient optionally watches the KongPlugin custom resources named by plugins annotations. Without it the
+	// annotation is ignored.
+	PluginClient cache.Getter
+	// IngressClass is the ingress class claimed by this controller
+	IngressClass string
+	// ClaimUnsetClass claims ingresses without an ingress class as well as those of IngressClass
+	ClaimUnsetClass bool
+	// RequireOptIn only handles ingresses that opt in with the managed annotation, whatever their class
+	RequireOptIn bool
+	// LogIgnored logs and counts ingresses that are skipped because they belong to another controller or cannot be
+	// represented in kong
+	LogIgnored bool
+	// NamespaceMetrics labels metrics with the namespace of the ingress they relate to
+	NamespaceMetrics bool
+	// Recorder optionally publishes events about ingresses the controller could not fully reconcile
+	Recorder record.EventRecorder
+	// InventoryFile optionally receives a snapshot of the apis and certificates the controller manages every reap cycle
+	InventoryFile string
+	// InformerHealthyTimeout is how long the informer may show no activity before CheckInformerHealthy fails.
+	// Zero disables the check.
+	InformerHealthyTimeout time.Duration
+	// KongMaxRetries is how many times a kong admin call that fails transiently is retried within a reconcile, waiting
+	// KongRetryBackoff before the first retry and twice as long before each one after. Zero disables retries.
+	KongMaxRetries   int
+	KongRetryBackoff time.Duration
+	// Workers is how many ingresses are reconciled at once. At least one worker runs.
+	Workers int
+	// PublishService optionally names the namespace/name of the kong proxy service whose load balancer address is
+	// written into the status of managed ingresses through StatusClient, a REST client for ingresses
+	PublishService string
+	StatusClient   rest.Interface
+	// ManagedPrefix is put in front of the name of every api the controller creates, and the reaper only deletes apis
+	// named with it, so that apis created by hand or by other controllers are left alone. Empty reaps every orphan.
+	ManagedPrefix string
+	// WatchNamespaces limits the controller to the ingresses of these namespaces, leaving the apis of other namespaces
+	// alone when reaping. All namespaces are watched when it is empty.
+	WatchNamespaces []string
+	// LoopStallTimeout is how long the reaper loop may overrun FullResyncInterval before CheckLoopHealthy fails.
+	// Zero disables the check.
+	LoopStallTimeout time.Duration
+	// ResolveUpstreams checks that the upstream host of each ingress resolves, raising a warning event when it does not
+	ResolveUpstreams bool
+	// Resolver looks up upstream hosts when ResolveUpstreams is set
+	Resolver Resolver
+	// StartupReconcileQPS limits how fast reconciles start when the controller starts, ramping up to unthrottled over
+	// StartupWarmup. Zero disables the limit.
+	StartupReconcileQPS float64
+	StartupWarmup       time.Duration
+	// RequestMutators are applied in order to the api derived from each ingress before it is sent to kong, so that
+	// embedders can enforce their own conventions. Drift is detected against the mutated api.
+	RequestMutators []RequestMutator
+	// ReaperTimeBudget bounds how long a reap cycle spends deleting orphaned apis. Orphans left when it runs out are reaped
+	// in the next cycle. Zero disables the limit.
+	ReaperTimeBudget time.Duration
+	// ReaperStaleTimeout is how long may pass without a successful reap cycle before CheckReaperHealthy fails.
+	// Zero disables the check.
+	ReaperStaleTimeout time.Duration
+	// ReapMaxDelete and ReapMaxDeletePercent limit how many of the managed apis in kong a reap cycle may delete, as a
+	// count and as a percentage of them. A cycle that would delete more deletes none, since that usually means the
+	// ingresses were not all listed. Zero disables a limit.
+	ReapMaxDelete        int
+	ReapMaxDeletePercent int
+	// ReapInterval is how often orphaned apis are reaped, on a schedule of its own with some jitter so that it need not
+	// follow the resync. New sets it to FullResyncInterval. Zero disables the reaper, leaving orphaned apis in kong both
+	// on startup and afterwards, so that nothing is deleted but the apis of ingresses the informer sees deleted.
+	ReapInterval time.Duration
+	// CreateGraceDelay holds back the first reconcile of an ingress until it is this old, giving the services and secrets
+	// created alongside it time to appear. Updates are reconciled immediately. Zero disables the delay.
+	CreateGraceDelay time.Duration
+	// ForceReconcileInterval is how long a resync may skip an ingress whose resource version has not changed since it was
+	// last reconciled. Zero reconciles every resync in full.
+	ForceReconcileInterval time.Duration
+	// SNIConflictPolicy decides which secret keeps an SNI claimed by more than one secret
+	SNIConflictPolicy string
+	// WatchEndpoints watches the endpoints of the watched namespaces through CoreClient, updating the targets of
+	// upstreams as soon as their pods change rather than when their ingresses are next reconciled
+	WatchEndpoints bool
+	// AnnotateReconcileErrors writes the error of the last failed reconcile of each ingress into its last-error
+	// annotation through StatusClient, removing it once the ingress reconciles successfully again
+	AnnotateReconcileErrors bool
+	// StatsdPlugin is the host:port of a statsd server the statsd plugin of every api sends its metrics to, unless the
+	// ingress of the api annotates a server of its own. Empty leaves apis without the plugin unless they annotate one.
+	StatsdPlugin string
+	// DefaultPlugins holds the config of each plugin to configure on every managed api, keyed by the name of the plugin.
+	// The annotations and KongPlugins of an ingress override the config of a default plugin, and disable-plugins leaves
+	// it off the apis of the ingress.
+	DefaultPlugins map[string]map[string]interface{}
+
+	sniTracker      sniTracker
+	startupThrottle reconcileThrottle
+	reconciled      reconciledVersions
+
+	// ingressStore is the informers' cache of ingresses, shared by the workers, the reaper and the handlers of the other
+	// informers, and ingressesSynced reports whether the informers have listed every ingress. createWatches publishes
+	// both while the health checks and the KongPlugin handlers may already be running, so they are only accessed under
+	// storeMutex.
+	storeMutex      sync.Mutex
+	ingressStore    ingressCache
+	ingressesSynced cache.InformerSynced
+
+	activityMutex    sync.Mutex
+	informerActivity time.Time
+	loopActivity     time.Time
+
+	reaperMutex       sync.Mutex
+	reaperLastSuccess time.Time
+	reaperLastFailure time.Time
+
+	drainMutex sync.Mutex
+	draining   bool
+	inFlight   sync.WaitGroup
+
+	// running tracks the informer, worker and reaper goroutines, which Run waits for before returning
+	running sync.WaitGroup
+
+	// queue holds the keys of changed ingresses for the workers, and deleted their last known state once deleted
+	queue   workqueue.RateLimitingInterface
+	deleted deletedObjects
+	// kongPluginStore caches the watched KongPlugins, and attachedPlugins the plugins configured from them on each api
+	kongPluginStore ingressCache
+	attachedPlugins attachedPlugins
+	// apiSnapshot holds the apis listed by the last reap cycle for the resync after it
+	apiSnapshot apiSnapshot
+	// processing counts the keys the workers are reconciling, which the queue no longer holds
+	processing int32
+	// startingUp is set from when Run starts until the startup reconcile has brought kong in line with the ingresses
+	startingUp int32
+
+	publishedAddress publishedAddress
 }
 
+// RequestMutator changes the api derived from an ingress before it is sent to kong
+type RequestMutator func(apiRequest *kong.ApiRequest, ingress *v1beta1.Ingress)
+
 // New returns an instance of a KongIngressController
-func New(ingressClient cache.Getter, kongClient *kong.Client) *KongIngressController {
+func New(ingressClient cache.Getter, coreClient corev1.CoreV1Interface, kongClient *kong.Client) *KongIngressController {
 	return &KongIngressController{
-		ingressClient,
-		kongClient,
+		IngressClient:          ingressClient,
+		CoreClient:             coreClient,
+		KongClient:             kongClient,
+		Resource:               ResourceIngress,
+		KongAPIModel:           KongAPIModelAPIs,
+		IngressClass:           DefaultIngressClass,
+		ClaimUnsetClass:        true,
+		SNIConflictPolicy:      SNIConflictFirstWins,
+		MaxPathsPerIngress:     DefaultMaxPathsPerIngress,
+		InformerHealthyTimeout: DefaultInformerHealthyTimeout,
+		LoopStallTimeout:       DefaultLoopStallTimeout,
+		KongMaxRetries:         DefaultKongMaxRetries,
+		KongRetryBackoff:       DefaultKongRetryBackoff,
+		Workers:                DefaultWorkers,
+		Resolver:               net.DefaultResolver,
+		StartupReconcileQPS:    DefaultStartupReconcileQPS,
+		StartupWarmup:          DefaultStartupWarmup,
+		ForceReconcileInterval: DefaultForceReconcileInterval,
+		ReapInterval:           FullResyncInterval,
 	}
 }
 
+const (
+	// ingressClassAnnotation selects the ingress controller responsible for an ingress
+	ingressClassAnnotation = "kubernetes.io/ingress.class"
+	// managedAnnotation opts an ingress in to being handled when RequireOptIn is set
+	managedAnnotation = "kong.managed"
+	// stripURIAnnotation strips the matched path from requests before they are forwarded, when set to "true"
+	stripURIAnnotation = annotationPrefix + "strip-uri"
+	// preserveHostAnnotation forwards the Host header of requests unless set to "false"
+	preserveHostAnnotation = annotationPrefix + "preserve-host"
+	// upstreamSchemeAnnotation is the scheme kong talks to the backend service with, http or https
+	upstreamSchemeAnnotation = annotationPrefix + "upstream-scheme"
+	// upstreamServiceAnnotation names a service in the namespace of the ingress that kong forwards to instead of the
+	// backend service, for instance a canary, leaving the backend of the ingress as it is
+	upstreamServiceAnnotation = annotationPrefix + "upstream-service"
+	// upstreamPathAnnotation is the path kong forwards requests to on the backend service, in front of the uri of the
+	// request less the path of the ingress when it is stripped
+	upstreamPathAnnotation = annotationPrefix + "upstream-path"
+	// The timeouts in milliseconds kong waits for the backend service to accept a connection, and between two reads
+	// from or writes to it, before failing the request
+	connectTimeoutAnnotation = annotationPrefix + "connect-timeout"
+	readTimeoutAnnotation    = annotationPrefix + "read-timeout"
+	writeTimeoutAnnotation   = annotationPrefix + "write-timeout"
+	// retriesAnnotation is how many times kong retries a request against the backend service when it fails to connect
+	retriesAnnotation = annotationPrefix + "retries"
+	// methodsAnnotation restricts the apis of the ingress to the comma separated HTTP methods, like GET,HEAD
+	methodsAnnotation = annotationPrefix + "methods"
+)
+
+const (
+	upstreamSchemeHTTP  = "http"
+	upstreamSchemeHTTPS = "https"
+)
+
+const (
+	// PatchStrategyField patches each drifted field of an api separately
+	PatchStrategyField = "field"
+	// PatchStrategyMerge patches all the drifted fields of an api with a single minimal merge patch
+	PatchStrategyMerge = "merge"
+)
+
+// ManageableAPIFields are the api fields whose drift the controller can correct
+var ManageableAPIFields = []string{
+	"upstream_url",
+	"hosts",
+	"uris",
+	"preserve_host",
+	"strip_uri",
+	"https_only",
+	"upstream_connect_timeout",
+	"upstream_read_timeout",
+	"upstream_send_timeout",
+	"retries",
+	"methods",
+}
+
+// DefaultIngressClass is the ingress class claimed by default
+const DefaultIngressClass = "kong"
+
+// DefaultMaxPathsPerIngress is the default limit on the number of paths an ingress may have before it is refused
+const DefaultMaxPathsPerIngress = 100
+
+// apiNameDisallowedChars matches the characters kong does not accept in api names
+var apiNameDisallowedChars = regexp.MustCompile(`[^a-z0-9._~-]`)
+
 // FullResyncInterval determines how often a a full reconciliation of the kong and ingress configurations is done
 var FullResyncInterval = time.Minute
 
-// Run starts the KongIngressController
+// cacheSyncPollInterval determines how often the startup cleanup checks whether the ingress cache has finished its initial sync
+var cacheSyncPollInterval = 100 * time.Millisecond
+
+// Run starts the KongIngressController and blocks until ctx is done and its informers and reaper have stopped. A reap
+// cycle in progress is finished first, so callers should Stop the controller before cancelling ctx.
 func (controller *KongIngressController) Run(ctx context.Context) error {
-	glog.Infof("Starting watch for Ingress updates")
+	logging.Infof(nil, "Starting watch for Ingress updates")
+	controller.recordInformerActivity()
+	controller.startupThrottle.begin()
 
 	_, err := controller.createWatches(ctx)
 	if err != nil {
 		return errors.Wrap(err, "Failed to register watchers for Ingress resources")
 	}
+	if controller.CoreClient != nil {
+		controller.createSecretWatch(ctx)
+		if controller.WatchEndpoints {
+			controller.createEndpointsWatch(ctx)
+		}
+		if controller.publishesStatus() {
+			controller.createPublishServiceWatch(ctx)
+		}
+	}
 
-	go apiReaper(ctx, controller)
+	atomic.StoreInt32(&controller.startingUp, 1)
+	controller.spawn(func() {
+		if !waitForIngressCache(ctx, controller) {
+			return
+		}
+		if !startupReconcile(ctx, controller) {
+			return
+		}
+		apiReaper(ctx, controller)
+	})
 
 	<-ctx.Done()
+	logging.Infof(nil, "Waiting for the informers and reaper to stop")
+	controller.running.Wait()
 	return ctx.Err()
 }
 
+// spawn runs f in a goroutine that Run waits for before returning
+func (controller *KongIngressController) spawn(f func()) {
+	controller.running.Add(1)
+	go func() {
+		defer controller.running.Done()
+		f()
+	}()
+}
+
+// Stop drains the controller: new ingress events are no longer accepted, and reconciles already in flight are given
+// up to timeout to finish so that Kong is not left half-configured
+func (controller *KongIngressController) Stop(timeout time.Duration) error {
+	controller.drainMutex.Lock()
+	controller.draining = true
+	controller.drainMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		controller.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		logging.Infof(nil, "All in-flight reconciles finished")
+		return nil
+	case <-time.After(timeout):
+		return errors.Errorf("Timed out after %v waiting for in-flight reconciles to finish", timeout)
+	}
+}
+
+// beginReconcile registers an in-flight reconcile, returning false once the controller is draining
+func (controller *KongIngressController) beginReconcile() bool {
+	controller.drainMutex.Lock()
+	defer controller.drainMutex.Unlock()
+	if controller.draining {
+		return false
+	}
+	controller.inFlight.Add(1)
+	return true
+}
+
+func (controller *KongIngressController) endReconcile() {
+	controller.inFlight.Done()
+}
+
+// recordWarning logs a problem with an object and, when a recorder is configured, publishes it as a warning event
+func (controller *KongIngressController) recordWarning(object runtime.Object, reason string, messageFmt string, args ...interface{}) {
+	logging.Warningf(keyFields(objectKey(object)).With(logging.Fields{"reason": reason}), reason+": "+messageFmt, args...)
+	if controller.Recorder != nil {
+		controller.Recorder.Eventf(object, v1.EventTypeWarning, reason, messageFmt, args...)
+	}
+}
+
+// cleanupDeletedIngresses removes the apis of ingresses that were deleted while the controller was not running, for
+// which the informer never delivers a delete. It runs once the ingress cache has synced, rather than waiting a full
+// resync interval for the reaper.
+func cleanupDeletedIngresses(controller *KongIngressController) {
+	if !controller.beginReconcile() {
+		return
+	}
+	defer controller.endReconcile()
+
+	logging.Infof(nil, "Removing apis of ingresses deleted while the controller was not running")
+	if err := reapOrphanedApis(controller); err != nil {
+		logging.Errorf(logging.Fields{"error": err}, "Failed to remove apis of deleted ingresses on startup: %v", err)
+	}
+}
+
+// startupReconcile brings kong in line with the ingresses once the cache has synced, before the reaper loop begins. The
+// apis of ingresses deleted while the controller was down are removed first, then the workers are given a resync
+// interval to reconcile every ingress the informer queued on its initial list. The controller is not ready until this
+// is done, so that it does not take traffic while kong still serves stale routes. It returns false when ctx is done.
+func startupReconcile(ctx context.Context, controller *KongIngressController) bool {
+	if controller.ReapInterval > 0 {
+		cleanupDeletedIngresses(controller)
+	}
+	if !waitForReconcilePass(ctx, controller, FullResyncInterval) {
+		if ctx.Err() != nil {
+			return false
+		}
+		logging.Warningf(nil, "Startup reconcile did not finish within %v, reporting ready with ingresses still queued", FullResyncInterval)
+	}
+	atomic.StoreInt32(&controller.startingUp, 0)
+	logging.Infof(nil, "Startup reconcile finished")
+	return true
+}
+
+// apiReaper periodically deletes apis whose ingress no longer exists, every ReapInterval with some jitter, and brings
+// the status of ingresses up to date every resync. It expects the ingress cache to have synced.
 func apiReaper(ctx context.Context, controller *KongIngressController) {
-	glog.Info("Reaper: watching for orphaned apis to kill")
+	var reap <-chan time.Time
+	if controller.ReapInterval <= 0 {
+		logging.Infof(nil, "Reaper: disabled, orphaned apis are left in kong")
+	} else {
+		logging.Infof(nil, "Reaper: watching for orphaned apis to kill every %v", controller.ReapInterval)
+		reap = time.After(jitterReapInterval(controller.ReapInterval))
+	}
+	resync := time.NewTicker(FullResyncInterval)
+	defer resync.Stop()
 
 	for {
-		glog.V(2).Info("Reaper: Looking for orphaned apis to kill...")
+		controller.recordLoopActivity()
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			err := reapOrphanedApis(controller.KongClient, controller.IngressClient)
-			if err != nil {
-				glog.Errorf("Failed to reap orphaned kong apis: %v", err)
+		case <-resync.C:
+			if !controller.publishesStatus() || !controller.beginReconcile() {
+				break
 			}
+			publishIngressStatuses(controller)
+			controller.endReconcile()
+		case <-reap:
+			reapCycle(ctx, controller)
+			reap = time.After(jitterReapInterval(controller.ReapInterval))
 		}
-
-		glog.V(2).Info("Reaper: Finished reap cycle")
-		time.Sleep(FullResyncInterval)
 	}
 }
 
-func reapOrphanedApis(kongClient *kong.Client, ingressClient cache.Getter) error {
-	kongApis, _, err := kongClient.Apis.GetAll(nil)
+// reapCycle deletes the apis no ingress accounts for once the ingresses queued so far have been reconciled
+func reapCycle(ctx context.Context, controller *KongIngressController) {
+	// The apis of ingresses just created are left alone only once the pass has created them
+	if !waitForReconcilePass(ctx, controller, FullResyncInterval) {
+		logging.V(2).Infof(nil, "Reaper: Reaping before every queued ingress is reconciled")
+	}
+	logging.V(2).Infof(nil, "Reaper: Looking for orphaned apis to kill...")
+	if !controller.beginReconcile() {
+		logging.V(2).Infof(nil, "Reaper: Skipping reap cycle while draining")
+		return
+	}
+	err := reapOrphanedApis(controller)
+	controller.endReconcile()
 	if err != nil {
-		return errors.Wrapf(err, "Failed to get kong api list")
+		logging.Errorf(logging.Fields{"error": err}, "Failed to reap orphaned kong apis: %v", err)
+	}
+	logging.V(2).Infof(nil, "Reaper: Finished reap cycle")
+}
+
+// ParseReapMaxDelete parses a limit on the apis a reap cycle may delete, either a count like "20" or a percentage of
+// the managed apis like "10%", into ReapMaxDelete and ReapMaxDeletePercent
+func ParseReapMaxDelete(value string) (count int, percent int, err error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err = strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, 0, errors.Errorf("Reap limit '%s' must be a percentage between 0%% and 100%%", value)
+		}
+		return 0, percent, nil
+	}
+	count, err = strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0, 0, errors.Errorf("Reap limit '%s' must be a count that is not negative or a percentage", value)
+	}
+	return count, 0, nil
+}
+
+// reapLimitExceeded reports whether deleting the orphans out of the managed apis is more than ReapMaxDelete or
+// ReapMaxDeletePercent allow, along with the limit in apis
+func (controller *KongIngressController) reapLimitExceeded(orphans int, managed int) (int, bool) {
+	if controller.ReapMaxDelete > 0 && orphans > controller.ReapMaxDelete {
+		return controller.ReapMaxDelete, true
+	}
+	if limit := managed * controller.ReapMaxDeletePercent / 100; controller.ReapMaxDeletePercent > 0 && orphans > limit {
+		return limit, true
+	}
+	return 0, false
+}
+
+// refusesReap reports whether deleting the orphaned entities out of the managed ones is more than the reap limit allows,
+// logging a warning and counting the refusal when it is
+func (controller *KongIngressController) refusesReap(entities string, orphans int, managed int) bool {
+	limit, exceeded := controller.reapLimitExceeded(orphans, managed)
+	if !exceeded {
+		return false
+	}
+	logging.Warningf(logging.Fields{"orphans": orphans, "limit": limit},
+		"Reaper: Refusing to reap %d of %d managed kong %s, more than the limit of %d, check that the ingresses are listed correctly",
+		orphans, managed, entities, limit)
+	reaperSafetyTripsCounter.Inc()
+	return true
+}
+
+// jitterReapInterval adds up to a tenth of the interval, so that the replicas of several controllers sharing a kong do
+// not all list and delete its apis at the same moment
+func jitterReapInterval(interval time.Duration) time.Duration {
+	return interval + time.Duration(rand.Int63n(int64(interval)/10+1))
+}
+
+// ingressCacheSynced reports whether the informers have listed every ingress, which they have not before they exist
+func (controller *KongIngressController) ingressCacheSynced() bool {
+	controller.storeMutex.Lock()
+	synced := controller.ingressesSynced
+	controller.storeMutex.Unlock()
+	return synced != nil && synced()
+}
+
+// cachedStore returns the informers' cache of ingresses, which is empty until createWatches has published it
+func (controller *KongIngressController) cachedStore() ingressCache {
+	controller.storeMutex.Lock()
+	defer controller.storeMutex.Unlock()
+	if controller.ingressStore == nil {
+		return namespacedStores{}
 	}
+	return controller.ingressStore
+}
+
+// waitForIngressCache blocks until the ingress cache has completed its initial list, since reaping against a partial
+// cache would delete the apis of ingresses that have not been seen yet
+func waitForIngressCache(ctx context.Context, controller *KongIngressController) bool {
+	for !controller.ingressCacheSynced() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(cacheSyncPollInterval):
+		}
+	}
+	return true
+}
+
+// listedAPI is an api the reaper listed, along with the model of kong entities it was listed in
+type listedAPI struct {
+	model string
+	api   *kong.Api
+}
+
+func reapOrphanedApis(controller *KongIngressController) (err error) {
+	cycleStarted := time.Now()
+	defer func() {
+		controller.recordReap(err)
+		countReapCycle(cycleStarted, err)
+	}()
 
-	ingressObjects, err := ingressClient.
-		Get().
-		Namespace(metav1.NamespaceAll).
-		Resource("ingresses").
-		Do().
-		Get()
+	upstreams, err := listManagedUpstreams(controller)
 	if err != nil {
-		return errors.Wrapf(err, "Failed to get ingress list")
+		return err
+	}
+	// The models are found before listing, and the ingresses looked at after, so that the apis of ingresses added in
+	// between are not taken for orphans
+	models := controller.apiModelsInUse()
+	kongApis := map[string][]*kong.Api{}
+	for _, model := range models {
+		if kongApis[model], err = listKongAPIs(controller, model); err != nil {
+			return err
+		}
+	}
+	// A listing taken while reconciles are in progress may miss what they change, so it is not kept for the resync
+	if apis, listed := kongApis[KongAPIModelAPIs]; listed && controller.queue != nil && controller.reconcilePassDone() {
+		controller.apiSnapshot.set(apis)
 	}
 
-	ingressList := ingressObjects.(*v1beta1.IngressList)
-	ingMap := map[string]bool{}
-	for _, ingress := range ingressList.Items {
-		ingMap[getQualifiedName(&ingress)] = true
+	// The ingresses are mapped by the name of their apis per model, so that the entity an ingress leaves behind in the
+	// model it moved away from is reaped
+	ingMaps := map[string]map[string]*v1beta1.Ingress{}
+	for _, model := range models {
+		ingMaps[model] = map[string]*v1beta1.Ingress{}
+	}
+	// attachedServices are the existing services ingresses attach their routes to, which the controller did not create
+	attachedServices := map[string]bool{}
+	for _, ingress := range controller.cachedIngresses() {
+		if fairGame, _ := ingressIsFairGame(controller, ingress); !fairGame {
+			continue
+		}
+		annotations := parseAnnotations(ingress)
+		if serviceName, attached := controller.attachedService(annotations); attached {
+			attachedServices[serviceName] = true
+			continue
+		}
+		ingMap, listed := ingMaps[controller.apiModel(annotations)]
+		if !listed {
+			continue
+		}
+		for _, path := range getIngressPaths(ingress) {
+			ingMap[getAPIName(controller, ingress, path)] = ingress
+		}
 	}
 
-	for _, api := range kongApis.Data {
-		if !ingMap[api.Name] {
-			err := deleteKongAPI(kongClient, api.Name)
-			if err != nil {
-				glog.Errorf("Error reaping orphaned kong api '%s': %v", api.Name, err)
+	started := time.Now()
+	remainingOrphans := 0
+	managedAPINamespaces := []string{}
+	inventory := Inventory{Timestamp: started, APIs: []InventoryAPI{}}
+	// keptApis are the apis left in kong, whose upstreams are kept
+	keptApis := []*kong.Api{}
+	orphans := []listedAPI{}
+	for _, model := range models {
+		for _, api := range kongApis[model] {
+			if !strings.HasPrefix(api.Name, controller.ManagedPrefix) || !controller.watchesNamespace(apiNamespace(api.Name)) ||
+				(model == KongAPIModelServices && attachedServices[api.Name]) {
+				keptApis = append(keptApis, api)
+				continue
+			}
+			if ingress, found := ingMaps[model][api.Name]; found {
+				keptApis = append(keptApis, api)
+				managedAPINamespaces = append(managedAPINamespaces, ingress.ObjectMeta.Namespace)
+				inventory.APIs = append(inventory.APIs, InventoryAPI{
+					Name:        api.Name,
+					Ingress:     getIngressKey(ingress),
+					Hosts:       api.Hosts,
+					UpstreamURL: api.UpstreamURL,
+				})
 			} else {
-				glog.Infof("Reaper: Die, die, die! Orphaned kong api '%s' was reaped", api.Name)
+				orphans = append(orphans, listedAPI{model: model, api: api})
 			}
 		}
 	}
+	// An ingress list that came back empty or partial makes every api look orphaned, so a cycle that would delete more
+	// than the limit deletes nothing at all
+	if controller.refusesReap("apis", len(orphans), len(managedAPINamespaces)+len(orphans)) {
+		for _, orphan := range orphans {
+			keptApis = append(keptApis, orphan.api)
+		}
+		orphans = nil
+	}
+	for _, orphan := range orphans {
+		api := orphan.api
+		if controller.ReaperTimeBudget > 0 && time.Since(started) >= controller.ReaperTimeBudget {
+			keptApis = append(keptApis, api)
+			remainingOrphans++
+		} else if err := deleteKongAPI(controller, "", orphan.model, api.Name); err != nil {
+			keptApis = append(keptApis, api)
+			logging.Errorf(logging.Fields{"api": api.Name, "error": err}, "Error reaping orphaned kong api '%s': %v", api.Name, err)
+		} else {
+			logging.Infof(logging.Fields{"api": api.Name}, "Reaper: Die, die, die! Orphaned kong api '%s' was reaped", api.Name)
+		}
+	}
+	if remainingOrphans > 0 {
+		logging.Infof(logging.Fields{"orphans": remainingOrphans}, "Reaper: Ran out of the %v time budget with %d orphaned kong apis left to reap next cycle", controller.ReaperTimeBudget, remainingOrphans)
+	}
+	controller.setManagedAPIs(managedAPINamespaces)
+	if err := reapOrphanedUpstreams(controller, upstreams, keptApis); err != nil {
+		logging.Errorf(logging.Fields{"error": err}, "%v", err)
+	}
+
+	if controller.InventoryFile != "" {
+		inventory.Certificates = controller.sniTracker.inventory()
+		if err := writeInventory(controller.InventoryFile, inventory); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (controller *KongIngressController) createWatches(ctx context.Context) (cache.Controller, error) {
-	watchedSource := cache.NewListWatchFromClient(
-		controller.IngressClient,
-		"ingresses",
-		metav1.NamespaceAll,
-		fields.Everything())
+// createWatches starts an informer for each watched namespace, along with the workers reconciling what they queue
+func (controller *KongIngressController) createWatches(ctx context.Context) ([]cache.Controller, error) {
+	client, resource, objType := controller.IngressClient, "ingresses", runtime.Object(&v1beta1.Ingress{})
+	if controller.Resource == ResourceHTTPRoute {
+		client, resource, objType = controller.HTTPRouteClient, httpRouteResource, &HTTPRoute{}
+	}
 
-	_, informController := cache.NewInformer(
-		watchedSource,
-		&v1beta1.Ingress{},
-		FullResyncInterval,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    ingressChanged(controller.KongClient),
-			UpdateFunc: ingressUpdated(controller.KongClient),
-			DeleteFunc: ingressDeleted(controller.KongClient),
-		},
-	)
+	controller.queue = newIngressQueue()
+	informers := []cache.Controller{}
+	// KongPlugins are watched first, so that their cache exists by the time ingresses are reconciled against it
+	if controller.PluginClient != nil {
+		informers = append(informers, controller.createKongPluginWatch(ctx)...)
+	}
+	stores := namespacedStores{}
+	for _, namespace := range controller.watchedNamespaces() {
+		watchedSource := trackInformerActivity(controller, cache.NewListWatchFromClient(
+			client,
+			resource,
+			namespace,
+			fields.Everything()))
 
-	go informController.Run(ctx.Done())
-	return informController, nil
-}
+		informer := cache.NewSharedIndexInformer(
+			watchedSource,
+			objType,
+			FullResyncInterval,
+			cache.Indexers{},
+		)
+		// The handlers only queue the keys of changed objects, so that a slow kong does not hold up the informer
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    ingressAdded(controller),
+			UpdateFunc: ingressUpdated(controller),
+			DeleteFunc: ingressDeleted(controller),
+		})
+		stores[namespace] = informer.GetStore()
+		informers = append(informers, informer)
+		controller.spawn(func() { informer.Run(ctx.Done()) })
+	}
+	controller.storeMutex.Lock()
+	controller.ingressStore = stores
+	controller.ingressesSynced = func() bool {
+		for _, informer := range informers {
+			if !informer.HasSynced() {
+				return false
+			}
+		}
+		return true
+	}
+	controller.storeMutex.Unlock()
 
-func ingressChanged(kongClient *kong.Client) func(interface{}) {
-	return func(obj interface{}) {
-		ingress := obj.(*v1beta1.Ingress)
+	workers := controller.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		controller.spawn(controller.runWorker)
+	}
+	controller.spawn(func() {
+		<-ctx.Done()
+		controller.queue.ShutDown()
+	})
+	return informers, nil
+}
 
-		if err := validateIngressSupported(ingress); err != nil {
-			glog.Errorf("Unsupported ingress '%s' in namespace '%s': %v", ingress.ObjectMeta.Name, ingress.ObjectMeta.ClusterName, err)
-			return
+// syncIngress reconciles an ingress, remembering its resource version when it succeeds so that resyncs can skip it
+func syncIngress(controller *KongIngressController, ingress *v1beta1.Ingress) error {
+	result, err := controller.ReconcileIngress(context.Background(), ingress)
+	if controller.annotatesReconcileErrors() && result.Ignored == "" {
+		// An outcome that could not be written is written when the ingress is next reconciled
+		if annotateErr := annotateReconcileOutcome(controller, ingress, err); annotateErr != nil {
+			logging.Errorf(ingressFields(ingress).With(logging.Fields{"error": annotateErr}), "%v", annotateErr)
+		}
+	}
+	// An ingress that lost a route to another is reconciled in full again, so that it takes the route once it is free
+	if err != nil || result.Ignored != "" || result.conflicted() {
+		controller.reconciled.forget(ingress)
+		return err
+	}
+	controller.reconciled.record(ingress)
+	if controller.publishesStatus() {
+		// A status that could not be written is brought up to date by the next resync
+		if err := updateIngressStatus(controller, ingress); err != nil {
+			logging.Errorf(ingressFields(ingress).With(logging.Fields{"error": err}), "%v", err)
 		}
+	}
+	return nil
+}
 
-		glog.V(2).Infof("Reconciling Ingress '%s' in namespace '%s' with Kong API", ingress.ObjectMeta.Name, ingress.ObjectMeta.Namespace)
-		err := reconcileAPI(kongClient, ingress)
-		if err != nil {
-			glog.Errorf("An error occurred attempting to create or update API '%s': %v", getQualifiedName(ingress), err)
-			return
+// ingressAdded queues a newly observed ingress, after the create grace delay when the ingress is younger than it.
+// Ingresses that already existed when the controller started are older and so are queued straight away.
+func ingressAdded(controller *KongIngressController) func(interface{}) {
+	return func(obj interface{}) {
+		controller.recordInformerActivity()
+		if ingress, isIngress := obj.(*v1beta1.Ingress); isIngress && controller.CreateGraceDelay > 0 {
+			if delay := controller.CreateGraceDelay - time.Since(ingress.ObjectMeta.CreationTimestamp.Time); delay > 0 {
+				logging.V(2).Infof(ingressFields(ingress), "Delaying reconcile of new ingress '%s' by %v", getIngressKey(ingress), delay)
+				controller.enqueue(obj, delay)
+				return
+			}
 		}
+		controller.enqueue(obj, 0)
 	}
 }
 
-func reconcileAPI(kongClient *kong.Client, ingress *v1beta1.Ingress) error {
-	apiName := getQualifiedName(ingress)
+// reconcileAPI makes the kong api for a path of the ingress match it
+func reconcileAPI(controller *KongIngressController, ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) (string, error) {
+	if controller.apiModel(annotations) == KongAPIModelServices {
+		return reconcileService(controller, ingress, path, annotations)
+	}
+	kongClient := controller.KongClient
+	ingressKey := getIngressKey(ingress)
+
+	path, err := resolveServicePort(controller, ingress, withUpstreamService(path, annotations))
+	if err != nil {
+		return "", err
+	}
+	if usesUpstream(annotations) {
+		if err := reconcileUpstream(controller, ingress, path, annotations); err != nil {
+			return "", err
+		}
+	}
+	override, err := resolveOverride(controller, ingress, annotations)
+	if err != nil {
+		return "", err
+	}
+	desiredAPI := desiredAPIRequest(controller, ingress, path, annotations, override)
+	apiName := desiredAPI.Name
 
-	api, resp, err := kongClient.Apis.Get(apiName)
-	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
-		return errors.Wrapf(err, "Failed to fetch API '%s'", apiName)
+	if hasDoublePrefix(path.path, desiredAPI) {
+		controller.recordWarning(ingress, "DoublePathPrefix", "Upstream URL '%s' already ends with path '%s' of ingress '%s' and the path is not stripped, so requests will be forwarded with the prefix twice", desiredAPI.UpstreamURL, path.path, ingressKey)
 	}
 
-	if resp.StatusCode == http.StatusNotFound {
-		glog.Infof("Creating new API '%s'", apiName)
-		kongAPI := apiRequestFromIngress(ingress)
-		_, err := kongClient.Apis.Post(&kongAPI)
+	// The listing of the last reap cycle stands in for fetching the api while it is no more than a cycle behind the
+	// resync, so apis changed in kong by hand since are only corrected by the resync after
+	api, listed := controller.apiSnapshot.take(apiName, 2*FullResyncInterval)
+	notFound := false
+	if !listed {
+		resp, err := retryKong(controller, "fetch API '"+apiName+"'", func() (resp *http.Response, err error) {
+			api, resp, err = kongClient.Apis.Get(apiName)
+			return resp, err
+		})
+		// The response is nil when kong could not be reached at all, so it is only looked at through notFound
+		notFound = resp != nil && resp.StatusCode == http.StatusNotFound
+		if err != nil && !notFound {
+			return "", errors.Wrapf(err, "Failed to fetch API '%s'", apiName)
+		}
+	}
+
+	if notFound {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"api": apiName}), "Creating new API '%s'", apiName)
+		_, err := retryKong(controller, "create API '"+apiName+"'", func() (*http.Response, error) {
+			return kongClient.Apis.Post(&desiredAPI)
+		})
 		if err != nil {
-			return errors.Wrapf(err, "Failed to create API '%s'", apiName)
+			return "", errors.Wrapf(err, "Failed to create API '%s'", apiName)
 		}
-	} else {
-		correctUpstreamURL := getUpstreamURL(ingress)
-		if api.UpstreamURL != correctUpstreamURL {
-			glog.Infof("Updating upstream URL from '%s' to '%s' on API '%s'", api.UpstreamURL, correctUpstreamURL, api.Name)
-			_, err := kongClient.Apis.Patch(&kong.ApiRequest{
-				ID:          api.ID,
-				UpstreamURL: correctUpstreamURL,
-			})
-			if err != nil {
-				return errors.Wrapf(err, "Failed to patch API '%s'", apiName)
+		controller.AuditLog.record(auditCreate, auditEntityAPI, apiName, ingressKey, desiredAPI)
+		return APICreated, nil
+	}
+	if api == nil {
+		return "", errors.Errorf("Failed to fetch API '%s', kong returned no API", apiName)
+	}
+
+	if controller.PatchStrategy == PatchStrategyMerge {
+		patch := mergePatch(api, desiredAPI, override, annotations)
+		if !controller.correctsPreserveHost(annotations, override) {
+			delete(patch, "preserve_host")
+		}
+		patch = controller.managedFieldsOf(patch)
+		if len(patch) == 0 {
+			return APIUnchanged, nil
+		}
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"api": apiName}), "Patching %v on API '%s'", patch, api.Name)
+		if resp, err := patchAPIFields(controller, ingressKey, api, patch); err != nil {
+			if controller.recreatesRefused(resp) {
+				return APIRecreated, recreateAPI(controller, ingressKey, api, desiredAPI)
 			}
+			return "", err
 		}
-		correctHosts := ingress.Spec.Rules[0].Host
-		if len(api.Hosts) != 1 || api.Hosts[0] != correctHosts {
-			glog.Infof("Updating Hosts from '%s' to '%s' on API '%s'", api.Hosts, correctHosts, api.Name)
-			_, err := kongClient.Apis.Patch(&kong.ApiRequest{
-				ID:    api.ID,
-				Hosts: correctHosts,
-			})
-			if err != nil {
-				return errors.Wrapf(err, "Failed to patch API '%s'", apiName)
+		return APIUpdated, nil
+	}
+
+	action := APIUnchanged
+	correctUpstreamURL := desiredAPI.UpstreamURL
+	if controller.managesField("upstream_url") && api.UpstreamURL != correctUpstreamURL {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"api": apiName}), "Updating upstream URL from '%s' to '%s' on API '%s'", api.UpstreamURL, correctUpstreamURL, api.Name)
+		apiPatch := kong.ApiRequest{
+			ID:          api.ID,
+			UpstreamURL: correctUpstreamURL,
+		}
+		resp, err := retryKong(controller, "patch API '"+apiName+"'", func() (*http.Response, error) {
+			return kongClient.Apis.Patch(&apiPatch)
+		})
+		if err != nil {
+			if controller.recreatesRefused(resp) {
+				return APIRecreated, recreateAPI(controller, ingressKey, api, desiredAPI)
 			}
+			return "", errors.Wrapf(err, "Failed to patch API '%s'", apiName)
+		}
+		controller.AuditLog.record(auditPatch, auditEntityAPI, apiName, ingressKey, apiPatch)
+		action = APIUpdated
+	}
+	if desiredHosts := strings.Split(desiredAPI.Hosts, ","); controller.managesField("hosts") && !sameHosts(api.Hosts, desiredHosts) {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"api": apiName}), "Updating Hosts from '%s' to '%s' on API '%s'", api.Hosts, desiredHosts, api.Name)
+		apiPatch := kong.ApiRequest{
+			ID:    api.ID,
+			Hosts: desiredAPI.Hosts,
 		}
-		if api.PreserveHost != true {
-			glog.Infof("Updating PreserveHost from '%s' to '%s' on API '%s'", false, true, api.Name)
-			_, err := kongClient.Apis.Patch(&kong.ApiRequest{
+		resp, err := retryKong(controller, "patch API '"+apiName+"'", func() (*http.Response, error) {
+			return kongClient.Apis.Patch(&apiPatch)
+		})
+		if err != nil {
+			if controller.recreatesRefused(resp) {
+				return APIRecreated, recreateAPI(controller, ingressKey, api, desiredAPI)
+			}
+			return "", errors.Wrapf(err, "Failed to patch API '%s'", apiName)
+		}
+		controller.AuditLog.record(auditPatch, auditEntityAPI, apiName, ingressKey, apiPatch)
+		action = APIUpdated
+	}
+	if controller.managesField("preserve_host") && controller.correctsPreserveHost(annotations, override) && api.PreserveHost != desiredAPI.PreserveHost {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"api": apiName}), "Updating PreserveHost from '%v' to '%v' on API '%s'", api.PreserveHost, desiredAPI.PreserveHost, api.Name)
+		if desiredAPI.PreserveHost {
+			apiPatch := kong.ApiRequest{
 				ID:           api.ID,
 				PreserveHost: true,
+			}
+			resp, err := retryKong(controller, "patch API '"+apiName+"'", func() (*http.Response, error) {
+				return kongClient.Apis.Patch(&apiPatch)
 			})
 			if err != nil {
-				return errors.Wrapf(err, "Failed to patch API '%s'", apiName)
+				if controller.recreatesRefused(resp) {
+					return APIRecreated, recreateAPI(controller, ingressKey, api, desiredAPI)
+				}
+				return "", errors.Wrapf(err, "Failed to patch API '%s'", apiName)
 			}
+			controller.AuditLog.record(auditPatch, auditEntityAPI, apiName, ingressKey, apiPatch)
+		} else if resp, err := patchAPIFields(controller, ingressKey, api, map[string]interface{}{"preserve_host": false}); err != nil {
+			if controller.recreatesRefused(resp) {
+				return APIRecreated, recreateAPI(controller, ingressKey, api, desiredAPI)
+			}
+			return "", err
+		}
+		action = APIUpdated
+	}
+	if fields := controller.managedFieldsOf(driftedFields(api, desiredAPI, override, annotations)); len(fields) > 0 {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"api": apiName}), "Updating %v on API '%s'", fields, api.Name)
+		if resp, err := patchAPIFields(controller, ingressKey, api, fields); err != nil {
+			if controller.recreatesRefused(resp) {
+				return APIRecreated, recreateAPI(controller, ingressKey, api, desiredAPI)
+			}
+			return "", err
+		}
+		action = APIUpdated
+	}
+
+	return action, nil
+}
+
+// desiredAPIRequest returns the api a path of the ingress should have in kong: the api derived from the path and the
+// annotations of the ingress and named with ManagedPrefix, with the override and then the request mutators applied
+func desiredAPIRequest(controller *KongIngressController, ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations, override *KongIngress) kong.ApiRequest {
+	apiRequest := apiRequestFromIngress(ingress, path, annotations)
+	apiRequest.Name = controller.ManagedPrefix + apiRequest.Name
+	apiRequest.Hosts = strings.Join(getAPIHosts(ingress, path, annotations), ",")
+	if usesUpstream(annotations) {
+		apiRequest.UpstreamURL = controller.upstreamURL(ingress, path, annotations)
+	}
+	override.apply(&apiRequest)
+	for _, mutate := range controller.RequestMutators {
+		mutate(&apiRequest, ingress)
+	}
+	return apiRequest
+}
+
+// getAPIName returns the name of the api for a path of the ingress once the request mutators, which may rename it, are
+// applied
+func getAPIName(controller *KongIngressController, ingress *v1beta1.Ingress, path *ingressPath) string {
+	if len(controller.RequestMutators) == 0 {
+		return controller.ManagedPrefix + getPathAPIName(ingress, path)
+	}
+	return desiredAPIRequest(controller, ingress, path, parseAnnotations(ingress), nil).Name
+}
+
+// driftedFields returns the optional settings of the desired api that differ on the api, keyed by their name in kong.
+// Settings the desired api leaves unset are not managed, so changes made to them directly in kong are kept.
+func driftedFields(api *kong.Api, desired kong.ApiRequest, override *KongIngress, annotations ingressAnnotations) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if desired.Uris != "" && strings.Join(api.Uris, ",") != desired.Uris {
+		fields["uris"] = strings.Split(desired.Uris, ",")
+	}
+	if desired.StripURI != nil && (api.StripURI == nil || *api.StripURI != *desired.StripURI) {
+		fields["strip_uri"] = *desired.StripURI
+	}
+	// An https_only of false is indistinguishable from unset, so it is only managed when an override sets the protocols
+	if api.HttpsOnly != desired.HttpsOnly && (desired.HttpsOnly || override.managesProtocols()) {
+		fields["https_only"] = desired.HttpsOnly
+	}
+	if desired.UpstreamConnectTimeout > 0 && api.UpstreamConnectTimeout != desired.UpstreamConnectTimeout {
+		fields["upstream_connect_timeout"] = desired.UpstreamConnectTimeout
+	}
+	if desired.UpstreamReadTimeout > 0 && api.UpstreamReadTimeout != desired.UpstreamReadTimeout {
+		fields["upstream_read_timeout"] = desired.UpstreamReadTimeout
+	}
+	if desired.UpstreamSendTimeout > 0 && api.UpstreamSendTimeout != desired.UpstreamSendTimeout {
+		fields["upstream_send_timeout"] = desired.UpstreamSendTimeout
+	}
+	// Retries of zero are indistinguishable from unset, so they are managed whenever the annotation sets them
+	if _, found := annotatedRetries(annotations); found && api.Retries != desired.Retries {
+		fields["retries"] = desired.Retries
+	}
+	// Methods are compared as sets, like hosts
+	if methods := splitList(desired.Methods); len(methods) > 0 && !sameHosts(api.Methods, methods) {
+		fields["methods"] = methods
+	}
+	return fields
+}
+
+// mergePatch returns a patch holding only the fields of the api that differ from the desired api, so that fields the
+// controller does not manage are never sent
+func mergePatch(api *kong.Api, desired kong.ApiRequest, override *KongIngress, annotations ingressAnnotations) map[string]interface{} {
+	patch := driftedFields(api, desired, override, annotations)
+	if api.UpstreamURL != desired.UpstreamURL {
+		patch["upstream_url"] = desired.UpstreamURL
+	}
+	if hosts := strings.Split(desired.Hosts, ","); !sameHosts(api.Hosts, hosts) {
+		patch["hosts"] = hosts
+	}
+	if api.PreserveHost != desired.PreserveHost {
+		patch["preserve_host"] = desired.PreserveHost
+	}
+	return patch
+}
+
+// correctsPreserveHost reports whether preserve_host is corrected when it drifts. The controller creates apis that
+// preserve the host by default, but only owns the setting once the ingress sets it through an annotation or override,
+// so that an api changed by hand for a special route is not flipped back, unless EnforceDefaults is set.
+func (controller *KongIngressController) correctsPreserveHost(annotations ingressAnnotations, override *KongIngress) bool {
+	_, annotated := annotations.values[preserveHostAnnotation]
+	return annotated || override.managesPreserveHost() || controller.EnforceDefaults
+}
+
+// managesField reports whether drift of the api field is corrected
+func (controller *KongIngressController) managesField(field string) bool {
+	if len(controller.ManagedFields) == 0 {
+		return true
+	}
+	for _, managed := range controller.ManagedFields {
+		if managed == field {
+			return true
 		}
 	}
+	return false
+}
+
+// managedFieldsOf drops the fields that are not managed from a patch
+func (controller *KongIngressController) managedFieldsOf(fields map[string]interface{}) map[string]interface{} {
+	for field := range fields {
+		if !controller.managesField(field) {
+			delete(fields, field)
+		}
+	}
+	return fields
+}
+
+// recreatesRefused decides whether an api whose patch kong answered with resp is recreated instead. Kong refuses a
+// patch with a client error when the api cannot take the change in place, while a 404 means the api went away since it
+// was fetched and is created again by the next resync.
+func (controller *KongIngressController) recreatesRefused(resp *http.Response) bool {
+	return controller.RecreateOnImmutable && resp != nil && resp.StatusCode >= http.StatusBadRequest &&
+		resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusNotFound
+}
+
+// recreateAPI replaces an api that kong refused to patch. The new api is created before the old one is deleted
+// so that the route stays available throughout.
+func recreateAPI(controller *KongIngressController, ingressKey string, existing *kong.Api, kongAPI kong.ApiRequest) error {
+	logging.Infof(keyFields(ingressKey).With(logging.Fields{"api": kongAPI.Name}), "Recreating API '%s' to replace API '%s' that kong refused to patch", kongAPI.Name, existing.ID)
+	_, err := retryKong(controller, "create API '"+kongAPI.Name+"'", func() (*http.Response, error) {
+		return controller.KongClient.Apis.Post(&kongAPI)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create API '%s'", kongAPI.Name)
+	}
+	controller.AuditLog.record(auditCreate, auditEntityAPI, kongAPI.Name, ingressKey, kongAPI)
+
+	_, err = retryKong(controller, "delete API '"+existing.ID+"'", func() (*http.Response, error) {
+		return controller.KongClient.Apis.Delete(existing.ID)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to delete API '%s' after recreating it as '%s'", existing.ID, kongAPI.Name)
+	}
+	controller.AuditLog.record(auditDelete, auditEntityAPI, existing.Name, ingressKey, nil)
 
 	return nil
 }
 
-func ingressUpdated(kongClient *kong.Client) func(interface{}, interface{}) {
+func ingressUpdated(controller *KongIngressController) func(interface{}, interface{}) {
 	return func(previousObj, newObj interface{}) {
-		ingressChanged(kongClient)(newObj)
+		controller.recordInformerActivity()
+		unchanged := true
+		for _, ingress := range ingressesOf(newObj) {
+			if !controller.reconciled.unchanged(ingress, controller.ForceReconcileInterval) {
+				unchanged = false
+			}
+			// Without the endpoints watch the targets of an upstream only follow its pods as the ingress is reconciled
+			if !controller.WatchEndpoints && usesUpstream(parseAnnotations(ingress)) {
+				unchanged = false
+			}
+		}
+		if unchanged {
+			logging.V(3).Infof(keyFields(objectKey(newObj)), "Skipping resync of '%s', unchanged since it was last reconciled", objectKey(newObj))
+			return
+		}
+		controller.enqueue(newObj, 0)
 	}
 }
 
-func ingressDeleted(kongClient *kong.Client) func(interface{}) {
+// ingressDeleted queues a deleted ingress, keeping its last known state for the worker that removes it from kong
+func ingressDeleted(controller *KongIngressController) func(interface{}) {
 	return func(obj interface{}) {
-		ingress := obj.(*v1beta1.Ingress)
-		glog.Infof("Ingress '%s' was deleted from namespace '%s'. Removing it from Kong.", ingress.ObjectMeta.Name, ingress.ObjectMeta.Namespace)
-		apiName := getQualifiedName(ingress)
-		err := deleteKongAPI(kongClient, apiName)
+		controller.recordInformerActivity()
+		if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+			obj = tombstone.Obj
+		}
+		key, err := cache.MetaNamespaceKeyFunc(obj)
 		if err != nil {
-			glog.Errorf("Failed to delete kong API '%s': %v", apiName, err)
+			logging.Errorf(logging.Fields{"error": err}, "Failed to get the key of deleted %v: %v", obj, err)
+			return
 		}
+		controller.deleted.add(key, obj)
+		controller.queue.Add(key)
 	}
 }
 
-func deleteKongAPI(kongClient *kong.Client, apiName string) error {
-	_, _, err := kongClient.Apis.Get(apiName)
+// syncDeletedIngress removes the apis and certificates of a deleted ingress from kong
+func syncDeletedIngress(controller *KongIngressController, ingress *v1beta1.Ingress) error {
+	controller.reconciled.forget(ingress)
+	if fairGame, _ := ingressIsFairGame(controller, ingress); !fairGame {
+		return nil
+	}
+	if !controller.beginReconcile() {
+		logging.V(2).Infof(ingressFields(ingress), "Ignoring deletion of ingress '%s' in namespace '%s' while draining", ingress.ObjectMeta.Name, ingress.ObjectMeta.Namespace)
+		return nil
+	}
+	defer controller.endReconcile()
+
+	logging.Infof(ingressFields(ingress), "Ingress '%s' was deleted from namespace '%s'. Removing it from Kong.", ingress.ObjectMeta.Name, ingress.ObjectMeta.Namespace)
+	errs := []error{}
+	annotations := parseAnnotations(ingress)
+	model := controller.apiModel(annotations)
+	serviceName, attached := controller.attachedService(annotations)
+	for _, path := range getIngressPaths(ingress) {
+		apiName := getAPIName(controller, ingress, path)
+		var err error
+		if attached {
+			err = detachRoute(controller, getIngressKey(ingress), serviceName, desiredAPIRequest(controller, ingress, path, annotations, nil))
+		} else {
+			err = deleteKongAPI(controller, getIngressKey(ingress), model, apiName)
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to delete kong API '%s'", apiName))
+		}
+	}
+	releaseCertificates(controller, ingress)
+	return utilerrors.NewAggregate(errs)
+}
+
+func deleteKongAPI(controller *KongIngressController, ingressKey string, model string, apiName string) error {
+	if model == KongAPIModelServices {
+		return deleteKongService(controller, ingressKey, apiName)
+	}
+	kongClient := controller.KongClient
+	_, err := retryKong(controller, "retrieve kong api '"+apiName+"'", func() (*http.Response, error) {
+		_, resp, err := kongClient.Apis.Get(apiName)
+		return resp, err
+	})
 	if err != nil {
 		return errors.Wrapf(err, "Failed to retrieve kong api '%s'", apiName)
 	}
 
-	_, err = kongClient.Apis.Delete(apiName)
+	_, err = retryKong(controller, "delete kong api '"+apiName+"'", func() (*http.Response, error) {
+		return kongClient.Apis.Delete(apiName)
+	})
 	if err != nil {
 		return errors.Wrapf(err, "Failed to delete kong api '%s'", apiName)
 	}
-	glog.Infof("Kong api '%s' was deleted", apiName)
+	controller.AuditLog.record(auditDelete, auditEntityAPI, apiName, ingressKey, nil)
+	controller.attachedPlugins.forget(apiName)
+	logging.Infof(keyFields(ingressKey).With(logging.Fields{"api": apiName}), "Kong api '%s' was deleted", apiName)
 
 	return nil
 }
 
+// ingressIsFairGame decides whether the ingress is meant to be handled by this controller, returning the reason it is
+// ignored when it is not
+func ingressIsFairGame(controller *KongIngressController, ingress *v1beta1.Ingress) (bool, string) {
+	class := ingress.ObjectMeta.Annotations[ingressClassAnnotation]
+	if class != controller.IngressClass && (class != "" || !controller.ClaimUnsetClass) {
+		return false, ignoredClass
+	}
+	if controller.RequireOptIn && !ingressOptedIn(ingress) {
+		return false, ignoredOptIn
+	}
+	return true, ""
+}
+
+func ingressOptedIn(ingress *v1beta1.Ingress) bool {
+	managed, err := strconv.ParseBool(ingress.ObjectMeta.Annotations[managedAnnotation])
+	return err == nil && managed
+}
+
+// ignoreIngress explains why an ingress is skipped, when enabled, so that it is clear why it is not in kong
+func (controller *KongIngressController) ignoreIngress(ingress *v1beta1.Ingress, reason string) {
+	if !controller.LogIgnored {
+		return
+	}
+	explanation := reason
+	switch reason {
+	case ignoredClass:
+		explanation = fmt.Sprintf("its ingress class '%s' is not '%s'", ingress.ObjectMeta.Annotations[ingressClassAnnotation], controller.IngressClass)
+	case ignoredOptIn:
+		explanation = fmt.Sprintf("it does not opt in with the annotation %s: \"true\"", managedAnnotation)
+	}
+	logging.Infof(ingressFields(ingress).With(logging.Fields{"reason": reason}), "Ignoring ingress '%s' because %s", getIngressKey(ingress), explanation)
+	controller.countIgnored(reason)
+}
+
 func validateIngressSupported(ingress *v1beta1.Ingress) error {
-	if ingress.Spec.Backend != nil {
-		return errors.New("Single Service Ingress types are not currently supported")
+	if ingress.Spec.Backend != nil && len(ingress.Spec.Rules) > 0 {
+		return errors.New("Ingresses with both rules and a default backend are not currently supported")
 	}
-	if len(ingress.Spec.Rules) != 1 {
-		return errors.New("Only ingresses with a single rule are currently supported")
+	if ingress.Spec.Backend == nil && len(ingress.Spec.Rules) == 0 {
+		return errors.New("Ingresses without rules or a default backend are not supported")
 	}
-	if len(ingress.Spec.Rules[0].HTTP.Paths) != 1 || ingress.Spec.Rules[0].HTTP.Paths[0].Path != "/" {
-		return errors.New("Only ingresses with a single root path are currently supported")
+	hosts := map[string]bool{}
+	// Each path becomes an api named after its host and path, which different paths may share once normalized
+	apiNames := map[string]string{}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil || len(rule.HTTP.Paths) == 0 {
+			return errors.New("Only ingress rules with http paths are currently supported")
+		}
+		if hosts[rule.Host] {
+			return errors.Errorf("Host '%s' has more than one rule, which is not supported", rule.Host)
+		}
+		if err := validateWildcardHost(rule.Host); err != nil {
+			return err
+		}
+		hosts[rule.Host] = true
+
+		paths := map[string]bool{}
+		for _, path := range rule.HTTP.Paths {
+			if path.Path != "" && !strings.HasPrefix(path.Path, "/") {
+				return errors.Errorf("Path '%s' of host '%s' does not start with '/'", path.Path, rule.Host)
+			}
+			if paths[rootPathOf(path.Path)] {
+				return errors.Errorf("Path '%s' of host '%s' is listed more than once", path.Path, rule.Host)
+			}
+			paths[rootPathOf(path.Path)] = true
+
+			apiName := getQualifiedAPIName(rule.Host, rootPathOf(path.Path), ingress.ObjectMeta.Namespace)
+			if other, found := apiNames[apiName]; found {
+				return errors.Errorf("Path '%s%s' gets the same API name '%s' as path '%s', which is not supported", rule.Host, path.Path, apiName, other)
+			}
+			apiNames[apiName] = rule.Host + path.Path
+		}
 	}
 
 	return nil
 }
 
-func apiRequestFromIngress(ingress *v1beta1.Ingress) kong.ApiRequest {
-	serviceName := getQualifiedName(ingress)
-	upstreamURL := getUpstreamURL(ingress)
-	return kong.ApiRequest{
+// hasDoublePrefix detects an upstream URL whose path repeats the ingress path, which kong forwards to as
+// upstream path + request path when the uri is not stripped, e.g. /api/api/users. This only warrants a warning, since
+// a backend may genuinely serve under a repeated prefix.
+func hasDoublePrefix(ingressPath string, apiRequest kong.ApiRequest) bool {
+	if apiRequest.StripURI != nil && *apiRequest.StripURI {
+		return false
+	}
+	prefix := strings.TrimSuffix(ingressPath, "/")
+	if prefix == "" {
+		return false
+	}
+	upstreamURL, err := url.Parse(apiRequest.UpstreamURL)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(strings.TrimSuffix(upstreamURL.Path, "/"), prefix)
+}
+
+func countIngressPaths(ingress *v1beta1.Ingress) int {
+	return len(getIngressPaths(ingress))
+}
+
+// apiRequestFromIngress returns the api for a path of the ingress, with the settings the annotations of the ingress
+// configure. An override applied afterwards takes precedence over the annotations.
+func apiRequestFromIngress(ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) kong.ApiRequest {
+	serviceName := getPathAPIName(ingress, path)
+	upstreamURL := getUpstreamURL(ingress, path, annotations)
+	apiRequest := kong.ApiRequest{
 		UpstreamURL:  upstreamURL,
 		Name:         serviceName,
-		Hosts:        ingress.Spec.Rules[0].Host,
+		Hosts:        path.host,
 		PreserveHost: true,
 	}
+	// A root path matches every uri, which is what an api without uris does, but an api must match either hosts or uris
+	if path.path != "/" || path.host == "" {
+		apiRequest.Uris = path.path
+	}
+	if value, found := annotations.values[stripURIAnnotation]; found {
+		stripURI, _ := strconv.ParseBool(value)
+		apiRequest.StripURI = &stripURI
+	}
+	if value, found := annotations.values[preserveHostAnnotation]; found {
+		apiRequest.PreserveHost, _ = strconv.ParseBool(value)
+	}
+	if value, found := annotations.values[connectTimeoutAnnotation]; found {
+		apiRequest.UpstreamConnectTimeout, _ = strconv.Atoi(value)
+	}
+	if value, found := annotations.values[readTimeoutAnnotation]; found {
+		apiRequest.UpstreamReadTimeout, _ = strconv.Atoi(value)
+	}
+	if value, found := annotations.values[writeTimeoutAnnotation]; found {
+		apiRequest.UpstreamSendTimeout, _ = strconv.Atoi(value)
+	}
+	// Retries of zero are left out of the api kong creates, and patched onto it by the next reconcile
+	if retries, found := annotatedRetries(annotations); found {
+		apiRequest.Retries = retries
+	}
+	apiRequest.Methods = strings.Join(annotatedMethods(annotations), ",")
+	return apiRequest
+}
+
+// getUpstreamURL returns the url kong forwards the requests for a path of the ingress to, over http unless the
+// upstream scheme annotation asks for https, and to the backend service unless the upstream service annotation names
+// another, under the path of the upstream path annotation if any. The port is always explicit, even when it is the
+// default of the scheme.
+func getUpstreamURL(ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) string {
+	scheme := upstreamSchemeHTTP
+	if value, found := annotations.values[upstreamSchemeAnnotation]; found {
+		scheme = value
+	}
+	backend := getIngressBackend(withUpstreamService(path, annotations))
+	return fmt.Sprintf("%s://%s.%s:%s%s", scheme, backend.ServiceName, ingress.ObjectMeta.Namespace, backend.ServicePort.String(), annotatedUpstreamPath(annotations))
+}
+
+// annotatedUpstreamPath returns the path the annotations of the ingress forward requests to on the backend service,
+// without a trailing slash, which is empty when they forward to its root
+func annotatedUpstreamPath(annotations ingressAnnotations) string {
+	return strings.TrimSuffix(annotations.values[upstreamPathAnnotation], "/")
 }
 
-func getUpstreamURL(ingress *v1beta1.Ingress) string {
-	backend := getIngressBackend(ingress)
-	return fmt.Sprintf("http://%s.%s:%s", backend.ServiceName, ingress.ObjectMeta.Namespace, backend.ServicePort.String())
+func validateUpstreamPath(value string) error {
+	if !strings.HasPrefix(value, "/") {
+		return errors.New("must start with '/'")
+	}
+	if strings.ContainsAny(value, "?# ") {
+		return errors.New("must be a path without a query or fragment")
+	}
+	return nil
+}
+
+// withUpstreamService returns the path with the service of its backend replaced by the one the upstream service
+// annotation names, if any, so that its port is resolved against and its endpoints are taken from that service
+func withUpstreamService(path *ingressPath, annotations ingressAnnotations) *ingressPath {
+	serviceName, found := annotations.values[upstreamServiceAnnotation]
+	if !found {
+		return path
+	}
+	overridden := *path
+	backend := *getIngressBackend(path)
+	backend.ServiceName = serviceName
+	overridden.backend = &backend
+	return &overridden
+}
+
+// annotatedRetries returns the retries the annotations of the ingress ask for, if any
+func annotatedRetries(annotations ingressAnnotations) (int, bool) {
+	value, found := annotations.values[retriesAnnotation]
+	if !found {
+		return 0, false
+	}
+	retries, _ := strconv.Atoi(value)
+	return retries, true
 }
 
+// annotatedMethods returns the HTTP methods the annotations of the ingress restrict its apis to, in upper case, or nil
+// when they leave the methods unrestricted
+func annotatedMethods(annotations ingressAnnotations) []string {
+	value, found := annotations.values[methodsAnnotation]
+	if !found {
+		return nil
+	}
+	methods := []string{}
+	for _, method := range strings.Split(value, ",") {
+		methods = append(methods, strings.ToUpper(strings.TrimSpace(method)))
+	}
+	return methods
+}
+
+func validateMethods(value string) error {
+	for _, method := range strings.Split(value, ",") {
+		method = strings.TrimSpace(method)
+		if method == "" || strings.Trim(method, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return errors.Errorf("must be comma separated HTTP methods, not '%s'", method)
+		}
+	}
+	return nil
+}
+
+func validateNonNegativeInt(value string) error {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if number < 0 {
+		return errors.New("must not be negative")
+	}
+	return nil
+}
+
+func validateUpstreamScheme(value string) error {
+	if value != upstreamSchemeHTTP && value != upstreamSchemeHTTPS {
+		return errors.Errorf("must be %s or %s", upstreamSchemeHTTP, upstreamSchemeHTTPS)
+	}
+	return nil
+}
+
+// getQualifiedName returns the name of the kong api for the ingress in the form kong stores it. A name kong stored
+// differently would not be found by the next fetch, and the api would be created again on every reconcile.
 func getQualifiedName(ingress *v1beta1.Ingress) string {
-	return fmt.Sprintf("%s.%s", ingress.ObjectMeta.Name, ingress.ObjectMeta.Namespace)
+	name := strings.ToLower(fmt.Sprintf("%s.%s", ingress.ObjectMeta.Name, ingress.ObjectMeta.Namespace))
+	return apiNameDisallowedChars.ReplaceAllString(name, "-")
+}
+
+// getPathAPIName returns the name of the kong api for a path of the ingress. The api of an ingress with a single path is
+// named after the ingress, so that existing apis keep their names, while each api of an ingress with several paths is
+// named after its host and path.
+func getPathAPIName(ingress *v1beta1.Ingress, path *ingressPath) string {
+	if countIngressPaths(ingress) == 1 {
+		return getQualifiedName(ingress)
+	}
+	return getQualifiedAPIName(path.host, path.path, ingress.ObjectMeta.Namespace)
+}
+
+// getQualifiedAPIName returns the name of the kong api for a host and path in a namespace. The namespace is separated by
+// a '~', which kubernetes names cannot contain, so these names never collide with those of single rule ingresses.
+// The '*' of a wildcard host is not allowed in names and becomes a '-', which hosts cannot start with either.
+func getQualifiedAPIName(host string, path string, namespace string) string {
+	name := strings.ToLower(fmt.Sprintf("%s%s~%s", host, strings.TrimSuffix(path, "/"), namespace))
+	return apiNameDisallowedChars.ReplaceAllString(name, "-")
+}
+
+func getIngressKey(ingress *v1beta1.Ingress) string {
+	return fmt.Sprintf("%s/%s", ingress.ObjectMeta.Namespace, ingress.ObjectMeta.Name)
+}
+
+// ingressPath is a path of a rule of an ingress, each of which is represented by a kong api of its own
+type ingressPath struct {
+	host    string
+	path    string
+	backend *v1beta1.IngressBackend
+}
+
+// getIngressPaths returns the paths of every rule of the ingress. The default backend of an ingress without rules is a
+// catch-all path matching every host and uri.
+func getIngressPaths(ingress *v1beta1.Ingress) []*ingressPath {
+	paths := []*ingressPath{}
+	if len(ingress.Spec.Rules) == 0 && ingress.Spec.Backend != nil {
+		paths = append(paths, &ingressPath{path: "/", backend: ingress.Spec.Backend})
+	}
+	for i := range ingress.Spec.Rules {
+		rule := &ingress.Spec.Rules[i]
+		if rule.HTTP == nil {
+			continue
+		}
+		for j := range rule.HTTP.Paths {
+			paths = append(paths, &ingressPath{
+				host:    rule.Host,
+				path:    rootPathOf(rule.HTTP.Paths[j].Path),
+				backend: &rule.HTTP.Paths[j].Backend,
+			})
+		}
+	}
+	return paths
+}
+
+// rootPathOf treats an empty ingress path as the root path, which it matches the same as
+func rootPathOf(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
 }
 
-func getIngressBackend(ingress *v1beta1.Ingress) *v1beta1.IngressBackend {
-	return &ingress.Spec.Rules[0].HTTP.Paths[0].Backend
+func getIngressBackend(path *ingressPath) *v1beta1.IngressBackend {
+	return path.backend
 }
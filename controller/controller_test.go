@@ -9,8 +9,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,11 +20,16 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/rest/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/nccurry/go-kong/kong"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 var (
@@ -32,6 +39,9 @@ var (
 	// Kong client being tested
 	kongClient *kong.Client
 
+	// Controller using the Kong client being tested
+	kiController *KongIngressController
+
 	// Test server used to stub Kong resources
 	server *httptest.Server
 
@@ -44,11 +54,12 @@ type Payload struct {
 	httpMethod string
 }
 
-func TestControllerIgnoresSingleServiceIngress(t *testing.T) {
+func TestKongAPIForDefaultBackendIngress(t *testing.T) {
 	setup()
 	defer shutdown()
+	waitGroup := sync.WaitGroup{}
 
-	unsupportedIngress := v1beta1.Ingress{
+	ingress := v1beta1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "somename",
 			Namespace: "infra",
@@ -61,58 +72,884 @@ func TestControllerIgnoresSingleServiceIngress(t *testing.T) {
 		},
 	}
 
+	waitGroup.Add(1)
+	go testKongOperationCalled(t, "/apis", http.MethodPost, kong.ApiRequest{
+		Name:         "somename.infra",
+		UpstreamURL:  "http://service-1.infra:32000",
+		Uris:         "/",
+		PreserveHost: true,
+	}, nil, &waitGroup)
+
+	syncIngress(kiController, &ingress)
+	waitGroup.Wait()
+
+	waitGroup.Add(1)
+	go testAPIDeleted(t, "somename.infra", &waitGroup)
+	syncDeletedIngress(kiController, &ingress)
+	waitGroup.Wait()
+}
+
+func TestKongAPIPerRuleOfIngressWithMultipleRules(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleMultiRuleIngress("somename", "infra")
+	expectedAPIs := map[string]bool{"somename.somedomain~infra": true, "some.other.host~infra": true}
+	for apiName := range expectedAPIs {
+		mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusNotFound)
+		})
+	}
+	createdAPIs := map[string]bool{}
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		apiRequest := kong.ApiRequest{}
+		json.NewDecoder(request.Body).Decode(&apiRequest)
+		if apiRequest.UpstreamURL != getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)) {
+			t.Errorf("API '%s' created with upstream '%s'", apiRequest.Name, apiRequest.UpstreamURL)
+		}
+		createdAPIs[apiRequest.Name] = true
+	})
+
+	result, err := kiController.ReconcileIngress(context.Background(), &ingress)
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling ingress: %v", err)
+	}
+	if !reflect.DeepEqual(createdAPIs, expectedAPIs) {
+		t.Errorf("Created APIs %v for the rules of the ingress, want %v", createdAPIs, expectedAPIs)
+	}
+	if len(result.Paths) != 2 {
+		t.Errorf("Reconcile reported %d paths, want one for each rule", len(result.Paths))
+	}
+}
+
+func TestKongAPIsOfEveryRuleDeletedWithIngress(t *testing.T) {
+	setup()
+	defer shutdown()
+	waitGroup := sync.WaitGroup{}
+
+	ingress := sampleMultiRuleIngress("somename", "infra")
+	for _, apiName := range []string{"somename.somedomain~infra", "some.other.host~infra"} {
+		waitGroup.Add(1)
+		go testAPIDeleted(t, apiName, &waitGroup)
+	}
+
+	syncDeletedIngress(kiController, &ingress)
+
+	waitGroup.Wait()
+}
+
+func TestControllerIgnoresIngressWithDuplicateRuleHosts(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	unsupportedIngress := sampleMultiRuleIngress("somename", "infra")
+	unsupportedIngress.Spec.Rules[1].Host = unsupportedIngress.Spec.Rules[0].Host
+
 	// This will match everything until we add more specific handlers
-	mux.HandleFunc("/apis/somename.infra", func(writer http.ResponseWriter, request *http.Request) {
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 		t.Fatal("No requests to Kong expected for unsupported ingress")
 	})
 
-	ingressChanged(kongClient)(&unsupportedIngress)
+	syncIngress(kiController, &unsupportedIngress)
 }
-func TestControllerIgnoresIngressWithMultipleRules(t *testing.T) {
+func TestStripURIAndPreserveHostAnnotationsReconcileToSteadyState(t *testing.T) {
 	setup()
 	defer shutdown()
+	kiController.PatchStrategy = PatchStrategyMerge
 
-	unsupportedIngress := sampleIngress("somename", "infra")
-	newRule := sampleIngress("somename", "infra").Spec.Rules[0]
-	newRule.Host = "some.other.host"
-	unsupportedIngress.Spec.Rules = append(unsupportedIngress.Spec.Rules, newRule)
+	ingress := sampleIngress("v1service", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{stripURIAnnotation: "true", preserveHostAnnotation: "false"}
+	apiName := getQualifiedName(&ingress)
+
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	patches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			patches++
+			testRequestMatches(t, request, http.MethodPatch, map[string]interface{}{"preserve_host": false, "strip_uri": true})
+			stripURI := true
+			kongAPI.StripURI = &stripURI
+			kongAPI.PreserveHost = false
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("API was patched %d times, want once before reaching a steady state", patches)
+	}
+
+	plain := sampleIngress("v1service", "prod")
+	if apiRequest := apiRequestFromIngress(&plain, getIngressPaths(&plain)[0], parseAnnotations(&plain)); apiRequest.StripURI != nil || !apiRequest.PreserveHost {
+		t.Errorf("API request without annotations has strip uri %v and preserve host %v, want unset and true", apiRequest.StripURI, apiRequest.PreserveHost)
+	}
+}
+
+func TestUnannotatedPreserveHostOnlyCorrectedWhenEnforcingDefaults(t *testing.T) {
+	for _, enforce := range []bool{false, true} {
+		setup()
+		kiController.PatchStrategy = PatchStrategyMerge
+		kiController.EnforceDefaults = enforce
+
+		ingress := sampleIngress("v1service", "prod")
+		apiName := getQualifiedName(&ingress)
+
+		// preserve_host was turned off in kong by hand
+		kongAPI := apiFromIngress(&ingress)
+		kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+		kongAPI.PreserveHost = false
+		patches := 0
+		mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+			switch request.Method {
+			case http.MethodGet:
+				writeObjectResponse(t, &writer, kongAPI)
+			case http.MethodPatch:
+				patches++
+				testRequestMatches(t, request, http.MethodPatch, map[string]interface{}{"preserve_host": true})
+				kongAPI.PreserveHost = true
+				writeObjectResponse(t, &writer, kongAPI)
+			}
+		})
+
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+		if enforce && patches != 1 {
+			t.Errorf("API was patched %d times with -enforce-defaults, want preserve_host corrected once", patches)
+		}
+		if !enforce && patches != 0 {
+			t.Errorf("API was patched %d times, want preserve_host left as changed in kong", patches)
+		}
+		shutdown()
+	}
+}
+
+func TestTimeoutAnnotationsPatchDriftedTimeouts(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.PatchStrategy = PatchStrategyMerge
+
+	ingress := sampleIngress("slowservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{
+		connectTimeoutAnnotation: "5000",
+		readTimeoutAnnotation:    "300000",
+		writeTimeoutAnnotation:   "-1",
+	}
+	apiName := getQualifiedName(&ingress)
+
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	kongAPI.UpstreamConnectTimeout = 60000
+	kongAPI.UpstreamReadTimeout = 60000
+	kongAPI.UpstreamSendTimeout = 60000
+	patches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			patches++
+			// The malformed write timeout is skipped, keeping the timeout kong has
+			testRequestMatches(t, request, http.MethodPatch, map[string]interface{}{"upstream_connect_timeout": 5000, "upstream_read_timeout": 300000})
+			kongAPI.UpstreamConnectTimeout = 5000
+			kongAPI.UpstreamReadTimeout = 300000
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("API was patched %d times, want once before reaching a steady state", patches)
+	}
+
+	plain := sampleIngress("slowservice", "prod")
+	apiRequest := apiRequestFromIngress(&plain, getIngressPaths(&plain)[0], parseAnnotations(&plain))
+	if apiRequest.UpstreamConnectTimeout != 0 || apiRequest.UpstreamReadTimeout != 0 || apiRequest.UpstreamSendTimeout != 0 {
+		t.Errorf("API request without annotations has timeouts set, want kong's defaults kept")
+	}
+}
+
+func TestRetriesAnnotationPatchesDriftedRetries(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("rollingservice", "prod")
+	apiName := getQualifiedName(&ingress)
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	kongAPI.Retries = 5
+	patches := []string{}
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			body, _ := ioutil.ReadAll(request.Body)
+			patches = append(patches, strings.TrimSpace(string(body)))
+			kongAPI.Retries = 0
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	// A malformed value is skipped, keeping the retries kong has
+	ingress.ObjectMeta.Annotations = map[string]string{retriesAnnotation: "-1"}
+	if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+		t.Fatalf("Unexpected error reconciling API: %v", err)
+	}
+	if len(patches) != 0 {
+		t.Errorf("API was patched with %v for malformed retries, want it left alone", patches)
+	}
+
+	// No retries at all is a setting of its own rather than kong's default
+	ingress.ObjectMeta.Annotations = map[string]string{retriesAnnotation: "0"}
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if len(patches) != 1 || patches[0] != `{"retries":0}` {
+		t.Errorf("API was patched with %v, want retries patched to 0 once", patches)
+	}
+}
+
+func TestMethodsAnnotationRestrictsAPIMethods(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("readonlyservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{methodsAnnotation: "get, head"}
+	apiName := getQualifiedName(&ingress)
+	if apiRequest := apiRequestFromIngress(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); apiRequest.Methods != "GET,HEAD" {
+		t.Errorf("API request has methods '%s', want 'GET,HEAD'", apiRequest.Methods)
+	}
+
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	kongAPI.Methods = []string{"GET", "POST"}
+	patches := []string{}
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			body, _ := ioutil.ReadAll(request.Body)
+			patches = append(patches, strings.TrimSpace(string(body)))
+			kongAPI.Methods = []string{"HEAD", "GET"}
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	// The methods are compared regardless of their order
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if len(patches) != 1 || patches[0] != `{"methods":["GET","HEAD"]}` {
+		t.Errorf("API was patched with %v, want the methods patched once", patches)
+	}
+
+	// Without the annotation every method is matched
+	plain := sampleIngress("readonlyservice", "prod")
+	if apiRequest := apiRequestFromIngress(&plain, getIngressPaths(&plain)[0], parseAnnotations(&plain)); apiRequest.Methods != "" {
+		t.Errorf("API request without annotations has methods '%s', want them unrestricted", apiRequest.Methods)
+	}
+	for _, value := range []string{"GET,,HEAD", "GET HEAD", "GET;POST"} {
+		if err := validateMethods(value); err == nil {
+			t.Errorf("Expected an error validating methods '%s'", value)
+		}
+	}
+}
+
+func TestAPIWithoutStripURIPatchedInsteadOfPanicking(t *testing.T) {
+	for _, strategy := range []string{PatchStrategyField, PatchStrategyMerge} {
+		reconcileWithoutStripURI(t, strategy)
+	}
+}
+
+func reconcileWithoutStripURI(t *testing.T, strategy string) {
+	setup()
+	defer shutdown()
+	kiController.PatchStrategy = strategy
+
+	ingress := sampleIngress("v1service", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{stripURIAnnotation: "false"}
+	apiName := getQualifiedName(&ingress)
+
+	// Apis created outside the controller or by older versions of kong may have no strip_uri at all
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	patches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			patches++
+			testRequestMatches(t, request, http.MethodPatch, map[string]interface{}{"strip_uri": false})
+			stripURI := false
+			kongAPI.StripURI = &stripURI
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API with the %s strategy: %v", strategy, err)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("API without strip uri was patched %d times with the %s strategy, want once", patches, strategy)
+	}
+}
+
+func TestUnreachableKongFailsReconcileWithoutPanicking(t *testing.T) {
+	setup()
+	defer shutdown()
+	// The transport fails before any response, as it does when the kong admin host cannot be resolved
+	unreachableClient, _ := kong.NewClient(&http.Client{Transport: unreachableTransport{}}, server.URL)
+	kiController.KongClient = unreachableClient
+	kiController.KongMaxRetries = 0
+
+	ingress := sampleIngress("someservice", "prod")
+	if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err == nil {
+		t.Error("Reconcile succeeded while kong cannot be reached")
+	}
+}
+
+// unreachableTransport fails every request without a response
+type unreachableTransport struct{}
+
+func (unreachableTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("dial tcp: lookup kong-admin: no such host")
+}
+
+func TestHTTPSUpstreamSchemeAnnotationPatchesUpstreamURL(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("tlsservice", "prod")
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort = intstr.FromInt(443)
+	apiName := getQualifiedName(&ingress)
+
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	ingress.ObjectMeta.Annotations = map[string]string{upstreamSchemeAnnotation: "https"}
+	expectedURL := "https://tlsservice.prod:443"
+	if upstreamURL := getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); upstreamURL != expectedURL {
+		t.Fatalf("Upstream URL is '%s', want '%s'", upstreamURL, expectedURL)
+	}
+
+	patches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			patches++
+			testRequestMatches(t, request, http.MethodPatch, kong.ApiRequest{ID: apiName, UpstreamURL: expectedURL})
+			kongAPI.UpstreamURL = expectedURL
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("API was patched %d times, want once before reaching a steady state", patches)
+	}
+}
+
+func TestUpstreamServiceAnnotationPatchedAndReverted(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("canaryservice", "prod")
+	apiName := getQualifiedName(&ingress)
+	backendURL := getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	ingress.ObjectMeta.Annotations = map[string]string{upstreamServiceAnnotation: "service-1-canary"}
+	canaryURL := "http://service-1-canary.prod:32000"
+	if upstreamURL := getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); upstreamURL != canaryURL {
+		t.Fatalf("Upstream URL is '%s', want '%s'", upstreamURL, canaryURL)
+	}
+	if serviceName := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName; serviceName != "service-1" {
+		t.Fatalf("Backend of the ingress changed to '%s'", serviceName)
+	}
+
+	patched := []string{}
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			patch := kong.ApiRequest{}
+			if err := json.NewDecoder(request.Body).Decode(&patch); err != nil {
+				t.Fatalf("Error decoding api patch: %v", err)
+			}
+			patched = append(patched, patch.UpstreamURL)
+			kongAPI.UpstreamURL = patch.UpstreamURL
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	ingress.ObjectMeta.Annotations = nil
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if strings.Join(patched, ",") != canaryURL+","+backendURL {
+		t.Errorf("Upstream URL patched to %v, want '%s' and back to '%s'", patched, canaryURL, backendURL)
+	}
+}
+
+func TestUpstreamPathAnnotationPatchesUpstreamURL(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("legacyservice", "prod")
+	ingress.Spec.Rules[0].HTTP.Paths[0].Path = "/api/v1"
+	apiName := getQualifiedName(&ingress)
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.Uris = []string{"/api/v1"}
+	kongAPI.PreserveHost = true
+	stripURI := true
+	kongAPI.StripURI = &stripURI
+	ingress.ObjectMeta.Annotations = map[string]string{stripURIAnnotation: "true", upstreamPathAnnotation: "/backendprefix/"}
+	expectedURL := "http://service-1.prod:32000/backendprefix"
+	if upstreamURL := getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); upstreamURL != expectedURL {
+		t.Fatalf("Upstream URL is '%s', want '%s'", upstreamURL, expectedURL)
+	}
+
+	patches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			patches++
+			testRequestMatches(t, request, http.MethodPatch, kong.ApiRequest{ID: apiName, UpstreamURL: expectedURL})
+			kongAPI.UpstreamURL = expectedURL
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("API was patched %d times, want once before reaching a steady state", patches)
+	}
+	for _, value := range []string{"backendprefix", "/backend?version=1", "/backend#top"} {
+		if err := validateUpstreamPath(value); err == nil {
+			t.Errorf("Expected an error validating upstream path '%s'", value)
+		}
+	}
+}
+
+func TestKongAPIForNonRootPathMatchesItsUri(t *testing.T) {
+	setup()
+	defer shutdown()
+	waitGroup := sync.WaitGroup{}
+
+	ingress := sampleIngress("somename", "infra")
+	ingress.Spec.Rules[0].HTTP.Paths[0].Path = "/somepath"
+	expectedAPI := getAPIRequestFromIngress(&ingress)
+	expectedAPI.Uris = "/somepath"
+
+	waitGroup.Add(1)
+	go testKongOperationCalled(t, "/apis", http.MethodPost, expectedAPI, nil, &waitGroup)
+
+	syncIngress(kiController, &ingress)
+	waitGroup.Wait()
+}
+
+func TestKongAPIPerPathOfRule(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleMultiPathIngress("somename", "infra")
+	createdAPIs := map[string]string{}
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		apiRequest := kong.ApiRequest{}
+		json.NewDecoder(request.Body).Decode(&apiRequest)
+		createdAPIs[apiRequest.Name] = apiRequest.Uris + " " + apiRequest.UpstreamURL
+	})
+
+	if _, err := kiController.ReconcileIngress(context.Background(), &ingress); err != nil {
+		t.Fatalf("Unexpected error reconciling ingress: %v", err)
+	}
+	expectedAPIs := map[string]string{
+		"somename.somedomain~infra":         " http://service-1.infra:32000",
+		"somename.somedomain-newpath~infra": "/newpath http://service-2.infra:32000",
+	}
+	if !reflect.DeepEqual(createdAPIs, expectedAPIs) {
+		t.Errorf("Created APIs %v for the paths of the rule, want %v", createdAPIs, expectedAPIs)
+	}
+}
+
+func TestReaperKeepsAPIsOfEveryPath(t *testing.T) {
+	setup()
+	defer shutdown()
+	waitGroup := sync.WaitGroup{}
+
+	ingress := sampleMultiPathIngress("somename", "infra")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&ingress)
+
+	removedPathAPI := "somename.somedomain-oldpath~infra"
+	kongApis := kong.Apis{Data: []*kong.Api{{Name: removedPathAPI}}}
+	for _, path := range getIngressPaths(&ingress) {
+		apiName := getPathAPIName(&ingress, path)
+		kongApis.Data = append(kongApis.Data, &kong.Api{Name: apiName})
+		mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+			t.Errorf("API of a path of the ingress should not be reaped, got %s", request.Method)
+		})
+	}
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongApis)
+	})
+	waitGroup.Add(1)
+	go testAPIDeleted(t, removedPathAPI, &waitGroup)
+
+	kiController.ingressStore = ingressStore
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Errorf("Unexpected error reaping apis: %v", err)
+	}
+
+	waitGroup.Wait()
+}
+
+func TestControllerIgnoresIngressWithDuplicatePaths(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	unsupportedIngress := sampleMultiPathIngress("somename", "infra")
+	unsupportedIngress.Spec.Rules[0].HTTP.Paths[1].Path = ""
 
 	// This will match everything until we add more specific handlers
-	mux.HandleFunc("/apis/somename.infra", func(writer http.ResponseWriter, request *http.Request) {
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 		t.Fatal("No requests to Kong expected for unsupported ingress")
 	})
 
-	ingressChanged(kongClient)(&unsupportedIngress)
+	syncIngress(kiController, &unsupportedIngress)
+}
+
+func TestIngressWithCollidingAPINamesRefused(t *testing.T) {
+	trailingSlash := sampleMultiPathIngress("somename", "infra")
+	trailingSlash.Spec.Rules[0].HTTP.Paths[0].Path = "/foo"
+	trailingSlash.Spec.Rules[0].HTTP.Paths[1].Path = "/foo/"
+
+	replacedChars := sampleMultiPathIngress("somename", "infra")
+	replacedChars.Spec.Rules[0].Host = "a"
+	replacedChars.Spec.Rules[0].HTTP.Paths = replacedChars.Spec.Rules[0].HTTP.Paths[:1]
+	replacedChars.Spec.Rules[0].HTTP.Paths[0].Path = "/b-c"
+	otherPath := replacedChars.Spec.Rules[0].HTTP.Paths[0]
+	otherPath.Path = "/c"
+	replacedChars.Spec.Rules = append(replacedChars.Spec.Rules, v1beta1.IngressRule{
+		Host: "a-b",
+		IngressRuleValue: v1beta1.IngressRuleValue{
+			HTTP: &v1beta1.HTTPIngressRuleValue{Paths: []v1beta1.HTTPIngressPath{otherPath}},
+		},
+	})
+
+	for _, ingress := range []v1beta1.Ingress{trailingSlash, replacedChars} {
+		if err := validateIngressSupported(&ingress); err == nil {
+			t.Errorf("Expected an error validating an ingress whose paths get the same API name, rules %v", ingress.Spec.Rules)
+		}
+	}
+}
+
+func TestMixedCaseIngressNameIsNotRecreated(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("Mixed_Case:Service", "prod")
+	apiName := getQualifiedName(&ingress)
+	if apiName != "mixed_case-service.prod" {
+		t.Errorf("Ingress name was normalized to '%s', want 'mixed_case-service.prod'", apiName)
+	}
+
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writeObjectResponse(t, &writer, kongAPI)
+	})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("API that exists under its normalized name should not be created again, got %s", request.Method)
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+}
+
+func TestRequireOptInClaimsOnlyOptedInIngresses(t *testing.T) {
+	optedIn := sampleIngress("optedin", "prod")
+	optedIn.ObjectMeta.Annotations = map[string]string{managedAnnotation: "true"}
+	optedOut := sampleIngress("optedout", "prod")
+	optedOut.ObjectMeta.Annotations = map[string]string{managedAnnotation: "false"}
+	unannotated := sampleIngress("unannotated", "prod")
+
+	kiController := KongIngressController{RequireOptIn: true}
+	if fairGame, reason := ingressIsFairGame(&kiController, &optedIn); !fairGame {
+		t.Errorf("Opted in ingress should be claimed, was ignored for %s", reason)
+	}
+	for _, ingress := range []v1beta1.Ingress{optedOut, unannotated} {
+		if fairGame, reason := ingressIsFairGame(&kiController, &ingress); fairGame || reason != ignoredOptIn {
+			t.Errorf("Ingress '%s' without opt in should be ignored for %s, got claimed %v for '%s'", ingress.ObjectMeta.Name, ignoredOptIn, fairGame, reason)
+		}
+	}
+
+	kiController.RequireOptIn = false
+	if fairGame, reason := ingressIsFairGame(&kiController, &unannotated); !fairGame {
+		t.Errorf("Ingress without opt in should be claimed when opt in is not required, was ignored for %s", reason)
+	}
+}
+
+func TestConfiguredIngressClassClaimed(t *testing.T) {
+	kiController := New(nil, nil, nil)
+	kiController.IngressClass = "kong-internal"
+	kiController.ClaimUnsetClass = false
+
+	classes := map[string]bool{
+		"kong-internal": true,
+		"kong-external": false,
+		"kong":          false,
+		"":              false,
+	}
+	for class, expected := range classes {
+		ingress := sampleIngress("someservice", "prod")
+		if class != "" {
+			ingress.ObjectMeta.Annotations = map[string]string{ingressClassAnnotation: class}
+		}
+		if fairGame, _ := ingressIsFairGame(kiController, &ingress); fairGame != expected {
+			t.Errorf("Ingress of class '%s' claimed: %v, want %v", class, fairGame, expected)
+		}
+	}
+
+	kiController.ClaimUnsetClass = true
+	ingress := sampleIngress("someservice", "prod")
+	if fairGame, _ := ingressIsFairGame(kiController, &ingress); !fairGame {
+		t.Error("Ingress without a class should be claimed with ClaimUnsetClass")
+	}
+}
+
+func TestRequestMutatorsAppliedBeforeSending(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("mutatedservice", "prod")
+	kiController.RequestMutators = []RequestMutator{
+		func(apiRequest *kong.ApiRequest, ingress *v1beta1.Ingress) {
+			apiRequest.Name = "team-" + apiRequest.Name
+		},
+		func(apiRequest *kong.ApiRequest, ingress *v1beta1.Ingress) {
+			apiRequest.Hosts += "," + ingress.ObjectMeta.Name + ".internal"
+		},
+	}
+	expectedRequest := getAPIRequestFromIngress(&ingress)
+	expectedRequest.Name = "team-mutatedservice.prod"
+	expectedRequest.Hosts = "mutatedservice.somedomain,mutatedservice.internal"
+
+	created := false
+	mux.HandleFunc("/apis/"+expectedRequest.Name, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			t.Errorf("API matching the mutated request should not be changed, got %s", request.Method)
+		}
+		if !created {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeObjectResponse(t, &writer, kong.Api{
+			ID:           expectedRequest.Name,
+			Name:         expectedRequest.Name,
+			UpstreamURL:  expectedRequest.UpstreamURL,
+			Hosts:        []string{"mutatedservice.somedomain", "mutatedservice.internal"},
+			PreserveHost: true,
+		})
+	})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, expectedRequest)
+		created = true
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if !created {
+		t.Error("API was not created from the mutated request")
+	}
+}
+
+func TestMergePatchContainsOnlyChangedFields(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.PatchStrategy = PatchStrategyMerge
+
+	ingress := sampleIngress("mergedservice", "prod")
+	apiName := getQualifiedName(&ingress)
+	patched := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kong.Api{
+				ID:                  apiName,
+				Name:                apiName,
+				UpstreamURL:         "http://stale.prod:80",
+				Hosts:               []string{ingress.Spec.Rules[0].Host},
+				PreserveHost:        true,
+				UpstreamReadTimeout: 60000,
+			})
+		default:
+			patched++
+			testRequestMatches(t, request, http.MethodPatch, map[string]interface{}{
+				"upstream_url": getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)),
+			})
+		}
+	})
+
+	action, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling API: %v", err)
+	}
+	if patched != 1 || action != APIUpdated {
+		t.Errorf("API was patched %d times with action '%s', want a single merge patch", patched, action)
+	}
+}
+
+func TestUnmanagedFieldDriftIsNotPatched(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.ManagedFields = []string{"upstream_url", "hosts"}
+
+	ingress := sampleIngress("tunedservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{overrideAnnotation: "slow-upstream"}
+	kiController.CoreClient = fakeclientset.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "slow-upstream", Namespace: "prod"},
+		Data:       map[string]string{overrideConfigMapKey: sampleKongIngressJSON},
+	}).CoreV1()
+	apiName := getQualifiedName(&ingress)
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			// Timeouts and preserve_host have been tuned by hand, away from the override
+			writeObjectResponse(t, &writer, kong.Api{
+				ID:                  apiName,
+				Name:                apiName,
+				UpstreamURL:         getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)),
+				Hosts:               []string{ingress.Spec.Rules[0].Host},
+				PreserveHost:        true,
+				UpstreamReadTimeout: 30000,
+			})
+		default:
+			t.Errorf("Unexpected %s of API with drift only in unmanaged fields", request.Method)
+		}
+	})
+
+	action, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling API: %v", err)
+	}
+	if action != APIUnchanged {
+		t.Errorf("API with drift only in unmanaged fields was '%s', want '%s'", action, APIUnchanged)
+	}
 }
-func TestControllerIgnoresIngressWithNonRootPath(t *testing.T) {
-	setup()
-	defer shutdown()
-
-	unsupportedIngress := sampleIngress("somename", "infra")
-	unsupportedIngress.Spec.Rules[0].HTTP.Paths[0].Path = "/somepath"
-
-	// This will match everything until we add more specific handlers
-	mux.HandleFunc("/apis/somename.infra", func(writer http.ResponseWriter, request *http.Request) {
-		t.Fatal("No requests to Kong expected for unsupported ingress")
-	})
 
-	ingressChanged(kongClient)(&unsupportedIngress)
+func TestDoublePathPrefixDetected(t *testing.T) {
+	stripURI := true
+	cases := []struct {
+		ingressPath string
+		upstreamURL string
+		stripURI    *bool
+		expected    bool
+	}{
+		{"/api", "http://svc.prod:80/api", nil, true},
+		{"/api/", "http://svc.prod:80/v1/api/", nil, true},
+		{"/api", "http://svc.prod:80/api", &stripURI, false},
+		{"/api", "http://svc.prod:80/myapi", nil, false},
+		{"/api", "http://svc.prod:80", nil, false},
+		{"/", "http://svc.prod:80/", nil, false},
+	}
+	for _, c := range cases {
+		apiRequest := kong.ApiRequest{UpstreamURL: c.upstreamURL, StripURI: c.stripURI}
+		if detected := hasDoublePrefix(c.ingressPath, apiRequest); detected != c.expected {
+			t.Errorf("Double prefix for path '%s' and upstream '%s' (strip %v) detected as %v, want %v", c.ingressPath, c.upstreamURL, c.stripURI != nil, detected, c.expected)
+		}
+	}
 }
 
-func TestControllerIgnoresIngressWithMultiplePaths(t *testing.T) {
+func TestControllerRejectsIngressWithTooManyPaths(t *testing.T) {
 	setup()
 	defer shutdown()
 
-	unsupportedIngress := sampleIngress("somename", "infra")
-	newPath := sampleIngress("somename", "infra").Spec.Rules[0].HTTP.Paths[0]
-	newPath.Path = "/newpath"
-	unsupportedIngress.Spec.Rules[0].HTTP.Paths = append(unsupportedIngress.Spec.Rules[0].HTTP.Paths, newPath)
+	recorder := record.NewFakeRecorder(10)
+	kiController.Recorder = recorder
+	kiController.MaxPathsPerIngress = 3
+
+	oversizedIngress := sampleIngress("somename", "infra")
+	paths := &oversizedIngress.Spec.Rules[0].HTTP.Paths
+	for i := 0; i < 3; i++ {
+		newPath := (*paths)[0]
+		newPath.Path = fmt.Sprintf("/path%d", i)
+		*paths = append(*paths, newPath)
+	}
+
 	// This will match everything until we add more specific handlers
-	mux.HandleFunc("/apis/somename.infra", func(writer http.ResponseWriter, request *http.Request) {
-		t.Fatal("No requests to Kong expected for unsupported ingress")
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		t.Fatal("No requests to Kong expected for an ingress over the path limit")
 	})
 
-	ingressChanged(kongClient)(&unsupportedIngress)
+	syncIngress(kiController, &oversizedIngress)
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning TooManyPaths") {
+			t.Errorf("Unexpected event for ingress over the path limit: %s", event)
+		}
+	default:
+		t.Error("No warning event raised for ingress over the path limit")
+	}
 }
 
 func TestKongUpdatedOnDeletedIngress(t *testing.T) {
@@ -126,7 +963,7 @@ func TestKongUpdatedOnDeletedIngress(t *testing.T) {
 	waitGroup.Add(1)
 	go testAPIDeleted(t, getQualifiedName(&ingress), &waitGroup)
 
-	ingressDeleted(kongClient)(&ingress)
+	syncDeletedIngress(kiController, &ingress)
 
 	waitGroup.Wait()
 }
@@ -142,10 +979,43 @@ func TestKongUpdatedOnNewIngress(t *testing.T) {
 	waitGroup.Add(1)
 	go testKongOperationCalled(t, "/apis", http.MethodPost, getAPIRequestFromIngress(&newIngress), nil, &waitGroup)
 
-	ingressChanged(kongClient)(&newIngress)
+	syncIngress(kiController, &newIngress)
 	waitGroup.Wait()
 }
 
+func TestNewIngressReconcileDelayedByCreateGraceDelay(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.CreateGraceDelay = 50 * time.Millisecond
+
+	newIngress := sampleIngress("freshservice", "prod")
+	newIngress.ObjectMeta.CreationTimestamp = metav1.Now()
+	created := make(chan time.Time, 1)
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, getAPIRequestFromIngress(&newIngress))
+		created <- time.Now()
+	})
+
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&newIngress)
+	kiController.ingressStore = ingressStore
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+	go kiController.runWorker()
+
+	added := time.Now()
+	ingressAdded(kiController)(&newIngress)
+
+	select {
+	case createdAt := <-created:
+		if createdAt.Sub(added) < 40*time.Millisecond {
+			t.Errorf("API was created after %v, before the create grace delay", createdAt.Sub(added))
+		}
+	case <-time.After(time.Second):
+		t.Error("API was not created once the create grace delay passed")
+	}
+}
+
 func TestKongUpdatedOnIngressBackendServiceUpdate(t *testing.T) {
 	setup()
 	defer shutdown()
@@ -156,7 +1026,7 @@ func TestKongUpdatedOnIngressBackendServiceUpdate(t *testing.T) {
 	originalIngress := sampleIngress(serviceName, serviceNamespace)
 	qualifiedName := getQualifiedName(&originalIngress)
 	newIngress := sampleIngress(serviceName, serviceNamespace)
-	ingressBackend := getIngressBackend(&newIngress)
+	ingressBackend := getIngressBackend(getIngressPaths(&newIngress)[0])
 	ingressBackend.ServiceName = ingressBackend.ServiceName + "v2"
 
 	expectedAPIPatch := kong.ApiRequest{
@@ -177,7 +1047,7 @@ func TestKongUpdatedOnIngressBackendServicePortUpdate(t *testing.T) {
 	originalIngress := sampleIngress(serviceName, serviceNamespace)
 	qualifiedName := getQualifiedName(&originalIngress)
 	newIngress := sampleIngress(serviceName, serviceNamespace)
-	ingressBackend := getIngressBackend(&newIngress)
+	ingressBackend := getIngressBackend(getIngressPaths(&newIngress)[0])
 	ingressBackend.ServicePort = intstr.FromInt(ingressBackend.ServicePort.IntValue() + 1)
 
 	expectedAPIPatch := kong.ApiRequest{
@@ -208,6 +1078,76 @@ func TestKongUpdatedOnIngressHostUpdate(t *testing.T) {
 	testKongAPIPatched(t, &originalIngress, &newIngress, &expectedAPIPatch)
 }
 
+func TestKongAPIRecreatedWhenPatchRefused(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("bestservice", "prod")
+	existingAPI := apiFromIngress(&ingress)
+	existingAPI.ID = "old-api-id"
+	kiController.RecreateOnImmutable = true
+
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(1)
+	go testKongOperationCalled(t, "/apis/"+getQualifiedName(&ingress), http.MethodGet, nil, existingAPI, &waitGroup)
+	waitGroup.Add(1)
+	go testKongOperationCalled(t, "/apis", http.MethodPost, apiRequestFromIngress(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)), nil, &waitGroup)
+
+	deleted := make(chan struct{})
+	mux.HandleFunc("/apis/old-api-id", func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPatch:
+			writer.WriteHeader(http.StatusBadRequest)
+		case http.MethodDelete:
+			writer.WriteHeader(http.StatusNoContent)
+			close(deleted)
+		default:
+			t.Errorf("Unexpected request %s %s", request.Method, request.URL.Path)
+		}
+	})
+
+	action, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	waitGroup.Wait()
+	if err != nil {
+		t.Fatalf("Unexpected error recreating the api: %v", err)
+	}
+	if action != APIRecreated {
+		t.Errorf("Ingress reconciled with action %s, want %s", action, APIRecreated)
+	}
+	select {
+	case <-deleted:
+	default:
+		t.Error("API kong refused to patch was not deleted after recreating it")
+	}
+}
+
+func TestKongAPIPatchRefusalFailsWithoutRecreate(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("bestservice", "prod")
+	existingAPI := apiFromIngress(&ingress)
+	existingAPI.ID = "old-api-id"
+
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(1)
+	go testKongOperationCalled(t, "/apis/"+getQualifiedName(&ingress), http.MethodGet, nil, existingAPI, &waitGroup)
+	mux.HandleFunc("/apis/old-api-id", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPatch {
+			t.Errorf("Unexpected request %s %s without -recreate-on-immutable", request.Method, request.URL.Path)
+		}
+		writer.WriteHeader(http.StatusBadRequest)
+	})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Unexpected request %s %s without -recreate-on-immutable", request.Method, request.URL.Path)
+	})
+
+	if err := syncIngress(kiController, &ingress); err == nil {
+		t.Error("Expected the refused patch to fail the reconcile")
+	}
+	waitGroup.Wait()
+}
+
 func TestKongReconciledWithNewIngresss(t *testing.T) {
 	setup()
 	defer shutdown()
@@ -229,9 +1169,9 @@ func TestKongReconciledWithNewIngresss(t *testing.T) {
 
 	// Create missing API
 	waitGroup.Add(1)
-	go testKongOperationCalled(t, "/apis", http.MethodPost, apiRequestFromIngress(&sampleIngress), nil, &waitGroup)
+	go testKongOperationCalled(t, "/apis", http.MethodPost, apiRequestFromIngress(&sampleIngress, getIngressPaths(&sampleIngress)[0], parseAnnotations(&sampleIngress)), nil, &waitGroup)
 
-	kiController := KongIngressController{restClient, kongClient}
+	kiController := KongIngressController{IngressClient: restClient, KongClient: kongClient}
 	ctx, _ := context.WithTimeout(context.Background(), time.Millisecond*5)
 	kiController.createWatches(ctx)
 
@@ -286,13 +1226,335 @@ func TestKongReconciledWithDeletedIngresss(t *testing.T) {
 		t.Fatal("Could not create rest client")
 	}
 
-	kiController := KongIngressController{restClient, kongClient}
-	ctx, _ := context.WithTimeout(context.Background(), time.Millisecond)
+	kiController := KongIngressController{IngressClient: restClient, KongClient: kongClient}
+	ctx, _ := context.WithTimeout(context.Background(), time.Millisecond*50)
+	kiController.Run(ctx)
+
+	waitGroup.Wait()
+}
+
+func TestDeletedIngressCleanedUpOnStartup(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	waitGroup := sync.WaitGroup{}
+
+	liveIngress := sampleIngress("liveservice", "infra")
+	deletedAPI := "deletedservice.infra"
+	waitGroup.Add(1)
+	go testKongOperationCalled(t, "/apis", http.MethodGet, nil, kong.Apis{
+		Data: []*kong.Api{
+			{Name: getQualifiedName(&liveIngress)},
+			{Name: deletedAPI},
+		},
+	}, &waitGroup)
+	waitGroup.Add(1)
+	go testAPIDeleted(t, deletedAPI, &waitGroup)
+	mux.HandleFunc("/apis/"+getQualifiedName(&liveIngress), func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodDelete {
+			t.Error("API of an ingress that still exists should not be deleted on startup")
+		}
+		writeObjectResponse(t, &writer, apiFromIngress(&liveIngress))
+	})
+
+	restClient, err := mockRESTClient([]v1beta1.Ingress{liveIngress})
+	if err != nil {
+		t.Fatal("Could not create rest client")
+	}
+
+	// The reaper would not run until a full resync interval has passed, so only the startup pass can delete the api
+	kiController := New(restClient, nil, kongClient)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	kiController.Run(ctx)
+
+	waitGroup.Wait()
+}
+
+func TestReaperRunsOnItsOwnInterval(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	// Startup cleans up once, and the resync interval of the tests is far longer than the reap interval
+	listings := int32(0)
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&listings, 1)
+		writeObjectResponse(t, &writer, kong.Apis{})
+	})
+
+	restClient, err := mockRESTClient([]v1beta1.Ingress{})
+	if err != nil {
+		t.Fatal("Could not create rest client")
+	}
+	kiController := New(restClient, nil, kongClient)
+	kiController.ReapInterval = time.Millisecond * 10
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*90)
+	defer cancel()
+	kiController.Run(ctx)
+
+	if got := atomic.LoadInt32(&listings); got < 3 {
+		t.Errorf("Kong apis listed %d times, want a reap every reap interval", got)
+	}
+}
+
+func TestDisabledReaperDeletesNothing(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Unexpected request %s %s with the reaper disabled", request.Method, request.URL.Path)
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+
+	restClient, err := mockRESTClient([]v1beta1.Ingress{})
+	if err != nil {
+		t.Fatal("Could not create rest client")
+	}
+	kiController := New(restClient, nil, kongClient)
+	kiController.ReapInterval = 0
+	kiController.ReaperStaleTimeout = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
 	kiController.Run(ctx)
 
+	if err := kiController.CheckReaperHealthy(); err != nil {
+		t.Errorf("Disabled reaper should not be reported stale, got: %v", err)
+	}
+}
+
+func TestReapIntervalJittered(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if interval := jitterReapInterval(time.Minute); interval < time.Minute || interval > time.Minute+6*time.Second {
+			t.Fatalf("Jittered reap interval is %v, want between %v and %v", interval, time.Minute, time.Minute+6*time.Second)
+		}
+	}
+}
+
+func TestReaperUsesIngressCache(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	waitGroup := sync.WaitGroup{}
+
+	cachedIngress := sampleIngress("cachedservice", "infra")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&cachedIngress)
+
+	orphanedAPI := "orphanedAPI"
+	waitGroup.Add(1)
+	go testKongOperationCalled(t, "/apis", http.MethodGet, nil, kong.Apis{
+		Data: []*kong.Api{
+			{Name: getQualifiedName(&cachedIngress)},
+			{Name: orphanedAPI},
+		},
+	}, &waitGroup)
+	waitGroup.Add(1)
+	go testAPIDeleted(t, orphanedAPI, &waitGroup)
+	mux.HandleFunc("/apis/"+getQualifiedName(&cachedIngress), func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("API of cached ingress should not be reaped, got %s", request.Method)
+	})
+
+	// Without an ingress client, any attempt to list ingresses from the API server rather than the cache would panic
+	kiController := New(nil, nil, kongClient)
+	kiController.ingressStore = ingressStore
+	err := reapOrphanedApis(kiController)
+	if err != nil {
+		t.Errorf("Unexpected error reaping apis: %v", err)
+	}
+
+	waitGroup.Wait()
+}
+
+func TestReaperRefusesMassDeletion(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	cachedIngress := sampleIngress("cachedservice", "infra")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&cachedIngress)
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Apis{Data: []*kong.Api{
+			{Name: getQualifiedName(&cachedIngress)},
+			{Name: "shopservice.infra"},
+			{Name: "cartservice.infra"},
+			{Name: "payservice.infra"},
+		}})
+	})
+	deleted := []string{}
+	mux.HandleFunc("/apis/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodDelete {
+			deleted = append(deleted, strings.TrimPrefix(request.URL.Path, "/apis/"))
+			writer.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeObjectResponse(t, &writer, kong.Api{Name: strings.TrimPrefix(request.URL.Path, "/apis/")})
+	})
+
+	kiController.ingressStore = ingressStore
+	kiController.ReapMaxDelete = 2
+	trips := testutil.ToFloat64(reaperSafetyTripsCounter)
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Fatalf("Unexpected error reaping apis: %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("Reaper deleted %v, want nothing deleted with more orphans than the limit", deleted)
+	}
+	if got := testutil.ToFloat64(reaperSafetyTripsCounter); got != trips+1 {
+		t.Errorf("Reaper safety trips counter is %v, want %v", got, trips+1)
+	}
+
+	// Three orphans out of four managed apis are more than half of them, but within 75%
+	kiController.ReapMaxDelete = 0
+	kiController.ReapMaxDeletePercent = 50
+	reapOrphanedApis(kiController)
+	if len(deleted) != 0 {
+		t.Errorf("Reaper deleted %v, want nothing deleted with more than half of the apis orphaned", deleted)
+	}
+	kiController.ReapMaxDeletePercent = 75
+	reapOrphanedApis(kiController)
+	if len(deleted) != 3 {
+		t.Errorf("Reaper deleted %v, want every orphan deleted within the limit", deleted)
+	}
+}
+
+func TestReapMaxDeleteParsed(t *testing.T) {
+	if count, percent, err := ParseReapMaxDelete("20"); err != nil || count != 20 || percent != 0 {
+		t.Errorf("Reap limit '20' parsed as %d apis and %d%%, error %v", count, percent, err)
+	}
+	if count, percent, err := ParseReapMaxDelete("10%"); err != nil || count != 0 || percent != 10 {
+		t.Errorf("Reap limit '10%%' parsed as %d apis and %d%%, error %v", count, percent, err)
+	}
+	for _, value := range []string{"-1", "150%", "ten", "%"} {
+		if _, _, err := ParseReapMaxDelete(value); err == nil {
+			t.Errorf("Expected an error parsing reap limit '%s'", value)
+		}
+	}
+}
+
+func TestResyncReconcilesAgainstReaperListing(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("listedservice", "infra")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&ingress)
+	kiController.ingressStore = ingressStore
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.Hosts = []string{ingress.Spec.Rules[0].Host}
+	kongAPI.PreserveHost = true
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Apis{Data: []*kong.Api{&kongAPI}})
+	})
+	fetches := 0
+	mux.HandleFunc("/apis/"+kongAPI.Name, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			t.Errorf("Unchanged API should not be changed, got %s", request.Method)
+		}
+		fetches++
+		writeObjectResponse(t, &writer, kongAPI)
+	})
+
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Fatalf("Unexpected error reaping apis: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	// The listed api stands in for the first fetch only, since that reconcile could have changed it
+	if fetches != 1 {
+		t.Errorf("API was fetched %d times, want once after the reaper listed it", fetches)
+	}
+}
+
+func TestReaperOnlyDeletesAPIsWithManagedPrefix(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.ManagedPrefix = "kic-"
+	waitGroup := sync.WaitGroup{}
+
+	cachedIngress := sampleIngress("cachedservice", "infra")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&cachedIngress)
+	managedAPI := "kic-" + getQualifiedName(&cachedIngress)
+	if apiName := getAPIName(kiController, &cachedIngress, getIngressPaths(&cachedIngress)[0]); apiName != managedAPI {
+		t.Errorf("API of the ingress is named '%s', want '%s'", apiName, managedAPI)
+	}
+	if apiRequest := desiredAPIRequest(kiController, &cachedIngress, getIngressPaths(&cachedIngress)[0], parseAnnotations(&cachedIngress), nil); apiRequest.Name != managedAPI {
+		t.Errorf("API of the ingress is created as '%s', want '%s'", apiRequest.Name, managedAPI)
+	}
+
+	orphanedAPI := "kic-orphanedservice.infra"
+	kongApis := kong.Apis{Data: []*kong.Api{{Name: managedAPI}, {Name: orphanedAPI}}}
+	for _, name := range []string{"manualservice.infra", "other-controller-service.infra", getQualifiedName(&cachedIngress)} {
+		kongApis.Data = append(kongApis.Data, &kong.Api{Name: name})
+		mux.HandleFunc("/apis/"+name, func(writer http.ResponseWriter, request *http.Request) {
+			t.Errorf("API without the managed prefix should not be reaped, got %s", request.Method)
+		})
+	}
+	mux.HandleFunc("/apis/"+managedAPI, func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("API of cached ingress should not be reaped, got %s", request.Method)
+	})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongApis)
+	})
+	waitGroup.Add(1)
+	go testAPIDeleted(t, orphanedAPI, &waitGroup)
+
+	kiController.ingressStore = ingressStore
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Errorf("Unexpected error reaping apis: %v", err)
+	}
+
 	waitGroup.Wait()
 }
 
+func TestReaperTimeBudgetResumesNextCycle(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	orphanedAPIs := []string{"orphan-1", "orphan-2", "orphan-3"}
+	kongApis := kong.Apis{}
+	for _, name := range orphanedAPIs {
+		kongApis.Data = append(kongApis.Data, &kong.Api{Name: name})
+	}
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongApis)
+	})
+	deleted := map[string]bool{}
+	for _, name := range orphanedAPIs {
+		name := name
+		mux.HandleFunc("/apis/"+name, func(writer http.ResponseWriter, request *http.Request) {
+			switch request.Method {
+			case http.MethodGet:
+				writeObjectResponse(t, &writer, kong.Api{ID: name, Name: name})
+			case http.MethodDelete:
+				time.Sleep(20 * time.Millisecond)
+				deleted[name] = true
+			}
+		})
+	}
+
+	kiController := KongIngressController{
+		KongClient:       kongClient,
+		ingressStore:     cache.NewStore(cache.MetaNamespaceKeyFunc),
+		ReaperTimeBudget: 10 * time.Millisecond,
+	}
+	for cycle := 1; cycle <= len(orphanedAPIs); cycle++ {
+		if err := reapOrphanedApis(&kiController); err != nil {
+			t.Fatalf("Unexpected error reaping apis: %v", err)
+		}
+		if len(deleted) != cycle {
+			t.Fatalf("%d apis were reaped after cycle %d, want %d", len(deleted), cycle, cycle)
+		}
+		kongApis.Data = kongApis.Data[1:]
+	}
+}
+
 func TestResilienceToKongUnavailable(t *testing.T) {
 	setup()
 	defer shutdown()
@@ -310,7 +1572,7 @@ func TestResilienceToKongUnavailable(t *testing.T) {
 	if err != nil {
 		t.Fatal("Could not create mock REST client")
 	}
-	kiController := KongIngressController{restClient, kongClient}
+	kiController := KongIngressController{IngressClient: restClient, KongClient: kongClient}
 	ctx, _ := context.WithTimeout(context.Background(), time.Millisecond*1100)
 
 	// Start controller without starting mock Kong endpoint
@@ -327,6 +1589,111 @@ func TestResilienceToKongUnavailable(t *testing.T) {
 	waitGroup.Wait()
 }
 
+func TestStopDrainsInFlightReconcile(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("drainedservice", "prod")
+	reconcileStarted := make(chan struct{})
+	releaseReconcile := make(chan struct{})
+	apiCreated := make(chan bool, 1)
+	mux.HandleFunc("/apis/"+getQualifiedName(&ingress), func(writer http.ResponseWriter, request *http.Request) {
+		close(reconcileStarted)
+		<-releaseReconcile
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, nil)
+		apiCreated <- true
+	})
+	mux.HandleFunc("/apis/lateservice.prod", func(writer http.ResponseWriter, request *http.Request) {
+		t.Error("No requests to Kong expected for ingress changes received while draining")
+	})
+
+	go syncIngress(kiController, &ingress)
+	<-reconcileStarted
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- kiController.Stop(time.Second)
+	}()
+
+	// Give Stop the opportunity to return early
+	time.Sleep(time.Millisecond * 20)
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the in-flight reconcile finished")
+	default:
+	}
+
+	lateIngress := sampleIngress("lateservice", "prod")
+	syncIngress(kiController, &lateIngress)
+
+	close(releaseReconcile)
+	if err := <-stopped; err != nil {
+		t.Errorf("Unexpected error draining controller: %v", err)
+	}
+	select {
+	case <-apiCreated:
+	default:
+		t.Error("In-flight reconcile did not complete during drain")
+	}
+}
+
+func TestStopTimesOutOnStuckReconcile(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("stuckservice", "prod")
+	reconcileStarted := make(chan struct{})
+	releaseReconcile := make(chan struct{})
+	defer close(releaseReconcile)
+	mux.HandleFunc("/apis/"+getQualifiedName(&ingress), func(writer http.ResponseWriter, request *http.Request) {
+		close(reconcileStarted)
+		<-releaseReconcile
+		writer.WriteHeader(http.StatusNotFound)
+	})
+
+	go syncIngress(kiController, &ingress)
+	<-reconcileStarted
+
+	if err := kiController.Stop(time.Millisecond * 20); err == nil {
+		t.Error("Expected Stop to time out while a reconcile is stuck")
+	}
+}
+
+func TestRunWaitsForReaperToStop(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	reapStarted := make(chan struct{})
+	reapFinished := make(chan struct{})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		close(reapStarted)
+		time.Sleep(time.Millisecond * 50)
+		close(reapFinished)
+		writeObjectResponse(t, &writer, kong.Apis{})
+	})
+
+	restClient, err := mockRESTClient([]v1beta1.Ingress{})
+	if err != nil {
+		t.Fatal("Could not create rest client")
+	}
+	kiController := New(restClient, nil, kongClient)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-reapStarted
+		cancel()
+	}()
+	kiController.Run(ctx)
+
+	select {
+	case <-reapFinished:
+	default:
+		t.Error("Run returned before the reap cycle in progress finished")
+	}
+}
+
 func testAPIDeleted(t *testing.T, apiName string, waitGroup *sync.WaitGroup) {
 	defer waitGroup.Done()
 	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
@@ -373,7 +1740,7 @@ func testKongAPIPatched(t *testing.T, originalIngress *v1beta1.Ingress, newIngre
 			request:    expectedPatch,
 		}}, &waitGroup)
 
-	ingressChanged(kongClient)(newIngress)
+	syncIngress(kiController, newIngress)
 	waitGroup.Wait()
 }
 
@@ -442,8 +1809,25 @@ func sampleIngress(name string, namespace string) v1beta1.Ingress {
 	}
 }
 
+func sampleMultiRuleIngress(name string, namespace string) v1beta1.Ingress {
+	ingress := sampleIngress(name, namespace)
+	newRule := sampleIngress(name, namespace).Spec.Rules[0]
+	newRule.Host = "some.other.host"
+	ingress.Spec.Rules = append(ingress.Spec.Rules, newRule)
+	return ingress
+}
+
+func sampleMultiPathIngress(name string, namespace string) v1beta1.Ingress {
+	ingress := sampleIngress(name, namespace)
+	newPath := sampleIngress(name, namespace).Spec.Rules[0].HTTP.Paths[0]
+	newPath.Path = "/newpath"
+	newPath.Backend.ServiceName = "service-2"
+	ingress.Spec.Rules[0].HTTP.Paths = append(ingress.Spec.Rules[0].HTTP.Paths, newPath)
+	return ingress
+}
+
 func apiFromIngress(ingress *v1beta1.Ingress) kong.Api {
-	backend := getIngressBackend(ingress)
+	backend := getIngressBackend(getIngressPaths(ingress)[0])
 	return kong.Api{
 		UpstreamURL: fmt.Sprintf("http://%s.%s:%s", backend.ServiceName, ingress.ObjectMeta.Namespace, backend.ServicePort.String()),
 		Name:        getQualifiedName(ingress),
@@ -452,7 +1836,7 @@ func apiFromIngress(ingress *v1beta1.Ingress) kong.Api {
 }
 
 func getAPIRequestFromIngress(ingress *v1beta1.Ingress) kong.ApiRequest {
-	backend := getIngressBackend(ingress)
+	backend := getIngressBackend(getIngressPaths(ingress)[0])
 	return kong.ApiRequest{
 		UpstreamURL:  fmt.Sprintf("http://%s.%s:%s", backend.ServiceName, ingress.ObjectMeta.Namespace, backend.ServicePort.String()),
 		Name:         getQualifiedName(ingress),
@@ -508,6 +1892,9 @@ func setup() {
 
 	kongClient, _ = kong.NewClient(nil, server.URL)
 	FullResyncInterval = time.Millisecond * 100
+	kiController = New(nil, nil, kongClient)
+	kiController.KongRetryBackoff = time.Millisecond
+	cacheSyncPollInterval = time.Millisecond
 	opTimeout = time.Millisecond * 100
 }
 
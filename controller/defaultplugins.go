@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// disablePluginsAnnotation lists the default plugins to leave off the apis of an ingress
+const disablePluginsAnnotation = annotationPrefix + "disable-plugins"
+
+func init() {
+	knownAnnotations[disablePluginsAnnotation] = validateList
+}
+
+// LoadDefaultPlugins reads the plugins to configure on every managed api from a JSON file holding an object with the
+// config of each plugin keyed by its name, like {"correlation-id": {"header_name": "X-Request-ID"}, "prometheus": {}}
+func LoadDefaultPlugins(path string) (map[string]map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read default plugins file '%s'", path)
+	}
+	plugins := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(contents, &plugins); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse default plugins file '%s'", path)
+	}
+	for name, config := range plugins {
+		if strings.TrimSpace(name) == "" {
+			return nil, errors.Errorf("Default plugins file '%s' has a plugin without a name", path)
+		}
+		// A plugin without settings is configured with kong's defaults
+		if config == nil {
+			plugins[name] = map[string]interface{}{}
+		}
+	}
+	return plugins, nil
+}
+
+// defaultPlugins returns the configs of the DefaultPlugins the annotations do not disable
+func (controller *KongIngressController) defaultPlugins(annotations ingressAnnotations) map[string]map[string]interface{} {
+	disabled := map[string]bool{}
+	if value, found := annotations.values[disablePluginsAnnotation]; found {
+		for _, name := range strings.Split(value, ",") {
+			disabled[strings.TrimSpace(name)] = true
+		}
+	}
+	plugins := map[string]map[string]interface{}{}
+	for name, config := range controller.DefaultPlugins {
+		if !disabled[name] {
+			plugins[name] = config
+		}
+	}
+	return plugins
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDefaultPluginsLoadedFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "default-plugins")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plugins.json")
+	ioutil.WriteFile(path, []byte(`{"correlation-id": {"header_name": "X-Request-ID"}, "prometheus": null}`), 0644)
+
+	plugins, err := LoadDefaultPlugins(path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading default plugins: %v", err)
+	}
+	expected := map[string]map[string]interface{}{
+		"correlation-id": {"header_name": "X-Request-ID"},
+		"prometheus":     {},
+	}
+	if !reflect.DeepEqual(plugins, expected) {
+		t.Errorf("Default plugins are %v, want %v", plugins, expected)
+	}
+
+	ioutil.WriteFile(path, []byte(`["correlation-id"]`), 0644)
+	if _, err := LoadDefaultPlugins(path); err == nil {
+		t.Error("Expected an error loading default plugins that are not an object of configs")
+	}
+}
+
+func TestDefaultPluginsOverriddenAndDisabledByAnnotations(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	kiController.DefaultPlugins = map[string]map[string]interface{}{
+		"correlation-id":   {"header_name": "X-Request-ID"},
+		rateLimitingPlugin: {"minute": float64(100)},
+	}
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{rateLimitMinuteAnnotation: "60"}
+	apiName := getQualifiedName(&ingress)
+
+	plugins := map[string]kongPlugin{}
+	changes := []string{}
+	mux.HandleFunc("/apis/"+apiName+"/plugins", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet {
+			list := kongPluginList{Data: []kongPlugin{}}
+			for _, plugin := range plugins {
+				list.Data = append(list.Data, plugin)
+			}
+			writeObjectResponse(t, &writer, list)
+			return
+		}
+		plugin := kongPlugin{}
+		if err := json.NewDecoder(request.Body).Decode(&plugin); err != nil {
+			t.Fatalf("Error decoding plugin: %v", err)
+		}
+		encoded, _ := json.Marshal(plugin.Config)
+		changes = append(changes, "POST "+plugin.Name+" "+string(encoded))
+		plugin.ID = "plugin-" + plugin.Name
+		plugins[plugin.Name] = plugin
+		writer.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/apis/"+apiName+"/plugins/", func(writer http.ResponseWriter, request *http.Request) {
+		name := strings.TrimPrefix(request.URL.Path, "/apis/"+apiName+"/plugins/plugin-")
+		if request.Method != http.MethodDelete {
+			patch := kongPlugin{}
+			if err := json.NewDecoder(request.Body).Decode(&patch); err != nil {
+				t.Fatalf("Error decoding plugin patch: %v", err)
+			}
+			encoded, _ := json.Marshal(patch.Config)
+			changes = append(changes, request.Method+" "+name+" "+string(encoded))
+			for key, value := range patch.Config {
+				plugins[name].Config[key] = value
+			}
+			writeObjectResponse(t, &writer, plugins[name])
+			return
+		}
+		changes = append(changes, request.Method+" "+name)
+		delete(plugins, name)
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	reconcile := func() {
+		if err := reconcilePlugins(kiController, getIngressKey(&ingress), apiName, parseAnnotations(&ingress), nil); err != nil {
+			t.Fatalf("Unexpected error reconciling plugins: %v", err)
+		}
+	}
+	expectChanges := func(expected ...string) {
+		sort.Strings(changes)
+		if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Plugins changed with\n%s\nwant\n%s", strings.Join(changes, "\n"), strings.Join(expected, "\n"))
+		}
+		changes = []string{}
+	}
+
+	// The rate limit of the annotation overrides the default one
+	reconcile()
+	expectChanges(
+		`POST correlation-id {"header_name":"X-Request-ID"}`,
+		`POST rate-limiting {"minute":60}`,
+	)
+
+	// Without the annotation the rate limit goes back to the default
+	ingress.ObjectMeta.Annotations = map[string]string{disablePluginsAnnotation: "correlation-id"}
+	reconcile()
+	expectChanges("DELETE correlation-id", `PATCH rate-limiting {"minute":100}`)
+
+	// Dropping a plugin from the defaults removes it from the apis it was configured on
+	delete(ingress.ObjectMeta.Annotations, disablePluginsAnnotation)
+	reconcile()
+	expectChanges(`POST correlation-id {"header_name":"X-Request-ID"}`)
+	delete(kiController.DefaultPlugins, "correlation-id")
+	reconcile()
+	expectChanges("DELETE correlation-id")
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+)
+
+// dryRunStatus is the status each kind of change is answered with, as kong answers it when the change succeeds
+var dryRunStatus = map[string]int{
+	http.MethodPost:   http.StatusCreated,
+	http.MethodPut:    http.StatusOK,
+	http.MethodPatch:  http.StatusOK,
+	http.MethodDelete: http.StatusNoContent,
+}
+
+// DryRunTransport logs the requests that would change kong instead of making them, answering them as if they had
+// succeeded, while requests that only read from kong are made as usual so that the changes can still be worked out
+type DryRunTransport struct {
+	// Transport makes the requests that read from kong, defaulting to http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (transport *DryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	status, changes := dryRunStatus[req.Method]
+	if !changes {
+		return base.RoundTrip(req)
+	}
+
+	body := []byte{}
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	logged := string(body)
+	// Certificates carry their private key, key-auth credentials their key, and plugins may send to URLs with credentials
+	if _, entity := kongRequestLabels(req); entity == "certificates" && len(body) > 0 {
+		logged = "(certificate not logged)"
+	} else if entity == "key-auth" && len(body) > 0 {
+		logged = "(key not logged)"
+	} else if entity == "plugins" && len(body) > 0 {
+		plugin := kongPlugin{}
+		if err := json.Unmarshal(body, &plugin); err != nil {
+			logged = "(plugin not logged)"
+		} else if redacted, err := json.Marshal(redactedPlugin(plugin)); err == nil {
+			logged = string(redacted)
+		}
+	}
+	logging.Infof(logging.Fields{"method": req.Method, "path": req.URL.Path}, "Dry run: would %s %s %s", req.Method, req.URL.Path, logged)
+
+	// Echoing the request lets callers decode the entity they sent as the one kong would have stored
+	if status == http.StatusNoContent {
+		body = []byte{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestDryRunReadsKongWithoutChangingIt(t *testing.T) {
+	setup()
+	defer shutdown()
+	dryRunClient, _ := kong.NewClient(NewKongHTTPClient(nil, true), server.URL)
+	kiController.KongClient = dryRunClient
+
+	ingress := sampleIngress("newservice", "prod")
+	apiName := getQualifiedName(&ingress)
+	fetches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			t.Errorf("Dry run changed kong with %s %s", request.Method, request.URL.Path)
+		}
+		fetches++
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Dry run changed kong with %s %s", request.Method, request.URL.Path)
+	})
+
+	action, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling in a dry run: %v", err)
+	}
+	if action != APICreated {
+		t.Errorf("Reconcile action is '%s', want '%s'", action, APICreated)
+	}
+	if fetches != 1 {
+		t.Errorf("API was fetched %d times, want once to work out the change", fetches)
+	}
+
+	orphan := "orphanedservice.prod"
+	mux.HandleFunc("/apis/"+orphan, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			t.Errorf("Dry run changed kong with %s %s", request.Method, request.URL.Path)
+		}
+		writeObjectResponse(t, &writer, kong.Api{ID: orphan, Name: orphan})
+	})
+	if err := deleteKongAPI(kiController, "", kiController.KongAPIModel, orphan); err != nil {
+		t.Errorf("Unexpected error deleting in a dry run: %v", err)
+	}
+}
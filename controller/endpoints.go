@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+)
+
+// createEndpointsWatch watches the endpoints of each watched namespace so that the targets of upstreams follow pods as
+// they come and go, without waiting for a change to the ingresses that use them. The watches do not resync, since the
+// ingress resync already reconciles every upstream.
+func (controller *KongIngressController) createEndpointsWatch(ctx context.Context) []cache.Controller {
+	informers := []cache.Controller{}
+	for _, namespace := range controller.watchedNamespaces() {
+		watchedSource := cache.NewListWatchFromClient(
+			controller.CoreClient.RESTClient(),
+			"endpoints",
+			namespace,
+			fields.Everything())
+
+		informer := cache.NewSharedIndexInformer(
+			watchedSource,
+			&v1.Endpoints{},
+			0,
+			cache.Indexers{},
+		)
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: endpointsUpdated(controller),
+		})
+
+		informers = append(informers, informer)
+		controller.spawn(func() { informer.Run(ctx.Done()) })
+	}
+	return informers
+}
+
+// endpointsUpdated queues every managed ingress that balances across the endpoints of a service when its addresses
+// change, so that the workers reconcile the targets of their upstreams. Updates that only touch the metadata, like the
+// renewals of leader election records kept on endpoints, are ignored.
+func endpointsUpdated(controller *KongIngressController) func(interface{}, interface{}) {
+	return func(previousObj, newObj interface{}) {
+		previous, endpoints := previousObj.(*v1.Endpoints), newObj.(*v1.Endpoints)
+		if reflect.DeepEqual(previous.Subsets, endpoints.Subsets) {
+			return
+		}
+		namespace, serviceName := endpoints.ObjectMeta.Namespace, endpoints.ObjectMeta.Name
+		for _, cached := range controller.cachedStore().List() {
+			for _, ingress := range ingressesOf(cached) {
+				if ingress.ObjectMeta.Namespace == namespace && balancesAcrossService(controller, ingress, serviceName) {
+					logging.V(2).Infof(ingressFields(ingress).With(logging.Fields{"service": serviceName}), "Endpoints of service '%s/%s' changed, queueing ingress '%s'", namespace, serviceName, getIngressKey(ingress))
+					controller.enqueue(cached, 0)
+					break
+				}
+			}
+		}
+	}
+}
+
+// balancesAcrossService reports whether a managed ingress has an upstream of the endpoints of the service
+func balancesAcrossService(controller *KongIngressController, ingress *v1beta1.Ingress, serviceName string) bool {
+	annotations := parseAnnotations(ingress)
+	if !usesUpstream(annotations) {
+		return false
+	}
+	if fairGame, _ := ingressIsFairGame(controller, ingress); !fairGame {
+		return false
+	}
+	for _, path := range getIngressPaths(ingress) {
+		if getIngressBackend(withUpstreamService(path, annotations)).ServiceName == serviceName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"sort"
+	"testing"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestEndpointsChangeQueuesIngressesOfService(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{useUpstreamAnnotation: "true"}
+	sharing := sampleIngress("shopservice-admin", "prod")
+	sharing.ObjectMeta.Annotations = map[string]string{useUpstreamAnnotation: "true"}
+	withoutUpstream := sampleIngress("cartservice", "prod")
+	otherNamespace := sampleIngress("shopservice", "staging")
+	otherNamespace.ObjectMeta.Annotations = map[string]string{useUpstreamAnnotation: "true"}
+	path := getIngressPaths(&ingress)[0]
+	_, previous := upstreamService(path.backend.ServiceName, "prod")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, cached := range []interface{}{&ingress, &sharing, &withoutUpstream, &otherNamespace} {
+		ingressStore.Add(cached)
+	}
+	kiController.ingressStore = ingressStore
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+
+	// A change to the metadata only, like a renewed leader election record, queues nothing
+	renewed := *previous
+	renewed.ObjectMeta.Annotations = map[string]string{leaderAnnotation: "{}"}
+	endpointsUpdated(kiController)(previous, &renewed)
+	if length := kiController.queue.Len(); length != 0 {
+		t.Fatalf("%d ingresses queued for endpoints whose addresses did not change, want none", length)
+	}
+
+	replaced := *previous
+	replaced.Subsets = []v1.EndpointSubset{{
+		Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.4"}},
+		Ports:     []v1.EndpointPort{{Name: "http", Port: 8080}},
+	}}
+	endpointsUpdated(kiController)(previous, &replaced)
+
+	queued := []string{}
+	for kiController.queue.Len() > 0 {
+		key, _ := kiController.queue.Get()
+		queued = append(queued, key.(string))
+		kiController.queue.Done(key)
+	}
+	sort.Strings(queued)
+	expected := []string{getIngressKey(&ingress), getIngressKey(&sharing)}
+	if len(queued) != len(expected) || queued[0] != expected[0] || queued[1] != expected[1] {
+		t.Errorf("Queued %v, want the ingresses balancing across the service %v", queued, expected)
+	}
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultKongAdminTokenHeader is the header kong reads the token of an admin API user from
+	DefaultKongAdminTokenHeader = "Kong-Admin-Token"
+	// KongAdminTokenEnv is the environment variable the kong admin token is read from when it is not given otherwise
+	KongAdminTokenEnv = "KONG_ADMIN_TOKEN"
+)
+
+// sensitiveHeaderWords mark header names whose values must not be logged
+var sensitiveHeaderWords = []string{"auth", "token", "key", "secret", "password", "cookie", "credential"}
+
+// HeaderTransport adds static headers to every request, for setups whose kong admin API sits behind a gateway that
+// requires them
+type HeaderTransport struct {
+	Headers http.Header
+	// Transport makes the requests, defaulting to http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (transport *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if len(transport.Headers) == 0 {
+		return base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request it is given
+	withHeaders := *req
+	withHeaders.Header = http.Header{}
+	for name, values := range req.Header {
+		withHeaders.Header[name] = values
+	}
+	for name, values := range transport.Headers {
+		withHeaders.Header[name] = values
+	}
+
+	return base.RoundTrip(&withHeaders)
+}
+
+// RedactHeader returns the value of a header as it may be logged, hiding values of headers that look like credentials
+func RedactHeader(name string, value string) string {
+	lowerName := strings.ToLower(name)
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(lowerName, word) {
+			return "REDACTED"
+		}
+	}
+	return value
+}
+
+// redactURL returns a URL as it may be logged, hiding its user info and the values of query parameters that look like
+// credentials. Values that are not absolute URLs are returned as they are.
+func redactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || !parsed.IsAbs() {
+		return value
+	}
+	if parsed.User != nil {
+		parsed.User = url.User("REDACTED")
+	}
+	query := parsed.Query()
+	for name, values := range query {
+		for i := range values {
+			values[i] = RedactHeader(name, values[i])
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// KongAdminToken returns the token to authenticate to the kong admin API with: token when it is set, or else the
+// contents of file, such as a mounted secret, or else the KongAdminTokenEnv environment variable. Reading the token
+// from a file or the environment keeps it off the command line. It returns an empty token when none is configured.
+func KongAdminToken(token string, file string) (string, error) {
+	if token != "" && file != "" {
+		return "", errors.New("The kong admin token may be given directly or read from a file, not both")
+	}
+	if token != "" {
+		return token, nil
+	}
+	if file != "" {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", errors.Wrapf(err, "Failed to read the kong admin token from '%s'", file)
+		}
+		// Files written by editors and secrets created with echo end in a newline
+		return strings.TrimSpace(string(contents)), nil
+	}
+	return os.Getenv(KongAdminTokenEnv), nil
+}
@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultInformerHealthyTimeout is how long the informer may go without listing, watching or delivering an event before
+// the controller reports itself unhealthy. Watches are re-established every few minutes even when no ingress changes,
+// so a healthy informer shows activity well within this.
+const DefaultInformerHealthyTimeout = 15 * time.Minute
+
+// DefaultLoopStallTimeout is how long the reaper loop may overrun its resync interval before the controller reports
+// itself unhealthy
+const DefaultLoopStallTimeout = 10 * time.Minute
+
+// kongReachability notes the outcome of the last request to the kong admin API. It is shared by every client built with
+// NewKongHTTPClient, since the transport is created before the controller.
+type kongReachability struct {
+	mutex   sync.Mutex
+	lastErr error
+}
+
+var lastKongRequest kongReachability
+
+// record notes the outcome of a request. Only a failure to reach kong or an error of kong itself counts, since a
+// request kong refuses, like an api with a conflicting name, says nothing about whether kong is available.
+func (reachability *kongReachability) record(req *http.Request, resp *http.Response, err error) {
+	reachability.mutex.Lock()
+	defer reachability.mutex.Unlock()
+	switch {
+	case err != nil:
+		reachability.lastErr = errors.Wrapf(err, "Last kong admin request %s %s failed", req.Method, req.URL.Path)
+	case resp.StatusCode >= 500:
+		reachability.lastErr = errors.Errorf("Last kong admin request %s %s failed with status %d", req.Method, req.URL.Path, resp.StatusCode)
+	default:
+		reachability.lastErr = nil
+	}
+}
+
+func (reachability *kongReachability) check() error {
+	reachability.mutex.Lock()
+	defer reachability.mutex.Unlock()
+	return reachability.lastErr
+}
+
+// CheckLive returns an error when the controller has stopped working and should be restarted
+func (controller *KongIngressController) CheckLive() error {
+	if err := controller.CheckInformerHealthy(); err != nil {
+		return err
+	}
+	return controller.CheckLoopHealthy()
+}
+
+// CheckReady returns an error until the ingress informer has synced and the startup reconcile has finished, and
+// whenever kong cannot be reached or the reaper has gone stale
+func (controller *KongIngressController) CheckReady() error {
+	if !controller.ingressCacheSynced() {
+		return errors.New("Ingress informer has not synced yet")
+	}
+	if atomic.LoadInt32(&controller.startingUp) != 0 {
+		return errors.New("Startup reconcile has not finished yet")
+	}
+	if err := lastKongRequest.check(); err != nil {
+		return err
+	}
+	return controller.CheckReaperHealthy()
+}
+
+// recordInformerActivity notes that the informer is still talking to the API server
+func (controller *KongIngressController) recordInformerActivity() {
+	controller.activityMutex.Lock()
+	defer controller.activityMutex.Unlock()
+	controller.informerActivity = time.Now()
+}
+
+// recordLoopActivity notes that the reaper loop is still going round
+func (controller *KongIngressController) recordLoopActivity() {
+	controller.activityMutex.Lock()
+	defer controller.activityMutex.Unlock()
+	controller.loopActivity = time.Now()
+}
+
+// CheckLoopHealthy returns an error once the reaper loop has not gone round for LoopStallTimeout beyond the resync
+// interval, which means a reap cycle is stuck, usually on a request that never returns
+func (controller *KongIngressController) CheckLoopHealthy() error {
+	if controller.LoopStallTimeout <= 0 {
+		return nil
+	}
+
+	controller.activityMutex.Lock()
+	defer controller.activityMutex.Unlock()
+	if controller.loopActivity.IsZero() {
+		return nil
+	}
+	limit := FullResyncInterval + controller.LoopStallTimeout
+	if idle := time.Since(controller.loopActivity); idle > limit {
+		return errors.Errorf("Reaper loop has not gone round for %v, more than the limit of %v", idle, limit)
+	}
+	return nil
+}
+
+// CheckInformerHealthy returns an error once the informer has shown no activity for longer than InformerHealthyTimeout,
+// which means its watch has died without recovering and the controller will no longer see ingress changes
+func (controller *KongIngressController) CheckInformerHealthy() error {
+	if controller.InformerHealthyTimeout <= 0 {
+		return nil
+	}
+
+	controller.activityMutex.Lock()
+	defer controller.activityMutex.Unlock()
+	if controller.informerActivity.IsZero() {
+		return nil
+	}
+	if idle := time.Since(controller.informerActivity); idle > controller.InformerHealthyTimeout {
+		return errors.Errorf("No ingress informer activity for %v, more than the limit of %v", idle, controller.InformerHealthyTimeout)
+	}
+	return nil
+}
+
+// recordReap notes the outcome of a reap cycle. Failed cycles leave the last success where it was, so that a reaper that
+// keeps failing goes stale.
+func (controller *KongIngressController) recordReap(err error) {
+	controller.reaperMutex.Lock()
+	defer controller.reaperMutex.Unlock()
+	if err != nil {
+		controller.reaperLastFailure = time.Now()
+		return
+	}
+	controller.reaperLastSuccess = time.Now()
+	reaperLastSuccessGauge.Set(float64(controller.reaperLastSuccess.Unix()))
+}
+
+// ReaperLastSuccess returns when a reap cycle last completed successfully, or the zero time when none has yet
+func (controller *KongIngressController) ReaperLastSuccess() time.Time {
+	controller.reaperMutex.Lock()
+	defer controller.reaperMutex.Unlock()
+	return controller.reaperLastSuccess
+}
+
+// CheckReaperHealthy returns an error when no reap cycle has completed successfully within ReaperStaleTimeout, which
+// means orphaned apis are piling up in kong, usually because kong cannot be reached. A disabled reaper is never stale.
+func (controller *KongIngressController) CheckReaperHealthy() error {
+	if controller.ReaperStaleTimeout <= 0 || controller.ReapInterval <= 0 {
+		return nil
+	}
+
+	controller.reaperMutex.Lock()
+	defer controller.reaperMutex.Unlock()
+	if controller.reaperLastSuccess.IsZero() {
+		return errors.New("No reap cycle has completed successfully yet")
+	}
+	if stale := time.Since(controller.reaperLastSuccess); stale > controller.ReaperStaleTimeout {
+		return errors.Errorf("No successful reap cycle for %v, more than the limit of %v, last failure at %v", stale, controller.ReaperStaleTimeout, controller.reaperLastFailure)
+	}
+	return nil
+}
+
+// trackInformerActivity wraps the list and watch of the informer so that their successes count as activity
+func trackInformerActivity(controller *KongIngressController, listWatch *cache.ListWatch) *cache.ListWatch {
+	list, watchIngresses := listWatch.ListFunc, listWatch.WatchFunc
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			result, err := list(options)
+			if err == nil {
+				controller.recordInformerActivity()
+			}
+			return result, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			result, err := watchIngresses(options)
+			if err == nil {
+				controller.recordInformerActivity()
+			}
+			return result, err
+		},
+	}
+}
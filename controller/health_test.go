@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nccurry/go-kong/kong"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStalledInformerFailsLiveness(t *testing.T) {
+	kiController := KongIngressController{InformerHealthyTimeout: time.Minute}
+	kiController.recordInformerActivity()
+	if err := kiController.CheckInformerHealthy(); err != nil {
+		t.Errorf("Informer with recent activity should be healthy, got: %v", err)
+	}
+
+	// Simulate an informer whose watch died without recovering
+	kiController.informerActivity = time.Now().Add(-2 * time.Minute)
+	if err := kiController.CheckInformerHealthy(); err == nil {
+		t.Error("Informer without activity for longer than the timeout should be unhealthy")
+	}
+
+	ingress := sampleIngress("someservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{ingressClassAnnotation: "nginx"}
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+	ingressUpdated(&kiController)(&ingress, &ingress)
+	if err := kiController.CheckInformerHealthy(); err != nil {
+		t.Errorf("Informer should be healthy again once it delivers an event, got: %v", err)
+	}
+}
+
+func TestReaperLastSuccessAdvancesOnlyOnSuccess(t *testing.T) {
+	setup()
+	defer shutdown()
+	kongAvailable := false
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		if !kongAvailable {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeObjectResponse(t, &writer, kong.Apis{})
+	})
+	kiController.ingressStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	kiController.ReaperStaleTimeout = time.Minute
+
+	if err := reapOrphanedApis(kiController); err == nil {
+		t.Fatal("Expected the reap cycle to fail while kong is unavailable")
+	}
+	if !kiController.ReaperLastSuccess().IsZero() {
+		t.Error("Failed reap cycle should not record a success")
+	}
+	if err := kiController.CheckReaperHealthy(); err == nil {
+		t.Error("Reaper without a successful cycle should be unhealthy")
+	}
+
+	kongAvailable = true
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Fatalf("Unexpected error reaping apis: %v", err)
+	}
+	lastSuccess := kiController.ReaperLastSuccess()
+	if lastSuccess.IsZero() {
+		t.Fatal("Successful reap cycle should record a success")
+	}
+	if got := testutil.ToFloat64(reaperLastSuccessGauge); got != float64(lastSuccess.Unix()) {
+		t.Errorf("Reaper last success gauge is %v, want %v", got, lastSuccess.Unix())
+	}
+	if err := kiController.CheckReaperHealthy(); err != nil {
+		t.Errorf("Reaper with a recent successful cycle should be healthy, got: %v", err)
+	}
+
+	kongAvailable = false
+	reapOrphanedApis(kiController)
+	if got := kiController.ReaperLastSuccess(); !got.Equal(lastSuccess) {
+		t.Errorf("Failed reap cycle moved the last success from %v to %v", lastSuccess, got)
+	}
+}
+
+func TestReadinessFollowsInformerSyncAndKong(t *testing.T) {
+	setup()
+	defer shutdown()
+	kongAvailable := true
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		if !kongAvailable {
+			writer.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeObjectResponse(t, &writer, kong.Apis{})
+	})
+	client := &http.Client{Transport: &MetricsTransport{}}
+	defer lastKongRequest.record(nil, &http.Response{StatusCode: http.StatusOK}, nil)
+
+	synced := false
+	kiController.ingressesSynced = func() bool { return synced }
+	if err := kiController.CheckReady(); err == nil {
+		t.Error("Controller should not be ready before the informer has synced")
+	}
+
+	synced = true
+	if _, err := client.Get(server.URL + "/apis"); err != nil {
+		t.Fatalf("Unexpected error listing apis: %v", err)
+	}
+	if err := kiController.CheckReady(); err != nil {
+		t.Errorf("Controller should be ready once synced with kong available, got: %v", err)
+	}
+
+	kongAvailable = false
+	if _, err := client.Get(server.URL + "/apis"); err != nil {
+		t.Fatalf("Unexpected error listing apis: %v", err)
+	}
+	if err := kiController.CheckReady(); err == nil {
+		t.Error("Controller should not be ready while kong fails")
+	}
+
+	// A request kong refuses does not mean kong is unavailable
+	mux.HandleFunc("/apis/conflicting", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusConflict)
+	})
+	if _, err := client.Post(server.URL+"/apis/conflicting", "application/json", nil); err != nil {
+		t.Fatalf("Unexpected error creating api: %v", err)
+	}
+	if err := kiController.CheckReady(); err != nil {
+		t.Errorf("Controller should be ready again once kong answers, got: %v", err)
+	}
+}
+
+func TestStalledReaperLoopFailsLiveness(t *testing.T) {
+	kiController := KongIngressController{LoopStallTimeout: time.Minute}
+	if err := kiController.CheckLive(); err != nil {
+		t.Errorf("Controller whose reaper has not started yet should be live, got: %v", err)
+	}
+
+	kiController.recordLoopActivity()
+	if err := kiController.CheckLive(); err != nil {
+		t.Errorf("Controller whose reaper loop just went round should be live, got: %v", err)
+	}
+
+	kiController.loopActivity = time.Now().Add(-FullResyncInterval - 2*time.Minute)
+	if err := kiController.CheckLive(); err == nil {
+		t.Error("Controller whose reaper loop stalled for longer than the timeout should not be live")
+	}
+}
+
+func TestNotReadyUntilStartupReconciled(t *testing.T) {
+	setup()
+	defer shutdown()
+	listings := int32(0)
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&listings, 1)
+		writeObjectResponse(t, &writer, kong.Apis{})
+	})
+	kiController.ingressStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	kiController.ingressesSynced = func() bool { return true }
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+	// The informer queues every ingress on its initial list
+	kiController.queue.Add("prod/shopservice")
+	atomic.StoreInt32(&kiController.startingUp, 1)
+	lastKongRequest.record(nil, &http.Response{StatusCode: http.StatusOK}, nil)
+
+	finished := make(chan bool)
+	go func() { finished <- startupReconcile(context.Background(), kiController) }()
+	if err := kiController.CheckReady(); err == nil {
+		t.Error("Controller should not be ready before the startup reconcile has finished")
+	}
+
+	key, _ := kiController.queue.Get()
+	kiController.queue.Done(key)
+	if !<-finished {
+		t.Fatal("Startup reconcile should finish once the queued ingresses are reconciled")
+	}
+	if atomic.LoadInt32(&listings) == 0 {
+		t.Error("Startup reconcile should reap the apis of deleted ingresses")
+	}
+	if err := kiController.CheckReady(); err != nil {
+		t.Errorf("Controller should be ready once the startup reconcile has finished, got: %v", err)
+	}
+}
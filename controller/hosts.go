@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/pkg/errors"
+)
+
+// additionalHostsAnnotation lists port qualified hosts, like example.com:8443, that the api matches as well as the
+// host of the ingress rule, for clients that send the port in their Host header
+const additionalHostsAnnotation = annotationPrefix + "additional-hosts"
+
+func init() {
+	knownAnnotations[additionalHostsAnnotation] = validateHostPorts
+}
+
+// wildcardHostPrefix starts a wildcard host, the only form of wildcard kong matches: a '*' standing for the whole
+// leftmost label, as in *.example.com, which kubernetes allows for ingress rule hosts as well
+const wildcardHostPrefix = "*."
+
+// validateWildcardHost refuses hosts with a '*' anywhere but as the whole leftmost label, which kong would reject or,
+// for a trailing wildcard, match differently than the ingress means
+func validateWildcardHost(host string) error {
+	if !strings.Contains(strings.TrimPrefix(host, wildcardHostPrefix), "*") {
+		return nil
+	}
+	return errors.Errorf("Host '%s' is not supported, a wildcard must be the whole leftmost label like '*.example.com'", host)
+}
+
+// getAPIHosts returns the hosts the api for a path of the ingress matches. When the ingress has several rules, each
+// additional host only goes to the paths of the rule for the same host without the port.
+func getAPIHosts(ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) []string {
+	hosts := []string{}
+	if path.host != "" {
+		hosts = append(hosts, path.host)
+	}
+	if additionalHosts, found := annotations.values[additionalHostsAnnotation]; found {
+		for _, host := range strings.Split(additionalHosts, ",") {
+			host = strings.TrimSpace(host)
+			if hostname, _, err := net.SplitHostPort(host); len(ingress.Spec.Rules) == 1 || (err == nil && hostname == path.host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// sameHosts compares hosts as sets, since kong does not promise to keep them in the order they were sent
+func sameHosts(actual []string, desired []string) bool {
+	return strings.Join(sortedUniqueHosts(actual), ",") == strings.Join(sortedUniqueHosts(desired), ",")
+}
+
+func sortedUniqueHosts(hosts []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, host := range hosts {
+		host = strings.ToLower(host)
+		if host != "" && !seen[host] {
+			seen[host] = true
+			unique = append(unique, host)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+func validateHostPorts(value string) error {
+	for _, hostPort := range strings.Split(value, ",") {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(hostPort))
+		if err != nil {
+			return errors.Wrapf(err, "'%s' is not a host:port", hostPort)
+		}
+		if !resourceNamePattern.MatchString(strings.TrimPrefix(strings.ToLower(host), wildcardHostPrefix)) {
+			return errors.Errorf("'%s' is not a valid host name", host)
+		}
+		if portNumber, err := strconv.Atoi(port); err != nil || portNumber < 1 || portNumber > 65535 {
+			return errors.Errorf("'%s' is not a valid port", port)
+		}
+	}
+	return nil
+}
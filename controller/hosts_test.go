@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestPortQualifiedHostReconcilesToSteadyState(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("portservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{additionalHostsAnnotation: "portservice.somedomain:8443"}
+	apiName := getQualifiedName(&ingress)
+
+	kongAPI := apiFromIngress(&ingress)
+	kongAPI.PreserveHost = true
+	patches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeObjectResponse(t, &writer, kongAPI)
+		case http.MethodPatch:
+			patches++
+			testRequestMatches(t, request, http.MethodPatch, kong.ApiRequest{
+				ID:    apiName,
+				Hosts: "portservice.somedomain,portservice.somedomain:8443",
+			})
+			// Kong may return the hosts in a different order to the one they were sent in
+			kongAPI.Hosts = []string{"portservice.somedomain:8443", "portservice.somedomain"}
+			writeObjectResponse(t, &writer, kongAPI)
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling API: %v", err)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("Hosts were patched %d times, want once before reaching a steady state", patches)
+	}
+}
+
+func TestAdditionalHostsMustBePortQualified(t *testing.T) {
+	for _, value := range []string{"example.com:8443", "example.com:8443, api.example.com:80"} {
+		if err := validateHostPorts(value); err != nil {
+			t.Errorf("Unexpected error validating '%s': %v", value, err)
+		}
+	}
+	for _, value := range []string{"example.com", "example.com:0", "example.com:https", "exa_mple.com:8443", "example.com:8443,"} {
+		if err := validateHostPorts(value); err == nil {
+			t.Errorf("Expected an error validating '%s'", value)
+		}
+	}
+}
+
+func TestWildcardHostPassedToKongAndKeptByReaper(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleMultiRuleIngress("devservices", "dev")
+	ingress.Spec.Rules[1].Host = "*.dev.example.com"
+	if err := validateIngressSupported(&ingress); err != nil {
+		t.Fatalf("Unexpected error validating an ingress with a wildcard host: %v", err)
+	}
+	path := getIngressPaths(&ingress)[1]
+	apiRequest := desiredAPIRequest(kiController, &ingress, path, parseAnnotations(&ingress), nil)
+	if apiRequest.Hosts != "*.dev.example.com" {
+		t.Errorf("API of the wildcard rule matches hosts '%s', want '*.dev.example.com'", apiRequest.Hosts)
+	}
+
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&ingress)
+	kiController.ingressStore = ingressStore
+	kongApis := kong.Apis{}
+	for _, path := range getIngressPaths(&ingress) {
+		apiName := getAPIName(kiController, &ingress, path)
+		kongApis.Data = append(kongApis.Data, &kong.Api{Name: apiName})
+		mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+			t.Errorf("API of a rule of the ingress should not be reaped, got %s", request.Method)
+		})
+	}
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongApis)
+	})
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Errorf("Unexpected error reaping apis: %v", err)
+	}
+}
+
+func TestMisplacedWildcardHostRefused(t *testing.T) {
+	for _, host := range []string{"dev.*.example.com", "example.*", "*dev.example.com", "*.*.example.com"} {
+		ingress := sampleIngress("devservice", "dev")
+		ingress.Spec.Rules[0].Host = host
+		if err := validateIngressSupported(&ingress); err == nil {
+			t.Errorf("Expected an error validating an ingress with host '%s'", host)
+		}
+	}
+	if err := validateHostPorts("*.dev.example.com:8443"); err != nil {
+		t.Errorf("Unexpected error validating an additional wildcard host: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+)
+
+// The resources the controller can watch for the routes to configure in kong
+const (
+	ResourceIngress   = "ingress"
+	ResourceHTTPRoute = "httproute"
+
+	httpRouteResource = "httproutes"
+	pathMatchPrefix   = "PathPrefix"
+)
+
+// HTTPRouteGroupVersion is the group and version of the Gateway API HTTPRoute resource
+var HTTPRouteGroupVersion = schema.GroupVersion{Group: "gateway.networking.k8s.io", Version: "v1"}
+
+// HTTPRoute is the subset of a Gateway API HTTPRoute that can be represented by kong apis
+type HTTPRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec HTTPRouteSpec `json:"spec"`
+}
+
+// HTTPRouteList is a list of HTTPRoutes
+type HTTPRouteList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []HTTPRoute `json:"items"`
+}
+
+// HTTPRouteSpec holds the hostnames an HTTPRoute matches and the rules that forward them to backends
+type HTTPRouteSpec struct {
+	Hostnames []string        `json:"hostnames,omitempty"`
+	Rules     []HTTPRouteRule `json:"rules,omitempty"`
+}
+
+// HTTPRouteRule forwards requests matching any of its matches to its backends
+type HTTPRouteRule struct {
+	Matches     []HTTPRouteMatch `json:"matches,omitempty"`
+	BackendRefs []HTTPBackendRef `json:"backendRefs,omitempty"`
+}
+
+// HTTPRouteMatch matches requests by path
+type HTTPRouteMatch struct {
+	Path *HTTPPathMatch `json:"path,omitempty"`
+}
+
+// HTTPPathMatch matches the path of a request. An unset type is a prefix match.
+type HTTPPathMatch struct {
+	Type  *string `json:"type,omitempty"`
+	Value *string `json:"value,omitempty"`
+}
+
+// HTTPBackendRef refers to the service requests are forwarded to. An unset namespace is the namespace of the route.
+type HTTPBackendRef struct {
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace,omitempty"`
+	Port      *int32  `json:"port,omitempty"`
+}
+
+// DeepCopyObject implements runtime.Object
+func (route *HTTPRoute) DeepCopyObject() runtime.Object {
+	copied := &HTTPRoute{}
+	deepCopyJSON(route, copied)
+	return copied
+}
+
+// DeepCopyObject implements runtime.Object
+func (list *HTTPRouteList) DeepCopyObject() runtime.Object {
+	copied := &HTTPRouteList{}
+	deepCopyJSON(list, copied)
+	return copied
+}
+
+func deepCopyJSON(in interface{}, out interface{}) {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		panic(err)
+	}
+}
+
+// NewHTTPRouteClient returns a client for Gateway API HTTPRoute resources
+func NewHTTPRouteClient(config *rest.Config) (*rest.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(HTTPRouteGroupVersion, &HTTPRoute{}, &HTTPRouteList{})
+	metav1.AddToGroupVersion(scheme, HTTPRouteGroupVersion)
+
+	routeConfig := *config
+	routeConfig.GroupVersion = &HTTPRouteGroupVersion
+	routeConfig.APIPath = "/apis"
+	routeConfig.ContentType = runtime.ContentTypeJSON
+	routeConfig.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	return rest.RESTClientFor(&routeConfig)
+}
+
+// ingressesFromHTTPRoute translates an HTTPRoute into the ingresses that describe it, one for each hostname and path of
+// each rule, so that routes are reconciled and reaped exactly like ingresses. The ingresses carry the name of the route
+// when it translates into a single ingress, and the name suffixed with their position otherwise. Matches and backends
+// that a kong api cannot represent are skipped.
+func ingressesFromHTTPRoute(route *HTTPRoute) []v1beta1.Ingress {
+	hostnames := route.Spec.Hostnames
+	if len(hostnames) == 0 {
+		hostnames = []string{""}
+	}
+
+	ingresses := []v1beta1.Ingress{}
+	routeKey := fmt.Sprintf("%s/%s", route.ObjectMeta.Namespace, route.ObjectMeta.Name)
+	for _, rule := range route.Spec.Rules {
+		backend, supported := httpRouteBackend(route, rule)
+		if !supported {
+			logging.V(2).Infof(keyFields(routeKey), "Skipping rule of HTTPRoute '%s' without a backend service in its namespace", routeKey)
+			continue
+		}
+		for _, path := range httpRoutePaths(routeKey, rule) {
+			for _, hostname := range hostnames {
+				ingresses = append(ingresses, v1beta1.Ingress{
+					ObjectMeta: route.ObjectMeta,
+					Spec: v1beta1.IngressSpec{
+						Rules: []v1beta1.IngressRule{{
+							Host: hostname,
+							IngressRuleValue: v1beta1.IngressRuleValue{
+								HTTP: &v1beta1.HTTPIngressRuleValue{
+									Paths: []v1beta1.HTTPIngressPath{{Path: path, Backend: backend}},
+								},
+							},
+						}},
+					},
+				})
+			}
+		}
+	}
+
+	if len(ingresses) > 1 {
+		for i := range ingresses {
+			ingresses[i].ObjectMeta.Name = fmt.Sprintf("%s-%d", route.ObjectMeta.Name, i)
+		}
+	}
+	return ingresses
+}
+
+// httpRouteBackend returns the first backend of the rule, since a kong api has a single upstream
+func httpRouteBackend(route *HTTPRoute, rule HTTPRouteRule) (v1beta1.IngressBackend, bool) {
+	if len(rule.BackendRefs) == 0 {
+		return v1beta1.IngressBackend{}, false
+	}
+	backendRef := rule.BackendRefs[0]
+	if backendRef.Namespace != nil && *backendRef.Namespace != route.ObjectMeta.Namespace {
+		return v1beta1.IngressBackend{}, false
+	}
+	port := int32(80)
+	if backendRef.Port != nil {
+		port = *backendRef.Port
+	}
+	return v1beta1.IngressBackend{ServiceName: backendRef.Name, ServicePort: intstr.FromInt(int(port))}, true
+}
+
+// httpRoutePaths returns the prefix paths the rule matches. A rule without matches matches every path.
+func httpRoutePaths(routeKey string, rule HTTPRouteRule) []string {
+	if len(rule.Matches) == 0 {
+		return []string{"/"}
+	}
+	paths := []string{}
+	for _, match := range rule.Matches {
+		if match.Path == nil {
+			paths = append(paths, "/")
+			continue
+		}
+		if match.Path.Type != nil && *match.Path.Type != pathMatchPrefix {
+			logging.V(2).Infof(keyFields(routeKey), "Skipping %s path match of HTTPRoute '%s', only %s matches are supported", *match.Path.Type, routeKey, pathMatchPrefix)
+			continue
+		}
+		path := "/"
+		if match.Path.Value != nil {
+			path = *match.Path.Value
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// cachedIngresses returns the ingresses in the informer's cache, translating HTTPRoutes when those are watched instead
+func (controller *KongIngressController) cachedIngresses() []*v1beta1.Ingress {
+	ingresses := []*v1beta1.Ingress{}
+	for _, obj := range controller.cachedStore().List() {
+		ingresses = append(ingresses, ingressesOf(obj)...)
+	}
+	return ingresses
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+const sampleHTTPRouteJSON = `{
+	"apiVersion": "gateway.networking.k8s.io/v1",
+	"kind": "HTTPRoute",
+	"metadata": {"name": "storefront", "namespace": "prod"},
+	"spec": {
+		"hostnames": ["shop.somedomain"],
+		"rules": [{
+			"matches": [{"path": {"type": "PathPrefix", "value": "/"}}],
+			"backendRefs": [{"name": "storefront-web", "port": 8080}]
+		}]
+	}
+}`
+
+func TestHTTPRouteTranslatedIntoAPIRequest(t *testing.T) {
+	route := HTTPRoute{}
+	if err := json.Unmarshal([]byte(sampleHTTPRouteJSON), &route); err != nil {
+		t.Fatalf("Failed to parse HTTPRoute: %v", err)
+	}
+
+	ingresses := ingressesFromHTTPRoute(&route)
+	if len(ingresses) != 1 {
+		t.Fatalf("HTTPRoute with one rule translated into %d ingresses, want 1", len(ingresses))
+	}
+	if err := validateIngressSupported(&ingresses[0]); err != nil {
+		t.Errorf("Translated HTTPRoute is not supported: %v", err)
+	}
+
+	apiRequest := desiredAPIRequest(New(nil, nil, nil), &ingresses[0], getIngressPaths(&ingresses[0])[0], parseAnnotations(&ingresses[0]), nil)
+	expected := kong.ApiRequest{
+		Name:         "storefront.prod",
+		UpstreamURL:  "http://storefront-web.prod:8080",
+		Hosts:        "shop.somedomain",
+		PreserveHost: true,
+	}
+	if !reflect.DeepEqual(apiRequest, expected) {
+		t.Errorf("HTTPRoute translated into %+v, want %+v", apiRequest, expected)
+	}
+}
+
+func TestHTTPRouteUnsupportedMatchesSkipped(t *testing.T) {
+	route := HTTPRoute{}
+	if err := json.Unmarshal([]byte(sampleHTTPRouteJSON), &route); err != nil {
+		t.Fatalf("Failed to parse HTTPRoute: %v", err)
+	}
+	exact := "Exact"
+	route.Spec.Rules[0].Matches[0].Path.Type = &exact
+	other := "other"
+	route.Spec.Rules = append(route.Spec.Rules, HTTPRouteRule{
+		BackendRefs: []HTTPBackendRef{{Name: "elsewhere", Namespace: &other}},
+	})
+
+	if ingresses := ingressesFromHTTPRoute(&route); len(ingresses) != 0 {
+		t.Errorf("HTTPRoute with only unsupported matches and backends translated into %d ingresses, want none", len(ingresses))
+	}
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Inventory is a snapshot of everything the controller manages in kong, for auditing or restoring kong
+type Inventory struct {
+	Timestamp    time.Time              `json:"timestamp"`
+	APIs         []InventoryAPI         `json:"apis"`
+	Certificates []InventoryCertificate `json:"certificates"`
+}
+
+// InventoryAPI is a kong api backed by an ingress
+type InventoryAPI struct {
+	Name        string   `json:"name"`
+	Ingress     string   `json:"ingress"`
+	Hosts       []string `json:"hosts"`
+	UpstreamURL string   `json:"upstream_url"`
+}
+
+// InventoryCertificate is an SNI configured from a TLS secret
+type InventoryCertificate struct {
+	SNI    string `json:"sni"`
+	Secret string `json:"secret"`
+}
+
+func (tracker *sniTracker) inventory() []InventoryCertificate {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	certificates := []InventoryCertificate{}
+	for sni, secretKey := range tracker.owners {
+		certificates = append(certificates, InventoryCertificate{SNI: sni, Secret: secretKey})
+	}
+	sort.Slice(certificates, func(i, j int) bool { return certificates[i].SNI < certificates[j].SNI })
+	return certificates
+}
+
+// writeInventory replaces the inventory file. The inventory is written to a temporary file that is renamed into place,
+// so that a reader never sees a partial inventory.
+func writeInventory(path string, inventory Inventory) error {
+	content, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Failed to serialize inventory")
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create temporary inventory file for '%s'", path)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		file.Close()
+		return errors.Wrapf(err, "Failed to write inventory to '%s'", file.Name())
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to write inventory to '%s'", file.Name())
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return errors.Wrapf(err, "Failed to replace inventory file '%s'", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestInventoryReflectsManagedAPIsAfterReap(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	inventoryFile := filepath.Join(dir, "inventory.json")
+
+	ingress := sampleIngress("managedservice", "infra")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&ingress)
+
+	waitGroup := sync.WaitGroup{}
+	managedAPI := kong.Api{
+		Name:        getQualifiedName(&ingress),
+		Hosts:       []string{ingress.Spec.Rules[0].Host},
+		UpstreamURL: getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)),
+	}
+	waitGroup.Add(1)
+	go testKongOperationCalled(t, "/apis", http.MethodGet, nil, kong.Apis{
+		Data: []*kong.Api{&managedAPI, {Name: "orphanedAPI"}},
+	}, &waitGroup)
+	waitGroup.Add(1)
+	go testAPIDeleted(t, "orphanedAPI", &waitGroup)
+
+	kiController := KongIngressController{KongClient: kongClient, ingressStore: ingressStore, InventoryFile: inventoryFile}
+	kiController.sniTracker.claim(ingress.Spec.Rules[0].Host, "infra/managed-tls")
+	if err := reapOrphanedApis(&kiController); err != nil {
+		t.Fatalf("Unexpected error reaping apis: %v", err)
+	}
+	waitGroup.Wait()
+
+	content, err := ioutil.ReadFile(inventoryFile)
+	if err != nil {
+		t.Fatalf("Failed to read inventory: %v", err)
+	}
+	inventory := Inventory{}
+	if err := json.Unmarshal(content, &inventory); err != nil {
+		t.Fatalf("Failed to parse inventory: %v", err)
+	}
+
+	expectedAPIs := []InventoryAPI{{
+		Name:        managedAPI.Name,
+		Ingress:     "infra/managedservice",
+		Hosts:       managedAPI.Hosts,
+		UpstreamURL: managedAPI.UpstreamURL,
+	}}
+	if !reflect.DeepEqual(inventory.APIs, expectedAPIs) {
+		t.Errorf("Inventory apis are %+v, want %+v", inventory.APIs, expectedAPIs)
+	}
+	expectedCertificates := []InventoryCertificate{{SNI: ingress.Spec.Rules[0].Host, Secret: "infra/managed-tls"}}
+	if !reflect.DeepEqual(inventory.Certificates, expectedCertificates) {
+		t.Errorf("Inventory certificates are %+v, want %+v", inventory.Certificates, expectedCertificates)
+	}
+}
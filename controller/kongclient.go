@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nccurry/go-kong/kong"
+	"github.com/pkg/errors"
+)
+
+// NewKongClient returns a client for the kong admin API at address, which may include a path prefix when the admin API
+// is served behind a reverse proxy. Requests are built relative to the address, so it is given a trailing slash to
+// keep the last segment of the prefix from being replaced by the resource path. With dryRun, the client only logs the
+// changes it would make to kong.
+func NewKongClient(address string, headers http.Header, dryRun bool) (*kong.Client, error) {
+	if !strings.HasSuffix(address, "/") {
+		address += "/"
+	}
+	kongClient, err := kong.NewClient(NewKongHTTPClient(headers, dryRun), address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create kong client for '%s'", address)
+	}
+	return kongClient, nil
+}
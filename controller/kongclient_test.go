@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestKongAddressPathPrefixIsPreserved(t *testing.T) {
+	requested := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requested[request.Method+" "+request.URL.Path] = true
+		if request.URL.Path == "/kong-admin/" {
+			writer.Write([]byte(`{"version": "0.11.0"}`))
+			return
+		}
+		writeObjectResponse(t, &writer, kong.Api{ID: "prefixed-id", Name: "prefixed"})
+	}))
+	defer server.Close()
+
+	prefixedClient, err := NewKongClient(server.URL+"/kong-admin", nil, false)
+	if err != nil {
+		t.Fatalf("Unexpected error creating kong client: %v", err)
+	}
+	controller := New(nil, nil, prefixedClient)
+
+	api, _, err := prefixedClient.Apis.Get("prefixed")
+	if err != nil {
+		t.Fatalf("Unexpected error getting api: %v", err)
+	}
+	if _, err := patchAPIFields(controller, "", api, map[string]interface{}{"preserve_host": false}); err != nil {
+		t.Fatalf("Unexpected error patching api: %v", err)
+	}
+	if _, err := CheckKongCompatibility(prefixedClient); err != nil {
+		t.Fatalf("Unexpected error checking compatibility: %v", err)
+	}
+
+	for _, expected := range []string{"GET /kong-admin/apis/prefixed", "PATCH /kong-admin/apis/prefixed-id", "GET /kong-admin/"} {
+		if !requested[expected] {
+			t.Errorf("Expected request '%s' under the path prefix, got %v", expected, requested)
+		}
+	}
+}
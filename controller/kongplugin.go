@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// pluginsAnnotation names the KongPlugins, comma separated, in the namespace of the ingress that are configured on
+	// each of its apis
+	pluginsAnnotation = "kong.plugins"
+
+	kongPluginResource = "kongplugins"
+)
+
+func init() {
+	knownAnnotations[pluginsAnnotation] = validateResourceNameList
+}
+
+func validateResourceNameList(value string) error {
+	if err := validateList(value); err != nil {
+		return err
+	}
+	for _, name := range strings.Split(value, ",") {
+		if err := validateResourceName(strings.TrimSpace(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KongPlugin configures a kong plugin on the apis of the ingresses that reference it, for plugins whose config is too
+// involved for annotations
+type KongPlugin struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Plugin is the name of the kong plugin
+	Plugin string `json:"plugin"`
+	// Config is the config of the plugin as kong takes it. Only the keys it sets are managed.
+	Config map[string]interface{} `json:"config,omitempty"`
+}
+
+// KongPluginList is a list of KongPlugins
+type KongPluginList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []KongPlugin `json:"items"`
+}
+
+// DeepCopyObject implements runtime.Object
+func (plugin *KongPlugin) DeepCopyObject() runtime.Object {
+	copied := &KongPlugin{}
+	deepCopyJSON(plugin, copied)
+	return copied
+}
+
+// DeepCopyObject implements runtime.Object
+func (list *KongPluginList) DeepCopyObject() runtime.Object {
+	copied := &KongPluginList{}
+	deepCopyJSON(list, copied)
+	return copied
+}
+
+// NewKongPluginClient returns a client for KongPlugin custom resources, which share the group of KongIngresses
+func NewKongPluginClient(config *rest.Config) (*rest.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(KongIngressGroupVersion, &KongPlugin{}, &KongPluginList{})
+	metav1.AddToGroupVersion(scheme, KongIngressGroupVersion)
+
+	pluginConfig := *config
+	pluginConfig.GroupVersion = &KongIngressGroupVersion
+	pluginConfig.APIPath = "/apis"
+	pluginConfig.ContentType = runtime.ContentTypeJSON
+	pluginConfig.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	return rest.RESTClientFor(&pluginConfig)
+}
+
+// createKongPluginWatch watches the KongPlugins of each watched namespace, reconciling the ingresses that reference a
+// KongPlugin whenever it changes or is deleted
+func (controller *KongIngressController) createKongPluginWatch(ctx context.Context) []cache.Controller {
+	informers := []cache.Controller{}
+	stores := namespacedStores{}
+	for _, namespace := range controller.watchedNamespaces() {
+		watchedSource := cache.NewListWatchFromClient(
+			controller.PluginClient,
+			kongPluginResource,
+			namespace,
+			fields.Everything())
+
+		informer := cache.NewSharedIndexInformer(
+			watchedSource,
+			&KongPlugin{},
+			0,
+			cache.Indexers{},
+		)
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: kongPluginChanged(controller),
+			UpdateFunc: func(previousObj, newObj interface{}) {
+				kongPluginChanged(controller)(newObj)
+			},
+			DeleteFunc: kongPluginChanged(controller),
+		})
+		stores[namespace] = informer.GetStore()
+		informers = append(informers, informer)
+		controller.spawn(func() { informer.Run(ctx.Done()) })
+	}
+	controller.kongPluginStore = stores
+	return informers
+}
+
+// kongPluginChanged queues the ingresses that reference a KongPlugin
+func kongPluginChanged(controller *KongIngressController) func(interface{}) {
+	return func(obj interface{}) {
+		if tombstone, isTombstone := obj.(cache.DeletedFinalStateUnknown); isTombstone {
+			obj = tombstone.Obj
+		}
+		plugin, isPlugin := obj.(*KongPlugin)
+		if !isPlugin {
+			return
+		}
+		for _, cached := range controller.cachedStore().List() {
+			var objectMeta metav1.ObjectMeta
+			switch object := cached.(type) {
+			case *v1beta1.Ingress:
+				objectMeta = object.ObjectMeta
+			case *HTTPRoute:
+				objectMeta = object.ObjectMeta
+			}
+			if objectMeta.Namespace == plugin.ObjectMeta.Namespace && referencesKongPlugin(objectMeta.Annotations[pluginsAnnotation], plugin.ObjectMeta.Name) {
+				controller.enqueue(cached, 0)
+			}
+		}
+	}
+}
+
+func referencesKongPlugin(annotation string, name string) bool {
+	for _, referenced := range strings.Split(annotation, ",") {
+		if strings.TrimSpace(referenced) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveKongPlugins returns the config of each plugin the KongPlugins referenced by the ingress configure, keyed by
+// the name of the kong plugin. KongPlugins that do not exist are warned about and configure nothing, so that the
+// plugins of those that are deleted are removed from kong.
+func resolveKongPlugins(controller *KongIngressController, ingress *v1beta1.Ingress, annotations ingressAnnotations) (map[string]map[string]interface{}, error) {
+	configs := map[string]map[string]interface{}{}
+	value, found := annotations.values[pluginsAnnotation]
+	if !found || controller.kongPluginStore == nil {
+		return configs, nil
+	}
+	namespace := ingress.ObjectMeta.Namespace
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		obj, exists, err := controller.kongPluginStore.GetByKey(namespace + "/" + name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to fetch KongPlugin '%s/%s'", namespace, name)
+		}
+		if !exists {
+			controller.recordWarning(ingress, "MissingKongPlugin", "KongPlugin '%s/%s' referenced by ingress '%s' does not exist", namespace, name, getIngressKey(ingress))
+			continue
+		}
+		plugin := obj.(*KongPlugin)
+		if plugin.Plugin == "" {
+			controller.recordWarning(ingress, "InvalidKongPlugin", "KongPlugin '%s/%s' referenced by ingress '%s' does not name a plugin", namespace, name, getIngressKey(ingress))
+			continue
+		}
+		config := map[string]interface{}{}
+		for key, value := range plugin.Config {
+			config[key] = value
+		}
+		configs[plugin.Plugin] = config
+	}
+	return configs, nil
+}
+
+// attachedPlugins remembers which plugins were configured on each api from KongPlugins or the default plugins, so that
+// they can be removed once nothing configures them any more. It is not persisted, so a KongPlugin deleted or no longer
+// referenced, or a default plugin dropped, while the controller is not running leaves its plugin in kong.
+type attachedPlugins struct {
+	mutex   sync.Mutex
+	plugins map[string]map[string]bool
+}
+
+// names returns the plugins configured on the api from KongPlugins or the default plugins
+func (attached *attachedPlugins) names(apiName string) []string {
+	attached.mutex.Lock()
+	defer attached.mutex.Unlock()
+	names := []string{}
+	for name := range attached.plugins[apiName] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// forget drops the plugins of an api that was deleted along with them
+func (attached *attachedPlugins) forget(apiName string) {
+	attached.mutex.Lock()
+	defer attached.mutex.Unlock()
+	delete(attached.plugins, apiName)
+}
+
+func (attached *attachedPlugins) set(apiName string, name string, isAttached bool) {
+	attached.mutex.Lock()
+	defer attached.mutex.Unlock()
+	if !isAttached {
+		delete(attached.plugins[apiName], name)
+		return
+	}
+	if attached.plugins == nil {
+		attached.plugins = map[string]map[string]bool{}
+	}
+	if attached.plugins[apiName] == nil {
+		attached.plugins[apiName] = map[string]bool{}
+	}
+	attached.plugins[apiName][name] = true
+}
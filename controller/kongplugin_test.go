@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestKongPluginsConfiguredAndRemoved(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	pluginStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	pluginStore.Add(&KongPlugin{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-acl", Namespace: "prod"},
+		Plugin:     "acl",
+		Config:     map[string]interface{}{"whitelist": "admins"},
+	})
+	pluginStore.Add(&KongPlugin{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-limits", Namespace: "prod"},
+		Plugin:     rateLimitingPlugin,
+		Config:     map[string]interface{}{"minute": float64(10)},
+	})
+	kiController.kongPluginStore = pluginStore
+
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{
+		pluginsAnnotation:         "shop-acl, shop-limits",
+		rateLimitMinuteAnnotation: "60",
+	}
+	apiName := getQualifiedName(&ingress)
+
+	mutex := sync.Mutex{}
+	plugins := map[string]kongPlugin{}
+	changes := []string{}
+	mux.HandleFunc("/apis/"+apiName+"/plugins", func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch request.Method {
+		case http.MethodGet:
+			list := kongPluginList{Data: []kongPlugin{}}
+			for _, plugin := range plugins {
+				list.Data = append(list.Data, plugin)
+			}
+			writeObjectResponse(t, &writer, list)
+		case http.MethodPost:
+			plugin := kongPlugin{}
+			if err := json.NewDecoder(request.Body).Decode(&plugin); err != nil {
+				t.Fatalf("Error decoding plugin: %v", err)
+			}
+			encoded, _ := json.Marshal(plugin.Config)
+			changes = append(changes, "POST "+plugin.Name+" "+string(encoded))
+			plugin.ID = "plugin-" + plugin.Name
+			plugins[plugin.Name] = plugin
+			writer.WriteHeader(http.StatusCreated)
+		}
+	})
+	mux.HandleFunc("/apis/"+apiName+"/plugins/", func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		name := strings.TrimPrefix(request.URL.Path, "/apis/"+apiName+"/plugins/plugin-")
+		if request.Method != http.MethodDelete {
+			patch := kongPlugin{}
+			if err := json.NewDecoder(request.Body).Decode(&patch); err != nil {
+				t.Fatalf("Error decoding plugin patch: %v", err)
+			}
+			encoded, _ := json.Marshal(patch.Config)
+			changes = append(changes, request.Method+" "+name+" "+string(encoded))
+			writeObjectResponse(t, &writer, plugins[name])
+			return
+		}
+		changes = append(changes, request.Method+" "+name)
+		delete(plugins, name)
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	reconcile := func() {
+		annotations := parseAnnotations(&ingress)
+		resourcePlugins, err := resolveKongPlugins(kiController, &ingress, annotations)
+		if err != nil {
+			t.Fatalf("Unexpected error resolving KongPlugins: %v", err)
+		}
+		if err := reconcilePlugins(kiController, getIngressKey(&ingress), apiName, annotations, resourcePlugins); err != nil {
+			t.Fatalf("Unexpected error reconciling plugins: %v", err)
+		}
+	}
+	expectChanges := func(expected ...string) {
+		sort.Strings(changes)
+		if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("Plugins changed with\n%s\nwant\n%s", strings.Join(changes, "\n"), strings.Join(expected, "\n"))
+		}
+		changes = []string{}
+	}
+
+	reconcile()
+	expectChanges(
+		`POST acl {"whitelist":"admins"}`,
+		`POST rate-limiting {"minute":10}`,
+	)
+
+	pluginStore.Delete(&KongPlugin{ObjectMeta: metav1.ObjectMeta{Name: "shop-acl", Namespace: "prod"}})
+	reconcile()
+	expectChanges("DELETE acl")
+
+	// Once the KongPlugin is no longer referenced the annotation configures the plugin again rather than removing it
+	ingress.ObjectMeta.Annotations[pluginsAnnotation] = "shop-acl"
+	reconcile()
+	expectChanges(`PATCH rate-limiting {"minute":60}`)
+}
+
+func TestKongPluginChangeQueuesReferencingIngresses(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	referencing := sampleIngress("shopservice", "prod")
+	referencing.ObjectMeta.Annotations = map[string]string{pluginsAnnotation: "other,shop-acl"}
+	otherNamespace := sampleIngress("shopservice", "staging")
+	otherNamespace.ObjectMeta.Annotations = map[string]string{pluginsAnnotation: "shop-acl"}
+	unreferencing := sampleIngress("cartservice", "prod")
+
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&referencing)
+	ingressStore.Add(&otherNamespace)
+	ingressStore.Add(&unreferencing)
+	kiController.ingressStore = ingressStore
+	kiController.queue = newIngressQueue()
+
+	kongPluginChanged(kiController)(&KongPlugin{ObjectMeta: metav1.ObjectMeta{Name: "shop-acl", Namespace: "prod"}})
+
+	if length := kiController.queue.Len(); length != 1 {
+		t.Fatalf("%d ingresses queued, want only the one referencing the KongPlugin", length)
+	}
+	if key, _ := kiController.queue.Get(); key != getIngressKey(&referencing) {
+		t.Errorf("Queued '%v', want '%s'", key, getIngressKey(&referencing))
+	}
+}
+
+func TestKongPluginChangeBeforeIngressWatchesQueuesNothing(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+
+	// KongPlugins are watched before the ingress cache is published, so their handler may run against no cache at all
+	kongPluginChanged(kiController)(&KongPlugin{ObjectMeta: metav1.ObjectMeta{Name: "shop-acl", Namespace: "prod"}})
+	if length := kiController.queue.Len(); length != 0 {
+		t.Errorf("%d ingresses queued before the ingress cache exists, want none", length)
+	}
+	if err := kiController.CheckReady(); err == nil {
+		t.Error("Controller should not be ready before the ingress cache exists")
+	}
+}
@@ -0,0 +1,497 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/nccurry/go-kong/kong"
+	"github.com/pkg/errors"
+)
+
+// The models of kong entities an api is represented by
+const (
+	// KongAPIModelAPIs represents every api as a legacy kong api, which kong deprecated in 0.13 and removed in 1.0
+	KongAPIModelAPIs = "apis"
+	// KongAPIModelServices represents every api as a kong service forwarding to the upstream and a single route
+	// matching its hosts and uris, which requires kong 0.13 or later
+	KongAPIModelServices = "services"
+)
+
+const (
+	// apiModelAnnotation represents the apis of an ingress with the model of kong entities it names instead of
+	// KongAPIModel, so that the ingresses sharing a kong move between the models one at a time
+	apiModelAnnotation = annotationPrefix + "api-model"
+	// serviceNameAnnotation names an existing kong service for the routes of the ingress to forward to with the services
+	// model, which the controller then leaves to whoever created it
+	serviceNameAnnotation = annotationPrefix + "service-name"
+	// The buffering annotations set whether kong buffers the body of requests before forwarding them to the service, and
+	// of responses before returning them, on the routes of the ingress
+	requestBufferingAnnotation  = annotationPrefix + "request-buffering"
+	responseBufferingAnnotation = annotationPrefix + "response-buffering"
+
+	auditEntityService = "service"
+	auditEntityRoute   = "route"
+)
+
+func init() {
+	knownAnnotations[apiModelAnnotation] = validateAPIModel
+	knownAnnotations[serviceNameAnnotation] = validateNotEmpty
+	knownAnnotations[requestBufferingAnnotation] = validateBool
+	knownAnnotations[responseBufferingAnnotation] = validateBool
+}
+
+func validateAPIModel(value string) error {
+	if value != KongAPIModelAPIs && value != KongAPIModelServices {
+		return errors.Errorf("must be '%s' or '%s'", KongAPIModelAPIs, KongAPIModelServices)
+	}
+	return nil
+}
+
+// apiModel is the model of kong entities the apis of an ingress with the annotations are represented by
+func (controller *KongIngressController) apiModel(annotations ingressAnnotations) string {
+	if model, found := annotations.values[apiModelAnnotation]; found {
+		return model
+	}
+	return controller.KongAPIModel
+}
+
+// attachedService returns the name of the existing service the routes of an ingress with the annotations are attached
+// to, which only the services model attaches routes to
+func (controller *KongIngressController) attachedService(annotations ingressAnnotations) (string, bool) {
+	serviceName, found := annotations.values[serviceNameAnnotation]
+	return serviceName, found && controller.apiModel(annotations) == KongAPIModelServices
+}
+
+// apiModelsInUse returns the models of kong entities the apis of the handled ingresses are represented by, starting with
+// KongAPIModel, which is always in use
+func (controller *KongIngressController) apiModelsInUse() []string {
+	models := []string{controller.KongAPIModel}
+	for _, ingress := range controller.cachedIngresses() {
+		if fairGame, _ := ingressIsFairGame(controller, ingress); !fairGame {
+			continue
+		}
+		if model := controller.apiModel(parseAnnotations(ingress)); model != controller.KongAPIModel {
+			return append(models, model)
+		}
+	}
+	return models
+}
+
+// kongService is a kong service. Kong splits the url it is created with into its protocol, host, port and path.
+type kongService struct {
+	ID             string  `json:"id,omitempty"`
+	Name           string  `json:"name"`
+	URL            string  `json:"url,omitempty"`
+	Protocol       string  `json:"protocol,omitempty"`
+	Host           string  `json:"host,omitempty"`
+	Port           int     `json:"port,omitempty"`
+	Path           *string `json:"path,omitempty"`
+	ConnectTimeout int     `json:"connect_timeout,omitempty"`
+	ReadTimeout    int     `json:"read_timeout,omitempty"`
+	WriteTimeout   int     `json:"write_timeout,omitempty"`
+	// Retries is a pointer so that a service can be created with no retries
+	Retries *int `json:"retries,omitempty"`
+}
+
+type kongServiceList struct {
+	Data []kongService `json:"data"`
+}
+
+// kongRoute is a kong route forwarding the requests it matches to its service
+type kongRoute struct {
+	ID           string         `json:"id,omitempty"`
+	Hosts        []string       `json:"hosts,omitempty"`
+	Paths        []string       `json:"paths,omitempty"`
+	Methods      []string       `json:"methods,omitempty"`
+	StripPath    *bool          `json:"strip_path,omitempty"`
+	PreserveHost bool           `json:"preserve_host"`
+	Protocols    []string       `json:"protocols,omitempty"`
+	Service      *kongEntityRef `json:"service,omitempty"`
+	// The buffering settings are pointers so that a route can be created without buffering
+	RequestBuffering  *bool `json:"request_buffering,omitempty"`
+	ResponseBuffering *bool `json:"response_buffering,omitempty"`
+}
+
+type kongRouteList struct {
+	Data []kongRoute `json:"data"`
+}
+
+type kongEntityRef struct {
+	ID string `json:"id"`
+}
+
+// url reassembles the url of the service from the parts kong stores
+func (service *kongService) url() string {
+	url := fmt.Sprintf("%s://%s:%d", service.Protocol, service.Host, service.Port)
+	if service.Path != nil {
+		url += *service.Path
+	}
+	return url
+}
+
+// reconcileService makes the kong service and route for a path of the ingress match the api the path should have. The
+// service and route are found by the name of the api, and drifted fields are patched with a single patch per entity.
+func reconcileService(controller *KongIngressController, ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) (string, error) {
+	ingressKey := getIngressKey(ingress)
+
+	path, err := resolveServicePort(controller, ingress, withUpstreamService(path, annotations))
+	if err != nil {
+		return "", err
+	}
+	attachedService, attached := controller.attachedService(annotations)
+	if usesUpstream(annotations) && !attached {
+		if err := reconcileUpstream(controller, ingress, path, annotations); err != nil {
+			return "", err
+		}
+	}
+	override, err := resolveOverride(controller, ingress, annotations)
+	if err != nil {
+		return "", err
+	}
+	desiredAPI := desiredAPIRequest(controller, ingress, path, annotations, override)
+	if attached {
+		return attachRoute(controller, ingressKey, attachedService, desiredAPI, override, annotations)
+	}
+	serviceName := desiredAPI.Name
+
+	if hasDoublePrefix(path.path, desiredAPI) {
+		controller.recordWarning(ingress, "DoublePathPrefix", "Upstream URL '%s' already ends with path '%s' of ingress '%s' and the path is not stripped, so requests will be forwarded with the prefix twice", desiredAPI.UpstreamURL, path.path, ingressKey)
+	}
+
+	service := kongService{}
+	found, err := getKongEntity(controller, "services/"+serviceName, &service)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to fetch service '%s'", serviceName)
+	}
+
+	if !found {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"service": serviceName}), "Creating new service '%s'", serviceName)
+		desiredService := kongService{
+			Name:           serviceName,
+			URL:            desiredAPI.UpstreamURL,
+			ConnectTimeout: desiredAPI.UpstreamConnectTimeout,
+			ReadTimeout:    desiredAPI.UpstreamReadTimeout,
+			WriteTimeout:   desiredAPI.UpstreamSendTimeout,
+		}
+		if retries, found := annotatedRetries(annotations); found {
+			desiredService.Retries = &retries
+		}
+		if err := doKongRequest(controller, http.MethodPost, "services", desiredService, &service); err != nil {
+			return "", errors.Wrapf(err, "Failed to create service '%s'", serviceName)
+		}
+		controller.AuditLog.record(auditCreate, auditEntityService, serviceName, ingressKey, desiredService)
+		if err := createRoute(controller, ingressKey, &service, desiredAPI, override, annotations); err != nil {
+			return "", err
+		}
+		return APICreated, nil
+	}
+
+	action := APIUnchanged
+	if patch := serviceDrift(controller, &service, desiredAPI, annotations); len(patch) > 0 {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"service": serviceName}), "Updating %v on service '%s'", patch, serviceName)
+		if err := doKongRequest(controller, http.MethodPatch, "services/"+service.ID, patch, nil); err != nil {
+			return "", errors.Wrapf(err, "Failed to patch service '%s'", serviceName)
+		}
+		controller.AuditLog.record(auditPatch, auditEntityService, serviceName, ingressKey, patch)
+		action = APIUpdated
+	}
+
+	routes := kongRouteList{}
+	if err := doKongRequest(controller, http.MethodGet, "services/"+service.ID+"/routes", nil, &routes); err != nil {
+		return "", errors.Wrapf(err, "Failed to fetch the routes of service '%s'", serviceName)
+	}
+	if len(routes.Data) == 0 {
+		if err := createRoute(controller, ingressKey, &service, desiredAPI, override, annotations); err != nil {
+			return "", err
+		}
+		return APIUpdated, nil
+	}
+	route := routes.Data[0]
+	if patch := routeDrift(controller, &route, desiredAPI, override, annotations); len(patch) > 0 {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"service": serviceName}), "Updating %v on the route of service '%s'", patch, serviceName)
+		if err := doKongRequest(controller, http.MethodPatch, "routes/"+route.ID, patch, nil); err != nil {
+			return "", errors.Wrapf(err, "Failed to patch the route of service '%s'", serviceName)
+		}
+		controller.AuditLog.record(auditPatch, auditEntityRoute, serviceName, ingressKey, patch)
+		action = APIUpdated
+	}
+
+	return action, nil
+}
+
+// attachRoute makes the route of a path on an existing service match the api the path should have. The service is left
+// as it is, and the route is told apart from the other routes of the service by its hosts and paths, so a route whose
+// hosts or paths change is created anew.
+func attachRoute(controller *KongIngressController, ingressKey string, serviceName string, desiredAPI kong.ApiRequest, override *KongIngress, annotations ingressAnnotations) (string, error) {
+	service := kongService{}
+	found, err := getKongEntity(controller, "services/"+serviceName, &service)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to fetch service '%s'", serviceName)
+	}
+	if !found {
+		return "", errors.Errorf("Service '%s' to attach the route of API '%s' to does not exist in kong", serviceName, desiredAPI.Name)
+	}
+
+	route, err := findAttachedRoute(controller, &service, desiredAPI)
+	if err != nil {
+		return "", err
+	}
+	if route == nil {
+		if err := createRoute(controller, ingressKey, &service, desiredAPI, override, annotations); err != nil {
+			return "", err
+		}
+		return APICreated, nil
+	}
+	if patch := routeDrift(controller, route, desiredAPI, override, annotations); len(patch) > 0 {
+		logging.Infof(keyFields(ingressKey).With(logging.Fields{"service": serviceName}), "Updating %v on route '%s' of service '%s'", patch, route.ID, serviceName)
+		if err := doKongRequest(controller, http.MethodPatch, "routes/"+route.ID, patch, nil); err != nil {
+			return "", errors.Wrapf(err, "Failed to patch route '%s' of service '%s'", route.ID, serviceName)
+		}
+		controller.AuditLog.record(auditPatch, auditEntityRoute, serviceName, ingressKey, patch)
+		return APIUpdated, nil
+	}
+	return APIUnchanged, nil
+}
+
+// findAttachedRoute returns the route of the service matching the hosts and paths of the api, nil when there is none
+func findAttachedRoute(controller *KongIngressController, service *kongService, desiredAPI kong.ApiRequest) (*kongRoute, error) {
+	routes := kongRouteList{}
+	if err := doKongRequest(controller, http.MethodGet, "services/"+service.ID+"/routes", nil, &routes); err != nil {
+		return nil, errors.Wrapf(err, "Failed to fetch the routes of service '%s'", service.Name)
+	}
+	for i := range routes.Data {
+		route := &routes.Data[i]
+		if sameHosts(route.Hosts, splitList(desiredAPI.Hosts)) && strings.Join(route.Paths, ",") == desiredAPI.Uris {
+			return route, nil
+		}
+	}
+	return nil, nil
+}
+
+// detachRoute deletes the route of a path from the existing service it is attached to, leaving the service and its
+// other routes in place
+func detachRoute(controller *KongIngressController, ingressKey string, serviceName string, desiredAPI kong.ApiRequest) error {
+	service := kongService{}
+	found, err := getKongEntity(controller, "services/"+serviceName, &service)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to fetch service '%s'", serviceName)
+	}
+	if !found {
+		return nil
+	}
+	route, err := findAttachedRoute(controller, &service, desiredAPI)
+	if err != nil || route == nil {
+		return err
+	}
+	if err := doKongRequest(controller, http.MethodDelete, "routes/"+route.ID, nil, nil); err != nil {
+		return errors.Wrapf(err, "Failed to delete route '%s' of service '%s'", route.ID, serviceName)
+	}
+	controller.AuditLog.record(auditDelete, auditEntityRoute, serviceName, ingressKey, nil)
+	logging.Infof(keyFields(ingressKey).With(logging.Fields{"service": serviceName}), "Route '%s' of kong service '%s' was deleted", route.ID, serviceName)
+	return nil
+}
+
+func createRoute(controller *KongIngressController, ingressKey string, service *kongService, desiredAPI kong.ApiRequest, override *KongIngress, annotations ingressAnnotations) error {
+	desiredRoute := kongRoute{
+		Hosts:             splitList(desiredAPI.Hosts),
+		Paths:             splitList(desiredAPI.Uris),
+		Methods:           splitList(desiredAPI.Methods),
+		StripPath:         desiredAPI.StripURI,
+		PreserveHost:      desiredAPI.PreserveHost,
+		Protocols:         desiredProtocols(desiredAPI, override),
+		Service:           &kongEntityRef{ID: service.ID},
+		RequestBuffering:  desiredBuffering(annotations, requestBufferingAnnotation, controller.DefaultRequestBuffering),
+		ResponseBuffering: desiredBuffering(annotations, responseBufferingAnnotation, controller.DefaultResponseBuffering),
+	}
+	logging.Infof(keyFields(ingressKey).With(logging.Fields{"service": service.Name}), "Creating new route for service '%s'", service.Name)
+	if err := doKongRequest(controller, http.MethodPost, "routes", desiredRoute, nil); err != nil {
+		return errors.Wrapf(err, "Failed to create the route of service '%s'", service.Name)
+	}
+	controller.AuditLog.record(auditCreate, auditEntityRoute, service.Name, ingressKey, desiredRoute)
+	return nil
+}
+
+// serviceDrift returns the managed fields of the service that differ from the desired api, keyed by their name in kong
+func serviceDrift(controller *KongIngressController, service *kongService, desired kong.ApiRequest, annotations ingressAnnotations) map[string]interface{} {
+	patch := map[string]interface{}{}
+	if controller.managesField("upstream_url") && service.url() != desired.UpstreamURL {
+		patch["url"] = desired.UpstreamURL
+	}
+	if controller.managesField("upstream_connect_timeout") && desired.UpstreamConnectTimeout > 0 && service.ConnectTimeout != desired.UpstreamConnectTimeout {
+		patch["connect_timeout"] = desired.UpstreamConnectTimeout
+	}
+	if controller.managesField("upstream_read_timeout") && desired.UpstreamReadTimeout > 0 && service.ReadTimeout != desired.UpstreamReadTimeout {
+		patch["read_timeout"] = desired.UpstreamReadTimeout
+	}
+	if controller.managesField("upstream_send_timeout") && desired.UpstreamSendTimeout > 0 && service.WriteTimeout != desired.UpstreamSendTimeout {
+		patch["write_timeout"] = desired.UpstreamSendTimeout
+	}
+	if retries, found := annotatedRetries(annotations); controller.managesField("retries") && found && (service.Retries == nil || *service.Retries != retries) {
+		patch["retries"] = retries
+	}
+	return patch
+}
+
+// routeDrift returns the managed fields of the route that differ from the desired api, keyed by their name in kong.
+// Settings the desired api leaves unset are not managed, as with apis.
+func routeDrift(controller *KongIngressController, route *kongRoute, desired kong.ApiRequest, override *KongIngress, annotations ingressAnnotations) map[string]interface{} {
+	patch := map[string]interface{}{}
+	if hosts := splitList(desired.Hosts); controller.managesField("hosts") && !sameHosts(route.Hosts, hosts) {
+		patch["hosts"] = hosts
+	}
+	if controller.managesField("uris") && desired.Uris != "" && strings.Join(route.Paths, ",") != desired.Uris {
+		patch["paths"] = splitList(desired.Uris)
+	}
+	if methods := splitList(desired.Methods); controller.managesField("methods") && len(methods) > 0 && !sameHosts(route.Methods, methods) {
+		patch["methods"] = methods
+	}
+	if controller.managesField("strip_uri") && desired.StripURI != nil && (route.StripPath == nil || *route.StripPath != *desired.StripURI) {
+		patch["strip_path"] = *desired.StripURI
+	}
+	if controller.managesField("preserve_host") && controller.correctsPreserveHost(annotations, override) && route.PreserveHost != desired.PreserveHost {
+		patch["preserve_host"] = desired.PreserveHost
+	}
+	// Protocols are compared as sets, like hosts
+	if protocols := desiredProtocols(desired, override); controller.managesField("https_only") && protocols != nil && !sameHosts(route.Protocols, protocols) {
+		patch["protocols"] = protocols
+	}
+	if buffering := desiredBuffering(annotations, requestBufferingAnnotation, controller.DefaultRequestBuffering); buffering != nil && (route.RequestBuffering == nil || *route.RequestBuffering != *buffering) {
+		patch["request_buffering"] = *buffering
+	}
+	if buffering := desiredBuffering(annotations, responseBufferingAnnotation, controller.DefaultResponseBuffering); buffering != nil && (route.ResponseBuffering == nil || *route.ResponseBuffering != *buffering) {
+		patch["response_buffering"] = *buffering
+	}
+	return patch
+}
+
+// desiredBuffering is the buffering a route should have, set by the annotation or else the default of the controller.
+// It is nil when neither sets it, leaving the buffering of the route as it is.
+func desiredBuffering(annotations ingressAnnotations, annotation string, defaultBuffering *bool) *bool {
+	if value, found := annotations.values[annotation]; found {
+		buffering, _ := strconv.ParseBool(value)
+		return &buffering
+	}
+	return defaultBuffering
+}
+
+// desiredProtocols maps the https only setting of an api onto the protocols of a route. Like https_only, the protocols
+// are only managed when the api is https only or an override sets them.
+func desiredProtocols(desired kong.ApiRequest, override *KongIngress) []string {
+	if desired.HttpsOnly {
+		return []string{"https"}
+	}
+	if override.managesProtocols() {
+		return []string{"http", "https"}
+	}
+	return nil
+}
+
+// deleteKongService deletes the routes of the service and then the service, since kong refuses to delete a service
+// that routes still forward to
+func deleteKongService(controller *KongIngressController, ingressKey string, serviceName string) error {
+	routes := kongRouteList{}
+	if err := doKongRequest(controller, http.MethodGet, "services/"+serviceName+"/routes", nil, &routes); err != nil {
+		return errors.Wrapf(err, "Failed to retrieve the routes of kong service '%s'", serviceName)
+	}
+	for _, route := range routes.Data {
+		if err := doKongRequest(controller, http.MethodDelete, "routes/"+route.ID, nil, nil); err != nil {
+			return errors.Wrapf(err, "Failed to delete route '%s' of kong service '%s'", route.ID, serviceName)
+		}
+		controller.AuditLog.record(auditDelete, auditEntityRoute, serviceName, ingressKey, nil)
+	}
+
+	if err := doKongRequest(controller, http.MethodDelete, "services/"+serviceName, nil, nil); err != nil {
+		return errors.Wrapf(err, "Failed to delete kong service '%s'", serviceName)
+	}
+	controller.AuditLog.record(auditDelete, auditEntityService, serviceName, ingressKey, nil)
+	logging.Infof(logging.Fields{"service": serviceName}, "Kong service '%s' was deleted", serviceName)
+
+	return nil
+}
+
+// listKongServices returns the services in kong as apis, with the hosts of their routes, so that they are reaped and
+// inventoried like apis
+func listKongServices(controller *KongIngressController) ([]*kong.Api, error) {
+	services := kongServiceList{}
+	if err := doKongRequest(controller, http.MethodGet, "services", nil, &services); err != nil {
+		return nil, errors.Wrap(err, "Failed to get kong service list")
+	}
+	routes := kongRouteList{}
+	if err := doKongRequest(controller, http.MethodGet, "routes", nil, &routes); err != nil {
+		return nil, errors.Wrap(err, "Failed to get kong route list")
+	}
+	hosts := map[string][]string{}
+	for _, route := range routes.Data {
+		if route.Service != nil {
+			hosts[route.Service.ID] = append(hosts[route.Service.ID], route.Hosts...)
+		}
+	}
+
+	apis := []*kong.Api{}
+	for i := range services.Data {
+		service := &services.Data[i]
+		apis = append(apis, &kong.Api{
+			ID:          service.ID,
+			Name:        service.Name,
+			UpstreamURL: service.url(),
+			Hosts:       hosts[service.ID],
+		})
+	}
+	return apis, nil
+}
+
+// listKongAPIs returns the apis in kong, or the services standing in for them with the services model
+func listKongAPIs(controller *KongIngressController, model string) ([]*kong.Api, error) {
+	if model == KongAPIModelServices {
+		return listKongServices(controller)
+	}
+	kongApis, _, err := controller.KongClient.Apis.GetAll(nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get kong api list")
+	}
+	return kongApis.Data, nil
+}
+
+// getKongEntity fetches the entity at the path into the result, reporting whether it exists
+func getKongEntity(controller *KongIngressController, path string, result interface{}) (bool, error) {
+	resp, err := retryKong(controller, "fetch '"+path+"'", func() (*http.Response, error) {
+		req, err := controller.KongClient.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		return controller.KongClient.Do(req, result)
+	})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// doKongRequest sends a request to the kong admin API, decoding the response into the result unless it is nil. The
+// request is retried while kong fails transiently.
+func doKongRequest(controller *KongIngressController, method string, path string, body interface{}, result interface{}) error {
+	_, err := retryKong(controller, method+" '"+path+"'", func() (*http.Response, error) {
+		// Sending a request consumes its body, so every attempt builds its own
+		req, err := controller.KongClient.NewRequest(method, path, body)
+		if err != nil {
+			return nil, err
+		}
+		return controller.KongClient.Do(req, result)
+	})
+	return err
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
@@ -0,0 +1,446 @@
+package controller
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestServicesModelCreatesServiceAndRoute(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	ingress := sampleIngress("newservice", "prod")
+	ingress.Spec.Rules[0].HTTP.Paths[0].Path = "/v1"
+	serviceName := getQualifiedName(&ingress)
+	mux.HandleFunc("/services/"+serviceName, func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/services", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, kongService{
+			Name: serviceName,
+			URL:  "http://newservice.prod:32000",
+		})
+		writer.WriteHeader(http.StatusCreated)
+		writeObjectResponse(t, &writer, kongService{ID: "service-1", Name: serviceName, Protocol: "http", Host: "newservice.prod", Port: 32000})
+	})
+	routeCreated := false
+	mux.HandleFunc("/routes", func(writer http.ResponseWriter, request *http.Request) {
+		routeCreated = true
+		testRequestMatches(t, request, http.MethodPost, kongRoute{
+			Hosts:        []string{"newservice.somedomain"},
+			Paths:        []string{"/v1"},
+			PreserveHost: true,
+			Service:      &kongEntityRef{ID: "service-1"},
+		})
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	action, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling service: %v", err)
+	}
+	if action != APICreated || !routeCreated {
+		t.Errorf("Reconcile was %s and created a route: %v, want the service and route created", action, routeCreated)
+	}
+}
+
+func TestServicesModelReconcilesToSteadyState(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	ingress := sampleIngress("steadyservice", "prod")
+	serviceName := getQualifiedName(&ingress)
+	mux.HandleFunc("/services/"+serviceName, func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writeObjectResponse(t, &writer, kongService{ID: "service-1", Name: serviceName, Protocol: "http", Host: "steadyservice.prod", Port: 32000})
+	})
+	route := kongRoute{ID: "route-1", Hosts: []string{"old.somedomain"}, PreserveHost: true, Service: &kongEntityRef{ID: "service-1"}}
+	mux.HandleFunc("/services/service-1/routes", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{route}})
+	})
+	patches := 0
+	mux.HandleFunc("/routes/route-1", func(writer http.ResponseWriter, request *http.Request) {
+		patches++
+		testRequestMatches(t, request, http.MethodPatch, map[string]interface{}{"hosts": []string{"steadyservice.somedomain"}})
+		route.Hosts = []string{"steadyservice.somedomain"}
+		writeObjectResponse(t, &writer, route)
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling service: %v", err)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("Route was patched %d times, want once before reaching a steady state", patches)
+	}
+}
+
+func TestServicesModelPatchesUpstreamPathOntoService(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	ingress := sampleIngress("legacyservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{upstreamPathAnnotation: "/backendprefix"}
+	serviceName := getQualifiedName(&ingress)
+	service := kongService{ID: "service-1", Name: serviceName, Protocol: "http", Host: "legacyservice.prod", Port: 32000}
+	patches := 0
+	mux.HandleFunc("/services/"+serviceName, func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, service)
+	})
+	mux.HandleFunc("/services/service-1", func(writer http.ResponseWriter, request *http.Request) {
+		patches++
+		testRequestMatches(t, request, http.MethodPatch, map[string]interface{}{"url": "http://legacyservice.prod:32000/backendprefix"})
+		path := "/backendprefix"
+		service.Path = &path
+		writeObjectResponse(t, &writer, service)
+	})
+	route := kongRoute{ID: "route-1", Hosts: []string{"legacyservice.somedomain"}, PreserveHost: true, Service: &kongEntityRef{ID: "service-1"}}
+	mux.HandleFunc("/services/service-1/routes", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{route}})
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling service: %v", err)
+		}
+	}
+	if patches != 1 {
+		t.Errorf("Service was patched %d times, want its path patched once", patches)
+	}
+}
+
+func TestServicesModelDeletesRoutesBeforeService(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	deleted := []string{}
+	mux.HandleFunc("/services/oldservice.prod/routes", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{{ID: "route-1"}, {ID: "route-2"}}})
+	})
+	for _, path := range []string{"/routes/route-1", "/routes/route-2", "/services/oldservice.prod"} {
+		path := path
+		mux.HandleFunc(path, func(writer http.ResponseWriter, request *http.Request) {
+			testRequestMatches(t, request, http.MethodDelete, nil)
+			deleted = append(deleted, path)
+			writer.WriteHeader(http.StatusNoContent)
+		})
+	}
+
+	if err := deleteKongAPI(kiController, "prod/oldservice", KongAPIModelServices, "oldservice.prod"); err != nil {
+		t.Fatalf("Unexpected error deleting service: %v", err)
+	}
+	expected := []string{"/routes/route-1", "/routes/route-2", "/services/oldservice.prod"}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Deleted %v, want %v", deleted, expected)
+	}
+}
+
+func TestServicesListedAsAPIsWithTheHostsOfTheirRoutes(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	path := "/api"
+	mux.HandleFunc("/services", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongServiceList{Data: []kongService{
+			{ID: "service-1", Name: "someservice.prod", Protocol: "https", Host: "someservice.prod", Port: 443, Path: &path},
+		}})
+	})
+	mux.HandleFunc("/routes", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{
+			{ID: "route-1", Hosts: []string{"someservice.somedomain"}, Service: &kongEntityRef{ID: "service-1"}},
+		}})
+	})
+
+	apis, err := listKongAPIs(kiController, KongAPIModelServices)
+	if err != nil {
+		t.Fatalf("Unexpected error listing services: %v", err)
+	}
+	if len(apis) != 1 || apis[0].Name != "someservice.prod" || apis[0].UpstreamURL != "https://someservice.prod:443/api" || !reflect.DeepEqual(apis[0].Hosts, []string{"someservice.somedomain"}) {
+		t.Errorf("Services listed as %+v", apis)
+	}
+}
+
+func TestAPIModelAnnotationOverridesModelPerIngress(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	migrated := sampleIngress("migratedservice", "prod")
+	migrated.ObjectMeta.Annotations = map[string]string{apiModelAnnotation: KongAPIModelServices}
+	legacy := sampleIngress("legacyservice", "prod")
+	serviceName, apiName := getQualifiedName(&migrated), getQualifiedName(&legacy)
+	mux.HandleFunc("/services/"+serviceName, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/services", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, kongService{
+			Name: serviceName,
+			URL:  "http://migratedservice.prod:32000",
+		})
+		writer.WriteHeader(http.StatusCreated)
+		writeObjectResponse(t, &writer, kongService{ID: "service-1", Name: serviceName})
+	})
+	routeCreated := false
+	mux.HandleFunc("/routes", func(writer http.ResponseWriter, request *http.Request) {
+		routeCreated = true
+		writer.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	apiCreated := false
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		apiCreated = true
+		testRequestMatches(t, request, http.MethodPost, apiRequestFromIngress(&legacy, getIngressPaths(&legacy)[0], parseAnnotations(&legacy)))
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	for _, ingress := range []*v1beta1.Ingress{&migrated, &legacy} {
+		if _, err := reconcileAPI(kiController, ingress, getIngressPaths(ingress)[0], parseAnnotations(ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling ingress '%s': %v", getIngressKey(ingress), err)
+		}
+	}
+	if !routeCreated || !apiCreated {
+		t.Errorf("Created a route: %v and an api: %v, want a service and route for the annotated ingress and an api for the other", routeCreated, apiCreated)
+	}
+}
+
+func TestReaperScopesOrphansByAPIModel(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	migrated := sampleIngress("migratedservice", "prod")
+	migrated.ObjectMeta.Annotations = map[string]string{apiModelAnnotation: KongAPIModelServices}
+	legacy := sampleIngress("legacyservice", "prod")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&migrated)
+	ingressStore.Add(&legacy)
+	kiController.ingressStore = ingressStore
+
+	// The api the migrated ingress had before it moved to a service is an orphan, while its service is not
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Apis{Data: []*kong.Api{{Name: getQualifiedName(&migrated)}, {Name: getQualifiedName(&legacy)}}})
+	})
+	mux.HandleFunc("/apis/"+getQualifiedName(&legacy), func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("API of an ingress with the apis model should not be reaped, got %s", request.Method)
+	})
+	mux.HandleFunc("/services", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongServiceList{Data: []kongService{
+			{ID: "service-1", Name: getQualifiedName(&migrated)},
+			{ID: "service-2", Name: "goneservice.prod"},
+		}})
+	})
+	mux.HandleFunc("/routes", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{}})
+	})
+	mux.HandleFunc("/services/"+getQualifiedName(&migrated), func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Service of an ingress with the services model should not be reaped, got %s", request.Method)
+	})
+	mux.HandleFunc("/services/goneservice.prod/routes", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{}})
+	})
+	serviceDeleted := false
+	mux.HandleFunc("/services/goneservice.prod", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodDelete, nil)
+		serviceDeleted = true
+		writer.WriteHeader(http.StatusNoContent)
+	})
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(1)
+	go testAPIDeleted(t, getQualifiedName(&migrated), &waitGroup)
+
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Fatalf("Unexpected error reaping: %v", err)
+	}
+	waitGroup.Wait()
+	if !serviceDeleted {
+		t.Error("Orphaned service was not reaped alongside the apis")
+	}
+}
+
+func TestServiceNameAnnotationAttachesRouteToExistingService(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	ingress := sampleIngress("teamservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{serviceNameAnnotation: "shared-gateway"}
+	mux.HandleFunc("/services/shared-gateway", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writeObjectResponse(t, &writer, kongService{ID: "shared-1", Name: "shared-gateway", Protocol: "http", Host: "gateway.infra", Port: 80})
+	})
+	mux.HandleFunc("/services/shared-1/routes", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{
+			{ID: "other-route", Hosts: []string{"otherteam.somedomain"}, Service: &kongEntityRef{ID: "shared-1"}},
+		}})
+	})
+	mux.HandleFunc("/services", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Service should not be created or changed when attaching to an existing one, got %s %s", request.Method, request.URL.Path)
+	})
+	routeCreated := false
+	mux.HandleFunc("/routes", func(writer http.ResponseWriter, request *http.Request) {
+		routeCreated = true
+		testRequestMatches(t, request, http.MethodPost, kongRoute{
+			Hosts:        []string{"teamservice.somedomain"},
+			PreserveHost: true,
+			Service:      &kongEntityRef{ID: "shared-1"},
+		})
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	action, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	if err != nil {
+		t.Fatalf("Unexpected error attaching route: %v", err)
+	}
+	if action != APICreated || !routeCreated {
+		t.Errorf("Reconcile was %s and created a route: %v, want a route created on the existing service", action, routeCreated)
+	}
+}
+
+func TestServiceNameAnnotationRequiresExistingService(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	ingress := sampleIngress("teamservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{serviceNameAnnotation: "missing-gateway"}
+	mux.HandleFunc("/services/missing-gateway", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/services", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Missing service to attach to should not be created, got %s %s", request.Method, request.URL.Path)
+	})
+
+	if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err == nil {
+		t.Error("Expected an error attaching a route to a service that does not exist")
+	}
+}
+
+func TestDeletedIngressDetachesItsRouteOnly(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	ingress := sampleIngress("teamservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{serviceNameAnnotation: "shared-gateway"}
+	mux.HandleFunc("/services/shared-gateway", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writeObjectResponse(t, &writer, kongService{ID: "shared-1", Name: "shared-gateway"})
+	})
+	mux.HandleFunc("/services/shared-1/routes", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{
+			{ID: "other-route", Hosts: []string{"otherteam.somedomain"}},
+			{ID: "team-route", Hosts: []string{"teamservice.somedomain"}},
+		}})
+	})
+	deleted := []string{}
+	for _, path := range []string{"/routes/other-route", "/routes/team-route"} {
+		path := path
+		mux.HandleFunc(path, func(writer http.ResponseWriter, request *http.Request) {
+			testRequestMatches(t, request, http.MethodDelete, nil)
+			deleted = append(deleted, path)
+			writer.WriteHeader(http.StatusNoContent)
+		})
+	}
+
+	if err := syncDeletedIngress(kiController, &ingress); err != nil {
+		t.Fatalf("Unexpected error deleting ingress: %v", err)
+	}
+	if !reflect.DeepEqual(deleted, []string{"/routes/team-route"}) {
+		t.Errorf("Deleted %v, want only the route of the ingress", deleted)
+	}
+}
+
+func TestReaperLeavesAttachedServices(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	ingress := sampleIngress("teamservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{serviceNameAnnotation: "gateway.prod"}
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&ingress)
+	kiController.ingressStore = ingressStore
+
+	mux.HandleFunc("/services", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongServiceList{Data: []kongService{{ID: "shared-1", Name: "gateway.prod"}}})
+	})
+	mux.HandleFunc("/routes", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongRouteList{Data: []kongRoute{}})
+	})
+	mux.HandleFunc("/services/gateway.prod/", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Service routes are attached to should not be reaped, got %s %s", request.Method, request.URL.Path)
+	})
+	mux.HandleFunc("/services/gateway.prod", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Service routes are attached to should not be reaped, got %s %s", request.Method, request.URL.Path)
+	})
+
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Fatalf("Unexpected error reaping: %v", err)
+	}
+}
+
+func TestRouteBufferingDefaultsOverriddenPerIngress(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+	disabled := false
+	kiController.DefaultRequestBuffering = &disabled
+	kiController.DefaultResponseBuffering = &disabled
+
+	ingress := sampleIngress("streamservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{responseBufferingAnnotation: "true"}
+	serviceName := getQualifiedName(&ingress)
+	mux.HandleFunc("/services/"+serviceName, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/services", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusCreated)
+		writeObjectResponse(t, &writer, kongService{ID: "service-1", Name: serviceName})
+	})
+	enabled := true
+	routeCreated := false
+	mux.HandleFunc("/routes", func(writer http.ResponseWriter, request *http.Request) {
+		routeCreated = true
+		testRequestMatches(t, request, http.MethodPost, kongRoute{
+			Hosts:             []string{"streamservice.somedomain"},
+			PreserveHost:      true,
+			Service:           &kongEntityRef{ID: "service-1"},
+			RequestBuffering:  &disabled,
+			ResponseBuffering: &enabled,
+		})
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	annotations := parseAnnotations(&ingress)
+	if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], annotations); err != nil {
+		t.Fatalf("Unexpected error reconciling service: %v", err)
+	}
+	if !routeCreated {
+		t.Fatal("Route was not created")
+	}
+
+	// Drift is measured against the effective buffering, so a route left at kong's defaults only has the default of the
+	// controller corrected
+	route := kongRoute{Hosts: []string{"streamservice.somedomain"}, PreserveHost: true, RequestBuffering: &enabled, ResponseBuffering: &enabled}
+	desiredAPI := desiredAPIRequest(kiController, &ingress, getIngressPaths(&ingress)[0], annotations, nil)
+	patch := routeDrift(kiController, &route, desiredAPI, nil, annotations)
+	if expected := map[string]interface{}{"request_buffering": false}; !reflect.DeepEqual(patch, expected) {
+		t.Errorf("Route drift was %v, want %v", patch, expected)
+	}
+}
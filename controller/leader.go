@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/pkg/errors"
+)
+
+// leaderAnnotation holds the leader election record on the lock config map, in the format client-go's leader election
+// uses, so that the holder of the lock can be inspected with the usual tools
+const leaderAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+// The defaults for leader election, matching those of the kubernetes controller manager
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// ErrLeadershipLost is returned by LeaderElector.Run when another replica took over the lock or it could not be renewed
+var ErrLeadershipLost = errors.New("Lost leadership")
+
+type leaderElectionRecord struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+}
+
+// LeaderElector elects a single leader among the replicas of the controller through a lock config map, so that only
+// one replica makes changes to kong
+type LeaderElector struct {
+	CoreClient corev1.CoreV1Interface
+	// Namespace and Name identify the lock config map, which is created when it does not exist
+	Namespace string
+	Name      string
+	// Identity tells the replicas apart, usually the name of the pod
+	Identity string
+	// LeaseDuration is how long the other replicas wait after the last renewal they saw before taking over the lock
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps retrying to renew the lock before giving up leadership
+	RenewDeadline time.Duration
+	// RetryPeriod is how often the lock is tried or renewed
+	RetryPeriod time.Duration
+
+	// observedRecord is the last record seen on the lock and observedTime when it was first seen. Leases expire by the
+	// local clock measured from when a record was seen, so that the replicas do not depend on their clocks agreeing.
+	observedRecord leaderElectionRecord
+	observedTime   time.Time
+}
+
+// NewLeaderElector returns a LeaderElector with the default timings
+func NewLeaderElector(coreClient corev1.CoreV1Interface, namespace string, name string, identity string) *LeaderElector {
+	return &LeaderElector{
+		CoreClient:    coreClient,
+		Namespace:     namespace,
+		Name:          name,
+		Identity:      identity,
+		LeaseDuration: DefaultLeaseDuration,
+		RenewDeadline: DefaultRenewDeadline,
+		RetryPeriod:   DefaultRetryPeriod,
+	}
+}
+
+// logFields are the log fields naming the lock and this replica
+func (elector *LeaderElector) logFields() logging.Fields {
+	return logging.Fields{"lock": elector.Namespace + "/" + elector.Name, "identity": elector.Identity}
+}
+
+// Run blocks until this replica holds the lock, then calls lead with a context that is cancelled as soon as leadership
+// is lost or ctx is done. Once lead has returned, it returns ErrLeadershipLost when leadership was lost, or the error of
+// ctx.
+func (elector *LeaderElector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	logging.Infof(elector.logFields(), "Waiting to acquire leader lock '%s/%s' as '%s'", elector.Namespace, elector.Name, elector.Identity)
+	if !elector.acquire(ctx) {
+		return ctx.Err()
+	}
+	logging.Infof(elector.logFields(), "Acquired leader lock '%s/%s' as '%s'", elector.Namespace, elector.Name, elector.Identity)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	led := make(chan struct{})
+	go func() {
+		defer close(led)
+		lead(leaderCtx)
+	}()
+
+	err := elector.renew(leaderCtx)
+	cancel()
+	<-led
+	return err
+}
+
+func (elector *LeaderElector) acquire(ctx context.Context) bool {
+	for {
+		acquired, err := elector.tryAcquireOrRenew()
+		if err != nil {
+			logging.Errorf(elector.logFields().With(logging.Fields{"error": err}), "Failed to acquire leader lock '%s/%s': %v", elector.Namespace, elector.Name, err)
+		}
+		if acquired {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(elector.RetryPeriod):
+		}
+	}
+}
+
+// renew keeps renewing the lock until ctx is done, giving up leadership as soon as another replica holds the lock or
+// once renewals have failed for RenewDeadline
+func (elector *LeaderElector) renew(ctx context.Context) error {
+	lastRenewal := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(elector.RetryPeriod):
+		}
+
+		renewed, err := elector.tryAcquireOrRenew()
+		switch {
+		case renewed:
+			lastRenewal = time.Now()
+		case err == nil:
+			logging.Errorf(elector.logFields().With(logging.Fields{"holder": elector.observedRecord.HolderIdentity}), "Leader lock '%s/%s' was taken over by '%s'", elector.Namespace, elector.Name, elector.observedRecord.HolderIdentity)
+			return ErrLeadershipLost
+		case time.Since(lastRenewal) > elector.RenewDeadline:
+			logging.Errorf(elector.logFields().With(logging.Fields{"error": err}), "Failed to renew leader lock '%s/%s' for %v: %v", elector.Namespace, elector.Name, elector.RenewDeadline, err)
+			return ErrLeadershipLost
+		default:
+			logging.Warningf(elector.logFields().With(logging.Fields{"error": err}), "Failed to renew leader lock '%s/%s', retrying: %v", elector.Namespace, elector.Name, err)
+		}
+	}
+}
+
+// tryAcquireOrRenew claims the lock when it is free, expired or already held by this replica. It returns false without
+// an error when another replica holds an unexpired lease.
+func (elector *LeaderElector) tryAcquireOrRenew() (bool, error) {
+	now := time.Now()
+	record := leaderElectionRecord{
+		HolderIdentity:       elector.Identity,
+		LeaseDurationSeconds: int(elector.LeaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	configMaps := elector.CoreClient.ConfigMaps(elector.Namespace)
+	configMap, err := configMaps.Get(elector.Name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, errors.Wrapf(err, "Failed to fetch config map '%s/%s'", elector.Namespace, elector.Name)
+		}
+		raw, err := json.Marshal(record)
+		if err != nil {
+			return false, err
+		}
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        elector.Name,
+				Namespace:   elector.Namespace,
+				Annotations: map[string]string{leaderAnnotation: string(raw)},
+			},
+		})
+		if err != nil {
+			return false, errors.Wrapf(err, "Failed to create config map '%s/%s'", elector.Namespace, elector.Name)
+		}
+		elector.observe(record, now)
+		return true, nil
+	}
+
+	existing := leaderElectionRecord{}
+	if raw, found := configMap.ObjectMeta.Annotations[leaderAnnotation]; found {
+		if err := json.Unmarshal([]byte(raw), &existing); err != nil {
+			return false, errors.Wrapf(err, "Failed to parse the leader election record of config map '%s/%s'", elector.Namespace, elector.Name)
+		}
+	}
+	elector.observe(existing, now)
+	if existing.HolderIdentity != "" && existing.HolderIdentity != elector.Identity && now.Before(elector.observedTime.Add(elector.LeaseDuration)) {
+		return false, nil
+	}
+	if existing.HolderIdentity == elector.Identity {
+		record.AcquireTime = existing.AcquireTime
+	}
+
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	if configMap.ObjectMeta.Annotations == nil {
+		configMap.ObjectMeta.Annotations = map[string]string{}
+	}
+	configMap.ObjectMeta.Annotations[leaderAnnotation] = string(raw)
+	// The update carries the resource version that was read, so a replica racing for the same lock gets a conflict
+	if _, err := configMaps.Update(configMap); err != nil {
+		return false, errors.Wrapf(err, "Failed to update config map '%s/%s'", elector.Namespace, elector.Name)
+	}
+	elector.observe(record, now)
+	return true, nil
+}
+
+// observe notes the record on the lock, along with when it was first seen while it does not change
+func (elector *LeaderElector) observe(record leaderElectionRecord, now time.Time) {
+	if record.HolderIdentity != elector.observedRecord.HolderIdentity || !record.RenewTime.Equal(elector.observedRecord.RenewTime) {
+		elector.observedRecord = record
+		elector.observedTime = now
+	}
+}
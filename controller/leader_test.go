@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestLeaderElectorAcquiresFreeLock(t *testing.T) {
+	coreClient := fake.NewSimpleClientset().CoreV1()
+	elector := NewLeaderElector(coreClient, "kube-system", "kong-ingress-controller-kong", "replica-1")
+
+	acquired, err := elector.tryAcquireOrRenew()
+	if err != nil || !acquired {
+		t.Fatalf("Free lock acquired: %v (%v), want it acquired", acquired, err)
+	}
+	if holder := lockHolder(t, coreClient.ConfigMaps("kube-system").Get); holder != "replica-1" {
+		t.Errorf("Lock is held by '%s', want 'replica-1'", holder)
+	}
+}
+
+func TestLeaderElectorTakesOverExpiredLease(t *testing.T) {
+	coreClient := fake.NewSimpleClientset(sampleLock("kube-system", "kong-ingress-controller-kong", "replica-2")).CoreV1()
+	elector := NewLeaderElector(coreClient, "kube-system", "kong-ingress-controller-kong", "replica-1")
+	elector.LeaseDuration = 20 * time.Millisecond
+
+	if acquired, err := elector.tryAcquireOrRenew(); err != nil || acquired {
+		t.Fatalf("Lock held by another replica acquired: %v (%v), want it left alone until the lease expires", acquired, err)
+	}
+	time.Sleep(2 * elector.LeaseDuration)
+	if acquired, err := elector.tryAcquireOrRenew(); err != nil || !acquired {
+		t.Fatalf("Expired lock acquired: %v (%v), want it acquired", acquired, err)
+	}
+	if holder := lockHolder(t, coreClient.ConfigMaps("kube-system").Get); holder != "replica-1" {
+		t.Errorf("Lock is held by '%s', want 'replica-1'", holder)
+	}
+}
+
+func TestLeaderElectorCancelsLeadingWhenLockIsTakenOver(t *testing.T) {
+	coreClient := fake.NewSimpleClientset().CoreV1()
+	elector := NewLeaderElector(coreClient, "kube-system", "kong-ingress-controller-kong", "replica-1")
+	elector.RetryPeriod = 5 * time.Millisecond
+
+	leading := make(chan context.Context, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- elector.Run(context.Background(), func(ctx context.Context) { leading <- ctx })
+	}()
+
+	var leaderCtx context.Context
+	select {
+	case leaderCtx = <-leading:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting to acquire leadership")
+	}
+	if _, err := coreClient.ConfigMaps("kube-system").Update(sampleLock("kube-system", "kong-ingress-controller-kong", "replica-2")); err != nil {
+		t.Fatalf("Error taking over the lock: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != ErrLeadershipLost {
+			t.Errorf("Run returned %v, want %v", err, ErrLeadershipLost)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for leadership to be lost")
+	}
+	if leaderCtx.Err() == nil {
+		t.Error("Expected the leading context to be cancelled once leadership was lost")
+	}
+}
+
+func sampleLock(namespace string, name string, holder string) *v1.ConfigMap {
+	raw, _ := json.Marshal(leaderElectionRecord{HolderIdentity: holder, LeaseDurationSeconds: 15, AcquireTime: time.Now(), RenewTime: time.Now()})
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: map[string]string{leaderAnnotation: string(raw)},
+		},
+	}
+}
+
+func lockHolder(t *testing.T, get func(string, metav1.GetOptions) (*v1.ConfigMap, error)) string {
+	configMap, err := get("kong-ingress-controller-kong", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error fetching the lock: %v", err)
+	}
+	record := leaderElectionRecord{}
+	if err := json.Unmarshal([]byte(configMap.ObjectMeta.Annotations[leaderAnnotation]), &record); err != nil {
+		t.Fatalf("Error parsing the leader election record: %v", err)
+	}
+	return record.HolderIdentity
+}
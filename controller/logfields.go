@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+)
+
+// ingressFields are the log fields naming an ingress
+func ingressFields(ingress *v1beta1.Ingress) logging.Fields {
+	return logging.Fields{"ingress": ingress.ObjectMeta.Name, "namespace": ingress.ObjectMeta.Namespace}
+}
+
+// keyFields are the log fields naming the ingress or HTTPRoute with a namespace/name key
+func keyFields(key string) logging.Fields {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return logging.Fields{"key": key}
+	}
+	return logging.Fields{"ingress": name, "namespace": namespace}
+}
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	reconcileSuccess = "success"
+	reconcileError   = "error"
+
+	ignoredClass       = "class"
+	ignoredOptIn       = "opt-in"
+	ignoredUnsupported = "unsupported"
+)
+
+// kongOperations names the operation of a kong admin API request by its method
+var kongOperations = map[string]string{
+	http.MethodGet:    "get",
+	http.MethodPost:   "create",
+	http.MethodPut:    "create",
+	http.MethodPatch:  "patch",
+	http.MethodDelete: "delete",
+}
+
+// kongEntities are the kong entities requests are labelled with. A request for a nested entity, like the plugins of an
+// api, is labelled with the innermost entity.
+var kongEntities = map[string]bool{
+	"apis":         true,
+	"plugins":      true,
+	"certificates": true,
+	"snis":         true,
+	"services":     true,
+	"routes":       true,
+	"consumers":    true,
+	"key-auth":     true,
+	"upstreams":    true,
+	"targets":      true,
+}
+
+var (
+	managedAPIsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kong_ingress_managed_apis",
+		Help: "Number of kong apis backed by an ingress, as of the last reap cycle",
+	}, []string{"namespace"})
+
+	reconcileCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kong_ingress_reconcile_total",
+		Help: "Number of ingress reconciles by result",
+	}, []string{"namespace", "result"})
+
+	reaperLastSuccessGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kong_ingress_reaper_last_success_timestamp",
+		Help: "Unix time of the last reap cycle that completed successfully",
+	})
+
+	kongRequestCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kong_ingress_kong_requests_total",
+		Help: "Number of requests made to the kong admin API by operation, entity and result",
+	}, []string{"operation", "entity", "result"})
+
+	kongRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "kong_ingress_kong_request_duration_seconds",
+		Help: "Duration of requests made to the kong admin API by operation and entity, including rate limit retries",
+	}, []string{"operation", "entity"})
+
+	reapCycleCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kong_ingress_reap_cycles_total",
+		Help: "Number of reap cycles by result",
+	}, []string{"result"})
+
+	reapCycleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "kong_ingress_reap_cycle_duration_seconds",
+		Help: "Duration of reap cycles",
+	})
+
+	reaperSafetyTripsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kong_ingress_reaper_safety_trips_total",
+		Help: "Number of reap cycles that deleted no apis because they would have deleted more than the reap limit",
+	})
+
+	ignoredCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kong_ingress_ignored_total",
+		Help: "Number of ingress changes skipped by reason, counted when ignored ingresses are logged",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(managedAPIsGauge, reconcileCounter, reaperLastSuccessGauge, ignoredCounter, kongRequestCounter,
+		kongRequestDuration, reapCycleCounter, reapCycleDuration, reaperSafetyTripsCounter)
+}
+
+// metricsNamespace returns the namespace label value for a metric. Namespaces are only distinguished when enabled,
+// since every namespace multiplies the number of series.
+func (controller *KongIngressController) metricsNamespace(namespace string) string {
+	if controller.NamespaceMetrics {
+		return namespace
+	}
+	return ""
+}
+
+func (controller *KongIngressController) countReconcile(namespace string, err error) {
+	result := reconcileSuccess
+	if err != nil {
+		result = reconcileError
+	}
+	reconcileCounter.WithLabelValues(controller.metricsNamespace(namespace), result).Inc()
+}
+
+func (controller *KongIngressController) countIgnored(reason string) {
+	if controller.LogIgnored {
+		ignoredCounter.WithLabelValues(reason).Inc()
+	}
+}
+
+// setManagedAPIs replaces the managed api counts so that namespaces without apis no longer report stale values
+func (controller *KongIngressController) setManagedAPIs(namespaceOfAPIs []string) {
+	counts := map[string]int{}
+	for _, namespace := range namespaceOfAPIs {
+		counts[controller.metricsNamespace(namespace)]++
+	}
+
+	managedAPIsGauge.Reset()
+	for namespace, count := range counts {
+		managedAPIsGauge.WithLabelValues(namespace).Set(float64(count))
+	}
+}
+
+// MetricsTransport counts and times the requests made to the kong admin API, and notes whether the last one reached kong
+// for the readiness probe
+type MetricsTransport struct {
+	// Transport makes the requests, defaulting to http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (transport *MetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	started := time.Now()
+	resp, err := base.RoundTrip(req)
+	operation, entity := kongRequestLabels(req)
+	kongRequestDuration.WithLabelValues(operation, entity).Observe(time.Since(started).Seconds())
+	result := reconcileSuccess
+	// Fetching an entity that does not exist is how the controller finds out it has to be created
+	if err != nil || (resp.StatusCode >= 400 && !(req.Method == http.MethodGet && resp.StatusCode == http.StatusNotFound)) {
+		result = reconcileError
+	}
+	kongRequestCounter.WithLabelValues(operation, entity, result).Inc()
+	lastKongRequest.record(req, resp, err)
+
+	return resp, err
+}
+
+// kongRequestLabels returns the operation and entity of a request, looking for the entity in the last segments of the
+// path so that a path prefix in the kong address is skipped
+func kongRequestLabels(req *http.Request) (string, string) {
+	operation, found := kongOperations[req.Method]
+	if !found {
+		operation = strings.ToLower(req.Method)
+	}
+	entity := "other"
+	for _, segment := range strings.Split(strings.Trim(req.URL.Path, "/"), "/") {
+		if kongEntities[segment] {
+			entity = segment
+		}
+	}
+	return operation, entity
+}
+
+// countReapCycle records the outcome and duration of a reap cycle
+func countReapCycle(started time.Time, err error) {
+	result := reconcileSuccess
+	if err != nil {
+		result = reconcileError
+	}
+	reapCycleCounter.WithLabelValues(result).Inc()
+	reapCycleDuration.Observe(time.Since(started).Seconds())
+}
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nccurry/go-kong/kong"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReconcileCountedPerNamespace(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.NamespaceMetrics = true
+
+	prodIngress := sampleIngress("bestservice", "prod")
+	infraIngress := sampleIngress("brokenservice", "infra")
+	mux.HandleFunc("/apis/"+getQualifiedName(&prodIngress), func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Api{
+			ID:           getQualifiedName(&prodIngress),
+			Name:         getQualifiedName(&prodIngress),
+			UpstreamURL:  getUpstreamURL(&prodIngress, getIngressPaths(&prodIngress)[0], parseAnnotations(&prodIngress)),
+			Hosts:        []string{prodIngress.Spec.Rules[0].Host},
+			PreserveHost: true,
+		})
+	})
+	mux.HandleFunc("/apis/"+getQualifiedName(&infraIngress), func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+
+	prodSuccesses := reconcileCounter.WithLabelValues("prod", reconcileSuccess)
+	infraErrors := reconcileCounter.WithLabelValues("infra", reconcileError)
+	prodSuccessesBefore := testutil.ToFloat64(prodSuccesses)
+	infraErrorsBefore := testutil.ToFloat64(infraErrors)
+
+	syncIngress(kiController, &prodIngress)
+	syncIngress(kiController, &prodIngress)
+	syncIngress(kiController, &infraIngress)
+
+	if got := testutil.ToFloat64(prodSuccesses) - prodSuccessesBefore; got != 2 {
+		t.Errorf("Successful reconciles in prod increased by %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(infraErrors) - infraErrorsBefore; got != 1 {
+		t.Errorf("Failed reconciles in infra increased by %v, want 1", got)
+	}
+}
+
+func TestCertificateFailureCountedAsFailedReconcile(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.NamespaceMetrics = true
+
+	ingress := sampleTLSIngress("secureservice", "certs", "missing-tls")
+	kiController.CoreClient = fake.NewSimpleClientset().CoreV1()
+	mux.HandleFunc("/apis/"+getQualifiedName(&ingress), func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Api{
+			ID:           getQualifiedName(&ingress),
+			Name:         getQualifiedName(&ingress),
+			UpstreamURL:  getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)),
+			Hosts:        []string{ingress.Spec.Rules[0].Host},
+			PreserveHost: true,
+		})
+	})
+
+	successes := reconcileCounter.WithLabelValues("certs", reconcileSuccess)
+	failures := reconcileCounter.WithLabelValues("certs", reconcileError)
+	successesBefore, failuresBefore := testutil.ToFloat64(successes), testutil.ToFloat64(failures)
+
+	if _, err := kiController.ReconcileIngress(context.Background(), &ingress); err == nil {
+		t.Fatal("Expected an error reconciling an ingress whose TLS secret is missing")
+	}
+	if got := testutil.ToFloat64(failures) - failuresBefore; got != 1 {
+		t.Errorf("Failed reconciles increased by %v, want 1 for the certificate that could not be configured", got)
+	}
+	if got := testutil.ToFloat64(successes) - successesBefore; got != 0 {
+		t.Errorf("Successful reconciles increased by %v, want none", got)
+	}
+}
+
+func TestManagedAPIsCountedPerNamespace(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingresses := []struct{ name, namespace string }{
+		{"bestservice", "prod"},
+		{"otherservice", "prod"},
+		{"infraservice", "infra"},
+	}
+	apis := []*kong.Api{}
+	for _, ingressID := range ingresses {
+		ingress := sampleIngress(ingressID.name, ingressID.namespace)
+		ingressStore.Add(&ingress)
+		apis = append(apis, &kong.Api{Name: getQualifiedName(&ingress)})
+	}
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Apis{Data: apis})
+	})
+
+	kiController := KongIngressController{KongClient: kongClient, ingressStore: ingressStore, NamespaceMetrics: true}
+	if err := reapOrphanedApis(&kiController); err != nil {
+		t.Fatalf("Unexpected error reaping apis: %v", err)
+	}
+
+	expected := map[string]float64{"prod": 2, "infra": 1}
+	for namespace, count := range expected {
+		if got := testutil.ToFloat64(managedAPIsGauge.WithLabelValues(namespace)); got != count {
+			t.Errorf("Managed apis in %s is %v, want %v", namespace, got, count)
+		}
+	}
+}
+
+func TestWrongClassIngressCountedAsIgnored(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.LogIgnored = true
+
+	ingress := sampleIngress("nginxservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{ingressClassAnnotation: "nginx"}
+	mux.HandleFunc("/apis/", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("Ingress of another class should not be reconciled, got %s %s", request.Method, request.URL.Path)
+	})
+
+	ignored := ignoredCounter.WithLabelValues(ignoredClass)
+	ignoredBefore := testutil.ToFloat64(ignored)
+
+	syncIngress(kiController, &ingress)
+
+	if count := testutil.ToFloat64(ignored) - ignoredBefore; count != 1 {
+		t.Errorf("Ignored count for class is %v, want 1", count)
+	}
+}
+
+func TestKongRequestsCountedByOperationAndEntity(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	mux.HandleFunc("/prefix/apis/someservice.prod/plugins", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/prefix/apis/missingservice.prod", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	client := &http.Client{Transport: &MetricsTransport{}}
+
+	created := kongRequestCounter.WithLabelValues("create", "plugins", reconcileSuccess)
+	createdBefore := testutil.ToFloat64(created)
+	if _, err := client.Post(server.URL+"/prefix/apis/someservice.prod/plugins", "application/json", nil); err != nil {
+		t.Fatalf("Unexpected error creating plugin: %v", err)
+	}
+	if count := testutil.ToFloat64(created) - createdBefore; count != 1 {
+		t.Errorf("Plugin creations counted %v times, want once", count)
+	}
+
+	fetched := kongRequestCounter.WithLabelValues("get", "apis", reconcileSuccess)
+	fetchedBefore := testutil.ToFloat64(fetched)
+	if _, err := client.Get(server.URL + "/prefix/apis/missingservice.prod"); err != nil {
+		t.Fatalf("Unexpected error fetching api: %v", err)
+	}
+	if count := testutil.ToFloat64(fetched) - fetchedBefore; count != 1 {
+		t.Errorf("Fetch of a missing api counted %v times as a success, want once", count)
+	}
+}
+
+func TestFailedReapCycleCounted(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+
+	failed := reapCycleCounter.WithLabelValues(reconcileError)
+	failedBefore := testutil.ToFloat64(failed)
+	if err := reapOrphanedApis(kiController); err == nil {
+		t.Fatal("Expected an error reaping while kong fails")
+	}
+	if count := testutil.ToFloat64(failed) - failedBefore; count != 1 {
+		t.Errorf("Failed reap cycles counted %v times, want once", count)
+	}
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ingressCache is the part of the informers' cache of ingresses the workers and the reaper read
+type ingressCache interface {
+	List() []interface{}
+	GetByKey(key string) (interface{}, bool, error)
+}
+
+// namespacedStores joins the caches of the informers of each watched namespace into one
+type namespacedStores map[string]cache.Store
+
+func (stores namespacedStores) List() []interface{} {
+	objects := []interface{}{}
+	for _, store := range stores {
+		objects = append(objects, store.List()...)
+	}
+	return objects
+}
+
+func (stores namespacedStores) GetByKey(key string) (interface{}, bool, error) {
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	store, found := stores[namespace]
+	if !found {
+		store, found = stores[metav1.NamespaceAll]
+	}
+	if !found {
+		return nil, false, nil
+	}
+	return store.GetByKey(key)
+}
+
+// watchedNamespaces returns the namespaces to run informers for, which is all of them unless WatchNamespaces is set
+func (controller *KongIngressController) watchedNamespaces() []string {
+	if len(controller.WatchNamespaces) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	return controller.WatchNamespaces
+}
+
+// watchesNamespace reports whether the controller is responsible for the ingresses of a namespace
+func (controller *KongIngressController) watchesNamespace(namespace string) bool {
+	if len(controller.WatchNamespaces) == 0 {
+		return true
+	}
+	for _, watched := range controller.WatchNamespaces {
+		if watched == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// apiNamespace returns the namespace of the ingress a kong api is named after, which getQualifiedName and
+// getQualifiedAPIName put after the last '.' or '~' of the name since namespaces cannot contain either
+func apiNamespace(apiName string) string {
+	if i := strings.LastIndex(apiName, "~"); i >= 0 {
+		return apiName[i+1:]
+	}
+	if i := strings.LastIndex(apiName, "."); i >= 0 {
+		return apiName[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestReaperLeavesAPIsOfUnwatchedNamespaces(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.WatchNamespaces = []string{"team-a"}
+	kiController.ingressStore = namespacedStores{"team-a": cache.NewStore(cache.MetaNamespaceKeyFunc)}
+
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Apis{Data: []*kong.Api{
+			{Name: "orphanedservice.team-a"},
+			{Name: "otherservice.team-b"},
+			{Name: "example.com-other~team-b"},
+			{Name: "manually-created"},
+		}})
+	})
+	deleted := map[string]bool{}
+	for _, name := range []string{"orphanedservice.team-a", "otherservice.team-b", "example.com-other~team-b", "manually-created"} {
+		name := name
+		mux.HandleFunc("/apis/"+name, func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method == http.MethodDelete {
+				deleted[name] = true
+				writer.WriteHeader(http.StatusNoContent)
+				return
+			}
+			writeObjectResponse(t, &writer, kong.Api{Name: name})
+		})
+	}
+
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Fatalf("Unexpected error reaping apis: %v", err)
+	}
+	if !deleted["orphanedservice.team-a"] {
+		t.Error("Orphaned api of a watched namespace should be reaped")
+	}
+	for _, name := range []string{"otherservice.team-b", "example.com-other~team-b", "manually-created"} {
+		if deleted[name] {
+			t.Errorf("Api '%s' outside the watched namespaces should not be reaped", name)
+		}
+	}
+}
+
+func TestAPINamespaceFromName(t *testing.T) {
+	ingress := sampleIngress("my.service", "prod")
+	names := map[string]string{
+		getQualifiedName(&ingress):                             "prod",
+		getQualifiedAPIName("api.example.com", "/v1", "infra"): "infra",
+		"manually-created":                                     "",
+	}
+	for name, expected := range names {
+		if namespace := apiNamespace(name); namespace != expected {
+			t.Errorf("Namespace of api '%s' is '%s', want '%s'", name, namespace, expected)
+		}
+	}
+}
+
+func TestNamespacedStoresFindIngressByNamespace(t *testing.T) {
+	teamA, teamB := cache.NewStore(cache.MetaNamespaceKeyFunc), cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressA, ingressB := sampleIngress("someservice", "team-a"), sampleIngress("someservice", "team-b")
+	teamA.Add(&ingressA)
+	teamB.Add(&ingressB)
+	stores := namespacedStores{"team-a": teamA, "team-b": teamB}
+
+	obj, found, err := stores.GetByKey("team-b/someservice")
+	if err != nil || !found {
+		t.Fatalf("Ingress of team-b not found: %v", err)
+	}
+	if namespace := obj.(*v1beta1.Ingress).ObjectMeta.Namespace; namespace != "team-b" {
+		t.Errorf("Found the ingress of namespace '%s', want team-b", namespace)
+	}
+	if _, found, _ := stores.GetByKey("team-c/someservice"); found {
+		t.Error("Ingress of an unwatched namespace should not be found")
+	}
+	if count := len(stores.List()); count != 2 {
+		t.Errorf("Listed %d ingresses, want those of both namespaces", count)
+	}
+}
@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
+
+	"github.com/nccurry/go-kong/kong"
+	"github.com/pkg/errors"
+)
+
+const (
+	// overrideAnnotation names a KongIngress in the namespace of the ingress whose settings are applied to its api
+	overrideAnnotation = "kong.override"
+	// overrideConfigMapKey is the key holding the KongIngress as JSON when it is stored in a ConfigMap instead
+	overrideConfigMapKey = "kongingress"
+
+	kongIngressResource = "kongingresses"
+)
+
+// KongIngressGroupVersion is the group and version of the KongIngress custom resource
+var KongIngressGroupVersion = schema.GroupVersion{Group: "configuration.konghq.com", Version: "v1"}
+
+var resourceNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+func init() {
+	knownAnnotations[overrideAnnotation] = validateResourceName
+}
+
+// KongIngress carries the route and proxy settings for an ingress that are not expressed by the ingress itself. Only the
+// settings the controller can apply to a kong api are understood.
+type KongIngress struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Route *KongIngressRoute `json:"route,omitempty"`
+	Proxy *KongIngressProxy `json:"proxy,omitempty"`
+}
+
+// KongIngressRoute holds the settings that decide how requests are matched and forwarded
+type KongIngressRoute struct {
+	StripPath    *bool    `json:"strip_path,omitempty"`
+	PreserveHost *bool    `json:"preserve_host,omitempty"`
+	Protocols    []string `json:"protocols,omitempty"`
+}
+
+// KongIngressProxy holds the timeouts, in milliseconds, used when kong talks to the upstream service
+type KongIngressProxy struct {
+	ConnectTimeout *int `json:"connect_timeout,omitempty"`
+	ReadTimeout    *int `json:"read_timeout,omitempty"`
+	WriteTimeout   *int `json:"write_timeout,omitempty"`
+}
+
+// NewKongIngressClient returns a client for KongIngress custom resources
+func NewKongIngressClient(config *rest.Config) (*rest.RESTClient, error) {
+	crdConfig := *config
+	crdConfig.GroupVersion = &KongIngressGroupVersion
+	crdConfig.APIPath = "/apis"
+	crdConfig.ContentType = runtime.ContentTypeJSON
+	crdConfig.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: api.Codecs}
+
+	return rest.RESTClientFor(&crdConfig)
+}
+
+// resolveOverride fetches the KongIngress named by the override annotation of the ingress, or returns nil when the
+// ingress has none. The KongIngress custom resource is preferred, falling back to a ConfigMap of the same name when the
+// custom resource is not available.
+func resolveOverride(controller *KongIngressController, ingress *v1beta1.Ingress, annotations ingressAnnotations) (*KongIngress, error) {
+	name, found := annotations.values[overrideAnnotation]
+	if !found {
+		return nil, nil
+	}
+	namespace := ingress.ObjectMeta.Namespace
+
+	if controller.OverrideClient != nil {
+		raw, err := controller.OverrideClient.Get().Namespace(namespace).Resource(kongIngressResource).Name(name).Do().Raw()
+		if err == nil {
+			return parseOverride(raw, namespace, name)
+		}
+		if !apierrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "Failed to fetch KongIngress '%s/%s'", namespace, name)
+		}
+	}
+
+	configMap, err := controller.CoreClient.ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to fetch KongIngress '%s/%s' as a custom resource or config map", namespace, name)
+	}
+	content, found := configMap.Data[overrideConfigMapKey]
+	if !found {
+		return nil, errors.Errorf("Config map '%s/%s' does not contain the key '%s'", namespace, name, overrideConfigMapKey)
+	}
+
+	return parseOverride([]byte(content), namespace, name)
+}
+
+func parseOverride(raw []byte, namespace string, name string) (*KongIngress, error) {
+	override := KongIngress{}
+	if err := json.Unmarshal(raw, &override); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse KongIngress '%s/%s'", namespace, name)
+	}
+	return &override, nil
+}
+
+// apply sets the fields of the api request that are configured by the override
+func (override *KongIngress) apply(apiRequest *kong.ApiRequest) {
+	if override == nil {
+		return
+	}
+	if route := override.Route; route != nil {
+		if route.StripPath != nil {
+			stripURI := *route.StripPath
+			apiRequest.StripURI = &stripURI
+		}
+		if route.PreserveHost != nil {
+			apiRequest.PreserveHost = *route.PreserveHost
+		}
+		if len(route.Protocols) > 0 {
+			apiRequest.HttpsOnly = isHTTPSOnly(route.Protocols)
+		}
+	}
+	if proxy := override.Proxy; proxy != nil {
+		if proxy.ConnectTimeout != nil {
+			apiRequest.UpstreamConnectTimeout = *proxy.ConnectTimeout
+		}
+		if proxy.ReadTimeout != nil {
+			apiRequest.UpstreamReadTimeout = *proxy.ReadTimeout
+		}
+		if proxy.WriteTimeout != nil {
+			apiRequest.UpstreamSendTimeout = *proxy.WriteTimeout
+		}
+	}
+}
+
+// managesProtocols reports whether the override decides which protocols the api accepts
+func (override *KongIngress) managesProtocols() bool {
+	return override != nil && override.Route != nil && len(override.Route.Protocols) > 0
+}
+
+// managesPreserveHost reports whether the override decides whether the api preserves the host
+func (override *KongIngress) managesPreserveHost() bool {
+	return override != nil && override.Route != nil && override.Route.PreserveHost != nil
+}
+
+// isHTTPSOnly maps route protocols onto the legacy api, which can only either accept plain http or refuse it
+func isHTTPSOnly(protocols []string) bool {
+	for _, protocol := range protocols {
+		if protocol != "https" {
+			return false
+		}
+	}
+	return true
+}
+
+// patchAPIFields patches fields of an api by their name in kong. Unlike kong.ApiRequest this can set fields to false.
+func patchAPIFields(controller *KongIngressController, ingressKey string, api *kong.Api, fields map[string]interface{}) (*http.Response, error) {
+	resp, err := retryKong(controller, "patch API '"+api.Name+"'", func() (*http.Response, error) {
+		// Sending a request consumes its body, so every attempt builds its own
+		req, err := controller.KongClient.NewRequest(http.MethodPatch, "apis/"+api.ID, fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to build patch for API '%s'", api.Name)
+		}
+		return controller.KongClient.Do(req, nil)
+	})
+	if err != nil {
+		return resp, errors.Wrapf(err, "Failed to patch API '%s'", api.Name)
+	}
+	controller.AuditLog.record(auditPatch, auditEntityAPI, api.Name, ingressKey, fields)
+
+	return resp, nil
+}
+
+func validateResourceName(value string) error {
+	if len(value) > 253 || !resourceNamePattern.MatchString(value) {
+		return errors.New("must be a lowercase DNS-1123 subdomain")
+	}
+	return nil
+}
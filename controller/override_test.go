@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+const sampleKongIngressJSON = `{
+	"apiVersion": "configuration.konghq.com/v1",
+	"kind": "KongIngress",
+	"metadata": {"name": "slow-upstream", "namespace": "prod"},
+	"route": {"strip_path": true, "preserve_host": false, "protocols": ["https"]},
+	"proxy": {"connect_timeout": 5000, "read_timeout": 120000, "write_timeout": 90000}
+}`
+
+func TestOverrideResolvedIntoAPIRequest(t *testing.T) {
+	overrideClient, err := mockRESTClientRaw(sampleKongIngressJSON)
+	if err != nil {
+		t.Fatalf("Failed to create mock KongIngress client: %v", err)
+	}
+	controller := New(nil, nil, nil)
+	controller.OverrideClient = overrideClient
+
+	testOverrideResolvedIntoAPIRequest(t, controller)
+}
+
+func TestOverrideFallsBackToConfigMap(t *testing.T) {
+	controller := New(nil, fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "slow-upstream",
+			Namespace: "prod",
+		},
+		Data: map[string]string{
+			overrideConfigMapKey: sampleKongIngressJSON,
+		},
+	}).CoreV1(), nil)
+
+	testOverrideResolvedIntoAPIRequest(t, controller)
+}
+
+func testOverrideResolvedIntoAPIRequest(t *testing.T, controller *KongIngressController) {
+	ingress := sampleIngress("someservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{overrideAnnotation: "slow-upstream"}
+
+	override, err := resolveOverride(controller, &ingress, parseAnnotations(&ingress))
+	if err != nil {
+		t.Fatalf("Unexpected error resolving override: %v", err)
+	}
+	apiRequest := apiRequestFromIngress(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	override.apply(&apiRequest)
+
+	stripURI := true
+	expected := apiRequestFromIngress(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	expected.StripURI = &stripURI
+	expected.PreserveHost = false
+	expected.HttpsOnly = true
+	expected.UpstreamConnectTimeout = 5000
+	expected.UpstreamReadTimeout = 120000
+	expected.UpstreamSendTimeout = 90000
+	if !reflect.DeepEqual(apiRequest, expected) {
+		t.Errorf("Override resolved into %+v, want %+v", apiRequest, expected)
+	}
+
+	drifted := driftedFields(&kong.Api{UpstreamReadTimeout: 120000}, apiRequest, override, ingressAnnotations{})
+	expectedDrift := map[string]interface{}{
+		"strip_uri":                true,
+		"https_only":               true,
+		"upstream_connect_timeout": 5000,
+		"upstream_send_timeout":    90000,
+	}
+	if !reflect.DeepEqual(drifted, expectedDrift) {
+		t.Errorf("Override drifted fields are %v, want %v", drifted, expectedDrift)
+	}
+}
@@ -0,0 +1,513 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/pkg/errors"
+)
+
+// The annotations that configure plugins on the apis of an ingress. The rate limits are the number of requests a client
+// may make in the period, the cors settings are comma separated lists, the request transformer adds comma separated
+// name:value headers to requests and removes comma separated header names from them, the whitelist and blacklist
+// are comma separated IPs or CIDRs the ip restriction allows or denies, the statsd host and port are the server the
+// statsd plugin sends the metrics of each api to, the request size limit is the largest request body in megabytes,
+// and the http log endpoint is the URL the http log plugin posts a log of each request to.
+const (
+	rateLimitMinuteAnnotation = annotationPrefix + "rate-limit-minute"
+	rateLimitHourAnnotation   = annotationPrefix + "rate-limit-hour"
+
+	corsOriginsAnnotation = annotationPrefix + "cors-origins"
+	corsMethodsAnnotation = annotationPrefix + "cors-methods"
+	corsHeadersAnnotation = annotationPrefix + "cors-headers"
+
+	requestTransformerAddHeadersAnnotation    = annotationPrefix + "request-transformer-add-headers"
+	requestTransformerRemoveHeadersAnnotation = annotationPrefix + "request-transformer-remove-headers"
+
+	ipWhitelistAnnotation = annotationPrefix + "whitelist"
+	ipBlacklistAnnotation = annotationPrefix + "blacklist"
+
+	statsdHostAnnotation = annotationPrefix + "statsd-host"
+	statsdPortAnnotation = annotationPrefix + "statsd-port"
+
+	requestSizeLimitAnnotation = annotationPrefix + "request-size-limit"
+
+	httpLogEndpointAnnotation = annotationPrefix + "http-log-endpoint"
+
+	rateLimitingPlugin        = "rate-limiting"
+	corsPlugin                = "cors"
+	requestTransformerPlugin  = "request-transformer"
+	ipRestrictionPlugin       = "ip-restriction"
+	statsdPlugin              = "statsd"
+	requestSizeLimitingPlugin = "request-size-limiting"
+	httpLogPlugin             = "http-log"
+	auditEntityPlugin         = "plugin"
+	rateLimitConfigMinute     = "minute"
+	rateLimitConfigHour       = "hour"
+
+	// defaultStatsdPort is the port statsd servers conventionally listen on, used when only the host is annotated
+	defaultStatsdPort = 8125
+)
+
+func init() {
+	knownAnnotations[rateLimitMinuteAnnotation] = validatePositiveInt
+	knownAnnotations[rateLimitHourAnnotation] = validatePositiveInt
+	knownAnnotations[corsOriginsAnnotation] = validateList
+	knownAnnotations[corsMethodsAnnotation] = validateList
+	knownAnnotations[corsHeadersAnnotation] = validateList
+	knownAnnotations[requestTransformerAddHeadersAnnotation] = validateHeaderList
+	knownAnnotations[requestTransformerRemoveHeadersAnnotation] = validateList
+	knownAnnotations[ipWhitelistAnnotation] = validateCIDRList
+	knownAnnotations[ipBlacklistAnnotation] = validateCIDRList
+	knownAnnotations[statsdHostAnnotation] = validateNotEmpty
+	knownAnnotations[statsdPortAnnotation] = validatePort
+	knownAnnotations[requestSizeLimitAnnotation] = validatePositiveInt
+	knownAnnotations[httpLogEndpointAnnotation] = validateHTTPEndpoint
+	// Kong refuses an ip restriction with both lists, and the whitelist already denies every address it does not list
+	exclusiveAnnotations[ipBlacklistAnnotation] = ipWhitelistAnnotation
+	requiredAnnotations[statsdPortAnnotation] = statsdHostAnnotation
+}
+
+// kongPlugin is a plugin configured on a kong api
+type kongPlugin struct {
+	ID     string                 `json:"id,omitempty"`
+	Name   string                 `json:"name"`
+	Config map[string]interface{} `json:"config"`
+}
+
+type kongPluginList struct {
+	Data []kongPlugin `json:"data"`
+}
+
+func validatePositiveInt(value string) error {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if number <= 0 {
+		return errors.New("must be a positive number")
+	}
+	return nil
+}
+
+func validatePort(value string) error {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if port < 1 || port > 65535 {
+		return errors.New("must be a port between 1 and 65535")
+	}
+	return nil
+}
+
+// validateHTTPEndpoint accepts absolute http and https URLs, as the http log takes them
+func validateHTTPEndpoint(value string) error {
+	endpoint, err := url.Parse(value)
+	if err != nil {
+		return errors.New("is not a URL")
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return errors.New("must be an http or https URL")
+	}
+	if endpoint.Host == "" {
+		return errors.New("must have a host")
+	}
+	return nil
+}
+
+func validateList(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			return errors.New("must not have empty entries")
+		}
+	}
+	return nil
+}
+
+// validateHeaderList accepts comma separated name:value headers, as the request transformer takes them
+func validateHeaderList(value string) error {
+	if err := validateList(value); err != nil {
+		return err
+	}
+	for _, entry := range strings.Split(value, ",") {
+		if parts := strings.SplitN(entry, ":", 2); len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return errors.Errorf("header '%s' is not in the form name:value", strings.TrimSpace(entry))
+		}
+	}
+	return nil
+}
+
+// validateCIDRList accepts comma separated IPs and CIDRs, as the ip restriction takes them
+func validateCIDRList(value string) error {
+	if err := validateList(value); err != nil {
+		return err
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return errors.Errorf("'%s' is neither an IP nor a CIDR", entry)
+		}
+	}
+	return nil
+}
+
+// apiPlugin is a kong plugin whose config is driven by the annotations of an ingress
+type apiPlugin struct {
+	name string
+	// desiredConfig returns the config of the plugin the annotations ask for, or nil when they do not ask for the plugin.
+	// Only the keys it returns are managed, and a nil value asks for the key to be cleared.
+	desiredConfig func(annotations ingressAnnotations) map[string]interface{}
+	// keptWhileMalformed lists the annotations whose malformed values leave the plugin as it is, rather than reconciling
+	// it without them, for plugins that would otherwise open an api up
+	keptWhileMalformed []string
+	// defaultConfig returns the config of the plugin on apis whose annotations do not ask for it, or nil to leave them
+	// without the plugin. It may be nil itself for plugins without a default.
+	defaultConfig func(controller *KongIngressController) map[string]interface{}
+}
+
+// apiPlugins are the plugins reconciled on every api
+var apiPlugins = []apiPlugin{
+	{name: rateLimitingPlugin, desiredConfig: desiredRateLimitConfig},
+	{name: corsPlugin, desiredConfig: desiredCORSConfig},
+	{name: requestTransformerPlugin, desiredConfig: desiredRequestTransformerConfig},
+	{name: keyAuthPlugin, desiredConfig: desiredKeyAuthConfig},
+	{name: ipRestrictionPlugin, desiredConfig: desiredIPRestrictionConfig, keptWhileMalformed: []string{ipWhitelistAnnotation, ipBlacklistAnnotation}},
+	{name: statsdPlugin, desiredConfig: desiredStatsdConfig, defaultConfig: defaultStatsdConfig},
+	{name: requestSizeLimitingPlugin, desiredConfig: desiredRequestSizeLimitConfig, keptWhileMalformed: []string{requestSizeLimitAnnotation}},
+	{name: httpLogPlugin, desiredConfig: desiredHTTPLogConfig, keptWhileMalformed: []string{httpLogEndpointAnnotation}},
+}
+
+// desiredRateLimitConfig asks for both limits so that a limit whose annotation is removed is cleared. Limits are held
+// as float64 to compare with the config kong returns.
+func desiredRateLimitConfig(annotations ingressAnnotations) map[string]interface{} {
+	config := map[string]interface{}{rateLimitConfigMinute: nil, rateLimitConfigHour: nil}
+	found := false
+	for key, annotation := range map[string]string{rateLimitConfigMinute: rateLimitMinuteAnnotation, rateLimitConfigHour: rateLimitHourAnnotation} {
+		if value, annotated := annotations.values[annotation]; annotated {
+			limit, _ := strconv.Atoi(value)
+			config[key] = float64(limit)
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return config
+}
+
+// desiredCORSConfig only asks for the settings that are annotated, leaving the others to kong's defaults. The lists are
+// held as []interface{} to compare with the config kong returns.
+func desiredCORSConfig(annotations ingressAnnotations) map[string]interface{} {
+	config := map[string]interface{}{}
+	for key, annotation := range map[string]string{"origins": corsOriginsAnnotation, "methods": corsMethodsAnnotation, "headers": corsHeadersAnnotation} {
+		if value, annotated := annotations.values[annotation]; annotated {
+			entries := []interface{}{}
+			for _, entry := range strings.Split(value, ",") {
+				entries = append(entries, strings.TrimSpace(entry))
+			}
+			config[key] = entries
+		}
+	}
+	if len(config) == 0 {
+		return nil
+	}
+	return config
+}
+
+// desiredRequestTransformerConfig asks for the headers of both annotations when either is set, so that the headers of an
+// annotation that is removed are cleared. Headers are held as []interface{} to compare with the config kong returns.
+func desiredRequestTransformerConfig(annotations ingressAnnotations) map[string]interface{} {
+	config := map[string]interface{}{}
+	found := false
+	for key, annotation := range map[string]string{"add": requestTransformerAddHeadersAnnotation, "remove": requestTransformerRemoveHeadersAnnotation} {
+		headers := []interface{}{}
+		if value, annotated := annotations.values[annotation]; annotated {
+			for _, entry := range strings.Split(value, ",") {
+				headers = append(headers, strings.TrimSpace(entry))
+			}
+			found = true
+		}
+		config[key] = map[string]interface{}{"headers": headers}
+	}
+	if !found {
+		return nil
+	}
+	return config
+}
+
+// desiredIPRestrictionConfig asks for both lists when either is annotated, so that switching from one list to the other
+// clears the list that is no longer annotated. Lists are held as []interface{} to compare with the config kong returns.
+func desiredIPRestrictionConfig(annotations ingressAnnotations) map[string]interface{} {
+	config := map[string]interface{}{"whitelist": nil, "blacklist": nil}
+	found := false
+	for key, annotation := range map[string]string{"whitelist": ipWhitelistAnnotation, "blacklist": ipBlacklistAnnotation} {
+		if value, annotated := annotations.values[annotation]; annotated {
+			entries := []interface{}{}
+			for _, entry := range strings.Split(value, ",") {
+				entries = append(entries, strings.TrimSpace(entry))
+			}
+			config[key] = entries
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return config
+}
+
+// desiredStatsdConfig asks for the server of the annotations, on the default statsd port unless one is annotated. Only
+// the server is managed, leaving the metrics kong sends to its defaults. The port is held as float64 to compare with
+// the config kong returns.
+func desiredStatsdConfig(annotations ingressAnnotations) map[string]interface{} {
+	host, annotated := annotations.values[statsdHostAnnotation]
+	if !annotated {
+		return nil
+	}
+	port := defaultStatsdPort
+	if value, annotated := annotations.values[statsdPortAnnotation]; annotated {
+		port, _ = strconv.Atoi(value)
+	}
+	return statsdConfig(strings.TrimSpace(host), port)
+}
+
+// defaultStatsdConfig asks for the server of StatsdPlugin on apis whose ingress does not annotate one
+func defaultStatsdConfig(controller *KongIngressController) map[string]interface{} {
+	if controller.StatsdPlugin == "" {
+		return nil
+	}
+	host, portValue, err := net.SplitHostPort(controller.StatsdPlugin)
+	if err != nil {
+		return nil
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return nil
+	}
+	return statsdConfig(host, port)
+}
+
+func statsdConfig(host string, port int) map[string]interface{} {
+	return map[string]interface{}{"host": host, "port": float64(port)}
+}
+
+// desiredRequestSizeLimitConfig asks for the annotated limit in megabytes, held as float64 to compare with the config
+// kong returns
+func desiredRequestSizeLimitConfig(annotations ingressAnnotations) map[string]interface{} {
+	value, annotated := annotations.values[requestSizeLimitAnnotation]
+	if !annotated {
+		return nil
+	}
+	limit, _ := strconv.Atoi(value)
+	return map[string]interface{}{"allowed_payload_size": float64(limit)}
+}
+
+// desiredHTTPLogConfig only asks for the endpoint, leaving the method, timeouts and the like to kong's defaults
+func desiredHTTPLogConfig(annotations ingressAnnotations) map[string]interface{} {
+	endpoint, annotated := annotations.values[httpLogEndpointAnnotation]
+	if !annotated {
+		return nil
+	}
+	return map[string]interface{}{"http_endpoint": endpoint}
+}
+
+// reconcilePlugins makes the plugins on the api match the default plugins, the annotations of its ingress and the
+// configs of the KongPlugins it references, each taking precedence over the one before for the same plugin. Plugins
+// configured from KongPlugins or the defaults before are removed once nothing configures them. The plugins of the api
+// are listed once, and only the plugins that are desired or attached to it are touched. The failures are returned
+// together.
+func reconcilePlugins(controller *KongIngressController, ingressKey string, apiName string, annotations ingressAnnotations, resourcePlugins map[string]map[string]interface{}) error {
+	defaults := controller.defaultPlugins(annotations)
+	desired := map[string]map[string]interface{}{}
+	for name, config := range defaults {
+		desired[name] = config
+	}
+	kept := map[string]bool{}
+	for _, plugin := range apiPlugins {
+		if annotations.anyMalformed(plugin.keptWhileMalformed) {
+			kept[plugin.name] = true
+			delete(desired, plugin.name)
+			continue
+		}
+		config := plugin.desiredConfig(annotations)
+		if config == nil && plugin.defaultConfig != nil {
+			config = plugin.defaultConfig(controller)
+		}
+		// A plugin the annotations do not ask for keeps its default config
+		if _, isDefault := desired[plugin.name]; config != nil || !isDefault {
+			desired[plugin.name] = config
+		}
+	}
+	for _, name := range controller.attachedPlugins.names(apiName) {
+		if _, found := desired[name]; !found && !kept[name] {
+			desired[name] = nil
+		}
+	}
+	for name, config := range resourcePlugins {
+		desired[name] = config
+	}
+
+	model := controller.apiModel(annotations)
+	attached, err := listAPIPlugins(controller, model, apiName)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := []error{}
+	for _, name := range names {
+		if err := reconcilePlugin(controller, ingressKey, model, apiName, name, attached[name], desired[name]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		_, fromResource := resourcePlugins[name]
+		_, fromDefaults := defaults[name]
+		controller.attachedPlugins.set(apiName, name, fromResource || fromDefaults)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// reconcilePlugin makes the plugin attached to the api, nil when there is none, match the desired config, creating it,
+// patching the keys that differ or deleting it once it is no longer desired. Keys left out of the desired config are
+// not managed, so settings of the plugin changed directly in kong are kept.
+func reconcilePlugin(controller *KongIngressController, ingressKey string, model string, apiName string, name string, plugin *kongPlugin, desiredConfig map[string]interface{}) error {
+	switch {
+	case plugin == nil && desiredConfig == nil:
+		return nil
+	case plugin == nil:
+		logging.Infof(keyFields(ingressKey).With(logging.Fields{"plugin": name, "api": apiName}), "Adding plugin '%s' to API '%s'", name, apiName)
+		desiredPlugin := kongPlugin{Name: name, Config: map[string]interface{}{}}
+		for key, value := range desiredConfig {
+			if value != nil {
+				desiredPlugin.Config[key] = value
+			}
+		}
+		if err := doKongRequest(controller, http.MethodPost, pluginsPath(model, apiName), desiredPlugin, nil); err != nil {
+			return errors.Wrapf(err, "Failed to add plugin '%s' to API '%s'", name, apiName)
+		}
+		controller.AuditLog.record(auditCreate, auditEntityPlugin, apiName, ingressKey, redactedPlugin(desiredPlugin))
+	case desiredConfig == nil:
+		logging.Infof(keyFields(ingressKey).With(logging.Fields{"plugin": name, "api": apiName}), "Removing plugin '%s' from API '%s'", name, apiName)
+		if err := doKongRequest(controller, http.MethodDelete, pluginsPath(model, apiName)+"/"+plugin.ID, nil, nil); err != nil {
+			return errors.Wrapf(err, "Failed to remove plugin '%s' from API '%s'", name, apiName)
+		}
+		controller.AuditLog.record(auditDelete, auditEntityPlugin, apiName, ingressKey, redactedPlugin(*plugin))
+	default:
+		patch := kongPlugin{Name: name, Config: map[string]interface{}{}}
+		for key, value := range desiredConfig {
+			if !pluginConfigMatches(plugin.Config[key], value) {
+				patch.Config[key] = value
+			}
+		}
+		if len(patch.Config) == 0 {
+			return nil
+		}
+		logging.Infof(keyFields(ingressKey).With(logging.Fields{"plugin": name, "api": apiName}), "Patching config %v of plugin '%s' on API '%s'", redactedPlugin(patch).Config, name, apiName)
+		if err := doKongRequest(controller, http.MethodPatch, pluginsPath(model, apiName)+"/"+plugin.ID, patch, nil); err != nil {
+			return errors.Wrapf(err, "Failed to patch plugin '%s' on API '%s'", name, apiName)
+		}
+		controller.AuditLog.record(auditPatch, auditEntityPlugin, apiName, ingressKey, redactedPlugin(patch))
+	}
+	return nil
+}
+
+// redactedPlugin returns the plugin as it may be logged or audited, with the credentials of URLs in its config, like
+// the endpoint of the http log, hidden
+func redactedPlugin(plugin kongPlugin) kongPlugin {
+	plugin.Config = redactConfig(plugin.Config).(map[string]interface{})
+	return plugin
+}
+
+func redactConfig(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return redactURL(value)
+	case map[string]interface{}:
+		redacted := map[string]interface{}{}
+		for key, entry := range value {
+			redacted[key] = redactConfig(entry)
+		}
+		return redacted
+	case []interface{}:
+		redacted := []interface{}{}
+		for _, entry := range value {
+			redacted = append(redacted, redactConfig(entry))
+		}
+		return redacted
+	}
+	return value
+}
+
+// pluginConfigMatches reports whether a config value of a plugin in kong is the desired one. Only the keys of a desired
+// object are compared, so that settings kong fills in beneath it do not count as drift, and an empty list matches the
+// empty object kong encodes it as. A cleared setting matches a setting kong does not have or has emptied.
+func pluginConfigMatches(current interface{}, desired interface{}) bool {
+	if desiredObject, isObject := desired.(map[string]interface{}); isObject {
+		currentObject, isObject := current.(map[string]interface{})
+		if !isObject {
+			return false
+		}
+		for key, value := range desiredObject {
+			if !pluginConfigMatches(currentObject[key], value) {
+				return false
+			}
+		}
+		return true
+	}
+	if desiredList, isList := desired.([]interface{}); desired == nil || (isList && len(desiredList) == 0) {
+		switch empty := current.(type) {
+		case nil:
+			return desired == nil
+		case []interface{}:
+			return len(empty) == 0
+		case map[string]interface{}:
+			return len(empty) == 0
+		}
+		return false
+	}
+	return reflect.DeepEqual(current, desired)
+}
+
+// pluginsPath is the path of the plugins of the kong entity that represents an api: the api itself or, with the services
+// model, its service
+func pluginsPath(model string, apiName string) string {
+	if model == KongAPIModelServices {
+		return "services/" + apiName + "/plugins"
+	}
+	return "apis/" + apiName + "/plugins"
+}
+
+// listAPIPlugins returns the plugins attached to the api by name. An api that does not exist has no plugins.
+func listAPIPlugins(controller *KongIngressController, model string, apiName string) (map[string]*kongPlugin, error) {
+	plugins := kongPluginList{}
+	resp, err := retryKong(controller, "list the plugins of API '"+apiName+"'", func() (*http.Response, error) {
+		req, err := controller.KongClient.NewRequest(http.MethodGet, pluginsPath(model, apiName), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to build request for the plugins of API '%s'", apiName)
+		}
+		return controller.KongClient.Do(req, &plugins)
+	})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return map[string]*kongPlugin{}, nil
+		}
+		return nil, errors.Wrapf(err, "Failed to fetch the plugins of API '%s'", apiName)
+	}
+	attached := map[string]*kongPlugin{}
+	for i := range plugins.Data {
+		attached[plugins.Data[i].Name] = &plugins.Data[i]
+	}
+	return attached, nil
+}
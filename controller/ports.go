@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/pkg/errors"
+)
+
+// resolveServicePort returns the path with a named service port of its backend translated into the number of the
+// service port, since kong forwards to the service by its DNS name and cannot resolve port names. Numeric ports are
+// returned as they are without looking up the service.
+func resolveServicePort(controller *KongIngressController, ingress *v1beta1.Ingress, path *ingressPath) (*ingressPath, error) {
+	backend := getIngressBackend(path)
+	if backend.ServicePort.Type == intstr.Int {
+		return path, nil
+	}
+
+	serviceKey := fmt.Sprintf("%s/%s", ingress.ObjectMeta.Namespace, backend.ServiceName)
+	service, err := controller.CoreClient.Services(ingress.ObjectMeta.Namespace).Get(backend.ServiceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to fetch service '%s' to resolve its port '%s'", serviceKey, backend.ServicePort.StrVal)
+	}
+	for _, port := range service.Spec.Ports {
+		if port.Name == backend.ServicePort.StrVal {
+			resolved := *path
+			resolvedBackend := *backend
+			resolvedBackend.ServicePort = intstr.FromInt(int(port.Port))
+			resolved.backend = &resolvedBackend
+			return &resolved, nil
+		}
+	}
+	return nil, errors.Errorf("Service '%s' has no port named '%s'", serviceKey, backend.ServicePort.StrVal)
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestNamedServicePortResolvedIntoUpstreamURL(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("namedservice", "prod")
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort = intstr.FromString("http")
+	kiController.CoreClient = fake.NewSimpleClientset(sampleService("prod", "namedservice", v1.ServicePort{Name: "metrics", Port: 9090}, v1.ServicePort{Name: "http", Port: 8080})).CoreV1()
+
+	path, err := resolveServicePort(kiController, &ingress, getIngressPaths(&ingress)[0])
+	if err != nil {
+		t.Fatalf("Unexpected error resolving service port: %v", err)
+	}
+	expectedURL := "http://namedservice.prod:8080"
+	if upstreamURL := getUpstreamURL(&ingress, path, parseAnnotations(&ingress)); upstreamURL != expectedURL {
+		t.Errorf("Upstream URL is '%s', want '%s'", upstreamURL, expectedURL)
+	}
+	if port := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort; port.Type != intstr.String {
+		t.Errorf("Resolving the service port changed the ingress to port %s", port.String())
+	}
+}
+
+func TestNumericServicePortNotResolved(t *testing.T) {
+	ingress := sampleIngress("numericservice", "prod")
+	path := getIngressPaths(&ingress)[0]
+
+	// Without a core client any lookup of the service would panic
+	resolved, err := resolveServicePort(&KongIngressController{}, &ingress, path)
+	if err != nil {
+		t.Fatalf("Unexpected error resolving service port: %v", err)
+	}
+	if resolved != path {
+		t.Errorf("Numeric service port was resolved into %+v", resolved.backend)
+	}
+}
+
+func TestMissingServicePortDoesNotCreateAPI(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("missingservice", "prod")
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort = intstr.FromString("http")
+	kiController.CoreClient = fake.NewSimpleClientset().CoreV1()
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		t.Errorf("No API expected for a service port that does not resolve, got %s", request.Method)
+	})
+
+	if _, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)); err == nil {
+		t.Error("Expected an error reconciling an ingress whose service does not exist")
+	}
+}
+
+func sampleService(namespace string, name string, ports ...v1.ServicePort) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1.ServiceSpec{Ports: ports},
+	}
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+)
+
+// DefaultWorkers is how many ingresses are reconciled at once by default
+const DefaultWorkers = 4
+
+// newIngressQueue returns the queue the informer handlers put the keys of changed ingresses on. Keys that fail to
+// reconcile are put back with a per key exponential backoff, and a key added again before a worker takes it is only
+// reconciled once.
+func newIngressQueue() workqueue.RateLimitingInterface {
+	return workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "ingresses")
+}
+
+// deletedObjects holds the last known state of deleted ingresses or HTTPRoutes until a worker has removed them from
+// kong, since they are no longer in the informer's cache by then
+type deletedObjects struct {
+	mutex   sync.Mutex
+	objects map[string]interface{}
+}
+
+func (deleted *deletedObjects) add(key string, obj interface{}) {
+	deleted.mutex.Lock()
+	defer deleted.mutex.Unlock()
+	if deleted.objects == nil {
+		deleted.objects = map[string]interface{}{}
+	}
+	deleted.objects[key] = obj
+}
+
+func (deleted *deletedObjects) get(key string) (interface{}, bool) {
+	deleted.mutex.Lock()
+	defer deleted.mutex.Unlock()
+	obj, found := deleted.objects[key]
+	return obj, found
+}
+
+func (deleted *deletedObjects) remove(key string) {
+	deleted.mutex.Lock()
+	defer deleted.mutex.Unlock()
+	delete(deleted.objects, key)
+}
+
+// enqueue puts the key of an informer object on the queue, after delay when it is positive
+func (controller *KongIngressController) enqueue(obj interface{}, delay time.Duration) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		logging.Errorf(logging.Fields{"error": err}, "Failed to get the key of %v: %v", obj, err)
+		return
+	}
+	if delay > 0 {
+		controller.queue.AddAfter(key, delay)
+		return
+	}
+	controller.queue.Add(key)
+}
+
+// objectKey returns the namespace/name key of an informer object for logging
+func objectKey(obj interface{}) string {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return fmt.Sprintf("%v", obj)
+	}
+	return key
+}
+
+// runWorker reconciles keys from the queue until it is shut down
+func (controller *KongIngressController) runWorker() {
+	for controller.processNextKey() {
+	}
+}
+
+func (controller *KongIngressController) processNextKey() bool {
+	item, shutdown := controller.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer controller.queue.Done(item)
+
+	key := item.(string)
+	atomic.AddInt32(&controller.processing, 1)
+	defer atomic.AddInt32(&controller.processing, -1)
+	if err := syncKey(controller, key); err != nil {
+		logging.Errorf(keyFields(key).With(logging.Fields{"error": err}), "Failed to reconcile '%s', retrying: %v", key, err)
+		controller.queue.AddRateLimited(key)
+		return true
+	}
+	controller.queue.Forget(key)
+	return true
+}
+
+// reconcilePassDone reports whether the workers have reconciled every key on the queue. Keys waiting out a delay or
+// a backoff are not counted, since they are not due yet.
+func (controller *KongIngressController) reconcilePassDone() bool {
+	return controller.queue.Len() == 0 && atomic.LoadInt32(&controller.processing) == 0
+}
+
+// waitForReconcilePass blocks until the workers have reconciled every queued key, returning false when ctx is done or
+// that takes longer than limit. The reaper loop does not count as stalled while it waits.
+func waitForReconcilePass(ctx context.Context, controller *KongIngressController, limit time.Duration) bool {
+	if controller.queue == nil {
+		return true
+	}
+	deadline := time.Now().Add(limit)
+	for !controller.reconcilePassDone() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		controller.recordLoopActivity()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(cacheSyncPollInterval):
+		}
+	}
+	return true
+}
+
+// syncKey reconciles the ingress or HTTPRoute with the key as it is now in the informer's cache, or removes it from
+// kong when it has been deleted
+func syncKey(controller *KongIngressController, key string) error {
+	obj, exists, err := controller.cachedStore().GetByKey(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		deleted, found := controller.deleted.get(key)
+		if !found {
+			return nil
+		}
+		errs := []error{}
+		for _, ingress := range ingressesOf(deleted) {
+			if err := syncDeletedIngress(controller, ingress); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) == 0 {
+			controller.deleted.remove(key)
+		}
+		return utilerrors.NewAggregate(errs)
+	}
+
+	// An object deleted and created again before its deletion was handled only needs reconciling
+	controller.deleted.remove(key)
+	errs := []error{}
+	for _, ingress := range ingressesOf(obj) {
+		if err := syncIngress(controller, ingress); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ingressesOf returns the ingress an informer object is, or those an HTTPRoute translates into
+func ingressesOf(obj interface{}) []*v1beta1.Ingress {
+	switch object := obj.(type) {
+	case *v1beta1.Ingress:
+		return []*v1beta1.Ingress{object}
+	case *HTTPRoute:
+		ingresses := []*v1beta1.Ingress{}
+		for _, ingress := range ingressesFromHTTPRoute(object) {
+			ingress := ingress
+			ingresses = append(ingresses, &ingress)
+		}
+		return ingresses
+	}
+	return nil
+}
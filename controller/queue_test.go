@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestFailedReconcileRequeued(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongMaxRetries = 0
+
+	ingress := sampleIngress("flakyservice", "prod")
+	fetches := 0
+	reconciled := make(chan struct{})
+	mux.HandleFunc("/apis/"+getQualifiedName(&ingress), func(writer http.ResponseWriter, request *http.Request) {
+		fetches++
+		if fetches < 3 {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeObjectResponse(t, &writer, apiFromIngress(&ingress))
+		close(reconciled)
+	})
+	startQueue(t, &ingress)
+	defer kiController.queue.ShutDown()
+
+	ingressAdded(kiController)(&ingress)
+	select {
+	case <-reconciled:
+	case <-time.After(time.Second):
+		t.Fatal("Ingress was not reconciled again after failing")
+	}
+}
+
+func TestDeletedIngressRemovedByWorker(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("deletedservice", "prod")
+	waitGroup := sync.WaitGroup{}
+	waitGroup.Add(1)
+	go testAPIDeleted(t, getQualifiedName(&ingress), &waitGroup)
+	startQueue(t)
+	defer kiController.queue.ShutDown()
+
+	// The informer hands over a tombstone when it missed the deletion itself
+	ingressDeleted(kiController)(cache.DeletedFinalStateUnknown{Key: getIngressKey(&ingress), Obj: &ingress})
+	waitGroup.Wait()
+}
+
+func TestRapidUpdatesCoalesced(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+
+	ingress := sampleIngress("busyservice", "prod")
+	for _, version := range []string{"1", "2", "3"} {
+		updated := ingress
+		updated.ObjectMeta.ResourceVersion = version
+		ingressUpdated(kiController)(&ingress, &updated)
+	}
+	if queued := kiController.queue.Len(); queued != 1 {
+		t.Errorf("Three updates of an ingress queued %d reconciles, want one", queued)
+	}
+}
+
+func TestReaperWaitsForQueuedIngresses(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("newservice", "prod")
+	release := make(chan struct{})
+	mux.HandleFunc("/apis/"+getQualifiedName(&ingress), func(writer http.ResponseWriter, request *http.Request) {
+		<-release
+		writeObjectResponse(t, &writer, apiFromIngress(&ingress))
+	})
+	startQueue(t, &ingress)
+	defer kiController.queue.ShutDown()
+
+	ingressAdded(kiController)(&ingress)
+	if waitForReconcilePass(context.Background(), kiController, 50*time.Millisecond) {
+		t.Error("Reconcile pass reported done while an ingress was still being reconciled")
+	}
+	close(release)
+	if !waitForReconcilePass(context.Background(), kiController, time.Second) {
+		t.Error("Reconcile pass not done once the queued ingress was reconciled")
+	}
+}
+
+// startQueue gives kiController a queue with a worker taking ingresses from a cache holding them
+func startQueue(t *testing.T, ingresses ...interface{}) {
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, ingress := range ingresses {
+		if err := ingressStore.Add(ingress); err != nil {
+			t.Fatalf("Could not add ingress to cache: %v", err)
+		}
+	}
+	kiController.ingressStore = ingressStore
+	kiController.queue = newIngressQueue()
+	go kiController.runWorker()
+}
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+)
+
+var (
+	// maxRateLimitRetries bounds how many times a request rate limited by kong is retried before the 429 is returned
+	maxRateLimitRetries = 3
+	// rateLimitBackoff is how long to wait before the first retry when kong does not say how long to wait
+	rateLimitBackoff = time.Second
+	// maxRetryAfter caps the wait kong can ask for, so that a bad header cannot stall a reconcile indefinitely
+	maxRetryAfter = time.Minute
+)
+
+// RateLimitTransport retries requests that the kong admin API rejects with 429 Too Many Requests, waiting as long
+// as its Retry-After header asks, or backing off exponentially when it does not say
+type RateLimitTransport struct {
+	// Transport makes the requests, defaulting to http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+// NewKongHTTPClient returns an http client for the kong admin API that honours its rate limiting, adds the headers
+// to every request and measures the requests. With dryRun, requests that would change kong are only logged.
+func NewKongHTTPClient(headers http.Header, dryRun bool) *http.Client {
+	transport := http.RoundTripper(&HeaderTransport{Headers: headers})
+	if dryRun {
+		transport = &DryRunTransport{Transport: transport}
+	}
+	return &http.Client{Transport: &MetricsTransport{Transport: &RateLimitTransport{Transport: transport}}}
+}
+
+// RoundTrip implements http.RoundTripper
+func (transport *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := transport.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := base.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= maxRateLimitRetries {
+			return resp, err
+		}
+		// A request whose body cannot be replayed cannot be retried
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := retryAfter(resp.Header.Get("Retry-After"), attempt)
+		resp.Body.Close()
+		logging.Warningf(logging.Fields{"method": req.Method, "path": req.URL.Path}, "Kong rate limited %s %s, retrying in %v", req.Method, req.URL.Path, delay)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retry := *req
+			retry.Body = body
+			req = &retry
+		}
+	}
+}
+
+// retryAfter interprets a Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(header string, attempt int) time.Duration {
+	delay := rateLimitBackoff << uint(attempt)
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		delay = time.Until(date)
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay
+}
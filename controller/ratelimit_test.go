@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestRateLimitedRequestRetriedAfterDelay(t *testing.T) {
+	requests := 0
+	var retriedAt time.Time
+	rateLimitedAt := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if requests == 1 {
+			rateLimitedAt = time.Now()
+			writer.Header().Set("Retry-After", "1")
+			writer.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		retriedAt = time.Now()
+		writeObjectResponse(t, &writer, kong.Api{Name: "ratelimited.prod"})
+	}))
+	defer server.Close()
+
+	rateLimitedClient, _ := kong.NewClient(NewKongHTTPClient(nil, false), server.URL)
+	api, _, err := rateLimitedClient.Apis.Get("ratelimited.prod")
+	if err != nil {
+		t.Fatalf("Rate limited request should succeed once retried, got: %v", err)
+	}
+	if api.Name != "ratelimited.prod" {
+		t.Errorf("Got api '%s', want 'ratelimited.prod'", api.Name)
+	}
+	if requests != 2 {
+		t.Errorf("Kong received %d requests, want 2", requests)
+	}
+	if delay := retriedAt.Sub(rateLimitedAt); delay < time.Second {
+		t.Errorf("Request was retried after %v, want at least the 1s asked for by Retry-After", delay)
+	}
+}
+
+func TestRetryAfterWithoutHeaderBacksOff(t *testing.T) {
+	if delay := retryAfter("", 0); delay != rateLimitBackoff {
+		t.Errorf("First retry without Retry-After waits %v, want %v", delay, rateLimitBackoff)
+	}
+	if delay := retryAfter("", 2); delay != 4*rateLimitBackoff {
+		t.Errorf("Third retry without Retry-After waits %v, want %v", delay, 4*rateLimitBackoff)
+	}
+	if delay := retryAfter("86400", 0); delay != maxRetryAfter {
+		t.Errorf("Retry-After of a day waits %v, want it capped at %v", delay, maxRetryAfter)
+	}
+}
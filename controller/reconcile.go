@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/pkg/errors"
+)
+
+// The actions a reconcile can take on the kong api for a path. APIConflict leaves the api alone, since another managed
+// ingress claimed the route or api name of the path first.
+const (
+	APICreated   = "created"
+	APIUpdated   = "updated"
+	APIRecreated = "recreated"
+	APIUnchanged = "unchanged"
+	APIConflict  = "conflict"
+)
+
+// ignoredTooManyPaths and ignoredDraining are reasons a reconcile is skipped that are reported in its result but are
+// not counted as ignored ingresses, since they are not about whether the ingress belongs to this controller
+const (
+	ignoredTooManyPaths = "too-many-paths"
+	ignoredDraining     = "draining"
+)
+
+// ReconcileResult describes what reconciling an ingress did
+type ReconcileResult struct {
+	// Ignored is the reason the ingress was skipped, or empty when it was reconciled
+	Ignored string
+	Paths   []PathResult
+}
+
+// PathResult describes what reconciling one path of an ingress did to its kong api
+type PathResult struct {
+	Host   string
+	Path   string
+	API    string
+	Action string
+}
+
+// conflicted reports whether a path was left alone because another ingress claimed its route
+func (result ReconcileResult) conflicted() bool {
+	for _, path := range result.Paths {
+		if path.Action == APIConflict {
+			return true
+		}
+	}
+	return false
+}
+
+// ReconcileIngress makes kong match the ingress and reports what it did. The queue workers are adapters around
+// it, and it may be called directly to reconcile an ingress synchronously. Every part of the ingress is reconciled even
+// when another part fails, and the failures are returned together, so the ingress only needs another attempt when the
+// returned error is not nil.
+func (controller *KongIngressController) ReconcileIngress(ctx context.Context, ingress *v1beta1.Ingress) (ReconcileResult, error) {
+	if fairGame, reason := ingressIsFairGame(controller, ingress); !fairGame {
+		controller.ignoreIngress(ingress, reason)
+		return ReconcileResult{Ignored: reason}, nil
+	}
+	controller.startupThrottle.wait(controller.StartupReconcileQPS, controller.StartupWarmup)
+	if !controller.beginReconcile() {
+		logging.V(2).Infof(ingressFields(ingress), "Ignoring change to ingress '%s' in namespace '%s' while draining", ingress.ObjectMeta.Name, ingress.ObjectMeta.Namespace)
+		return ReconcileResult{Ignored: ignoredDraining}, nil
+	}
+	defer controller.endReconcile()
+
+	if paths := countIngressPaths(ingress); controller.MaxPathsPerIngress > 0 && paths > controller.MaxPathsPerIngress {
+		controller.recordWarning(ingress, "TooManyPaths", "Ingress '%s' has %d paths, more than the limit of %d, so it will not be reconciled", getIngressKey(ingress), paths, controller.MaxPathsPerIngress)
+		return ReconcileResult{Ignored: ignoredTooManyPaths}, nil
+	}
+
+	if err := validateIngressSupported(ingress); err != nil {
+		logging.Errorf(ingressFields(ingress).With(logging.Fields{"error": err}), "Unsupported ingress '%s' in namespace '%s': %v", ingress.ObjectMeta.Name, ingress.ObjectMeta.ClusterName, err)
+		controller.countIgnored(ignoredUnsupported)
+		return ReconcileResult{Ignored: ignoredUnsupported}, nil
+	}
+
+	annotations := parseAnnotations(ingress)
+	reportAnnotationProblems(controller, ingress, annotations)
+
+	if controller.ResolveUpstreams {
+		checkUpstreamResolves(ctx, controller, ingress, annotations)
+	}
+
+	logging.V(2).Infof(ingressFields(ingress), "Reconciling Ingress '%s' in namespace '%s' with Kong API", ingress.ObjectMeta.Name, ingress.ObjectMeta.Namespace)
+	errs := []error{}
+	result := ReconcileResult{}
+	// Plugins are left as they are while the KongPlugins cannot be read, rather than removing those configured from them
+	resourcePlugins, err := resolveKongPlugins(controller, ingress, annotations)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// The plugins of a service the routes are attached to are left to whoever created it
+	_, attached := controller.attachedService(annotations)
+	claims := earlierRouteClaims(controller, ingress)
+	for _, path := range getIngressPaths(ingress) {
+		apiName := getAPIName(controller, ingress, path)
+		if owner := claims.conflictingIngress(controller, ingress, path, annotations); owner != "" {
+			controller.recordWarning(ingress, "RouteConflict", "Path '%s%s' of ingress '%s' is already routed by ingress '%s', so API '%s' will not be created or updated", path.host, path.path, getIngressKey(ingress), owner, apiName)
+			result.Paths = append(result.Paths, PathResult{Host: path.host, Path: path.path, API: apiName, Action: APIConflict})
+			continue
+		}
+		action, err := reconcileAPI(controller, ingress, path, annotations)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to create or update API '%s'", apiName))
+		} else if resourcePlugins != nil && !attached {
+			if err := reconcilePlugins(controller, getIngressKey(ingress), apiName, annotations, resourcePlugins); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		result.Paths = append(result.Paths, PathResult{
+			Host:   path.host,
+			Path:   path.path,
+			API:    apiName,
+			Action: action,
+		})
+	}
+	if err := reconcileConsumers(controller, ingress, annotations); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i := range ingress.Spec.TLS {
+		ingressTLS := &ingress.Spec.TLS[i]
+		err := reconcileCertificate(controller, ingress, ingressTLS)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to create or update the certificate from secret '%s'", getSecretKey(ingress, ingressTLS)))
+		}
+	}
+	if err := releaseDroppedSNIs(controller, ingress); err != nil {
+		errs = append(errs, errors.Wrap(err, "Failed to release the SNIs dropped from the tls section"))
+	}
+
+	err = utilerrors.NewAggregate(errs)
+	controller.countReconcile(ingress.ObjectMeta.Namespace, err)
+	return result, err
+}
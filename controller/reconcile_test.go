@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestReconcileIngressReportsCreatedAPI(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("newservice", "prod")
+	apiName := getQualifiedName(&ingress)
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodGet, nil)
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, getAPIRequestFromIngress(&ingress))
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	result, err := kiController.ReconcileIngress(context.Background(), &ingress)
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling ingress: %v", err)
+	}
+	expected := ReconcileResult{
+		Paths: []PathResult{{
+			Host:   ingress.Spec.Rules[0].Host,
+			Path:   "/",
+			API:    apiName,
+			Action: APICreated,
+		}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Reconcile result is %+v, want %+v", result, expected)
+	}
+}
+
+func TestReconcileIngressReportsIgnoredIngress(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("nginxservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{ingressClassAnnotation: "nginx"}
+
+	result, err := kiController.ReconcileIngress(context.Background(), &ingress)
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling ingress: %v", err)
+	}
+	if result.Ignored != ignoredClass || len(result.Paths) != 0 {
+		t.Errorf("Reconcile result is %+v, want the ingress ignored for its class", result)
+	}
+}
+
+func TestReconcileIngressAggregatesFailures(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("mixedservice", "prod", "present-tls")
+	ingress.Spec.TLS = append(ingress.Spec.TLS, v1beta1.IngressTLS{
+		Hosts:      []string{"other.somedomain"},
+		SecretName: "missing-tls",
+	})
+	kiController.CoreClient = fake.NewSimpleClientset(sampleTLSSecret("prod", "present-tls", "cert-1")).CoreV1()
+	apiName := getQualifiedName(&ingress)
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Api{
+			ID:           apiName,
+			Name:         apiName,
+			UpstreamURL:  getUpstreamURL(&ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress)),
+			Hosts:        []string{ingress.Spec.Rules[0].Host},
+			PreserveHost: true,
+		})
+	})
+	mux.HandleFunc("/certificates/"+ingress.Spec.TLS[0].Hosts[0], func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kong.Certificate{ID: "present-cert", Cert: "cert-1", Key: "key-cert-1"})
+	})
+
+	result, err := kiController.ReconcileIngress(context.Background(), &ingress)
+	if len(result.Paths) != 1 || result.Paths[0].Action != APIUnchanged {
+		t.Errorf("Reconcile result is %+v, want the api reconciled despite the failed certificate", result)
+	}
+	aggregate, ok := err.(utilerrors.Aggregate)
+	if !ok {
+		t.Fatalf("Reconcile error is %v, want the failures aggregated", err)
+	}
+	if len(aggregate.Errors()) != 1 || !strings.Contains(aggregate.Error(), "prod/missing-tls") {
+		t.Errorf("Reconcile failures are %v, want only the missing secret", aggregate.Errors())
+	}
+}
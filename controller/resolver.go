@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+// upstreamLookupTimeout bounds how long a reconcile waits on DNS when checking its upstream
+var upstreamLookupTimeout = 5 * time.Second
+
+// Resolver looks up the addresses of a host. net.Resolver satisfies it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// checkUpstreamResolves raises a warning event when the host of the ingress's upstream does not resolve, which usually
+// means the backend service name or namespace is wrong. The api is reconciled regardless, since the service may be
+// created later.
+func checkUpstreamResolves(ctx context.Context, controller *KongIngressController, ingress *v1beta1.Ingress, annotations ingressAnnotations) {
+	for _, path := range getIngressPaths(ingress) {
+		upstreamURL, err := url.Parse(getUpstreamURL(ingress, path, annotations))
+		if err != nil {
+			continue
+		}
+		host := upstreamURL.Hostname()
+
+		lookupCtx, cancel := context.WithTimeout(ctx, upstreamLookupTimeout)
+		_, err = controller.Resolver.LookupHost(lookupCtx, host)
+		cancel()
+		if err != nil {
+			controller.recordWarning(ingress, "UpstreamUnresolvable", "Upstream host '%s' of ingress '%s' does not resolve: %v", host, getIngressKey(ingress), err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+)
+
+type fakeResolver struct {
+	addresses map[string][]string
+}
+
+func (resolver fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addresses, found := resolver.addresses[host]
+	if !found {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	return addresses, nil
+}
+
+func TestUnresolvableUpstreamRaisesWarning(t *testing.T) {
+	ingress := sampleIngress("missingservice", "prod")
+	recorder := record.NewFakeRecorder(10)
+	kiController := KongIngressController{Recorder: recorder, Resolver: fakeResolver{}}
+
+	checkUpstreamResolves(context.Background(), &kiController, &ingress, parseAnnotations(&ingress))
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning UpstreamUnresolvable") || !strings.Contains(event, "no such host") {
+			t.Errorf("Unexpected event for unresolvable upstream: %s", event)
+		}
+	default:
+		t.Error("No warning event raised for unresolvable upstream")
+	}
+}
+
+func TestResolvableUpstreamRaisesNoWarning(t *testing.T) {
+	ingress := sampleIngress("someservice", "prod")
+	backend := getIngressBackend(getIngressPaths(&ingress)[0])
+	recorder := record.NewFakeRecorder(10)
+	kiController := KongIngressController{
+		Recorder: recorder,
+		Resolver: fakeResolver{addresses: map[string][]string{
+			backend.ServiceName + ".prod": {"10.0.0.1"},
+		}},
+	}
+
+	checkUpstreamResolves(context.Background(), &kiController, &ingress, parseAnnotations(&ingress))
+
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("Unexpected event for resolvable upstream: %s", event)
+	default:
+	}
+}
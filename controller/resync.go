@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+// DefaultForceReconcileInterval is how long an unchanged ingress goes without a full reconcile by default
+const DefaultForceReconcileInterval = 10 * time.Minute
+
+// reconciledVersions remembers the resource version each ingress was last successfully reconciled at, so that resyncs
+// redelivering an unchanged ingress can skip calling kong
+type reconciledVersions struct {
+	mutex    sync.Mutex
+	versions map[string]reconciledVersion
+}
+
+type reconciledVersion struct {
+	resourceVersion string
+	reconciledAt    time.Time
+}
+
+func (tracker *reconciledVersions) record(ingress *v1beta1.Ingress) {
+	if ingress.ObjectMeta.ResourceVersion == "" {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.versions == nil {
+		tracker.versions = map[string]reconciledVersion{}
+	}
+	tracker.versions[getIngressKey(ingress)] = reconciledVersion{
+		resourceVersion: ingress.ObjectMeta.ResourceVersion,
+		reconciledAt:    time.Now(),
+	}
+}
+
+func (tracker *reconciledVersions) forget(ingress *v1beta1.Ingress) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	delete(tracker.versions, getIngressKey(ingress))
+}
+
+// succeeded reports whether the last reconcile of the ingress at its current resource version succeeded
+func (tracker *reconciledVersions) succeeded(ingress *v1beta1.Ingress) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	reconciled, found := tracker.versions[getIngressKey(ingress)]
+	return found && reconciled.resourceVersion == ingress.ObjectMeta.ResourceVersion
+}
+
+// unchanged reports whether the ingress was reconciled at its current resource version less than forceInterval ago.
+// A zero forceInterval always reports false, so every resync is reconciled in full.
+func (tracker *reconciledVersions) unchanged(ingress *v1beta1.Ingress, forceInterval time.Duration) bool {
+	if forceInterval <= 0 || ingress.ObjectMeta.ResourceVersion == "" {
+		return false
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	reconciled, found := tracker.versions[getIngressKey(ingress)]
+	return found && reconciled.resourceVersion == ingress.ObjectMeta.ResourceVersion && time.Since(reconciled.reconciledAt) < forceInterval
+}
@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUnchangedResyncIssuesNoKongRequests(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("steadyservice", "prod")
+	ingress.ObjectMeta.ResourceVersion = "42"
+	requests := 0
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if request.Method == http.MethodGet {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	syncIngress(kiController, &ingress)
+	if requests == 0 {
+		t.Fatal("Expected the first reconcile to call kong")
+	}
+
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+	ingressUpdated(kiController)(&ingress, &ingress)
+	if queued := kiController.queue.Len(); queued != 0 {
+		t.Errorf("Resync of an unchanged ingress queued %d reconciles, want none", queued)
+	}
+
+	updated := ingress
+	updated.ObjectMeta.ResourceVersion = "43"
+	ingressUpdated(kiController)(&ingress, &updated)
+	if queued := kiController.queue.Len(); queued != 1 {
+		t.Errorf("Ingress with a new resource version queued %d reconciles, want one", queued)
+	}
+}
+
+func TestUnchangedIngressWithUpstreamResyncedWithoutEndpointsWatch(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("balancedservice", "prod")
+	ingress.ObjectMeta.ResourceVersion = "42"
+	ingress.ObjectMeta.Annotations = map[string]string{useUpstreamAnnotation: "true"}
+	kiController.reconciled.record(&ingress)
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+
+	kiController.WatchEndpoints = true
+	ingressUpdated(kiController)(&ingress, &ingress)
+	if queued := kiController.queue.Len(); queued != 0 {
+		t.Errorf("Resync of an unchanged ingress whose targets follow the endpoints watch queued %d reconciles, want none", queued)
+	}
+
+	kiController.WatchEndpoints = false
+	ingressUpdated(kiController)(&ingress, &ingress)
+	if queued := kiController.queue.Len(); queued != 1 {
+		t.Errorf("Resync of an unchanged ingress with an upstream and no endpoints watch queued %d reconciles, want one", queued)
+	}
+}
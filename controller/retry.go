@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+)
+
+const (
+	// DefaultKongMaxRetries is how many times a kong admin call that fails transiently is retried by default
+	DefaultKongMaxRetries = 3
+	// DefaultKongRetryBackoff is how long to wait before the first retry by default, doubling with every retry after
+	DefaultKongRetryBackoff = 200 * time.Millisecond
+)
+
+// maxKongRetryBackoff caps the wait between retries, so that a reconcile gives up on kong well within a resync
+var maxKongRetryBackoff = 10 * time.Second
+
+// retryKong makes a kong admin call, retrying it with exponential backoff and jitter up to KongMaxRetries times while it
+// fails transiently. It returns the response and error of the last attempt, so that callers can still tell a missing
+// entity apart from a failure.
+func retryKong(controller *KongIngressController, description string, call func() (*http.Response, error)) (*http.Response, error) {
+	backoff := controller.KongRetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := call()
+		if err == nil || !isTransientKongError(resp) || attempt >= controller.KongMaxRetries {
+			return resp, err
+		}
+
+		// Jitter keeps the reconciles that failed together from retrying against kong in lockstep
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		logging.Warningf(logging.Fields{"error": err}, "Failed to %s, retrying in %v: %v", description, delay, err)
+		time.Sleep(delay)
+		if backoff *= 2; backoff > maxKongRetryBackoff {
+			backoff = maxKongRetryBackoff
+		}
+	}
+}
+
+// isTransientKongError decides whether a failed kong admin call is worth retrying: kong could not be reached at all, or
+// failed with a server error. Errors kong answers with, like a 404 for an entity that does not exist or a 409 for a
+// conflicting one, will not go away by asking again.
+func isTransientKongError(resp *http.Response) bool {
+	return resp == nil || resp.StatusCode >= http.StatusInternalServerError
+}
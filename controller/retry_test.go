@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func TestTransientKongFailureRetriedWithinReconcile(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("flakyservice", "prod")
+	apiName := getQualifiedName(&ingress)
+	fetches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		fetches++
+		if fetches < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeObjectResponse(t, &writer, apiFromIngress(&ingress))
+	})
+
+	action, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling while kong recovers: %v", err)
+	}
+	if action != APIUnchanged {
+		t.Errorf("Reconcile action is '%s', want '%s'", action, APIUnchanged)
+	}
+	if fetches != 3 {
+		t.Errorf("API was fetched %d times, want 3", fetches)
+	}
+}
+
+func TestMissingAPINotRetried(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("newservice", "prod")
+	apiName := getQualifiedName(&ingress)
+	fetches := 0
+	mux.HandleFunc("/apis/"+apiName, func(writer http.ResponseWriter, request *http.Request) {
+		fetches++
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		testRequestMatches(t, request, http.MethodPost, nil)
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	action, err := reconcileAPI(kiController, &ingress, getIngressPaths(&ingress)[0], parseAnnotations(&ingress))
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling: %v", err)
+	}
+	if action != APICreated {
+		t.Errorf("Reconcile action is '%s', want '%s'", action, APICreated)
+	}
+	if fetches != 1 {
+		t.Errorf("Missing API was fetched %d times, want once", fetches)
+	}
+}
+
+func TestKongRetriesBounded(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	deletes := 0
+	mux.HandleFunc("/apis/doomedservice.prod", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet {
+			writeObjectResponse(t, &writer, kong.Api{Name: "doomedservice.prod"})
+			return
+		}
+		deletes++
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+	kiController.KongMaxRetries = 2
+
+	if err := deleteKongAPI(kiController, "prod/doomedservice", kiController.KongAPIModel, "doomedservice.prod"); err == nil {
+		t.Fatal("Expected an error deleting an api while kong keeps failing")
+	}
+	if deletes != 3 {
+		t.Errorf("Delete was attempted %d times, want once and 2 retries", deletes)
+	}
+}
+
+func TestTransientKongFailureRetriedForPlugins(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("limitedservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{rateLimitMinuteAnnotation: "60"}
+	apiName := getQualifiedName(&ingress)
+	attempts := map[string]int{}
+	mux.HandleFunc("/apis/"+apiName+"/plugins", func(writer http.ResponseWriter, request *http.Request) {
+		attempts[request.Method]++
+		if attempts[request.Method] < 2 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if request.Method == http.MethodGet {
+			writeObjectResponse(t, &writer, kongPluginList{Data: []kongPlugin{}})
+			return
+		}
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	if err := reconcilePlugins(kiController, getIngressKey(&ingress), apiName, parseAnnotations(&ingress), nil); err != nil {
+		t.Fatalf("Unexpected error reconciling plugins while kong recovers: %v", err)
+	}
+	if attempts[http.MethodGet] != 2 || attempts[http.MethodPost] != 2 {
+		t.Errorf("Plugins listed %d and added %d times, want twice each", attempts[http.MethodGet], attempts[http.MethodPost])
+	}
+}
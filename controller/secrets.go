@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+)
+
+// createSecretWatch watches the secrets in each watched namespace so that renewed certificates and changed consumer
+// keys are pushed to kong without waiting for a change to the ingresses that reference them. Consumers secrets may be of
+// any type, so the watch cannot select TLS secrets only. The watches do not resync, since the ingress resync already
+// reconciles every certificate and consumer.
+func (controller *KongIngressController) createSecretWatch(ctx context.Context) []cache.Controller {
+	informers := []cache.Controller{}
+	for _, namespace := range controller.watchedNamespaces() {
+		watchedSource := cache.NewListWatchFromClient(
+			controller.CoreClient.RESTClient(),
+			"secrets",
+			namespace,
+			fields.Everything())
+
+		informer := cache.NewSharedIndexInformer(
+			watchedSource,
+			&v1.Secret{},
+			0,
+			cache.Indexers{},
+		)
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: secretUpdated(controller),
+		})
+
+		informers = append(informers, informer)
+		controller.spawn(func() { informer.Run(ctx.Done()) })
+	}
+	return informers
+}
+
+// secretUpdated queues every managed ingress that references a secret whose certificate or key changed, or that holds
+// its consumers and whose entries changed, so that the workers push the renewed certificate or keys to kong
+func secretUpdated(controller *KongIngressController) func(interface{}, interface{}) {
+	return func(previousObj, newObj interface{}) {
+		previous, secret := previousObj.(*v1.Secret), newObj.(*v1.Secret)
+		if reflect.DeepEqual(previous.Data, secret.Data) {
+			return
+		}
+		certificateChanged := !bytes.Equal(previous.Data[v1.TLSCertKey], secret.Data[v1.TLSCertKey]) || !bytes.Equal(previous.Data[v1.TLSPrivateKeyKey], secret.Data[v1.TLSPrivateKeyKey])
+		for _, cached := range controller.cachedStore().List() {
+			for _, ingress := range ingressesOf(cached) {
+				if ingress.ObjectMeta.Namespace != secret.ObjectMeta.Namespace {
+					continue
+				}
+				if (certificateChanged && referencesSecret(controller, ingress, secret.ObjectMeta.Name)) || referencesConsumersSecret(controller, ingress, secret.ObjectMeta.Name) {
+					logging.Infof(ingressFields(ingress).With(logging.Fields{"secret": secret.ObjectMeta.Name}), "Secret '%s/%s' changed, queueing ingress '%s'", secret.ObjectMeta.Namespace, secret.ObjectMeta.Name, getIngressKey(ingress))
+					controller.enqueue(cached, 0)
+					break
+				}
+			}
+		}
+	}
+}
+
+// referencesConsumersSecret reports whether a managed ingress takes its consumers from the secret
+func referencesConsumersSecret(controller *KongIngressController, ingress *v1beta1.Ingress, secretName string) bool {
+	if fairGame, _ := ingressIsFairGame(controller, ingress); !fairGame {
+		return false
+	}
+	return ingress.ObjectMeta.Annotations[authConsumersSecretAnnotation] == secretName
+}
+
+// referencesSecret reports whether a managed ingress lists the secret in its tls section
+func referencesSecret(controller *KongIngressController, ingress *v1beta1.Ingress, secretName string) bool {
+	if fairGame, _ := ingressIsFairGame(controller, ingress); !fairGame {
+		return false
+	}
+	for _, ingressTLS := range ingress.Spec.TLS {
+		if ingressTLS.SecretName == secretName {
+			return true
+		}
+	}
+	return false
+}
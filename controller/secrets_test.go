@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestRenewedSecretQueuesReferencingIngresses(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleTLSIngress("secureservice", "prod", "secure-tls")
+	otherNamespaceIngress := sampleTLSIngress("secureservice", "infra", "secure-tls")
+	otherSecretIngress := sampleTLSIngress("cartservice", "prod", "cart-tls")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&ingress)
+	ingressStore.Add(&otherNamespaceIngress)
+	ingressStore.Add(&otherSecretIngress)
+	kiController.ingressStore = ingressStore
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+
+	renewed := sampleTLSSecret("prod", "secure-tls", "cert-2")
+	secretUpdated(kiController)(renewed, renewed)
+	if length := kiController.queue.Len(); length != 0 {
+		t.Fatalf("%d ingresses queued for a secret whose certificate did not change, want none", length)
+	}
+
+	secretUpdated(kiController)(sampleTLSSecret("prod", "secure-tls", "cert-1"), renewed)
+	if length := kiController.queue.Len(); length != 1 {
+		t.Fatalf("%d ingresses queued, want only the one referencing the secret in its namespace", length)
+	}
+	if key, _ := kiController.queue.Get(); key != getIngressKey(&ingress) {
+		t.Errorf("Queued '%v', want '%s'", key, getIngressKey(&ingress))
+	}
+}
+
+func TestChangedConsumersSecretQueuesReferencingIngresses(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{authAnnotation: authKeyAuth, authConsumersSecretAnnotation: "shop-consumers"}
+	unreferencing := sampleIngress("cartservice", "prod")
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&ingress)
+	ingressStore.Add(&unreferencing)
+	kiController.ingressStore = ingressStore
+	kiController.queue = newIngressQueue()
+	defer kiController.queue.ShutDown()
+
+	previous := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-consumers", Namespace: "prod"},
+		Data:       map[string][]byte{"alice": []byte("key-1\nkey-2"), "bob": []byte("key-3")},
+	}
+	revoked := *previous
+	revoked.Data = map[string][]byte{"alice": []byte("key-1")}
+	secretUpdated(kiController)(previous, &revoked)
+
+	if length := kiController.queue.Len(); length != 1 {
+		t.Fatalf("%d ingresses queued, want only the one taking its consumers from the secret", length)
+	}
+	if key, _ := kiController.queue.Get(); key != getIngressKey(&ingress) {
+		t.Errorf("Queued '%v', want '%s'", key, getIngressKey(&ingress))
+	}
+}
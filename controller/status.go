@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	// lastErrorAnnotation is written by the controller with the error of the last failed reconcile of an ingress, and
+	// removed once the ingress reconciles successfully again
+	lastErrorAnnotation = annotationPrefix + "last-error"
+	// lastReconciledAnnotation is written by the controller with when the ingress first reconciled successfully after
+	// it was created or last failed
+	lastReconciledAnnotation = annotationPrefix + "last-reconciled"
+)
+
+// maxLastErrorLength caps the error written into lastErrorAnnotation, keeping the ingress well within the size limit
+// of its annotations
+const maxLastErrorLength = 1024
+
+func init() {
+	knownAnnotations[lastErrorAnnotation] = validateAnyValue
+	knownAnnotations[lastReconciledAnnotation] = validateAnyValue
+}
+
+// validateAnyValue accepts the annotations the controller writes itself
+func validateAnyValue(value string) error {
+	return nil
+}
+
+// publishedAddress holds the load balancer address of the publish service, once the service has been seen
+type publishedAddress struct {
+	mutex   sync.Mutex
+	known   bool
+	ingress []v1.LoadBalancerIngress
+}
+
+// set records the address of the service, returning whether it changed
+func (address *publishedAddress) set(ingress []v1.LoadBalancerIngress) bool {
+	address.mutex.Lock()
+	defer address.mutex.Unlock()
+	if address.known && sameLoadBalancerIngress(address.ingress, ingress) {
+		return false
+	}
+	address.known = true
+	address.ingress = append([]v1.LoadBalancerIngress{}, ingress...)
+	return true
+}
+
+// get returns the address of the service, or false until the service has been seen
+func (address *publishedAddress) get() ([]v1.LoadBalancerIngress, bool) {
+	address.mutex.Lock()
+	defer address.mutex.Unlock()
+	return append([]v1.LoadBalancerIngress{}, address.ingress...), address.known
+}
+
+func sameLoadBalancerIngress(a []v1.LoadBalancerIngress, b []v1.LoadBalancerIngress) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// publishesStatus reports whether the status of managed ingresses is kept up to date with the publish service
+func (controller *KongIngressController) publishesStatus() bool {
+	return controller.PublishService != "" && controller.Resource == ResourceIngress
+}
+
+// createPublishServiceWatch watches the publish service, so that managed ingresses are given its load balancer address
+// as soon as it is assigned or changes
+func (controller *KongIngressController) createPublishServiceWatch(ctx context.Context) cache.Controller {
+	namespace, name := splitPublishService(controller.PublishService)
+	watchedSource := cache.NewListWatchFromClient(
+		controller.CoreClient.RESTClient(),
+		"services",
+		namespace,
+		fields.OneTermEqualSelector("metadata.name", name))
+
+	informer := cache.NewSharedIndexInformer(
+		watchedSource,
+		&v1.Service{},
+		0,
+		cache.Indexers{},
+	)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: publishServiceChanged(controller),
+		UpdateFunc: func(previousObj, newObj interface{}) {
+			publishServiceChanged(controller)(newObj)
+		},
+	})
+
+	controller.spawn(func() { informer.Run(ctx.Done()) })
+	return informer
+}
+
+// publishServiceChanged updates the status of every managed ingress when the address of the publish service changes
+func publishServiceChanged(controller *KongIngressController) func(interface{}) {
+	return func(obj interface{}) {
+		service := obj.(*v1.Service)
+		if !controller.publishedAddress.set(service.Status.LoadBalancer.Ingress) {
+			return
+		}
+		logging.Infof(logging.Fields{"service": controller.PublishService}, "Address of service '%s' is now %v, updating the status of managed ingresses", controller.PublishService, service.Status.LoadBalancer.Ingress)
+		publishIngressStatuses(controller)
+	}
+}
+
+// publishIngressStatuses brings the status of every ingress last reconciled successfully up to date with the address of
+// the publish service
+func publishIngressStatuses(controller *KongIngressController) {
+	for _, ingress := range controller.cachedIngresses() {
+		if !controller.reconciled.succeeded(ingress) {
+			continue
+		}
+		if err := updateIngressStatus(controller, ingress); err != nil {
+			logging.Errorf(ingressFields(ingress).With(logging.Fields{"error": err}), "%v", err)
+		}
+	}
+}
+
+// updateIngressStatus writes the address of the publish service into the status of the ingress, unless it is there
+// already. Nothing is written until the publish service has been seen, so that a restart does not clear the status.
+func updateIngressStatus(controller *KongIngressController, ingress *v1beta1.Ingress) error {
+	address, known := controller.publishedAddress.get()
+	if !known || sameLoadBalancerIngress(ingress.Status.LoadBalancer.Ingress, address) {
+		return nil
+	}
+
+	updated := *ingress
+	updated.Status.LoadBalancer.Ingress = address
+	err := controller.StatusClient.Put().
+		Namespace(ingress.ObjectMeta.Namespace).
+		Resource("ingresses").
+		Name(ingress.ObjectMeta.Name).
+		SubResource("status").
+		Body(&updated).
+		Do().
+		Error()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to update the status of ingress '%s'", getIngressKey(ingress))
+	}
+	logging.V(2).Infof(ingressFields(ingress), "Updated the status of ingress '%s' to %v", getIngressKey(ingress), address)
+	return nil
+}
+
+// splitPublishService returns the namespace and name of a namespace/name service reference
+func splitPublishService(service string) (string, string) {
+	parts := strings.SplitN(service, "/", 2)
+	if len(parts) < 2 {
+		return "", service
+	}
+	return parts[0], parts[1]
+}
+
+// annotatesReconcileErrors reports whether the outcome of reconciles is written into the annotations of ingresses
+func (controller *KongIngressController) annotatesReconcileErrors() bool {
+	return controller.AnnotateReconcileErrors && controller.Resource == ResourceIngress
+}
+
+// annotateReconcileOutcome writes the error of a failed reconcile into the annotations of the ingress, or clears it and
+// notes the time once the ingress reconciles successfully. Only changes are written, since every write updates the
+// ingress and so queues it to be reconciled again.
+func annotateReconcileOutcome(controller *KongIngressController, ingress *v1beta1.Ingress, reconcileErr error) error {
+	current := ingress.ObjectMeta.Annotations
+	annotations := map[string]interface{}{}
+	if reconcileErr != nil {
+		message := reconcileErr.Error()
+		if len(message) > maxLastErrorLength {
+			message = message[:maxLastErrorLength] + "..."
+		}
+		if current[lastErrorAnnotation] == message {
+			return nil
+		}
+		annotations[lastErrorAnnotation] = message
+	} else {
+		_, failed := current[lastErrorAnnotation]
+		if _, reconciled := current[lastReconciledAnnotation]; reconciled && !failed {
+			return nil
+		}
+		// A null value removes the annotation in a merge patch
+		annotations[lastErrorAnnotation] = nil
+		annotations[lastReconciledAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to encode the reconcile outcome of ingress '%s'", getIngressKey(ingress))
+	}
+	err = controller.StatusClient.Patch(types.MergePatchType).
+		Namespace(ingress.ObjectMeta.Namespace).
+		Resource("ingresses").
+		Name(ingress.ObjectMeta.Name).
+		Body(patch).
+		Do().
+		Error()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to annotate the reconcile outcome of ingress '%s'", getIngressKey(ingress))
+	}
+	logging.V(2).Infof(ingressFields(ingress), "Annotated the reconcile outcome of ingress '%s'", getIngressKey(ingress))
+	return nil
+}
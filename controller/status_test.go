@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/rest/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/pkg/errors"
+)
+
+func TestPublishServiceAddressWrittenToReconciledIngresses(t *testing.T) {
+	kiController := New(nil, nil, nil)
+	kiController.PublishService = "kong/kong-proxy"
+	statuses := map[string]v1beta1.IngressStatus{}
+	kiController.StatusClient = mockStatusClient(t, statuses)
+
+	reconciled := sampleIngress("reconciledservice", "prod")
+	reconciled.ObjectMeta.ResourceVersion = "7"
+	published := sampleIngress("publishedservice", "prod")
+	published.ObjectMeta.ResourceVersion = "8"
+	published.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: "kong.example.com"}}
+	failed := sampleIngress("failedservice", "prod")
+	failed.ObjectMeta.ResourceVersion = "9"
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, ingress := range []*v1beta1.Ingress{&reconciled, &published, &failed} {
+		ingressStore.Add(ingress)
+	}
+	kiController.ingressStore = ingressStore
+	kiController.reconciled.record(&reconciled)
+	kiController.reconciled.record(&published)
+
+	service := sampleService("kong", "kong-proxy")
+	service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: "kong.example.com"}}
+	publishServiceChanged(kiController)(service)
+
+	if len(statuses) != 1 {
+		t.Errorf("Updated the status of %d ingresses, want only that of the reconciled ingress without the address", len(statuses))
+	}
+	status, found := statuses["/apis/extensions/v1beta1/namespaces/prod/ingresses/reconciledservice/status"]
+	if !found {
+		t.Fatalf("Status of the reconciled ingress was not updated, got %v", statuses)
+	}
+	if got := status.LoadBalancer.Ingress; len(got) != 1 || got[0].Hostname != "kong.example.com" {
+		t.Errorf("Ingress status has load balancer %v, want the hostname of the publish service", got)
+	}
+
+	// An unchanged address does not update the statuses again
+	delete(statuses, "/apis/extensions/v1beta1/namespaces/prod/ingresses/reconciledservice/status")
+	publishServiceChanged(kiController)(service)
+	if len(statuses) != 0 {
+		t.Errorf("Unchanged publish service address updated %d statuses", len(statuses))
+	}
+}
+
+func TestIngressStatusNotClearedBeforePublishServiceSeen(t *testing.T) {
+	kiController := New(nil, nil, nil)
+	kiController.PublishService = "kong/kong-proxy"
+	statuses := map[string]v1beta1.IngressStatus{}
+	kiController.StatusClient = mockStatusClient(t, statuses)
+
+	ingress := sampleIngress("publishedservice", "prod")
+	ingress.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	if err := updateIngressStatus(kiController, &ingress); err != nil {
+		t.Fatalf("Unexpected error updating ingress status: %v", err)
+	}
+	if len(statuses) != 0 {
+		t.Errorf("Ingress status was written before the publish service was seen: %v", statuses)
+	}
+}
+
+// mockStatusClient returns a REST client that records the ingress statuses put to it by path
+func mockStatusClient(t *testing.T, statuses map[string]v1beta1.IngressStatus) *rest.RESTClient {
+	scheme := runtime.NewScheme()
+	v1beta1.AddToScheme(scheme)
+	config := rest.Config{}
+	config.GroupVersion = &v1beta1.SchemeGroupVersion
+	config.APIPath = "/apis"
+	config.ContentType = runtime.ContentTypeJSON
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	restClient, err := rest.RESTClientFor(&config)
+	if err != nil {
+		t.Fatalf("Could not create rest client: %v", err)
+	}
+	restClient.Client = fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+		testRequestMatches(t, req, http.MethodPut, nil)
+		ingress := v1beta1.Ingress{}
+		if err := json.NewDecoder(req.Body).Decode(&ingress); err != nil {
+			t.Errorf("Error decoding ingress status update: %v", err)
+		}
+		statuses[req.URL.Path] = ingress.Status
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader("{}")),
+			Request:    req,
+		}, nil
+	})
+	return restClient
+}
+
+func TestReconcileErrorAnnotatedOnIngress(t *testing.T) {
+	kiController := New(nil, nil, nil)
+	kiController.AnnotateReconcileErrors = true
+	patches := map[string][]map[string]interface{}{}
+	kiController.StatusClient = mockPatchClient(t, patches)
+	path := "/apis/extensions/v1beta1/namespaces/prod/ingresses/failingservice"
+
+	ingress := sampleIngress("failingservice", "prod")
+	reconcileErr := errors.New("Failed to create kong api 'failingservice.prod': 409 Conflict")
+	if err := annotateReconcileOutcome(kiController, &ingress, reconcileErr); err != nil {
+		t.Fatalf("Unexpected error annotating ingress: %v", err)
+	}
+	if len(patches[path]) != 1 || patches[path][0][lastErrorAnnotation] != reconcileErr.Error() {
+		t.Fatalf("Ingress patched with %v, want the error in its %s annotation", patches[path], lastErrorAnnotation)
+	}
+
+	// The same error is not written again
+	ingress.ObjectMeta.Annotations = map[string]string{lastErrorAnnotation: reconcileErr.Error()}
+	annotateReconcileOutcome(kiController, &ingress, reconcileErr)
+	if len(patches[path]) != 1 {
+		t.Errorf("Unchanged error patched again: %v", patches[path][1:])
+	}
+
+	annotateReconcileOutcome(kiController, &ingress, nil)
+	if len(patches[path]) != 2 {
+		t.Fatalf("Successful reconcile patched the ingress %d times, want the error cleared", len(patches[path])-1)
+	}
+	cleared := patches[path][1]
+	if value, found := cleared[lastErrorAnnotation]; !found || value != nil {
+		t.Errorf("Successful reconcile patched %s to %v, want it removed", lastErrorAnnotation, value)
+	}
+	if _, err := time.Parse(time.RFC3339, fmt.Sprint(cleared[lastReconciledAnnotation])); err != nil {
+		t.Errorf("Successful reconcile patched %s to %v, want the time of the reconcile", lastReconciledAnnotation, cleared[lastReconciledAnnotation])
+	}
+
+	// Further successes leave the ingress alone
+	ingress.ObjectMeta.Annotations = map[string]string{lastReconciledAnnotation: fmt.Sprint(cleared[lastReconciledAnnotation])}
+	annotateReconcileOutcome(kiController, &ingress, nil)
+	if len(patches[path]) != 2 {
+		t.Errorf("Ingress patched again without an error to clear: %v", patches[path][2:])
+	}
+}
+
+// mockPatchClient returns a REST client that records the annotations merge patched into ingresses by path
+func mockPatchClient(t *testing.T, patches map[string][]map[string]interface{}) *rest.RESTClient {
+	restClient := mockStatusClient(t, nil)
+	restClient.Client = fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+		testRequestMatches(t, req, http.MethodPatch, nil)
+		patch := struct {
+			Metadata struct {
+				Annotations map[string]interface{} `json:"annotations"`
+			} `json:"metadata"`
+		}{}
+		if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+			t.Errorf("Error decoding ingress patch: %v", err)
+		}
+		patches[req.URL.Path] = append(patches[req.URL.Path], patch.Metadata.Annotations)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader("{}")),
+			Request:    req,
+		}, nil
+	})
+	return restClient
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultStartupReconcileQPS is the rate reconciles start at when the controller starts
+	DefaultStartupReconcileQPS = 20
+	// DefaultStartupWarmup is how long it takes for reconciles to ramp up from the startup rate to unthrottled
+	DefaultStartupWarmup = time.Minute
+)
+
+// reconcileThrottle smooths out the burst of reconciles from the informer's initial list, which on a large cluster
+// would otherwise fetch every api from kong at once
+type reconcileThrottle struct {
+	mutex sync.Mutex
+	start time.Time
+	next  time.Time
+}
+
+func (throttle *reconcileThrottle) begin() {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	throttle.start = time.Now()
+	throttle.next = throttle.start
+}
+
+// wait blocks until the next reconcile may start. Reconciles are spaced at 1/qps when the throttle begins, and the
+// spacing shrinks linearly until it disappears at the end of the warmup.
+func (throttle *reconcileThrottle) wait(qps float64, warmup time.Duration) {
+	throttle.mutex.Lock()
+	now := time.Now()
+	elapsed := now.Sub(throttle.start)
+	if qps <= 0 || throttle.start.IsZero() || elapsed >= warmup {
+		throttle.mutex.Unlock()
+		return
+	}
+
+	ramp := 1 - float64(elapsed)/float64(warmup)
+	if throttle.next.Before(now) {
+		throttle.next = now
+	}
+	delay := throttle.next.Sub(now)
+	throttle.next = throttle.next.Add(time.Duration(float64(time.Second) / qps * ramp))
+	throttle.mutex.Unlock()
+
+	time.Sleep(delay)
+}
@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStartupBurstIsSpreadOverTime(t *testing.T) {
+	throttle := reconcileThrottle{}
+	throttle.begin()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		throttle.wait(100, time.Minute)
+	}
+	// The first reconcile starts straight away and the other four are spaced at just under 10ms while warming up
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("Burst of 5 reconciles at 100 qps took %v, want them spread over at least 35ms", elapsed)
+	}
+}
+
+func TestThrottleLiftedAfterWarmup(t *testing.T) {
+	throttle := reconcileThrottle{}
+	throttle.begin()
+	throttle.start = time.Now().Add(-2 * time.Minute)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		throttle.wait(1, time.Minute)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Reconciles after the warmup took %v, want them unthrottled", elapsed)
+	}
+}
@@ -0,0 +1,440 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/nccurry/go-kong/kong"
+	"github.com/pkg/errors"
+)
+
+// The annotations that have kong balance the requests of an ingress across the endpoints of its backend services
+// itself, through a kong upstream per service port, rather than forwarding them to the service for kube-proxy to
+// balance. The health check annotations configure the active health checks of the upstream, and the hash annotations
+// the consistent hashing it balances with.
+const (
+	useUpstreamAnnotation                   = annotationPrefix + "use-upstream"
+	healthcheckPathAnnotation               = annotationPrefix + "healthcheck-path"
+	healthcheckIntervalAnnotation           = annotationPrefix + "healthcheck-interval"
+	healthcheckHealthyThresholdAnnotation   = annotationPrefix + "healthcheck-healthy-threshold"
+	healthcheckUnhealthyThresholdAnnotation = annotationPrefix + "healthcheck-unhealthy-threshold"
+	healthcheckHostHeaderAnnotation         = annotationPrefix + "healthcheck-host-header"
+	upstreamSlotsAnnotation                 = annotationPrefix + "upstream-slots"
+	upstreamHashOnAnnotation                = annotationPrefix + "upstream-hash-on"
+	upstreamHashOnHeaderAnnotation          = annotationPrefix + "upstream-hash-on-header"
+	upstreamHashOnCookieAnnotation          = annotationPrefix + "upstream-hash-on-cookie"
+	upstreamHashFallbackAnnotation          = annotationPrefix + "upstream-hash-fallback"
+
+	auditEntityUpstream = "upstream"
+	auditEntityTarget   = "target"
+
+	// The number of slots of the balancer of an upstream kong accepts
+	minUpstreamSlots = 10
+	maxUpstreamSlots = 65536
+
+	// defaultTargetWeight is the weight kong gives a target it is not told the weight of
+	defaultTargetWeight = 100
+
+	// managedUpstreamSuffix ends the name of every upstream the controller creates, so that the reaper leaves alone the
+	// upstreams created by hand or by other controllers
+	managedUpstreamSuffix = ".kong-ingress"
+)
+
+func init() {
+	knownAnnotations[useUpstreamAnnotation] = validateBool
+	knownAnnotations[healthcheckPathAnnotation] = validateHealthcheckPath
+	knownAnnotations[healthcheckIntervalAnnotation] = validatePositiveInt
+	knownAnnotations[healthcheckHealthyThresholdAnnotation] = validatePositiveInt
+	knownAnnotations[healthcheckUnhealthyThresholdAnnotation] = validatePositiveInt
+	knownAnnotations[healthcheckHostHeaderAnnotation] = validateHostname
+	knownAnnotations[upstreamSlotsAnnotation] = validateUpstreamSlots
+	knownAnnotations[upstreamHashOnAnnotation] = validateHashOn
+	knownAnnotations[upstreamHashOnHeaderAnnotation] = validateNotEmpty
+	knownAnnotations[upstreamHashOnCookieAnnotation] = validateNotEmpty
+	knownAnnotations[upstreamHashFallbackAnnotation] = validateHashOn
+	for _, annotation := range []string{healthcheckPathAnnotation, healthcheckIntervalAnnotation, healthcheckHealthyThresholdAnnotation, healthcheckUnhealthyThresholdAnnotation, healthcheckHostHeaderAnnotation, upstreamSlotsAnnotation, upstreamHashOnAnnotation, upstreamHashFallbackAnnotation} {
+		requiredAnnotations[annotation] = useUpstreamAnnotation
+	}
+	// The header and cookie to hash on only take effect along with hashing on them
+	requiredAnnotations[upstreamHashOnHeaderAnnotation] = upstreamHashOnAnnotation
+	requiredAnnotations[upstreamHashOnCookieAnnotation] = upstreamHashOnAnnotation
+}
+
+// hashOnValues are what kong balances the requests of an upstream by, hashing on none of them balancing round robin
+var hashOnValues = []string{"none", "consumer", "ip", "header", "cookie"}
+
+func validateHashOn(value string) error {
+	for _, hashOn := range hashOnValues {
+		if value == hashOn {
+			return nil
+		}
+	}
+	return errors.Errorf("must be one of %s", strings.Join(hashOnValues, ", "))
+}
+
+func validateUpstreamSlots(value string) error {
+	slots, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if slots < minUpstreamSlots || slots > maxUpstreamSlots {
+		return errors.Errorf("must be between %d and %d", minUpstreamSlots, maxUpstreamSlots)
+	}
+	return nil
+}
+
+func validateHealthcheckPath(value string) error {
+	if !strings.HasPrefix(value, "/") {
+		return errors.New("must start with '/'")
+	}
+	return nil
+}
+
+func validateHostname(value string) error {
+	if len(value) > 253 || !resourceNamePattern.MatchString(strings.ToLower(value)) {
+		return errors.New("must be a hostname")
+	}
+	return nil
+}
+
+// kongUpstream is a kong upstream, a virtual host kong balances across its targets. Only the health checks, host header
+// and hashing the annotations configure are managed.
+type kongUpstream struct {
+	ID           string                 `json:"id,omitempty"`
+	Name         string                 `json:"name"`
+	Healthchecks map[string]interface{} `json:"healthchecks,omitempty"`
+	HostHeader   string                 `json:"host_header,omitempty"`
+	Slots        int                    `json:"slots,omitempty"`
+	HashOn       string                 `json:"hash_on,omitempty"`
+	HashOnHeader string                 `json:"hash_on_header,omitempty"`
+	HashOnCookie string                 `json:"hash_on_cookie,omitempty"`
+	HashFallback string                 `json:"hash_fallback,omitempty"`
+}
+
+type kongUpstreamList struct {
+	Data []kongUpstream `json:"data"`
+}
+
+// kongTarget is an address of an upstream, an endpoint of its service. Kong keeps the history of the weights of a
+// target and a target of weight zero has been removed.
+type kongTarget struct {
+	ID     string `json:"id,omitempty"`
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+}
+
+type kongTargetList struct {
+	Data []kongTarget `json:"data"`
+}
+
+func usesUpstream(annotations ingressAnnotations) bool {
+	useUpstream, _ := strconv.ParseBool(annotations.values[useUpstreamAnnotation])
+	return useUpstream
+}
+
+// upstreamName returns the name of the kong upstream for the service port of a path, which the apis of every managed
+// ingress with the same backend share. The path is expected to have its service port resolved to a number, so that
+// ingresses naming the port share the upstream of those numbering it. The namespace comes last, like in the names of
+// apis, followed by managedUpstreamSuffix.
+func (controller *KongIngressController) upstreamName(ingress *v1beta1.Ingress, path *ingressPath) string {
+	backend := getIngressBackend(path)
+	name := strings.ToLower(fmt.Sprintf("%s%s.%s.%s", controller.ManagedPrefix, backend.ServiceName, backend.ServicePort.String(), ingress.ObjectMeta.Namespace))
+	return apiNameDisallowedChars.ReplaceAllString(name, "-") + managedUpstreamSuffix
+}
+
+// managesUpstream reports whether the upstream is one the controller created for a watched namespace
+func (controller *KongIngressController) managesUpstream(name string) bool {
+	if !strings.HasSuffix(name, managedUpstreamSuffix) || !strings.HasPrefix(name, controller.ManagedPrefix) {
+		return false
+	}
+	return controller.watchesNamespace(apiNamespace(strings.TrimSuffix(name, managedUpstreamSuffix)))
+}
+
+// upstreamURL returns the url kong forwards the requests for a path to when it balances them itself, whose host is the
+// upstream. Kong forwards to the ports of the targets, so the port of the url only keeps it explicit.
+func (controller *KongIngressController) upstreamURL(ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) string {
+	scheme := upstreamSchemeHTTP
+	if value, found := annotations.values[upstreamSchemeAnnotation]; found {
+		scheme = value
+	}
+	return fmt.Sprintf("%s://%s:%s%s", scheme, controller.upstreamName(ingress, path), getIngressBackend(path).ServicePort.String(), annotatedUpstreamPath(annotations))
+}
+
+// desiredHealthchecks returns the health checks the annotations configure, leaving those that are not annotated to
+// kong's defaults, or nil when none are annotated. Active checks only run once an interval is annotated.
+func desiredHealthchecks(annotations ingressAnnotations) map[string]interface{} {
+	active := map[string]interface{}{}
+	healthy := map[string]interface{}{}
+	unhealthy := map[string]interface{}{}
+	if value, found := annotations.values[healthcheckPathAnnotation]; found {
+		active["http_path"] = value
+	}
+	if value, found := annotations.values[healthcheckIntervalAnnotation]; found {
+		interval, _ := strconv.Atoi(value)
+		healthy["interval"] = float64(interval)
+		unhealthy["interval"] = float64(interval)
+	}
+	if value, found := annotations.values[healthcheckHealthyThresholdAnnotation]; found {
+		successes, _ := strconv.Atoi(value)
+		healthy["successes"] = float64(successes)
+	}
+	if value, found := annotations.values[healthcheckUnhealthyThresholdAnnotation]; found {
+		failures, _ := strconv.Atoi(value)
+		for _, key := range []string{"http_failures", "tcp_failures", "timeouts"} {
+			unhealthy[key] = float64(failures)
+		}
+	}
+	if len(healthy) > 0 {
+		active["healthy"] = healthy
+	}
+	if len(unhealthy) > 0 {
+		active["unhealthy"] = unhealthy
+	}
+	if len(active) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"active": active}
+}
+
+// desiredHostHeader returns the host header the annotation sets for the upstream, which kong sends to its targets with
+// the requests it forwards and health checks it runs. Kong only has the host header of upstreams in versions that no
+// longer have legacy apis, so it is left out for ingresses with the apis model.
+func (controller *KongIngressController) desiredHostHeader(ingress *v1beta1.Ingress, annotations ingressAnnotations) string {
+	hostHeader, found := annotations.values[healthcheckHostHeaderAnnotation]
+	if found && controller.apiModel(annotations) != KongAPIModelServices {
+		controller.recordWarning(ingress, "UnsupportedAnnotation", "Ignoring annotation '%s' on ingress '%s', the host header of upstreams needs the services model", healthcheckHostHeaderAnnotation, getIngressKey(ingress))
+		return ""
+	}
+	return hostHeader
+}
+
+// upstreamDrift returns the settings of the desired upstream that differ on the upstream, keyed by their name in kong.
+// Settings the desired upstream leaves unset are not managed.
+func upstreamDrift(upstream *kongUpstream, desired kongUpstream) map[string]interface{} {
+	patch := map[string]interface{}{}
+	// Kong merges the health checks it is patched with into those it has
+	if desired.Healthchecks != nil && !pluginConfigMatches(upstream.Healthchecks, desired.Healthchecks) {
+		patch["healthchecks"] = desired.Healthchecks
+	}
+	if desired.HostHeader != "" && !strings.EqualFold(upstream.HostHeader, desired.HostHeader) {
+		patch["host_header"] = desired.HostHeader
+	}
+	if desired.Slots > 0 && upstream.Slots != desired.Slots {
+		patch["slots"] = desired.Slots
+	}
+	if desired.HashOn != "" && upstream.HashOn != desired.HashOn {
+		patch["hash_on"] = desired.HashOn
+	}
+	if desired.HashOnHeader != "" && upstream.HashOnHeader != desired.HashOnHeader {
+		patch["hash_on_header"] = desired.HashOnHeader
+	}
+	if desired.HashOnCookie != "" && upstream.HashOnCookie != desired.HashOnCookie {
+		patch["hash_on_cookie"] = desired.HashOnCookie
+	}
+	if desired.HashFallback != "" && upstream.HashFallback != desired.HashFallback {
+		patch["hash_fallback"] = desired.HashFallback
+	}
+	return patch
+}
+
+// reconcileUpstream makes the upstream for the service port of a path match the annotations of the ingress, and its
+// targets the ready endpoints of the service. The path is expected to have its service port resolved to a number.
+func reconcileUpstream(controller *KongIngressController, ingress *v1beta1.Ingress, path *ingressPath, annotations ingressAnnotations) error {
+	ingressKey := getIngressKey(ingress)
+	upstreamName := controller.upstreamName(ingress, path)
+	desiredUpstream := kongUpstream{
+		Name:         upstreamName,
+		Healthchecks: desiredHealthchecks(annotations),
+		HostHeader:   controller.desiredHostHeader(ingress, annotations),
+		HashOn:       annotations.values[upstreamHashOnAnnotation],
+		HashOnHeader: annotations.values[upstreamHashOnHeaderAnnotation],
+		HashOnCookie: annotations.values[upstreamHashOnCookieAnnotation],
+		HashFallback: annotations.values[upstreamHashFallbackAnnotation],
+	}
+	if value, found := annotations.values[upstreamSlotsAnnotation]; found {
+		desiredUpstream.Slots, _ = strconv.Atoi(value)
+	}
+
+	upstream := kongUpstream{}
+	found, err := getKongEntity(controller, "upstreams/"+upstreamName, &upstream)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to fetch upstream '%s'", upstreamName)
+	}
+	if !found {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"upstream": upstreamName}), "Creating new upstream '%s'", upstreamName)
+		if err := doKongRequest(controller, http.MethodPost, "upstreams", desiredUpstream, &upstream); err != nil {
+			return errors.Wrapf(err, "Failed to create upstream '%s'", upstreamName)
+		}
+		controller.AuditLog.record(auditCreate, auditEntityUpstream, upstreamName, ingressKey, desiredUpstream)
+	} else if patch := upstreamDrift(&upstream, desiredUpstream); len(patch) > 0 {
+		logging.Infof(ingressFields(ingress).With(logging.Fields{"upstream": upstreamName}), "Patching %v on upstream '%s'", patch, upstreamName)
+		if err := doKongRequest(controller, http.MethodPatch, "upstreams/"+upstreamName, patch, nil); err != nil {
+			return errors.Wrapf(err, "Failed to patch upstream '%s'", upstreamName)
+		}
+		controller.AuditLog.record(auditPatch, auditEntityUpstream, upstreamName, ingressKey, patch)
+	}
+
+	namespace := ingress.ObjectMeta.Namespace
+	backend := getIngressBackend(path)
+	portName, err := servicePortName(controller, namespace, backend)
+	if err != nil {
+		return err
+	}
+	endpoints, err := controller.CoreClient.Endpoints(namespace).Get(backend.ServiceName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to fetch the endpoints of service '%s/%s'", namespace, backend.ServiceName)
+	}
+	return reconcileTargets(controller, ingressKey, upstreamName, readyTargets(endpoints, portName))
+}
+
+// servicePortName returns the name the service of the backend gives its port, by which the port of its endpoints is
+// found. The port of the backend is expected to be resolved to a number.
+func servicePortName(controller *KongIngressController, namespace string, backend *v1beta1.IngressBackend) (string, error) {
+	serviceKey := namespace + "/" + backend.ServiceName
+	service, err := controller.CoreClient.Services(namespace).Get(backend.ServiceName, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to fetch service '%s' for the targets of its upstream", serviceKey)
+	}
+	for _, port := range service.Spec.Ports {
+		if port.Port == backend.ServicePort.IntVal {
+			return port.Name, nil
+		}
+	}
+	return "", errors.Errorf("Service '%s' has no port %s", serviceKey, backend.ServicePort.String())
+}
+
+// readyTargets returns the addresses of the ready endpoints of the named port as kong targets. Endpoints that are not
+// ready, like those of pods shutting down, are left out.
+func readyTargets(endpoints *v1.Endpoints, portName string) map[string]bool {
+	targets := map[string]bool{}
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != portName {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				targets[fmt.Sprintf("%s:%d", address.IP, port.Port)] = true
+			}
+		}
+	}
+	return targets
+}
+
+// activeTargets returns the targets of the upstream that have not been removed, by their address. Kong lists the
+// newest entry for a target first, which is the one in effect.
+func activeTargets(controller *KongIngressController, upstreamName string) (map[string]kongTarget, error) {
+	targets := kongTargetList{}
+	if err := doKongRequest(controller, http.MethodGet, "upstreams/"+upstreamName+"/targets", nil, &targets); err != nil {
+		return nil, errors.Wrapf(err, "Failed to fetch the targets of upstream '%s'", upstreamName)
+	}
+	seen := map[string]bool{}
+	active := map[string]kongTarget{}
+	for _, target := range targets.Data {
+		if seen[target.Target] {
+			continue
+		}
+		seen[target.Target] = true
+		if target.Weight > 0 {
+			active[target.Target] = target
+		}
+	}
+	return active, nil
+}
+
+// reconcileTargets adds the targets the upstream is missing and removes those it should no longer have. Targets are
+// added before any is removed, so that the upstream does not lose capacity while pods are replaced. Targets that stay
+// keep the weight they have in kong, and only new ones get the default weight. Kong stops balancing new requests to a
+// removed target but lets those in flight finish.
+func reconcileTargets(controller *KongIngressController, ingressKey string, upstreamName string, targets map[string]bool) error {
+	active, err := activeTargets(controller, upstreamName)
+	if err != nil {
+		return err
+	}
+
+	addresses := []string{}
+	for address := range targets {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	errs := []error{}
+	for _, address := range addresses {
+		if _, found := active[address]; found {
+			delete(active, address)
+			continue
+		}
+		logging.Infof(keyFields(ingressKey).With(logging.Fields{"upstream": upstreamName, "target": address}), "Adding target '%s' to upstream '%s'", address, upstreamName)
+		target := kongTarget{Target: address, Weight: defaultTargetWeight}
+		if err := doKongRequest(controller, http.MethodPost, "upstreams/"+upstreamName+"/targets", target, nil); err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to add target '%s' to upstream '%s'", address, upstreamName))
+			continue
+		}
+		controller.AuditLog.record(auditCreate, auditEntityTarget, upstreamName, ingressKey, target)
+	}
+	for address, target := range active {
+		logging.Infof(keyFields(ingressKey).With(logging.Fields{"upstream": upstreamName, "target": address}), "Removing target '%s' from upstream '%s'", address, upstreamName)
+		if err := doKongRequest(controller, http.MethodDelete, "upstreams/"+upstreamName+"/targets/"+target.ID, nil, nil); err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to remove target '%s' from upstream '%s'", address, upstreamName))
+			continue
+		}
+		controller.AuditLog.record(auditDelete, auditEntityTarget, upstreamName, ingressKey, target)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// listManagedUpstreams returns the upstreams the controller made for the watched namespaces. Kong versions without
+// upstreams answer with not found, and have none.
+func listManagedUpstreams(controller *KongIngressController) ([]kongUpstream, error) {
+	upstreams := kongUpstreamList{}
+	if _, err := getKongEntity(controller, "upstreams", &upstreams); err != nil {
+		return nil, errors.Wrap(err, "Failed to get kong upstream list")
+	}
+	managed := []kongUpstream{}
+	for _, upstream := range upstreams.Data {
+		if controller.managesUpstream(upstream.Name) {
+			managed = append(managed, upstream)
+		}
+	}
+	return managed, nil
+}
+
+// reapOrphanedUpstreams deletes the managed upstreams no api forwards to any more. The upstreams are listed before the
+// apis, so that one made for an api created in between is kept. An upstream whose api is created later than the apis
+// were listed is deleted, and made again by the next reconcile of its ingress. Like apis, none are deleted when more are
+// orphaned than the reap limit allows.
+func reapOrphanedUpstreams(controller *KongIngressController, upstreams []kongUpstream, apis []*kong.Api) error {
+	referenced := map[string]bool{}
+	for _, api := range apis {
+		if upstreamURL, err := url.Parse(api.UpstreamURL); err == nil {
+			referenced[upstreamURL.Hostname()] = true
+		}
+	}
+	orphans := []kongUpstream{}
+	for _, upstream := range upstreams {
+		if !referenced[upstream.Name] {
+			orphans = append(orphans, upstream)
+		}
+	}
+	if controller.refusesReap("upstreams", len(orphans), len(upstreams)) {
+		return nil
+	}
+	errs := []error{}
+	for _, upstream := range orphans {
+		if err := doKongRequest(controller, http.MethodDelete, "upstreams/"+upstream.Name, nil, nil); err != nil {
+			errs = append(errs, errors.Wrapf(err, "Failed to reap orphaned upstream '%s'", upstream.Name))
+			continue
+		}
+		controller.AuditLog.record(auditDelete, auditEntityUpstream, upstream.Name, "", upstream)
+		logging.Infof(logging.Fields{"upstream": upstream.Name}, "Reaper: Orphaned kong upstream '%s' was reaped", upstream.Name)
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,306 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/nccurry/go-kong/kong"
+)
+
+func upstreamService(name string, namespace string) (*v1.Service, *v1.Endpoints) {
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "http", Port: 32000}, {Name: "metrics", Port: 9090}}},
+	}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Subsets: []v1.EndpointSubset{{
+			Addresses:         []v1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+			NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.3"}},
+			Ports:             []v1.EndpointPort{{Name: "http", Port: 8080}, {Name: "metrics", Port: 9090}},
+		}},
+	}
+	return service, endpoints
+}
+
+func TestUpstreamTargetsMatchReadyEndpoints(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{
+		useUpstreamAnnotation:                   "true",
+		healthcheckPathAnnotation:               "/healthz",
+		healthcheckIntervalAnnotation:           "5",
+		healthcheckUnhealthyThresholdAnnotation: "3",
+	}
+	path := getIngressPaths(&ingress)[0]
+	service, endpoints := upstreamService(path.backend.ServiceName, "prod")
+	kiController.CoreClient = fake.NewSimpleClientset(service, endpoints).CoreV1()
+	upstreamName := kiController.upstreamName(&ingress, path)
+
+	mutex := sync.Mutex{}
+	changes := []string{}
+	change := func(request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body := map[string]interface{}{}
+		json.NewDecoder(request.Body).Decode(&body)
+		encoded, _ := json.Marshal(body)
+		changes = append(changes, request.Method+" "+request.URL.Path+" "+string(encoded))
+	}
+	mux.HandleFunc("/upstreams/"+upstreamName, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/upstreams", func(writer http.ResponseWriter, request *http.Request) {
+		change(request)
+		writer.WriteHeader(http.StatusCreated)
+		writeObjectResponse(t, &writer, kongUpstream{ID: "upstream-1", Name: upstreamName})
+	})
+	mux.HandleFunc("/upstreams/"+upstreamName+"/targets", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			change(request)
+			writer.WriteHeader(http.StatusCreated)
+			return
+		}
+		// Kong lists the newest entry of a target first, so 10.0.0.2 was removed after it was added
+		writeObjectResponse(t, &writer, kongTargetList{Data: []kongTarget{
+			{ID: "target-4", Target: "10.0.0.2:8080", Weight: 0},
+			{ID: "target-3", Target: "10.0.0.9:8080", Weight: 100},
+			{ID: "target-2", Target: "10.0.0.2:8080", Weight: 100},
+			{ID: "target-1", Target: "10.0.0.1:8080", Weight: 100},
+		}})
+	})
+	mux.HandleFunc("/upstreams/"+upstreamName+"/targets/target-3", func(writer http.ResponseWriter, request *http.Request) {
+		change(request)
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := reconcileUpstream(kiController, &ingress, path, parseAnnotations(&ingress)); err != nil {
+		t.Fatalf("Unexpected error reconciling upstream: %v", err)
+	}
+
+	sort.Strings(changes)
+	expected := []string{
+		`DELETE /upstreams/` + upstreamName + `/targets/target-3 {}`,
+		`POST /upstreams {"healthchecks":{"active":{"healthy":{"interval":5},"http_path":"/healthz","unhealthy":{"http_failures":3,"interval":5,"tcp_failures":3,"timeouts":3}}},"name":"` + upstreamName + `"}`,
+		`POST /upstreams/` + upstreamName + `/targets {"target":"10.0.0.2:8080","weight":100}`,
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Upstream changed with\n%s\nwant\n%s", strings.Join(changes, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestUpstreamAPIForwardsToUpstream(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("shopservice", "prod")
+	path := getIngressPaths(&ingress)[0]
+	if upstreamURL := desiredAPIRequest(kiController, &ingress, path, parseAnnotations(&ingress), nil).UpstreamURL; upstreamURL != getUpstreamURL(&ingress, path, parseAnnotations(&ingress)) {
+		t.Errorf("Api without the upstream annotation forwards to '%s', want the service", upstreamURL)
+	}
+
+	ingress.ObjectMeta.Annotations = map[string]string{useUpstreamAnnotation: "true"}
+	expectedURL := "http://" + path.backend.ServiceName + "." + path.backend.ServicePort.String() + ".prod.kong-ingress:" + path.backend.ServicePort.String()
+	if upstreamURL := desiredAPIRequest(kiController, &ingress, path, parseAnnotations(&ingress), nil).UpstreamURL; upstreamURL != expectedURL {
+		t.Errorf("Api forwards to '%s', want '%s'", upstreamURL, expectedURL)
+	}
+}
+
+func TestReaperDeletesUnreferencedUpstreams(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{useUpstreamAnnotation: "true"}
+	path := getIngressPaths(&ingress)[0]
+	usedUpstream := kiController.upstreamName(&ingress, path)
+	ingressStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ingressStore.Add(&ingress)
+	kiController.ingressStore = ingressStore
+
+	mux.HandleFunc("/apis", func(writer http.ResponseWriter, request *http.Request) {
+		api := apiFromIngress(&ingress)
+		api.UpstreamURL = kiController.upstreamURL(&ingress, path, parseAnnotations(&ingress))
+		writeObjectResponse(t, &writer, kong.Apis{Data: []*kong.Api{&api}})
+	})
+	mux.HandleFunc("/upstreams", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongUpstreamList{Data: []kongUpstream{
+			{ID: "upstream-1", Name: usedUpstream},
+			{ID: "upstream-2", Name: "cartservice.80.prod.kong-ingress"},
+			// Upstreams made by hand are not the controller's to reap, even when no api forwards to them
+			{ID: "upstream-3", Name: "billingservice.80.prod"},
+			{ID: "upstream-4", Name: "legacy-backend"},
+		}})
+	})
+	deleted := []string{}
+	for _, name := range []string{usedUpstream, "cartservice.80.prod.kong-ingress", "billingservice.80.prod", "legacy-backend"} {
+		name := name
+		mux.HandleFunc("/upstreams/"+name, func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method == http.MethodDelete {
+				deleted = append(deleted, name)
+			}
+			writer.WriteHeader(http.StatusNoContent)
+		})
+	}
+
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Fatalf("Unexpected error reaping: %v", err)
+	}
+	if strings.Join(deleted, ",") != "cartservice.80.prod.kong-ingress" {
+		t.Errorf("Reaped upstreams %v, want only the managed one no api forwards to", deleted)
+	}
+
+	// Orphans beyond the reap limit are all kept
+	deleted = []string{}
+	kiController.ReapMaxDeletePercent = 25
+	if err := reapOrphanedApis(kiController); err != nil {
+		t.Fatalf("Unexpected error reaping: %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("Reaped upstreams %v, want none with half of the managed upstreams orphaned", deleted)
+	}
+}
+
+func TestUpstreamHostHeaderConfigured(t *testing.T) {
+	setup()
+	defer shutdown()
+	kiController.KongAPIModel = KongAPIModelServices
+
+	ingress := sampleIngress("shopservice", "prod")
+	ingress.ObjectMeta.Annotations = map[string]string{
+		useUpstreamAnnotation:           "true",
+		healthcheckHostHeaderAnnotation: "shop.internal",
+	}
+	path := getIngressPaths(&ingress)[0]
+	service, endpoints := upstreamService(path.backend.ServiceName, "prod")
+	kiController.CoreClient = fake.NewSimpleClientset(service, endpoints).CoreV1()
+	upstreamName := kiController.upstreamName(&ingress, path)
+
+	patches := []map[string]interface{}{}
+	mux.HandleFunc("/upstreams/"+upstreamName, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodPatch {
+			patch := map[string]interface{}{}
+			json.NewDecoder(request.Body).Decode(&patch)
+			patches = append(patches, patch)
+			return
+		}
+		writeObjectResponse(t, &writer, kongUpstream{ID: "upstream-1", Name: upstreamName})
+	})
+	mux.HandleFunc("/upstreams/"+upstreamName+"/targets", func(writer http.ResponseWriter, request *http.Request) {
+		writeObjectResponse(t, &writer, kongTargetList{Data: []kongTarget{
+			{ID: "target-2", Target: "10.0.0.2:8080", Weight: 100},
+			{ID: "target-1", Target: "10.0.0.1:8080", Weight: 100},
+		}})
+	})
+
+	if err := reconcileUpstream(kiController, &ingress, path, parseAnnotations(&ingress)); err != nil {
+		t.Fatalf("Unexpected error reconciling upstream: %v", err)
+	}
+	if len(patches) != 1 || patches[0]["host_header"] != "shop.internal" || len(patches[0]) != 1 {
+		t.Errorf("Upstream patched with %v, want just the host header", patches)
+	}
+
+	// Kong versions with legacy apis have no host header on upstreams
+	patches = []map[string]interface{}{}
+	kiController.KongAPIModel = KongAPIModelAPIs
+	if err := reconcileUpstream(kiController, &ingress, path, parseAnnotations(&ingress)); err != nil {
+		t.Fatalf("Unexpected error reconciling upstream: %v", err)
+	}
+	if len(patches) != 0 {
+		t.Errorf("Upstream patched with %v with the apis model, want no host header", patches)
+	}
+
+	if err := validateHostname("shop_internal"); err == nil {
+		t.Error("Expected an error validating a host header that is not a hostname")
+	}
+}
+
+func TestUpstreamConsistentHashingConfigured(t *testing.T) {
+	setup()
+	defer shutdown()
+
+	created := ""
+	mux.HandleFunc("/upstreams", func(writer http.ResponseWriter, request *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(request.Body).Decode(&body)
+		encoded, _ := json.Marshal(body)
+		created = string(encoded)
+		writer.WriteHeader(http.StatusCreated)
+		writeObjectResponse(t, &writer, kongUpstream{ID: "upstream-1", Name: body["name"].(string)})
+	})
+	for _, test := range []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name: "header",
+			annotations: map[string]string{
+				upstreamSlotsAnnotation:        "1000",
+				upstreamHashOnAnnotation:       "header",
+				upstreamHashOnHeaderAnnotation: "X-Session-Id",
+				upstreamHashFallbackAnnotation: "ip",
+			},
+			expected: `{"hash_fallback":"ip","hash_on":"header","hash_on_header":"X-Session-Id","name":"%s","slots":1000}`,
+		},
+		{
+			name: "cookie",
+			annotations: map[string]string{
+				upstreamHashOnAnnotation:       "cookie",
+				upstreamHashOnCookieAnnotation: "session",
+			},
+			expected: `{"hash_on":"cookie","hash_on_cookie":"session","name":"%s"}`,
+		},
+	} {
+		ingress := sampleIngress("shop"+test.name, "prod")
+		ingress.ObjectMeta.Annotations = map[string]string{useUpstreamAnnotation: "true"}
+		for key, value := range test.annotations {
+			ingress.ObjectMeta.Annotations[key] = value
+		}
+		path := getIngressPaths(&ingress)[0]
+		service, endpoints := upstreamService(path.backend.ServiceName, "prod")
+		kiController.CoreClient = fake.NewSimpleClientset(service, endpoints).CoreV1()
+		upstreamName := kiController.upstreamName(&ingress, path)
+
+		mux.HandleFunc("/upstreams/"+upstreamName, func(writer http.ResponseWriter, request *http.Request) {
+			writer.WriteHeader(http.StatusNotFound)
+		})
+		mux.HandleFunc("/upstreams/"+upstreamName+"/targets", func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method == http.MethodGet {
+				writeObjectResponse(t, &writer, kongTargetList{})
+				return
+			}
+			writer.WriteHeader(http.StatusCreated)
+		})
+
+		if err := reconcileUpstream(kiController, &ingress, path, parseAnnotations(&ingress)); err != nil {
+			t.Fatalf("Unexpected error reconciling %s hashing upstream: %v", test.name, err)
+		}
+		if expected := fmt.Sprintf(test.expected, upstreamName); created != expected {
+			t.Errorf("Upstream hashing on a %s created with %s, want %s", test.name, created, expected)
+		}
+	}
+
+	// Kong balances round robin unless told otherwise, which is corrected once hashing is annotated
+	existing := kongUpstream{Name: "shopcookie", Slots: 10000, HashOn: "none", HashFallback: "none"}
+	desired := kongUpstream{Name: "shopcookie", HashOn: "cookie", HashOnCookie: "session"}
+	patch := upstreamDrift(&existing, desired)
+	if len(patch) != 2 || patch["hash_on"] != "cookie" || patch["hash_on_cookie"] != "session" {
+		t.Errorf("Upstream drift was %v, want only the annotated hashing", patch)
+	}
+	if err := validateHashOn("path"); err == nil {
+		t.Error("Expected an error validating an unknown hash_on")
+	}
+}
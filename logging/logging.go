@@ -0,0 +1,129 @@
+// Package logging writes the log statements of the controller through glog, or as JSON lines that keep the ingress,
+// namespace and kong entity a statement is about in fields of their own rather than only in its message
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// FormatText writes logs through glog, in its usual format
+	FormatText = "text"
+	// FormatJSON writes logs to stderr as a JSON object per line
+	FormatJSON = "json"
+)
+
+// Fields are the structured fields of a log statement. Text logs leave them out, since the message holds them already.
+type Fields map[string]interface{}
+
+var (
+	mutex  sync.Mutex
+	format           = FormatText
+	output io.Writer = os.Stderr
+)
+
+// SetFormat chooses how logs are written, returning an error for formats other than FormatText and FormatJSON
+func SetFormat(logFormat string) error {
+	if logFormat != FormatText && logFormat != FormatJSON {
+		return fmt.Errorf("Unsupported log format '%s'", logFormat)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	format = logFormat
+	return nil
+}
+
+// Infof logs at the info level
+func Infof(fields Fields, messageFmt string, args ...interface{}) {
+	write("info", 0, fields, fmt.Sprintf(messageFmt, args...))
+}
+
+// Warningf logs at the warning level
+func Warningf(fields Fields, messageFmt string, args ...interface{}) {
+	write("warning", 0, fields, fmt.Sprintf(messageFmt, args...))
+}
+
+// Errorf logs at the error level
+func Errorf(fields Fields, messageFmt string, args ...interface{}) {
+	write("error", 0, fields, fmt.Sprintf(messageFmt, args...))
+}
+
+// Verbose logs at the info level only when the -v flag of glog is at least its level, like glog.Verbose
+type Verbose struct {
+	level   glog.Level
+	enabled bool
+}
+
+// V returns a Verbose for the level, so that glog.V(2).Infof(...) becomes logging.V(2).Infof(fields, ...)
+func V(level glog.Level) Verbose {
+	return Verbose{level: level, enabled: bool(glog.V(level))}
+}
+
+// Infof logs at the info level when the verbosity is enabled
+func (verbose Verbose) Infof(fields Fields, messageFmt string, args ...interface{}) {
+	if verbose.enabled {
+		write("info", verbose.level, fields, fmt.Sprintf(messageFmt, args...))
+	}
+}
+
+// write logs the message through glog or as JSON, attributing it to the caller of the exported function
+func write(level string, verbosity glog.Level, fields Fields, message string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if format == FormatText {
+		switch level {
+		case "warning":
+			glog.WarningDepth(2, message)
+		case "error":
+			glog.ErrorDepth(2, message)
+		default:
+			glog.InfoDepth(2, message)
+		}
+		return
+	}
+
+	// The fields of the statement come first so that they cannot replace the ones every line has
+	line := map[string]interface{}{}
+	for name, value := range fields {
+		if err, isError := value.(error); isError {
+			value = err.Error()
+		}
+		line[name] = value
+	}
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = level
+	if verbosity > 0 {
+		line["v"] = verbosity
+	}
+	if _, file, lineNumber, ok := runtime.Caller(2); ok {
+		line["caller"] = fmt.Sprintf("%s:%d", filepath.Base(file), lineNumber)
+	}
+	line["msg"] = message
+
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		encoded, _ = json.Marshal(map[string]interface{}{"level": "error", "msg": fmt.Sprintf("Failed to encode log line %q: %v", message, err)})
+	}
+	output.Write(append(encoded, '\n'))
+}
+
+// With returns the fields along with the extra fields, which take precedence
+func (fields Fields) With(extra Fields) Fields {
+	merged := Fields{}
+	for name, value := range fields {
+		merged[name] = value
+	}
+	for name, value := range extra {
+		merged[name] = value
+	}
+	return merged
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestJSONLogsKeepFieldsSeparate(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	output = buffer
+	if err := SetFormat(FormatJSON); err != nil {
+		t.Fatalf("Unexpected error setting the log format: %v", err)
+	}
+	defer SetFormat(FormatText)
+
+	fields := Fields{"ingress": "shop", "namespace": "prod"}
+	Errorf(fields.With(Fields{"api": "shop.prod", "error": errors.New("kong is down"), "msg": "replaced"}), "Failed to patch API '%s'", "shop.prod")
+
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(buffer.Bytes(), &line); err != nil {
+		t.Fatalf("Log line %q is not JSON: %v", buffer.String(), err)
+	}
+	expected := map[string]interface{}{
+		"ingress":   "shop",
+		"namespace": "prod",
+		"api":       "shop.prod",
+		"error":     "kong is down",
+		"level":     "error",
+		"msg":       "Failed to patch API 'shop.prod'",
+	}
+	for name, value := range expected {
+		if line[name] != value {
+			t.Errorf("Log field %s is %v, want %v", name, line[name], value)
+		}
+	}
+	if caller, _ := line["caller"].(string); caller == "" {
+		t.Errorf("Log line %q has no caller", buffer.String())
+	}
+	if _, found := fields["api"]; found {
+		t.Error("With changed the fields it was called on")
+	}
+}
+
+func TestUnsupportedLogFormatRefused(t *testing.T) {
+	if err := SetFormat("yaml"); err == nil {
+		t.Error("Expected an error setting an unsupported log format")
+	}
+}
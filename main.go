@@ -3,15 +3,30 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/SprintHive/kong-ingress-controller/controller"
-	"github.com/nccurry/go-kong/kong"
+	"github.com/SprintHive/kong-ingress-controller/logging"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -19,7 +34,66 @@ func main() {
 	var kubeConfig *string
 	var err error
 	externalAPIAccess := flag.Bool("externalapi", false, "connect to the API from outside the kubernetes cluster")
-	kongAPIAddress := flag.String("kongaddress", "http://kong-admin:8001", "address of the kong API server")
+	kongAPIAddress := flag.String("kongaddress", "http://kong-admin:8001", "address of the kong API server, which may include a path prefix")
+	dryRun := flag.Bool("dry-run", false, "log the changes that would be made to kong without making them")
+	kongHeaders := headerFlag{}
+	flag.Var(&kongHeaders, "kong-header", "a key=value header to add to every kong API request, may be repeated")
+	kongAdminToken := flag.String("kong-admin-token", "", "(optional) token to authenticate to the kong API with, read from -kong-admin-token-file or $"+controller.KongAdminTokenEnv+" when not set")
+	kongAdminTokenFile := flag.String("kong-admin-token-file", "", "(optional) path of a file holding the token to authenticate to the kong API with")
+	kongAdminTokenHeader := flag.String("kong-admin-token-header", controller.DefaultKongAdminTokenHeader, "header to send the kong admin token in")
+	kongMaxRetries := flag.Int("kong-max-retries", controller.DefaultKongMaxRetries, "how many times to retry a kong API call that fails with a server error or cannot reach kong (0 to disable)")
+	publishService := flag.String("publish-service", "", "(optional) namespace/name of the kong proxy service whose load balancer address is written into the status of managed ingresses")
+	watchNamespace := flag.String("watch-namespace", "", "(optional) comma separated namespaces to limit the controller to, all namespaces by default")
+	workers := flag.Int("workers", controller.DefaultWorkers, "how many ingresses to reconcile at once")
+	kongRetryBackoff := flag.Duration("kong-retry-backoff", controller.DefaultKongRetryBackoff, "how long to wait before retrying a failed kong API call, doubling with every retry")
+	sniConflict := flag.String("sni-conflict", controller.SNIConflictFirstWins, "how to resolve an SNI claimed by more than one TLS secret: first-wins or last-wins")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long to wait for in-flight reconciles to finish on SIGTERM or SIGINT")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for the informers and reaper to stop once in-flight reconciles are drained")
+	patchStrategy := flag.String("patch-strategy", controller.PatchStrategyField, "how to patch drifted kong apis: field sends a patch per field, merge a single patch of just the changed fields")
+	enforceDefaults := flag.Bool("enforce-defaults", false, "also correct drift of preserve_host on apis whose ingress does not set it with an annotation or override")
+	recreateOnImmutable := flag.Bool("recreate-on-immutable", false, "recreate kong apis when kong refuses to patch their drifted fields")
+	managedPrefix := flag.String("managed-prefix", "", "(optional) prefix of the names of the kong apis the controller creates, limiting the reaper to apis named with it")
+	maxPathsPerIngress := flag.Int("max-paths-per-ingress", controller.DefaultMaxPathsPerIngress, "refuse to reconcile ingresses with more paths than this (0 for no limit)")
+	metricsAddress := flag.String("metrics-addr", "", "(optional) address to serve prometheus metrics on at /metrics, e.g. :9090")
+	namespaceMetrics := flag.Bool("namespace-metrics", false, "label metrics with the ingress namespace, which adds series for every namespace")
+	printKongCompat := flag.Bool("print-kong-schema-compat", false, "print which controller features the kong API server supports, then exit")
+	annotateReconcileErrors := flag.Bool("annotate-reconcile-errors", false, "write the error of the last failed reconcile of each ingress into its kong.sprinthive.io/last-error annotation, which needs permission to patch ingresses")
+	auditFile := flag.String("audit-file", "", "(optional) path of a file to append a JSON line to for every change made to kong")
+	inventoryFile := flag.String("inventory-file", "", "(optional) path of a file to write a JSON snapshot of the apis and certificates managed in kong to every reap cycle")
+	kongIngressCRD := flag.Bool("kongingress-crd", false, "read override annotations from KongIngress custom resources before falling back to config maps")
+	kongPluginCRD := flag.Bool("kongplugin-crd", false, "watch the KongPlugin custom resources that ingresses reference with the kong.plugins annotation and configure their plugins")
+	resolveUpstreams := flag.Bool("resolve-upstreams", false, "raise a warning event for ingresses whose backend service does not resolve in DNS")
+	defaultPlugins := flag.String("default-plugins", "", "(optional) path of a JSON file with the config of each plugin to configure on every managed api, keyed by plugin name")
+	statsdPlugin := flag.String("statsd-plugin", "", "(optional) host:port of a statsd server for kong's statsd plugin on every api to send its metrics to, unless its ingress annotates another")
+	watchEndpoints := flag.Bool("watch-endpoints", true, "watch endpoints to update the targets of kong upstreams as soon as pods change, which needs list and watch access to endpoints")
+	logFormat := flag.String("log-format", logging.FormatText, "how to write logs: text through glog, or json with the ingress, namespace and kong entity of each line in fields of their own")
+	logIgnored := flag.Bool("log-ignored", false, "log and count ingresses that are skipped because of their class or an unsupported shape")
+	requireOptIn := flag.Bool("require-opt-in", false, "only handle ingresses annotated with kong.managed: \"true\"")
+	webhookAddress := flag.String("webhook-addr", "", "(optional) address to serve the validating admission webhook for ingresses on at /validate over TLS, e.g. :8443")
+	webhookCertFile := flag.String("webhook-cert-file", "", "path of the TLS certificate the admission webhook serves, required with -webhook-addr")
+	webhookKeyFile := flag.String("webhook-key-file", "", "path of the TLS key of the admission webhook certificate, required with -webhook-addr")
+	healthAddress := flag.String("health-addr", "", "(optional) address to serve the /healthz liveness and /readyz readiness probes on, e.g. :10254")
+	informerHealthyTimeout := flag.Duration("informer-healthy-timeout", controller.DefaultInformerHealthyTimeout, "fail /healthz when the ingress informer shows no activity for this long (0 to disable)")
+	loopStallTimeout := flag.Duration("loop-stall-timeout", controller.DefaultLoopStallTimeout, "fail /healthz when the reaper loop overruns -resync-interval by this long (0 to disable)")
+	startupQPS := flag.Float64("startup-qps", controller.DefaultStartupReconcileQPS, "how many ingresses per second to reconcile when the controller starts (0 for no limit)")
+	startupWarmup := flag.Duration("startup-warmup", controller.DefaultStartupWarmup, "how long reconciles take to ramp up from -startup-qps to unthrottled")
+	createGraceDelay := flag.Duration("create-grace-delay", 0, "delay the first reconcile of a newly created ingress until it is this old, so its service and secrets can appear first")
+	reaperTimeBudget := flag.Duration("reaper-time-budget", 0, "stop reaping orphaned kong apis after this long in a cycle, leaving the rest for the next cycle (0 for no limit)")
+	forceReconcileInterval := flag.Duration("force-reconcile-interval", controller.DefaultForceReconcileInterval, "how long resyncs may skip ingresses unchanged since they were last reconciled (0 to reconcile every resync)")
+	reaperStaleTimeout := flag.Duration("reaper-stale-timeout", 0, "fail /readyz when no reap cycle has succeeded for this long (0 to disable)")
+	managedFields := flag.String("managed-fields", strings.Join(controller.ManageableAPIFields, ","), "comma separated kong api fields whose drift is corrected, leaving manual changes to the others in place")
+	resource := flag.String("resource", controller.ResourceIngress, "the resource to configure kong from: ingress, or httproute for Gateway API HTTPRoutes")
+	ingressClass := flag.String("ingressclass", controller.DefaultIngressClass, "the kubernetes.io/ingress.class of the ingresses this controller handles")
+	claimUnsetClass := flag.Bool("claim-unset-class", true, "also handle ingresses without a kubernetes.io/ingress.class annotation")
+	leaderElect := flag.Bool("leader-elect", false, "elect a leader among the replicas of the controller, so that only one of them changes kong")
+	leaderElectNamespace := flag.String("leader-elect-namespace", "default", "the namespace of the config map used as the leader election lock")
+	leaderElectLock := flag.String("leader-elect-lock", "", "(optional) the name of the config map used as the leader election lock, kong-ingress-controller-<ingressclass> by default")
+	defaultRequestBuffering := flag.String("default-request-buffering", "", "(optional) true or false to set request_buffering on every kong route, unless its ingress annotates it, leaving kong's default when not set")
+	defaultResponseBuffering := flag.String("default-response-buffering", "", "(optional) true or false to set response_buffering on every kong route, unless its ingress annotates it, leaving kong's default when not set")
+	kongAPIModel := flag.String("kong-api-model", controller.KongAPIModelAPIs, "the kong entities to represent each ingress path with: apis, or services for a service and route on kong 0.13 and later")
+	resyncInterval := flag.Duration("resync-interval", controller.FullResyncInterval, "how often ingresses are resynced; values below a few seconds will hammer the kong admin API")
+	reapMaxDelete := flag.String("reap-max-delete", "", "(optional) most managed kong apis a reap cycle may delete, as a count like 20 or a percentage like 10%, beyond which it deletes none")
+	reapInterval := flag.Duration("reap-interval", controller.FullResyncInterval, "how often orphaned kong apis are reaped, with up to a tenth of it added as jitter, following -resync-interval unless set (0 to never delete orphaned apis)")
 	if home := homeDir(); home != "" {
 		kubeConfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
 	} else {
@@ -28,6 +102,124 @@ func main() {
 
 	flag.Parse()
 
+	if err := logging.SetFormat(*logFormat); err != nil {
+		panic(fmt.Sprintf("Unsupported -log-format value '%s', it must be %s or %s", *logFormat, logging.FormatText, logging.FormatJSON))
+	}
+	if *sniConflict != controller.SNIConflictFirstWins && *sniConflict != controller.SNIConflictLastWins {
+		panic(fmt.Sprintf("Unsupported -sni-conflict value '%s'", *sniConflict))
+	}
+	if *patchStrategy != controller.PatchStrategyField && *patchStrategy != controller.PatchStrategyMerge {
+		panic(fmt.Sprintf("Unsupported -patch-strategy value '%s'", *patchStrategy))
+	}
+	if *resyncInterval <= 0 {
+		panic(fmt.Sprintf("Unsupported -resync-interval value '%v', it must be positive", *resyncInterval))
+	}
+	controller.FullResyncInterval = *resyncInterval
+	reapMaxDeleteCount, reapMaxDeletePercent := 0, 0
+	if *reapMaxDelete != "" {
+		if reapMaxDeleteCount, reapMaxDeletePercent, err = controller.ParseReapMaxDelete(*reapMaxDelete); err != nil {
+			panic(fmt.Sprintf("Unsupported -reap-max-delete value: %v", err))
+		}
+	}
+	if *reapInterval < 0 {
+		panic(fmt.Sprintf("Unsupported -reap-interval value '%v', it must not be negative", *reapInterval))
+	}
+	reapIntervalSet := false
+	flag.Visit(func(set *flag.Flag) {
+		if set.Name == "reap-interval" {
+			reapIntervalSet = true
+		}
+	})
+	if !reapIntervalSet {
+		*reapInterval = *resyncInterval
+	}
+	if *kongMaxRetries < 0 {
+		panic(fmt.Sprintf("Unsupported -kong-max-retries value '%d', it must not be negative", *kongMaxRetries))
+	}
+	if *publishService != "" && strings.Count(*publishService, "/") != 1 {
+		panic(fmt.Sprintf("Unsupported -publish-service value '%s', it must be namespace/name", *publishService))
+	}
+	if strings.Trim(*managedPrefix, "abcdefghijklmnopqrstuvwxyz0123456789._~-") != "" {
+		panic(fmt.Sprintf("Unsupported -managed-prefix value '%s', it may only contain lower case letters, digits, '.', '_', '~' and '-'", *managedPrefix))
+	}
+	if *statsdPlugin != "" {
+		_, port, err := net.SplitHostPort(*statsdPlugin)
+		if portNumber, portErr := strconv.Atoi(port); err != nil || portErr != nil || portNumber < 1 || portNumber > 65535 {
+			panic(fmt.Sprintf("Unsupported -statsd-plugin value '%s', it must be host:port", *statsdPlugin))
+		}
+	}
+	if *webhookAddress != "" && (*webhookCertFile == "" || *webhookKeyFile == "") {
+		panic("Unsupported -webhook-addr without -webhook-cert-file and -webhook-key-file, the admission webhook must be served over TLS")
+	}
+	watchNamespaces := []string{}
+	if *watchNamespace != "" {
+		for _, namespace := range strings.Split(*watchNamespace, ",") {
+			if namespace = strings.TrimSpace(namespace); namespace == "" {
+				panic(fmt.Sprintf("Unsupported -watch-namespace value '%s', it must not contain empty namespaces", *watchNamespace))
+			}
+			watchNamespaces = append(watchNamespaces, namespace)
+		}
+	}
+	if *workers < 1 {
+		panic(fmt.Sprintf("Unsupported -workers value '%d', it must be positive", *workers))
+	}
+	if *kongRetryBackoff <= 0 {
+		panic(fmt.Sprintf("Unsupported -kong-retry-backoff value '%v', it must be positive", *kongRetryBackoff))
+	}
+	if *resource != controller.ResourceIngress && *resource != controller.ResourceHTTPRoute {
+		panic(fmt.Sprintf("Unsupported -resource value '%s'", *resource))
+	}
+	if *kongAPIModel != controller.KongAPIModelAPIs && *kongAPIModel != controller.KongAPIModelServices {
+		panic(fmt.Sprintf("Unsupported -kong-api-model value '%s'", *kongAPIModel))
+	}
+	requestBuffering := optionalBool("default-request-buffering", *defaultRequestBuffering)
+	responseBuffering := optionalBool("default-response-buffering", *defaultResponseBuffering)
+	managedFieldList := strings.Split(*managedFields, ",")
+	for _, field := range managedFieldList {
+		if !isManageableAPIField(field) {
+			panic(fmt.Sprintf("Unsupported -managed-fields field '%s', must be one of %s", field, strings.Join(controller.ManageableAPIFields, ", ")))
+		}
+	}
+
+	// Create Kong client
+	for name, values := range kongHeaders.headers {
+		for _, value := range values {
+			logging.Infof(logging.Fields{"header": name}, "Adding header %s: %s to kong API requests", name, controller.RedactHeader(name, value))
+		}
+	}
+	adminToken, err := controller.KongAdminToken(*kongAdminToken, *kongAdminTokenFile)
+	if err != nil {
+		panic(err.Error())
+	}
+	if adminToken != "" {
+		if strings.TrimSpace(*kongAdminTokenHeader) == "" {
+			panic(fmt.Sprintf("Unsupported -kong-admin-token-header value '%s', it must not be empty", *kongAdminTokenHeader))
+		}
+		// The token is added after the headers are logged, since the header it is sent in may not look like a credential
+		if kongHeaders.headers == nil {
+			kongHeaders.headers = http.Header{}
+		}
+		kongHeaders.headers.Set(*kongAdminTokenHeader, adminToken)
+		logging.Infof(logging.Fields{"header": *kongAdminTokenHeader}, "Authenticating kong API requests with header %s", *kongAdminTokenHeader)
+	}
+	if *dryRun {
+		logging.Infof(nil, "Dry run, changes to kong are only logged")
+	}
+	kongClient, err := controller.NewKongClient(*kongAPIAddress, kongHeaders.headers, *dryRun)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if *printKongCompat {
+		report, err := controller.CheckKongCompatibility(kongClient)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		fmt.Print(report)
+		return
+	}
+
 	if *externalAPIAccess {
 		// use the current context in kubeConfig
 		config, err = clientcmd.BuildConfigFromFlags("", *kubeConfig)
@@ -47,18 +239,237 @@ func main() {
 		panic(err.Error())
 	}
 
-	// Create Kong client
-	kongClient, err := kong.NewClient(nil, *kongAPIAddress)
+	ingController := controller.New(ingClient, clientSet.CoreV1(), kongClient)
+	ingController.SNIConflictPolicy = *sniConflict
+	ingController.RecreateOnImmutable = *recreateOnImmutable
+	ingController.EnforceDefaults = *enforceDefaults
+	ingController.PatchStrategy = *patchStrategy
+	ingController.ManagedFields = managedFieldList
+	ingController.NamespaceMetrics = *namespaceMetrics
+	ingController.MaxPathsPerIngress = *maxPathsPerIngress
+	ingController.ManagedPrefix = *managedPrefix
+	ingController.ResolveUpstreams = *resolveUpstreams
+	ingController.WatchEndpoints = *watchEndpoints
+	ingController.StatsdPlugin = *statsdPlugin
+	if *defaultPlugins != "" {
+		ingController.DefaultPlugins, err = controller.LoadDefaultPlugins(*defaultPlugins)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	ingController.LogIgnored = *logIgnored
+	ingController.IngressClass = *ingressClass
+	ingController.ClaimUnsetClass = *claimUnsetClass
+	ingController.RequireOptIn = *requireOptIn
+	ingController.InformerHealthyTimeout = *informerHealthyTimeout
+	ingController.LoopStallTimeout = *loopStallTimeout
+	ingController.KongMaxRetries = *kongMaxRetries
+	ingController.KongRetryBackoff = *kongRetryBackoff
+	ingController.Workers = *workers
+	ingController.WatchNamespaces = watchNamespaces
+	ingController.PublishService = *publishService
+	ingController.StatusClient = ingClient
+	ingController.AnnotateReconcileErrors = *annotateReconcileErrors
+	ingController.StartupReconcileQPS = *startupQPS
+	ingController.StartupWarmup = *startupWarmup
+	ingController.InventoryFile = *inventoryFile
+	ingController.CreateGraceDelay = *createGraceDelay
+	ingController.ReaperTimeBudget = *reaperTimeBudget
+	ingController.ReaperStaleTimeout = *reaperStaleTimeout
+	ingController.ReapInterval = *reapInterval
+	ingController.ReapMaxDelete = reapMaxDeleteCount
+	ingController.ReapMaxDeletePercent = reapMaxDeletePercent
+	ingController.ForceReconcileInterval = *forceReconcileInterval
+	ingController.Resource = *resource
+	ingController.KongAPIModel = *kongAPIModel
+	ingController.DefaultRequestBuffering = requestBuffering
+	ingController.DefaultResponseBuffering = responseBuffering
+	if *resource == controller.ResourceHTTPRoute {
+		ingController.HTTPRouteClient, err = controller.NewHTTPRouteClient(config)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if *kongIngressCRD {
+		ingController.OverrideClient, err = controller.NewKongIngressClient(config)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if *kongPluginCRD {
+		ingController.PluginClient, err = controller.NewKongPluginClient(config)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: clientSet.CoreV1().Events(metav1.NamespaceAll)})
+	ingController.Recorder = eventBroadcaster.NewRecorder(api.Scheme, v1.EventSource{Component: "kong-ingress-controller"})
+	if *auditFile != "" {
+		ingController.AuditLog, err = controller.NewAuditLog(*auditFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		defer ingController.AuditLog.Close()
+	}
+
+	if *metricsAddress != "" {
+		go serveMetrics(*metricsAddress)
+	}
+	if *healthAddress != "" {
+		go serveHealth(*healthAddress, ingController)
+	}
+	if *webhookAddress != "" {
+		go serveWebhook(*webhookAddress, *webhookCertFile, *webhookKeyFile, ingController)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if *leaderElect {
+			runLeaderElected(ctx, ingController, clientSet.CoreV1(), *leaderElectNamespace, leaderLockName(*leaderElectLock, *ingressClass), *drainTimeout)
+		} else {
+			ingController.Run(ctx)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	received := <-signals
+
+	logging.Infof(logging.Fields{"signal": received.String()}, "Received %v, draining in-flight reconciles for up to %v", received, *drainTimeout)
+	if err := ingController.Stop(*drainTimeout); err != nil {
+		logging.Errorf(logging.Fields{"error": err}, "Forcing exit: %v", err)
+	}
+	cancel()
+	select {
+	case <-stopped:
+		logging.Infof(nil, "Controller stopped")
+	case <-time.After(*shutdownTimeout):
+		logging.Errorf(nil, "Forcing exit: timed out after %v waiting for the informers and reaper to stop", *shutdownTimeout)
+	}
+	glog.Flush()
+}
+
+// runLeaderElected only runs the controller while this replica is the leader. Losing leadership cancels the controller
+// and exits once in-flight reconciles are drained, so that the replica restarts with a clean slate and can stand by for
+// the lock again.
+func runLeaderElected(ctx context.Context, ingController *controller.KongIngressController, coreClient corev1.CoreV1Interface, namespace string, lockName string, drainTimeout time.Duration) {
+	identity, err := os.Hostname()
 	if err != nil {
 		panic(err.Error())
 	}
+	elector := controller.NewLeaderElector(coreClient, namespace, lockName, identity)
+	err = elector.Run(ctx, func(leaderCtx context.Context) {
+		ingController.Run(leaderCtx)
+	})
+	if ctx.Err() != nil {
+		return
+	}
+
+	logging.Errorf(logging.Fields{"error": err}, "Stopping the controller: %v", err)
+	if err := ingController.Stop(drainTimeout); err != nil {
+		logging.Errorf(logging.Fields{"error": err}, "Forcing exit: %v", err)
+	}
+	glog.Flush()
+	os.Exit(1)
+}
+
+func leaderLockName(lockName string, ingressClass string) string {
+	if lockName != "" {
+		return lockName
+	}
+	return "kong-ingress-controller-" + ingressClass
+}
+
+// headerFlag collects repeated key=value flags into http headers
+type headerFlag struct {
+	headers http.Header
+}
+
+func (kongHeaders *headerFlag) String() string {
+	headers := []string{}
+	for name, values := range kongHeaders.headers {
+		for _, value := range values {
+			headers = append(headers, name+"="+controller.RedactHeader(name, value))
+		}
+	}
+	return strings.Join(headers, ",")
+}
 
-	ingController := controller.New(ingClient, kongClient)
+func (kongHeaders *headerFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("header '%s' is not in the form key=value", value)
+	}
+	if kongHeaders.headers == nil {
+		kongHeaders.headers = http.Header{}
+	}
+	kongHeaders.headers.Add(strings.TrimSpace(parts[0]), parts[1])
+	return nil
+}
 
-	ctx := context.Background()
-	go ingController.Run(ctx)
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	logging.Infof(logging.Fields{"address": address}, "Serving metrics on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logging.Errorf(logging.Fields{"address": address, "error": err}, "Metrics server stopped: %v", err)
+	}
+}
 
-	<-ctx.Done()
+func serveHealth(address string, ingController *controller.KongIngressController) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(writer http.ResponseWriter, request *http.Request) {
+		if err := ingController.CheckLive(); err != nil {
+			http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(writer, "ok")
+	})
+	mux.HandleFunc("/readyz", func(writer http.ResponseWriter, request *http.Request) {
+		if err := ingController.CheckReady(); err != nil {
+			http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(writer, "ok")
+	})
+	logging.Infof(logging.Fields{"address": address}, "Serving health checks on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logging.Errorf(logging.Fields{"address": address, "error": err}, "Health server stopped: %v", err)
+	}
+}
+
+func serveWebhook(address string, certFile string, keyFile string, ingController *controller.KongIngressController) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", ingController.ServeAdmission)
+	logging.Infof(logging.Fields{"address": address}, "Serving the admission webhook on %s", address)
+	if err := http.ListenAndServeTLS(address, certFile, keyFile, mux); err != nil {
+		logging.Errorf(logging.Fields{"address": address, "error": err}, "Admission webhook server stopped: %v", err)
+	}
+}
+
+func isManageableAPIField(field string) bool {
+	for _, manageable := range controller.ManageableAPIFields {
+		if field == manageable {
+			return true
+		}
+	}
+	return false
+}
+
+// optionalBool parses the value of a flag that is left unset when empty
+func optionalBool(name string, value string) *bool {
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		panic(fmt.Sprintf("Unsupported -%s value '%s', it must be true or false", name, value))
+	}
+	return &parsed
 }
 
 func homeDir() string {